@@ -0,0 +1,295 @@
+// couchbase-backup takes full and incremental backups of a Couchbase cluster with cbbackupmgr, either once or on a
+// schedule, uploads them to S3 or an S3-compatible object store, enforces a retention policy, and restores backups from
+// the object store to a cluster. It's meant to run on a Couchbase node, as cbbackupmgr ships with Couchbase Server.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/backup"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+)
+
+// This variable is set at build time using -ldflags parameters
+var VERSION string
+
+var awsRegionFlag = cli.StringFlag{
+	Name:   "aws-region",
+	Usage:  "The AWS region of the S3 bucket, also used for ssm:// and secretsmanager:// password references. Default: look up the region from the AWS SDK config or EC2 metadata.",
+	EnvVar: "AWS_REGION",
+}
+
+var localDirFlag = cli.StringFlag{
+	Name:   "local-dir",
+	Usage:  "If set, resolve ssm:// and secretsmanager:// password references from files in this folder instead of AWS. Only meant for local testing.",
+	EnvVar: "COUCHBASE_SECRETS_LOCAL_DIR",
+}
+
+var storeFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "s3-url",
+		Usage: "Where to store the backups, in the form s3://<BUCKET>/<PREFIX>. Required.",
+	},
+	cli.StringFlag{
+		Name:  "s3-endpoint",
+		Usage: "If set, talk to an S3-compatible server at this URL instead of AWS: e.g., http://minio:9000.",
+	},
+	cli.StringFlag{
+		Name:  "archive",
+		Usage: "The local cbbackupmgr archive folder. It must be on a volume with room for a full backup and its incremental backups. Required.",
+	},
+	cli.StringFlag{
+		Name:  "repo",
+		Usage: "The name of the backup repository. Clusters that store backups under the same --s3-url must use different repository names.",
+		Value: "couchbase",
+	},
+	awsRegionFlag,
+}
+
+var clusterFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "cluster-url",
+		Usage: "The URL of the cluster to back up or restore to.",
+		Value: "http://localhost:8091",
+	},
+	cli.StringFlag{
+		Name:  "username",
+		Usage: "The username to connect to the cluster as. The user needs the Data Backup & Restore role or the Full Admin role. Required.",
+	},
+	cli.StringFlag{
+		Name:  "password",
+		Usage: "The password to connect with. May be a reference to a secret, such as ssm://<PARAMETER_NAME>. Required.",
+	},
+	cli.StringFlag{
+		Name:  "cbbackupmgr",
+		Usage: "The path to the cbbackupmgr binary, which ships with Couchbase Server Enterprise Edition.",
+		Value: backup.DefaultCbbackupmgrPath,
+	},
+	cli.IntFlag{
+		Name:  "threads",
+		Usage: "How many concurrent clients cbbackupmgr uses to transfer data. Default: the cbbackupmgr default.",
+	},
+	localDirFlag,
+}
+
+var keepFullBackupsFlag = cli.IntFlag{
+	Name:  "keep-full-backups",
+	Usage: "After each backup, delete all but this many of the most recent full backups, along with their incremental backups, from the object store. Set to 0 to keep every backup.",
+	Value: 7,
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "couchbase-backup"
+	app.Usage = "Back up a Couchbase cluster to S3 with cbbackupmgr, and restore backups from S3."
+	app.Version = VERSION
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "backup",
+			Usage: "Take a single backup and upload it to the object store.",
+			Flags: append(append([]cli.Flag{
+				cli.BoolFlag{
+					Name:  "full",
+					Usage: "Take a full backup. Default: take an incremental backup, unless there is no earlier backup in the local repository to build on.",
+				},
+				keepFullBackupsFlag,
+			}, storeFlags...), clusterFlags...),
+			Action: runBackup,
+		},
+		{
+			Name:  "schedule",
+			Usage: "Take full and incremental backups at the given intervals until stopped. Meant to run as a service, such as under systemd.",
+			Flags: append(append([]cli.Flag{
+				cli.DurationFlag{
+					Name:  "full-interval",
+					Usage: "How often to take a full backup.",
+					Value: 24 * time.Hour,
+				},
+				cli.DurationFlag{
+					Name:  "incremental-interval",
+					Usage: "How often to take an incremental backup in between full backups. Set to 0 to only take full backups.",
+					Value: time.Hour,
+				},
+				cli.DurationFlag{
+					Name:  "retry-interval",
+					Usage: "How long to wait before trying again after a backup fails.",
+					Value: 5 * time.Minute,
+				},
+				keepFullBackupsFlag,
+			}, storeFlags...), clusterFlags...),
+			Action: runSchedule,
+		},
+		{
+			Name:  "restore",
+			Usage: "Download a backup, along with the backups it builds on, and restore it to the cluster. The buckets must already exist.",
+			Flags: append(append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "backup",
+					Usage: fmt.Sprintf("The name of the backup to restore, as shown by the list command, or %s for the most recent backup.", backup.LatestBackup),
+					Value: backup.LatestBackup,
+				},
+			}, storeFlags...), clusterFlags...),
+			Action: runRestore,
+		},
+		{
+			Name:   "list",
+			Usage:  "List the backups in the object store as JSON, oldest first.",
+			Flags:  storeFlags,
+			Action: runList,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runBackup(cliContext *cli.Context) error {
+	options, err := newOptions(cliContext, true)
+	if err != nil {
+		return err
+	}
+
+	if _, err := backup.TakeBackup(options, cliContext.Bool("full")); err != nil {
+		return err
+	}
+
+	_, err = backup.ApplyRetention(options, cliContext.Int("keep-full-backups"))
+	return err
+}
+
+func runSchedule(cliContext *cli.Context) error {
+	options, err := newOptions(cliContext, true)
+	if err != nil {
+		return err
+	}
+
+	// Stop cleanly on SIGTERM, such as when systemd stops the service, once the current backup, if any, is done
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		options.Logger.Printf("Received %s. Stopping after the current backup, if any.", sig)
+		close(stop)
+	}()
+
+	return backup.Schedule(options, backup.ScheduleOptions{
+		FullInterval:        cliContext.Duration("full-interval"),
+		IncrementalInterval: cliContext.Duration("incremental-interval"),
+		RetryInterval:       cliContext.Duration("retry-interval"),
+		KeepFull:            cliContext.Int("keep-full-backups"),
+		Stop:                stop,
+	})
+}
+
+func runRestore(cliContext *cli.Context) error {
+	options, err := newOptions(cliContext, true)
+	if err != nil {
+		return err
+	}
+
+	restored, err := backup.Restore(options, cliContext.String("backup"))
+	if err != nil {
+		return err
+	}
+
+	options.Logger.Printf("Restored backup %s", restored.Name)
+	return nil
+}
+
+func runList(cliContext *cli.Context) error {
+	options, err := newOptions(cliContext, false)
+	if err != nil {
+		return err
+	}
+
+	backups, err := backup.ListBackups(options)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(backups)
+}
+
+// Build the backup options from the CLI flags. If needsCluster is false, the cluster flags are not required, and
+// cbbackupmgr is never run.
+func newOptions(cliContext *cli.Context, needsCluster bool) (backup.Options, error) {
+	requiredFlags := []string{"s3-url", "archive"}
+	if needsCluster {
+		requiredFlags = append(requiredFlags, "username", "password")
+	}
+	for _, flagName := range requiredFlags {
+		if cliContext.String(flagName) == "" {
+			return backup.Options{}, RequiredFlagErr{Name: flagName}
+		}
+	}
+
+	bucket, prefix, err := backup.ParseS3Url(cliContext.String("s3-url"))
+	if err != nil {
+		return backup.Options{}, err
+	}
+
+	manager := backup.CbBackupMgr{}
+	if needsCluster {
+		password, err := newResolver(cliContext).Resolve(cliContext.String("password"))
+		if err != nil {
+			return backup.Options{}, err
+		}
+
+		manager = backup.CbBackupMgr{
+			Path:       cliContext.String("cbbackupmgr"),
+			ClusterUrl: cliContext.String("cluster-url"),
+			Username:   cliContext.String("username"),
+			Password:   password,
+			Threads:    cliContext.Int("threads"),
+		}
+	}
+
+	return backup.Options{
+		Manager: manager,
+		Store: &backup.S3Store{
+			Bucket:   bucket,
+			Session:  credentials.NewLazyAwsSession(cliContext.String(awsRegionFlag.Name)),
+			Endpoint: cliContext.String("s3-endpoint"),
+		},
+		Archive: cliContext.String("archive"),
+		Repo:    cliContext.String("repo"),
+		Prefix:  prefix,
+		Logger:  log.New(os.Stderr, "[couchbase-backup] ", log.LstdFlags),
+	}, nil
+}
+
+func newResolver(cliContext *cli.Context) *credentials.Resolver {
+	resolver := credentials.NewDefaultResolver(cliContext.String(awsRegionFlag.Name))
+
+	localDir := cliContext.String(localDirFlag.Name)
+	if localDir != "" {
+		resolver.Register(credentials.SsmScheme, credentials.DirectoryBackend{Dir: localDir})
+		resolver.Register(credentials.SecretsManagerScheme, credentials.DirectoryBackend{Dir: localDir})
+	}
+
+	return resolver
+}
+
+// Custom error types
+
+type RequiredFlagErr struct {
+	Name string
+}
+
+func (err RequiredFlagErr) Error() string {
+	return fmt.Sprintf("The --%s flag is required.", err.Name)
+}
@@ -0,0 +1,297 @@
+// couchbase-certs generates or imports the certificate authority (CA) for a Couchbase cluster, generates the node
+// certificates signed by that CA that each Couchbase node serves on its TLS ports, and verifies that a node is serving a
+// certificate signed by the expected CA. It is used by the run-couchbase-server script to configure node certificates
+// on boot.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/certs"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+)
+
+// This variable is set at build time using -ldflags parameters
+var VERSION string
+
+// Couchbase loads node certificates from chain.pem and pkey.key in this folder
+const defaultInboxDir = "/opt/couchbase/var/lib/couchbase/inbox"
+
+const chainFileName = "chain.pem"
+const keyFileName = "pkey.key"
+
+const hoursPerDay = 24
+
+var awsRegionFlag = cli.StringFlag{
+	Name:   "aws-region",
+	Usage:  "The AWS region to use for ssm:// and secretsmanager:// references. Default: look up the region from the AWS SDK config or EC2 metadata.",
+	EnvVar: "AWS_REGION",
+}
+
+var localDirFlag = cli.StringFlag{
+	Name:   "local-dir",
+	Usage:  "If set, resolve ssm:// and secretsmanager:// references from files in this folder instead of AWS. Only meant for local testing.",
+	EnvVar: "COUCHBASE_SECRETS_LOCAL_DIR",
+}
+
+var caCertFlag = cli.StringFlag{
+	Name:  "ca-cert",
+	Usage: "The CA certificate in PEM format. May be a path to a file or a reference to a secret, such as ssm://<PARAMETER_NAME>. Required.",
+}
+
+var caKeyFlag = cli.StringFlag{
+	Name:  "ca-key",
+	Usage: "The private key of the CA in PEM format. May be a path to a file or a reference to a secret, such as secretsmanager://<SECRET_ID>. Required.",
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "couchbase-certs"
+	app.Usage = "Manage the CA and node certificates Couchbase uses for TLS."
+	app.Version = VERSION
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "generate-ca",
+			Usage: "Generate a new self-signed CA for a Couchbase cluster. To use an existing CA instead, pass its certificate and key straight to generate-node.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "common-name",
+					Usage: "The common name of the CA.",
+					Value: "Couchbase CA",
+				},
+				cli.IntFlag{
+					Name:  "validity-days",
+					Usage: "How many days the CA is valid for.",
+					Value: int(certs.DefaultCaValidity.Hours() / hoursPerDay),
+				},
+				cli.StringFlag{
+					Name:  "cert-out",
+					Usage: "Write the CA certificate to this path. Required.",
+				},
+				cli.StringFlag{
+					Name:  "key-out",
+					Usage: "Write the private key of the CA to this path. The file is only readable by its owner. Required.",
+				},
+			},
+			Action: generateCa,
+		},
+		{
+			Name:  "generate-node",
+			Usage: "Generate a node certificate signed by the given CA and write it to the folder Couchbase loads node certificates from.",
+			Flags: []cli.Flag{
+				caCertFlag,
+				caKeyFlag,
+				cli.StringSliceFlag{
+					Name:  "host",
+					Usage: "A hostname or IP address the node is reachable at. May be repeated. The first host is used as the common name. Required.",
+				},
+				cli.IntFlag{
+					Name:  "validity-days",
+					Usage: "How many days the node certificate is valid for.",
+					Value: int(certs.DefaultNodeValidity.Hours() / hoursPerDay),
+				},
+				cli.StringFlag{
+					Name:  "out-dir",
+					Usage: fmt.Sprintf("Write the node certificate to %s and its private key to %s in this folder.", chainFileName, keyFileName),
+					Value: defaultInboxDir,
+				},
+				cli.StringFlag{
+					Name:  "ca-cert-out",
+					Usage: "If set, also write the CA certificate to this path, so it can be uploaded to Couchbase as the cluster CA.",
+				},
+				awsRegionFlag,
+				localDirFlag,
+			},
+			Action: generateNode,
+		},
+		{
+			Name:  "verify",
+			Usage: "Connect to one or more TLS ports and check that each serves a certificate chain signed by the given CA.",
+			Flags: []cli.Flag{
+				caCertFlag,
+				cli.StringSliceFlag{
+					Name:  "address",
+					Usage: "The <HOST>:<PORT> to connect to, such as localhost:18091. May be repeated. Required.",
+				},
+				cli.StringFlag{
+					Name:  "server-name",
+					Usage: "If set, also check that the served certificate is valid for this hostname or IP address.",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "How long to wait for each TLS connection.",
+					Value: 10 * time.Second,
+				},
+				awsRegionFlag,
+				localDirFlag,
+			},
+			Action: verify,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generateCa(cliContext *cli.Context) error {
+	if err := checkRequiredFlags(cliContext, "cert-out", "key-out"); err != nil {
+		return err
+	}
+
+	ca, err := certs.GenerateCa(cliContext.String("common-name"), days(cliContext.Int("validity-days")))
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(cliContext.String("cert-out"), ca.CertPem(), 0644); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(cliContext.String("key-out"), ca.KeyPem(), 0600)
+}
+
+func generateNode(cliContext *cli.Context) error {
+	if err := checkRequiredFlags(cliContext, caCertFlag.Name, caKeyFlag.Name); err != nil {
+		return err
+	}
+
+	hosts := cliContext.StringSlice("host")
+	if len(hosts) == 0 {
+		return RequiredFlagErr{Name: "host"}
+	}
+
+	resolver := newResolver(cliContext)
+
+	caCertPem, err := readPem(resolver, cliContext.String(caCertFlag.Name))
+	if err != nil {
+		return err
+	}
+
+	caKeyPem, err := readPem(resolver, cliContext.String(caKeyFlag.Name))
+	if err != nil {
+		return err
+	}
+
+	ca, err := certs.ParseCa(caCertPem, caKeyPem)
+	if err != nil {
+		return err
+	}
+
+	node, err := certs.GenerateNodeCert(ca, hosts, days(cliContext.Int("validity-days")))
+	if err != nil {
+		return err
+	}
+
+	outDir := cliContext.String("out-dir")
+	if err := os.MkdirAll(outDir, 0700); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(outDir, chainFileName), node.CertPem(), 0644); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(outDir, keyFileName), node.KeyPem(), 0600); err != nil {
+		return err
+	}
+
+	caCertOut := cliContext.String("ca-cert-out")
+	if caCertOut != "" {
+		return ioutil.WriteFile(caCertOut, ca.CertPem(), 0644)
+	}
+
+	return nil
+}
+
+func verify(cliContext *cli.Context) error {
+	if err := checkRequiredFlags(cliContext, caCertFlag.Name); err != nil {
+		return err
+	}
+
+	addresses := cliContext.StringSlice("address")
+	if len(addresses) == 0 {
+		return RequiredFlagErr{Name: "address"}
+	}
+
+	caCertPem, err := readPem(newResolver(cliContext), cliContext.String(caCertFlag.Name))
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		chain, err := certs.FetchChain(address, cliContext.Duration("timeout"))
+		if err != nil {
+			return VerifyAddressErr{Address: address, Underlying: err}
+		}
+
+		if err := certs.VerifyChain(chain, caCertPem, cliContext.String("server-name")); err != nil {
+			return VerifyAddressErr{Address: address, Underlying: err}
+		}
+
+		fmt.Fprintf(os.Stdout, "%s serves certificate %s, issued by the expected CA\n", address, chain[0].Subject)
+	}
+
+	return nil
+}
+
+// Read PEM from either a reference to a secret or a path to a file
+func readPem(resolver *credentials.Resolver, value string) ([]byte, error) {
+	if credentials.IsReference(value) {
+		pem, err := resolver.Resolve(value)
+		return []byte(pem), err
+	}
+	return ioutil.ReadFile(value)
+}
+
+func checkRequiredFlags(cliContext *cli.Context, flagNames ...string) error {
+	for _, flagName := range flagNames {
+		if cliContext.String(flagName) == "" {
+			return RequiredFlagErr{Name: flagName}
+		}
+	}
+	return nil
+}
+
+func days(numDays int) time.Duration {
+	return time.Duration(numDays) * hoursPerDay * time.Hour
+}
+
+func newResolver(cliContext *cli.Context) *credentials.Resolver {
+	resolver := credentials.NewDefaultResolver(cliContext.String(awsRegionFlag.Name))
+
+	localDir := cliContext.String(localDirFlag.Name)
+	if localDir != "" {
+		resolver.Register(credentials.SsmScheme, credentials.DirectoryBackend{Dir: localDir})
+		resolver.Register(credentials.SecretsManagerScheme, credentials.DirectoryBackend{Dir: localDir})
+	}
+
+	return resolver
+}
+
+// Custom error types
+
+type RequiredFlagErr struct {
+	Name string
+}
+
+func (err RequiredFlagErr) Error() string {
+	return fmt.Sprintf("The --%s flag is required.", err.Name)
+}
+
+type VerifyAddressErr struct {
+	Address    string
+	Underlying error
+}
+
+func (err VerifyAddressErr) Error() string {
+	return fmt.Sprintf("Failed to verify the certificate served at %s: %v", err.Address, err.Underlying)
+}
@@ -0,0 +1,357 @@
+// couchbase-dataset seeds a Couchbase bucket from a JSON Lines file, where each line is one document, and exports a
+// bucket back to JSON Lines. It is meant for loading realistic data into test and demo environments.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/dataset"
+)
+
+// This variable is set at build time using -ldflags parameters
+var VERSION string
+
+var awsRegionFlag = cli.StringFlag{
+	Name:   "aws-region",
+	Usage:  "The AWS region to use for ssm:// and secretsmanager:// password references. Default: look up the region from the AWS SDK config or EC2 metadata.",
+	EnvVar: "AWS_REGION",
+}
+
+var localDirFlag = cli.StringFlag{
+	Name:   "local-dir",
+	Usage:  "If set, resolve ssm:// and secretsmanager:// password references from files in this folder instead of AWS. Only meant for local testing.",
+	EnvVar: "COUCHBASE_SECRETS_LOCAL_DIR",
+}
+
+var connectionFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "connection-string",
+		Usage: "The Couchbase connection string of the cluster: e.g., couchbase://10.0.0.1, or couchbase://localhost:11210?network=external to connect via alternate addresses. Required.",
+	},
+	cli.StringFlag{
+		Name:  "username",
+		Usage: "The username to connect as. Required.",
+	},
+	cli.StringFlag{
+		Name:  "password",
+		Usage: "The password to connect with. May be a reference to a secret, such as ssm://<PARAMETER_NAME>. Required.",
+	},
+	cli.StringFlag{
+		Name:  "bucket",
+		Usage: "The bucket to seed or export. Required.",
+	},
+	cli.DurationFlag{
+		Name:  "connect-timeout",
+		Usage: "How long to wait for the connection to the bucket to be ready.",
+		Value: 30 * time.Second,
+	},
+	cli.DurationFlag{
+		Name:  "op-timeout",
+		Usage: "How long each write may take before it fails. Default: the Couchbase SDK's default.",
+	},
+	cli.DurationFlag{
+		Name:  "progress-interval",
+		Usage: "How often to log progress.",
+		Value: 10 * time.Second,
+	},
+	awsRegionFlag,
+	localDirFlag,
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "couchbase-dataset"
+	app.Usage = "Seed a Couchbase bucket from a JSON Lines file, or export a bucket to JSON Lines."
+	app.Version = VERSION
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "seed",
+			Usage: "Write each line of a JSON Lines file to a bucket as a document.",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "The JSON Lines file to read, or - to read from stdin. Required.",
+				},
+				cli.StringFlag{
+					Name:  "key-field",
+					Usage: "Use the value of this field of each document as its key. Nested fields are separated by dots: e.g., user.id. Exactly one of --key-field, --key-template, or --envelopes is required.",
+				},
+				cli.StringFlag{
+					Name:  "key-template",
+					Usage: "Build the key of each document from this template, where each {field} is replaced by the value of that field: e.g., user::{id}.",
+				},
+				cli.BoolFlag{
+					Name:  "envelopes",
+					Usage: "Each line is an envelope with the key, value, expiration, and flags of a document, as written by the export command.",
+				},
+				cli.DurationFlag{
+					Name:  "expiry",
+					Usage: "Make each document expire this long after it is written. Not allowed with --envelopes, which keep the expiration of each document. Default: never expire.",
+				},
+				cli.UintFlag{
+					Name:  "flags",
+					Usage: "The flags to store with each document. Not allowed with --envelopes, which keep the flags of each document. Default: the flags for JSON documents.",
+				},
+				cli.IntFlag{
+					Name:  "concurrency",
+					Usage: "How many documents to write concurrently.",
+					Value: 8,
+				},
+				cli.StringFlag{
+					Name:  "checkpoint-file",
+					Usage: "Record progress in this file, and if it already exists, skip the lines it says were written, so an interrupted run can be resumed by running the same command again.",
+				},
+			}, connectionFlags...),
+			Action: seed,
+		},
+		{
+			Name:  "export",
+			Usage: "Write every JSON document in a bucket to a JSON Lines file, in key order. The bucket needs a primary index.",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "output",
+					Usage: "The JSON Lines file to write, or - to write to stdout.",
+					Value: "-",
+				},
+				cli.StringFlag{
+					Name:  "key-field",
+					Usage: "Write each document as-is, with its key added as this field, which seed --key-field can read back. Only works for documents that are JSON objects. Default: write envelopes with the key, value, expiration, and flags of each document, which seed --envelopes can read back.",
+				},
+				cli.IntFlag{
+					Name:  "batch-size",
+					Usage: "How many documents to fetch per query.",
+					Value: 1000,
+				},
+				cli.BoolFlag{
+					Name:  "create-primary-index",
+					Usage: "Create a primary index on the bucket if it doesn't have one already.",
+				},
+			}, connectionFlags...),
+			Action: export,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func seed(cliContext *cli.Context) error {
+	if cliContext.String("file") == "" {
+		return RequiredFlagErr{Name: "file"}
+	}
+
+	keyTemplate, err := parseKeyOptions(cliContext)
+	if err != nil {
+		return err
+	}
+
+	if cliContext.Bool("envelopes") {
+		for _, flagName := range []string{"expiry", "flags"} {
+			if cliContext.IsSet(flagName) {
+				return ConflictingFlagsErr{Names: []string{flagName, "envelopes"}}
+			}
+		}
+	}
+
+	input, err := openInput(cliContext.String("file"))
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	bucket, err := connect(cliContext)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+
+	logger := newLogger()
+
+	result, err := dataset.Seed(dataset.SeedOptions{
+		Writer:           bucket,
+		Input:            input,
+		Source:           cliContext.String("file"),
+		Envelopes:        cliContext.Bool("envelopes"),
+		KeyTemplate:      keyTemplate,
+		Expiry:           cliContext.Duration("expiry"),
+		Flags:            uint32(cliContext.Uint("flags")),
+		Concurrency:      cliContext.Int("concurrency"),
+		CheckpointPath:   cliContext.String("checkpoint-file"),
+		ProgressInterval: cliContext.Duration("progress-interval"),
+		Logger:           logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.Resumed > 0 {
+		logger.Printf("Skipped %d lines that were already written according to %s", result.Resumed, cliContext.String("checkpoint-file"))
+	}
+	if result.Expired > 0 {
+		logger.Printf("Skipped %d documents that had already expired", result.Expired)
+	}
+
+	return nil
+}
+
+func export(cliContext *cli.Context) error {
+	bucket, err := connect(cliContext)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+
+	logger := newLogger()
+
+	if cliContext.Bool("create-primary-index") {
+		logger.Printf("Creating a primary index on bucket %s if it doesn't have one already", cliContext.String("bucket"))
+		if err := bucket.CreatePrimaryIndex(); err != nil {
+			return err
+		}
+	}
+
+	output, err := openOutput(cliContext.String("output"))
+	if err != nil {
+		return err
+	}
+
+	_, exportErr := dataset.Export(dataset.ExportOptions{
+		Scanner:          bucket,
+		Output:           output,
+		BatchSize:        cliContext.Int("batch-size"),
+		KeyField:         cliContext.String("key-field"),
+		ProgressInterval: cliContext.Duration("progress-interval"),
+		Logger:           logger,
+	})
+
+	if err := output.Close(); err != nil && exportErr == nil {
+		return err
+	}
+
+	return exportErr
+}
+
+// Return the key template for the --key-field or --key-template flags, or nil if --envelopes is set. Exactly one of
+// those flags must be set.
+func parseKeyOptions(cliContext *cli.Context) (*dataset.KeyTemplate, error) {
+	keyFlags := []string{}
+	for _, flagName := range []string{"key-field", "key-template", "envelopes"} {
+		if cliContext.IsSet(flagName) {
+			keyFlags = append(keyFlags, flagName)
+		}
+	}
+
+	if len(keyFlags) == 0 {
+		return nil, MissingKeyFlagErr{}
+	}
+	if len(keyFlags) > 1 {
+		return nil, ConflictingFlagsErr{Names: keyFlags}
+	}
+
+	switch keyFlags[0] {
+	case "key-field":
+		return dataset.KeyTemplateForField(cliContext.String("key-field")), nil
+	case "key-template":
+		return dataset.ParseKeyTemplate(cliContext.String("key-template"))
+	default:
+		return nil, nil
+	}
+}
+
+func connect(cliContext *cli.Context) (*dataset.CouchbaseBucket, error) {
+	for _, flagName := range []string{"connection-string", "username", "password", "bucket"} {
+		if cliContext.String(flagName) == "" {
+			return nil, RequiredFlagErr{Name: flagName}
+		}
+	}
+
+	password, err := newResolver(cliContext).Resolve(cliContext.String("password"))
+	if err != nil {
+		return nil, err
+	}
+
+	return dataset.NewCouchbaseBucket(couchbase.SdkOptions{
+		ConnectionString: cliContext.String("connection-string"),
+		Username:         cliContext.String("username"),
+		Password:         password,
+		BucketName:       cliContext.String("bucket"),
+		ConnectTimeout:   cliContext.Duration("connect-timeout"),
+		OpTimeout:        cliContext.Duration("op-timeout"),
+	})
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// Wraps stdout so closing the output doesn't close stdout
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Log to stderr, so the exported documents can go to stdout
+func newLogger() *log.Logger {
+	return log.New(os.Stderr, "[couchbase-dataset] ", log.LstdFlags)
+}
+
+func newResolver(cliContext *cli.Context) *credentials.Resolver {
+	resolver := credentials.NewDefaultResolver(cliContext.String(awsRegionFlag.Name))
+
+	localDir := cliContext.String(localDirFlag.Name)
+	if localDir != "" {
+		resolver.Register(credentials.SsmScheme, credentials.DirectoryBackend{Dir: localDir})
+		resolver.Register(credentials.SecretsManagerScheme, credentials.DirectoryBackend{Dir: localDir})
+	}
+
+	return resolver
+}
+
+// Custom error types
+
+type RequiredFlagErr struct {
+	Name string
+}
+
+func (err RequiredFlagErr) Error() string {
+	return fmt.Sprintf("The --%s flag is required.", err.Name)
+}
+
+type MissingKeyFlagErr struct{}
+
+func (err MissingKeyFlagErr) Error() string {
+	return "One of --key-field, --key-template, or --envelopes is required, so the key of each document can be determined."
+}
+
+type ConflictingFlagsErr struct {
+	Names []string
+}
+
+func (err ConflictingFlagsErr) Error() string {
+	return fmt.Sprintf("Only one of these flags may be set at a time: --%s.", strings.Join(err.Names, ", --"))
+}
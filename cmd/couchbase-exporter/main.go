@@ -0,0 +1,185 @@
+// couchbase-exporter serves the stats of the Couchbase Server and Sync Gateway on the current node as Prometheus
+// metrics. It's meant to run as a service on each node, such as under systemd, so Prometheus can scrape every node
+// directly. See the install-couchbase-server and install-sync-gateway modules for how to install it.
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/metrics"
+)
+
+// This variable is set at build time using -ldflags parameters
+var VERSION string
+
+// The default port to serve metrics on
+const defaultPort = 9420
+
+var awsRegionFlag = cli.StringFlag{
+	Name:   "aws-region",
+	Usage:  "The AWS region to use for ssm:// and secretsmanager:// password references. Default: look up the region from the AWS SDK config or EC2 metadata.",
+	EnvVar: "AWS_REGION",
+}
+
+var localDirFlag = cli.StringFlag{
+	Name:   "local-dir",
+	Usage:  "If set, resolve ssm:// and secretsmanager:// password references from files in this folder instead of AWS. Only meant for local testing.",
+	EnvVar: "COUCHBASE_SECRETS_LOCAL_DIR",
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "couchbase-exporter"
+	app.Usage = "Serve the stats of Couchbase Server and Sync Gateway as Prometheus metrics."
+	app.Version = VERSION
+
+	// Every flag can also be set via an environment variable, so the systemd unit created by the install scripts can
+	// read them from an environment file
+	app.Commands = []cli.Command{
+		{
+			Name:  "run",
+			Usage: "Serve metrics until stopped. Every scrape fetches the latest stats from Couchbase and Sync Gateway.",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:   "port",
+					Usage:  "The port to serve metrics on.",
+					Value:  defaultPort,
+					EnvVar: "COUCHBASE_EXPORTER_PORT",
+				},
+				cli.StringFlag{
+					Name:   "bind-address",
+					Usage:  "The IP address to serve metrics on. Default: all interfaces.",
+					EnvVar: "COUCHBASE_EXPORTER_BIND_ADDRESS",
+				},
+				cli.StringFlag{
+					Name:   "metrics-path",
+					Usage:  "The path to serve metrics on.",
+					Value:  "/metrics",
+					EnvVar: "COUCHBASE_EXPORTER_METRICS_PATH",
+				},
+				cli.StringFlag{
+					Name:   "couchbase-url",
+					Usage:  "The URL of the Couchbase Server REST API: e.g., http://localhost:8091. If not set, Couchbase Server metrics are not exported. At least one of --couchbase-url and --sync-gateway-url is required.",
+					EnvVar: "COUCHBASE_EXPORTER_COUCHBASE_URL",
+				},
+				cli.StringFlag{
+					Name:   "username",
+					Usage:  "The username to connect to Couchbase Server as. The read-only External Stats Reader role is enough. Required with --couchbase-url.",
+					EnvVar: "COUCHBASE_EXPORTER_USERNAME",
+				},
+				cli.StringFlag{
+					Name:   "password",
+					Usage:  "The password to connect to Couchbase Server with. May be a reference to a secret, such as ssm://<PARAMETER_NAME>. Required with --couchbase-url.",
+					EnvVar: "COUCHBASE_EXPORTER_PASSWORD",
+				},
+				cli.StringFlag{
+					Name:   "sync-gateway-url",
+					Usage:  "The URL of the Sync Gateway admin REST API: e.g., http://localhost:4985. If not set, Sync Gateway metrics are not exported.",
+					EnvVar: "COUCHBASE_EXPORTER_SYNC_GATEWAY_URL",
+				},
+				cli.DurationFlag{
+					Name:   "timeout",
+					Usage:  "How long to wait for each request to Couchbase Server or Sync Gateway. Keep this below the Prometheus scrape timeout.",
+					Value:  5 * time.Second,
+					EnvVar: "COUCHBASE_EXPORTER_TIMEOUT",
+				},
+				awsRegionFlag,
+				localDirFlag,
+			},
+			Action: runExporter,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runExporter(cliContext *cli.Context) error {
+	couchbaseUrl := cliContext.String("couchbase-url")
+	syncGatewayUrl := cliContext.String("sync-gateway-url")
+
+	if couchbaseUrl == "" && syncGatewayUrl == "" {
+		return NoSourcesErr{}
+	}
+
+	httpClient := &http.Client{Timeout: cliContext.Duration("timeout")}
+	collectors := []metrics.Collector{}
+
+	if couchbaseUrl != "" {
+		for _, flagName := range []string{"username", "password"} {
+			if cliContext.String(flagName) == "" {
+				return RequiredFlagErr{Name: flagName}
+			}
+		}
+
+		password, err := newResolver(cliContext).Resolve(cliContext.String("password"))
+		if err != nil {
+			return err
+		}
+
+		client := couchbase.NewClient(couchbaseUrl, cliContext.String("username"), password)
+		client.HttpClient = httpClient
+		collectors = append(collectors, metrics.CouchbaseCollector{Client: client})
+	}
+
+	if syncGatewayUrl != "" {
+		collectors = append(collectors, metrics.SyncGatewayCollector{Url: syncGatewayUrl, HttpClient: httpClient})
+	}
+
+	exporter := metrics.NewExporter(collectors...)
+	metricsPath := cliContext.String("metrics-path")
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, exporter)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "couchbase-exporter: metrics are at %s\n", metricsPath)
+	})
+
+	address := net.JoinHostPort(cliContext.String("bind-address"), strconv.Itoa(cliContext.Int("port")))
+	exporter.Logger.Printf("Serving metrics on %s%s", address, metricsPath)
+
+	return http.ListenAndServe(address, mux)
+}
+
+func newResolver(cliContext *cli.Context) *credentials.Resolver {
+	resolver := credentials.NewDefaultResolver(cliContext.String(awsRegionFlag.Name))
+
+	localDir := cliContext.String(localDirFlag.Name)
+	if localDir != "" {
+		resolver.Register(credentials.SsmScheme, credentials.DirectoryBackend{Dir: localDir})
+		resolver.Register(credentials.SecretsManagerScheme, credentials.DirectoryBackend{Dir: localDir})
+	}
+
+	return resolver
+}
+
+// Custom error types
+
+type RequiredFlagErr struct {
+	Name string
+}
+
+func (err RequiredFlagErr) Error() string {
+	return fmt.Sprintf("The --%s flag is required.", err.Name)
+}
+
+type NoSourcesErr struct{}
+
+func (err NoSourcesErr) Error() string {
+	return "At least one of --couchbase-url and --sync-gateway-url is required."
+}
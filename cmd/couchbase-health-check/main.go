@@ -0,0 +1,221 @@
+// couchbase-health-check serves readiness checks for the Couchbase Server and Sync Gateway on the current node, so load
+// balancer target groups can route only to nodes that are ready to serve traffic. It's meant to run as a service on each
+// node, such as under systemd. See the install-couchbase-server and install-sync-gateway modules for how to install it,
+// and the load-balancer-target-group module for how to point a target group at it.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/health"
+)
+
+// This variable is set at build time using -ldflags parameters
+var VERSION string
+
+// The default port to serve health checks on
+const defaultPort = 9421
+
+// The paths the health checks are served on
+const (
+	couchbasePath   = "/couchbase"
+	syncGatewayPath = "/sync-gateway"
+)
+
+var awsRegionFlag = cli.StringFlag{
+	Name:   "aws-region",
+	Usage:  "The AWS region to use for ssm:// and secretsmanager:// password references. Default: look up the region from the AWS SDK config or EC2 metadata.",
+	EnvVar: "AWS_REGION",
+}
+
+var localDirFlag = cli.StringFlag{
+	Name:   "local-dir",
+	Usage:  "If set, resolve ssm:// and secretsmanager:// password references from files in this folder instead of AWS. Only meant for local testing.",
+	EnvVar: "COUCHBASE_SECRETS_LOCAL_DIR",
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "couchbase-health-check"
+	app.Usage = "Serve readiness checks for Couchbase Server and Sync Gateway for load balancer health checks."
+	app.Version = VERSION
+
+	// Every flag can also be set via an environment variable, so the systemd unit created by the install scripts can
+	// read them from an environment file
+	app.Commands = []cli.Command{
+		{
+			Name:  "run",
+			Usage: fmt.Sprintf("Serve health checks until stopped. The Couchbase Server check is at %s and the Sync Gateway check is at %s. Each returns 200 if the service is ready and 503 if it isn't.", couchbasePath, syncGatewayPath),
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:   "port",
+					Usage:  "The port to serve health checks on.",
+					Value:  defaultPort,
+					EnvVar: "COUCHBASE_HEALTH_CHECK_PORT",
+				},
+				cli.StringFlag{
+					Name:   "bind-address",
+					Usage:  "The IP address to serve health checks on. Default: all interfaces.",
+					EnvVar: "COUCHBASE_HEALTH_CHECK_BIND_ADDRESS",
+				},
+				cli.StringFlag{
+					Name:   "couchbase-url",
+					Usage:  "The URL of the Couchbase Server REST API on this node: e.g., http://localhost:8091. If not set, the Couchbase Server check is not served. At least one of --couchbase-url and --sync-gateway-url is required.",
+					EnvVar: "COUCHBASE_HEALTH_CHECK_COUCHBASE_URL",
+				},
+				cli.StringFlag{
+					Name:   "username",
+					Usage:  "The username to connect to Couchbase Server as. Any role can read the cluster state, so the read-only External Stats Reader role is enough. Required with --couchbase-url.",
+					EnvVar: "COUCHBASE_HEALTH_CHECK_USERNAME",
+				},
+				cli.StringFlag{
+					Name:   "password",
+					Usage:  "The password to connect to Couchbase Server with. May be a reference to a secret, such as ssm://<PARAMETER_NAME>. Required with --couchbase-url.",
+					EnvVar: "COUCHBASE_HEALTH_CHECK_PASSWORD",
+				},
+				cli.BoolFlag{
+					Name:   "wait-for-warmup",
+					Usage:  "If set, the Couchbase Server check fails while any bucket on this node is warming up.",
+					EnvVar: "COUCHBASE_HEALTH_CHECK_WAIT_FOR_WARMUP",
+				},
+				cli.BoolFlag{
+					Name:   "wait-for-rebalance",
+					Usage:  "If set, the Couchbase Server check fails while a rebalance is running. Note that this fails the check on every node in the cluster at once.",
+					EnvVar: "COUCHBASE_HEALTH_CHECK_WAIT_FOR_REBALANCE",
+				},
+				cli.StringFlag{
+					Name:   "sync-gateway-url",
+					Usage:  "The URL of the Sync Gateway admin REST API on this node: e.g., http://localhost:4985. If not set, the Sync Gateway check is not served.",
+					EnvVar: "COUCHBASE_HEALTH_CHECK_SYNC_GATEWAY_URL",
+				},
+				cli.StringSliceFlag{
+					Name:   "sync-gateway-database",
+					Usage:  "A Sync Gateway database that must be Online for the Sync Gateway check to pass. May be specified more than once. Default: every database Sync Gateway is configured with.",
+					EnvVar: "COUCHBASE_HEALTH_CHECK_SYNC_GATEWAY_DATABASES",
+				},
+				cli.DurationFlag{
+					Name:   "timeout",
+					Usage:  "How long to wait for each request to Couchbase Server or Sync Gateway. Keep this below the timeout of the load balancer health check.",
+					Value:  2 * time.Second,
+					EnvVar: "COUCHBASE_HEALTH_CHECK_TIMEOUT",
+				},
+				awsRegionFlag,
+				localDirFlag,
+			},
+			Action: runHealthCheck,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runHealthCheck(cliContext *cli.Context) error {
+	couchbaseUrl := cliContext.String("couchbase-url")
+	syncGatewayUrl := cliContext.String("sync-gateway-url")
+
+	if couchbaseUrl == "" && syncGatewayUrl == "" {
+		return NoSourcesErr{}
+	}
+
+	httpClient := &http.Client{Timeout: cliContext.Duration("timeout")}
+	mux := http.NewServeMux()
+	paths := []string{}
+
+	if couchbaseUrl != "" {
+		for _, flagName := range []string{"username", "password"} {
+			if cliContext.String(flagName) == "" {
+				return RequiredFlagErr{Name: flagName}
+			}
+		}
+
+		password, err := newResolver(cliContext).Resolve(cliContext.String("password"))
+		if err != nil {
+			return err
+		}
+
+		client := couchbase.NewClient(couchbaseUrl, cliContext.String("username"), password)
+		client.HttpClient = httpClient
+
+		mux.Handle(couchbasePath, health.Handler{Checker: health.CouchbaseChecker{
+			Client:           client,
+			WaitForWarmup:    cliContext.Bool("wait-for-warmup"),
+			WaitForRebalance: cliContext.Bool("wait-for-rebalance"),
+		}})
+		paths = append(paths, couchbasePath)
+	}
+
+	if syncGatewayUrl != "" {
+		mux.Handle(syncGatewayPath, health.Handler{Checker: health.SyncGatewayChecker{
+			Url:        syncGatewayUrl,
+			HttpClient: httpClient,
+			Databases:  nonEmpty(cliContext.StringSlice("sync-gateway-database")),
+		}})
+		paths = append(paths, syncGatewayPath)
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "couchbase-health-check: health checks are at %v\n", paths)
+	})
+
+	address := net.JoinHostPort(cliContext.String("bind-address"), strconv.Itoa(cliContext.Int("port")))
+	log.Printf("Serving health checks %v on %s", paths, address)
+
+	return http.ListenAndServe(address, mux)
+}
+
+// nonEmpty returns the non-empty values in the given list. The install scripts always write every setting to the
+// environment file, so an empty list of databases shows up as a single empty value.
+func nonEmpty(values []string) []string {
+	out := []string{}
+	for _, value := range values {
+		if value != "" {
+			out = append(out, value)
+		}
+	}
+	return out
+}
+
+func newResolver(cliContext *cli.Context) *credentials.Resolver {
+	resolver := credentials.NewDefaultResolver(cliContext.String(awsRegionFlag.Name))
+
+	localDir := cliContext.String(localDirFlag.Name)
+	if localDir != "" {
+		resolver.Register(credentials.SsmScheme, credentials.DirectoryBackend{Dir: localDir})
+		resolver.Register(credentials.SecretsManagerScheme, credentials.DirectoryBackend{Dir: localDir})
+	}
+
+	return resolver
+}
+
+// Custom error types
+
+type RequiredFlagErr struct {
+	Name string
+}
+
+func (err RequiredFlagErr) Error() string {
+	return fmt.Sprintf("The --%s flag is required.", err.Name)
+}
+
+type NoSourcesErr struct{}
+
+func (err NoSourcesErr) Error() string {
+	return "At least one of --couchbase-url and --sync-gateway-url is required."
+}
@@ -0,0 +1,273 @@
+// couchbase-loadgen puts a configurable key-value workload on a Couchbase bucket via the Couchbase Go SDK and reports
+// the throughput and latency percentiles of each type of operation as JSON. It is meant to help size instance types and
+// validate memory quota settings before going to production.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/loadgen"
+)
+
+// This variable is set at build time using -ldflags parameters
+var VERSION string
+
+var awsRegionFlag = cli.StringFlag{
+	Name:   "aws-region",
+	Usage:  "The AWS region to use for ssm:// and secretsmanager:// password references. Default: look up the region from the AWS SDK config or EC2 metadata.",
+	EnvVar: "AWS_REGION",
+}
+
+var localDirFlag = cli.StringFlag{
+	Name:   "local-dir",
+	Usage:  "If set, resolve ssm:// and secretsmanager:// password references from files in this folder instead of AWS. Only meant for local testing.",
+	EnvVar: "COUCHBASE_SECRETS_LOCAL_DIR",
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "couchbase-loadgen"
+	app.Usage = "Put a key-value workload on a Couchbase bucket and report throughput and latency percentiles as JSON."
+	app.Version = VERSION
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "run",
+			Usage: "Run a mix of get, upsert, and delete operations against a bucket and write a JSON report to stdout.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "connection-string",
+					Usage: "The Couchbase connection string of the cluster: e.g., couchbase://10.0.0.1, or couchbase://localhost:11210?network=external to connect via alternate addresses. Required.",
+				},
+				cli.StringFlag{
+					Name:  "username",
+					Usage: "The username to connect as. The user needs read and write access to --bucket. Required.",
+				},
+				cli.StringFlag{
+					Name:  "password",
+					Usage: "The password to connect with. May be a reference to a secret, such as ssm://<PARAMETER_NAME>. Required.",
+				},
+				cli.StringFlag{
+					Name:  "bucket",
+					Usage: "The bucket to run the workload against. Required.",
+				},
+				cli.IntFlag{
+					Name:  "workers",
+					Usage: "How many workers run operations concurrently.",
+					Value: 8,
+				},
+				cli.Float64Flag{
+					Name:  "target-ops-per-sec",
+					Usage: "The total number of operations per second to aim for across all workers. Default: as fast as possible.",
+				},
+				cli.DurationFlag{
+					Name:  "duration",
+					Usage: "How long to run the workload for.",
+					Value: 60 * time.Second,
+				},
+				cli.Int64Flag{
+					Name:  "operations",
+					Usage: "Stop after this many operations in total, or after --duration, whichever comes first. Default: no limit.",
+				},
+				cli.StringFlag{
+					Name:  "mix",
+					Usage: "The relative weight of each operation, as a comma-separated list of OP=WEIGHT pairs, where OP is one of get, upsert, or delete.",
+					Value: "get=80,upsert=20",
+				},
+				cli.IntFlag{
+					Name:  "keys",
+					Usage: "How many distinct keys the workload uses.",
+					Value: 10000,
+				},
+				cli.StringFlag{
+					Name:  "key-prefix",
+					Usage: "The prefix of every key the workload uses.",
+					Value: "loadgen-",
+				},
+				cli.StringFlag{
+					Name:  "key-distribution",
+					Usage: fmt.Sprintf("How to pick the key for each operation. Must be one of: %s, %s (a few keys are much hotter than the rest).", loadgen.KeyDistributionUniform, loadgen.KeyDistributionZipf),
+					Value: loadgen.KeyDistributionUniform,
+				},
+				cli.Float64Flag{
+					Name:  "zipf-exponent",
+					Usage: "How skewed the zipf key distribution is. Must be greater than 1. Higher values make the hot keys hotter.",
+					Value: 1.1,
+				},
+				cli.IntFlag{
+					Name:  "min-doc-size",
+					Usage: "The minimum size, in bytes, of the documents the workload writes.",
+					Value: 1024,
+				},
+				cli.IntFlag{
+					Name:  "max-doc-size",
+					Usage: "The maximum size, in bytes, of the documents the workload writes. Document sizes are picked uniformly at random between --min-doc-size and --max-doc-size.",
+					Value: 1024,
+				},
+				cli.BoolFlag{
+					Name:  "populate",
+					Usage: "Write every key once before starting the workload, so get and delete operations find documents. These writes are not included in the report.",
+				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Usage: "Seed the random numbers that pick operations, keys, and document sizes, so runs are repeatable. Default: a random seed.",
+				},
+				cli.DurationFlag{
+					Name:  "connect-timeout",
+					Usage: "How long to wait for the connection to the bucket to be ready.",
+					Value: 30 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:  "op-timeout",
+					Usage: "How long each operation may take before it counts as an error. Default: the Couchbase SDK's default.",
+				},
+				awsRegionFlag,
+				localDirFlag,
+			},
+			Action: run,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(cliContext *cli.Context) error {
+	for _, flagName := range []string{"connection-string", "username", "password", "bucket"} {
+		if cliContext.String(flagName) == "" {
+			return RequiredFlagErr{Name: flagName}
+		}
+	}
+
+	mix, err := parseMix(cliContext.String("mix"))
+	if err != nil {
+		return err
+	}
+
+	password, err := newResolver(cliContext).Resolve(cliContext.String("password"))
+	if err != nil {
+		return err
+	}
+
+	logger := log.New(os.Stderr, "[couchbase-loadgen] ", log.LstdFlags)
+	logger.Printf("Connecting to bucket %s at %s", cliContext.String("bucket"), cliContext.String("connection-string"))
+
+	store, err := loadgen.NewCouchbaseStore(couchbase.SdkOptions{
+		ConnectionString: cliContext.String("connection-string"),
+		Username:         cliContext.String("username"),
+		Password:         password,
+		BucketName:       cliContext.String("bucket"),
+		ConnectTimeout:   cliContext.Duration("connect-timeout"),
+		OpTimeout:        cliContext.Duration("op-timeout"),
+	})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := loadgen.Run(loadgen.Options{
+		Store:           store,
+		Workers:         cliContext.Int("workers"),
+		TargetOpsPerSec: cliContext.Float64("target-ops-per-sec"),
+		Duration:        cliContext.Duration("duration"),
+		Operations:      cliContext.Int64("operations"),
+		Mix:             mix,
+		NumKeys:         cliContext.Int("keys"),
+		KeyPrefix:       cliContext.String("key-prefix"),
+		KeyDistribution: cliContext.String("key-distribution"),
+		ZipfExponent:    cliContext.Float64("zipf-exponent"),
+		MinDocSize:      cliContext.Int("min-doc-size"),
+		MaxDocSize:      cliContext.Int("max-doc-size"),
+		Populate:        cliContext.Bool("populate"),
+		Seed:            cliContext.Int64("seed"),
+		Logger:          logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeReport(os.Stdout, report)
+}
+
+// Parse an operation mix of the format get=80,upsert=15,delete=5. Operations that are left out get a weight of zero.
+func parseMix(value string) (loadgen.Mix, error) {
+	mix := loadgen.Mix{}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return mix, InvalidMixErr{Value: value}
+		}
+
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil || weight < 0 {
+			return mix, InvalidMixErr{Value: value}
+		}
+
+		switch parts[0] {
+		case loadgen.OpGet:
+			mix.Get = weight
+		case loadgen.OpUpsert:
+			mix.Upsert = weight
+		case loadgen.OpDelete:
+			mix.Delete = weight
+		default:
+			return mix, InvalidMixErr{Value: value}
+		}
+	}
+
+	if mix == (loadgen.Mix{}) {
+		return mix, InvalidMixErr{Value: value}
+	}
+
+	return mix, nil
+}
+
+func writeReport(writer io.Writer, report *loadgen.Report) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func newResolver(cliContext *cli.Context) *credentials.Resolver {
+	resolver := credentials.NewDefaultResolver(cliContext.String(awsRegionFlag.Name))
+
+	localDir := cliContext.String(localDirFlag.Name)
+	if localDir != "" {
+		resolver.Register(credentials.SsmScheme, credentials.DirectoryBackend{Dir: localDir})
+		resolver.Register(credentials.SecretsManagerScheme, credentials.DirectoryBackend{Dir: localDir})
+	}
+
+	return resolver
+}
+
+// Custom error types
+
+type RequiredFlagErr struct {
+	Name string
+}
+
+func (err RequiredFlagErr) Error() string {
+	return fmt.Sprintf("The --%s flag is required.", err.Name)
+}
+
+type InvalidMixErr struct {
+	Value string
+}
+
+func (err InvalidMixErr) Error() string {
+	return fmt.Sprintf("Invalid --mix '%s'. Must be a comma-separated list of OP=WEIGHT pairs, where OP is one of %s, %s, or %s, WEIGHT is a non-negative integer, and at least one WEIGHT is positive: e.g., get=80,upsert=15,delete=5.", err.Value, loadgen.OpGet, loadgen.OpUpsert, loadgen.OpDelete)
+}
@@ -0,0 +1,382 @@
+// couchbase-rotate-password rotates the password of a Couchbase cluster's admin account or of one of its RBAC users,
+// updating the XDCR remote cluster references and Sync Gateway configs that depend on that password along the way.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/rotation"
+)
+
+// This variable is set at build time using -ldflags parameters
+var VERSION string
+
+const defaultRemoteCommand = "/opt/couchbase-commons/bin/couchbase-rotate-password"
+const defaultSyncGatewayConfig = "/home/sync_gateway/sync_gateway.json"
+const defaultSyncGatewayService = "sync_gateway"
+const defaultSyncGatewayUrl = "http://localhost:4984"
+
+const executorSsm = "ssm"
+const executorDocker = "docker"
+const executorLocal = "local"
+
+var awsRegionFlag = cli.StringFlag{
+	Name:   "aws-region",
+	Usage:  "The AWS region to use for ssm:// and secretsmanager:// password references and for the ssm executor. Default: look up the region from the AWS SDK config or EC2 metadata.",
+	EnvVar: "AWS_REGION",
+}
+
+var localDirFlag = cli.StringFlag{
+	Name:   "local-dir",
+	Usage:  "If set, resolve ssm:// and secretsmanager:// password references from files in this folder instead of AWS. Only meant for local testing.",
+	EnvVar: "COUCHBASE_SECRETS_LOCAL_DIR",
+}
+
+var newPasswordFlag = cli.StringFlag{
+	Name:  "new-password",
+	Usage: "The new password. May be a reference to a secret, such as ssm://<PARAMETER_NAME>, which is also what gets passed on to the Sync Gateway nodes, so the plaintext password never shows up in their command history. Must be a reference if there are Sync Gateway nodes to update with the ssm or docker executor. Required.",
+}
+
+var syncGatewayConfigFlag = cli.StringFlag{
+	Name:  "sync-gateway-config",
+	Usage: "The path of the Sync Gateway config file on each Sync Gateway node.",
+	Value: defaultSyncGatewayConfig,
+}
+
+var syncGatewayServiceFlag = cli.StringFlag{
+	Name:  "sync-gateway-service",
+	Usage: "The name of the systemd service that runs Sync Gateway on each Sync Gateway node.",
+	Value: defaultSyncGatewayService,
+}
+
+var syncGatewayUrlFlag = cli.StringFlag{
+	Name:  "sync-gateway-url",
+	Usage: "The URL at which each Sync Gateway node can reach its own Sync Gateway REST API, used to check each database is back online after the restart.",
+	Value: defaultSyncGatewayUrl,
+}
+
+var syncGatewayDatabaseFlag = cli.StringSliceFlag{
+	Name:  "sync-gateway-database",
+	Usage: "Only update this Sync Gateway database. May be repeated. Default: update every database that connects to Couchbase as the user whose password is rotated.",
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "couchbase-rotate-password"
+	app.Usage = "Rotate the password of a Couchbase admin account or RBAC user, along with the XDCR remote cluster references and Sync Gateway configs that use it."
+	app.Version = VERSION
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "rotate",
+			Usage: "Change the password in the cluster, update every XDCR remote cluster reference that uses it, and update and restart the Sync Gateway nodes that use it, one at a time.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "cluster-url",
+					Usage: "The URL of any node in the cluster in which to rotate the password: e.g., http://10.0.0.1:8091. Required.",
+				},
+				cli.StringFlag{
+					Name:  "username",
+					Usage: "The username of the cluster's admin account. Required.",
+				},
+				cli.StringFlag{
+					Name:  "password",
+					Usage: "The current password of the cluster's admin account. May be a reference to a secret, such as ssm://<PARAMETER_NAME>. Required.",
+				},
+				cli.StringFlag{
+					Name:  "user",
+					Usage: "The RBAC user whose password to rotate. Default: rotate the password of the admin account.",
+				},
+				newPasswordFlag,
+				cli.StringSliceFlag{
+					Name:  "xdcr-source-cluster-url",
+					Usage: "The URL of a cluster that replicates into this cluster using XDCR. Every remote cluster reference in it that points to this cluster and uses the rotated user is updated. May be repeated.",
+				},
+				cli.StringFlag{
+					Name:  "xdcr-source-username",
+					Usage: "The username of the admin account of the --xdcr-source-cluster-url clusters. Default: the value of --username.",
+				},
+				cli.StringFlag{
+					Name:  "xdcr-source-password",
+					Usage: "The password of the admin account of the --xdcr-source-cluster-url clusters. May be a reference to a secret. Default: the value of --password.",
+				},
+				cli.StringSliceFlag{
+					Name:  "xdcr-reference",
+					Usage: "Only update the XDCR remote cluster reference with this name. May be repeated. Default: update every reference that points to this cluster.",
+				},
+				cli.StringSliceFlag{
+					Name:  "sync-gateway-node",
+					Usage: "A Sync Gateway node to update: an EC2 Instance ID with the ssm executor, a container name with the docker executor. May be repeated.",
+				},
+				cli.StringFlag{
+					Name:  "sync-gateway-asg-name",
+					Usage: "Update all the Sync Gateway nodes in this Auto Scaling Group. Only works with the ssm executor.",
+				},
+				cli.StringFlag{
+					Name:  "sync-gateway-executor",
+					Usage: fmt.Sprintf("How to run commands on the Sync Gateway nodes. Must be one of: %s (SSM Run Command), %s (docker exec, for local testing), or %s (run on this machine).", executorSsm, executorDocker, executorLocal),
+					Value: executorSsm,
+				},
+				cli.StringFlag{
+					Name:  "sync-gateway-command",
+					Usage: "The path of the couchbase-rotate-password binary on the Sync Gateway nodes.",
+					Value: defaultRemoteCommand,
+				},
+				syncGatewayConfigFlag,
+				syncGatewayServiceFlag,
+				syncGatewayUrlFlag,
+				syncGatewayDatabaseFlag,
+				cli.StringFlag{
+					Name:  "sync-gateway-local-dir",
+					Usage: "If set, the Sync Gateway nodes resolve ssm:// and secretsmanager:// password references from files in this folder on the node instead of AWS. Only meant for local testing.",
+				},
+				awsRegionFlag,
+				localDirFlag,
+			},
+			Action: rotate,
+		},
+		{
+			Name:  "update-sync-gateway",
+			Usage: "Update the password in the Sync Gateway config on this node, restart Sync Gateway, and wait for it to come back online. The rotate command runs this on each Sync Gateway node.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "username",
+					Usage: "Update the password of every database that connects to Couchbase as this user. Required.",
+				},
+				newPasswordFlag,
+				cli.StringFlag{
+					Name:  "config",
+					Usage: "The path of the Sync Gateway config file.",
+					Value: defaultSyncGatewayConfig,
+				},
+				cli.StringFlag{
+					Name:  "service",
+					Usage: "The name of the systemd service that runs Sync Gateway.",
+					Value: defaultSyncGatewayService,
+				},
+				cli.StringFlag{
+					Name:  "url",
+					Usage: "The URL of the Sync Gateway REST API, used to check each database is back online after the restart.",
+					Value: defaultSyncGatewayUrl,
+				},
+				cli.StringSliceFlag{
+					Name:  "database",
+					Usage: "Only update this database. May be repeated. Default: update every database that connects as --username.",
+				},
+				awsRegionFlag,
+				localDirFlag,
+			},
+			Action: updateSyncGateway,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func rotate(cliContext *cli.Context) error {
+	for _, flagName := range []string{"cluster-url", "username", "password", "new-password"} {
+		if cliContext.String(flagName) == "" {
+			return RequiredFlagErr{Name: flagName}
+		}
+	}
+
+	resolver := newResolver(cliContext)
+
+	password, err := resolver.Resolve(cliContext.String("password"))
+	if err != nil {
+		return err
+	}
+
+	newPassword, err := resolver.Resolve(cliContext.String("new-password"))
+	if err != nil {
+		return err
+	}
+
+	username := cliContext.String("username")
+
+	xdcrSources, err := newXdcrSourceClients(cliContext, resolver, username, password)
+	if err != nil {
+		return err
+	}
+
+	syncGatewayNodes, executor, err := getSyncGatewayNodes(cliContext)
+	if err != nil {
+		return err
+	}
+
+	// The ssm and docker executors record the commands they run, in the SSM command history and in the process
+	// listings of the host, so only ever pass them a reference to the new password, never the plaintext
+	executorName := cliContext.String("sync-gateway-executor")
+	if len(syncGatewayNodes) > 0 && executorName != executorLocal && !credentials.IsReference(cliContext.String("new-password")) {
+		return PlaintextNewPasswordErr{Executor: executorName}
+	}
+
+	userToRotate := cliContext.String("user")
+	if userToRotate == "" {
+		userToRotate = username
+	}
+
+	// Pass the new password on as given, so if it's a reference, only the reference ends up in the command history of
+	// the Sync Gateway nodes
+	syncGatewayCommand := []string{
+		cliContext.String("sync-gateway-command"),
+		"update-sync-gateway",
+		"--username", userToRotate,
+		"--new-password", cliContext.String("new-password"),
+		"--config", cliContext.String("sync-gateway-config"),
+		"--service", cliContext.String("sync-gateway-service"),
+		"--url", cliContext.String("sync-gateway-url"),
+	}
+	for _, database := range cliContext.StringSlice("sync-gateway-database") {
+		syncGatewayCommand = append(syncGatewayCommand, "--database", database)
+	}
+	if localDir := cliContext.String("sync-gateway-local-dir"); localDir != "" {
+		syncGatewayCommand = append(syncGatewayCommand, "--local-dir", localDir)
+	}
+
+	return rotation.Rotate(rotation.Options{
+		Cluster:             couchbase.NewClient(cliContext.String("cluster-url"), username, password),
+		Username:            userToRotate,
+		NewPassword:         newPassword,
+		XdcrSources:         xdcrSources,
+		XdcrReferenceNames:  cliContext.StringSlice("xdcr-reference"),
+		SyncGatewayNodes:    syncGatewayNodes,
+		SyncGatewayExecutor: executor,
+		SyncGatewayCommand:  syncGatewayCommand,
+		Logger:              newLogger(),
+	})
+}
+
+func newXdcrSourceClients(cliContext *cli.Context, resolver *credentials.Resolver, defaultUsername string, defaultPassword string) ([]*couchbase.Client, error) {
+	username := cliContext.String("xdcr-source-username")
+	if username == "" {
+		username = defaultUsername
+	}
+
+	password := defaultPassword
+	if cliContext.String("xdcr-source-password") != "" {
+		resolvedPassword, err := resolver.Resolve(cliContext.String("xdcr-source-password"))
+		if err != nil {
+			return nil, err
+		}
+		password = resolvedPassword
+	}
+
+	clients := []*couchbase.Client{}
+	for _, sourceUrl := range cliContext.StringSlice("xdcr-source-cluster-url") {
+		clients = append(clients, couchbase.NewClient(sourceUrl, username, password))
+	}
+
+	return clients, nil
+}
+
+func getSyncGatewayNodes(cliContext *cli.Context) ([]string, rotation.NodeExecutor, error) {
+	nodes := cliContext.StringSlice("sync-gateway-node")
+	asgName := cliContext.String("sync-gateway-asg-name")
+
+	switch cliContext.String("sync-gateway-executor") {
+	case executorSsm:
+		awsSession := credentials.NewLazyAwsSession(cliContext.String(awsRegionFlag.Name))
+		if asgName != "" {
+			asgNodes, err := rotation.LookupAsgInstanceIds(awsSession, asgName)
+			if err != nil {
+				return nil, nil, err
+			}
+			nodes = append(nodes, asgNodes...)
+		}
+		return nodes, &rotation.SsmExecutor{Session: awsSession, SleepBetweenPolls: 5 * time.Second}, nil
+	case executorDocker:
+		if asgName != "" {
+			return nil, nil, AsgRequiresSsmErr{}
+		}
+		return nodes, rotation.DockerExecutor{}, nil
+	case executorLocal:
+		if asgName != "" {
+			return nil, nil, AsgRequiresSsmErr{}
+		}
+		return nodes, rotation.LocalExecutor{}, nil
+	default:
+		return nil, nil, UnsupportedExecutorErr{Name: cliContext.String("sync-gateway-executor")}
+	}
+}
+
+func updateSyncGateway(cliContext *cli.Context) error {
+	for _, flagName := range []string{"username", "new-password"} {
+		if cliContext.String(flagName) == "" {
+			return RequiredFlagErr{Name: flagName}
+		}
+	}
+
+	newPassword, err := newResolver(cliContext).Resolve(cliContext.String("new-password"))
+	if err != nil {
+		return err
+	}
+
+	return rotation.UpdateSyncGateway(rotation.SyncGatewayOptions{
+		ConfigPath:     cliContext.String("config"),
+		Username:       cliContext.String("username"),
+		NewPassword:    newPassword,
+		Databases:      cliContext.StringSlice("database"),
+		ServiceName:    cliContext.String("service"),
+		SyncGatewayUrl: cliContext.String("url"),
+		Logger:         newLogger(),
+	})
+}
+
+func newResolver(cliContext *cli.Context) *credentials.Resolver {
+	resolver := credentials.NewDefaultResolver(cliContext.String(awsRegionFlag.Name))
+
+	localDir := cliContext.String(localDirFlag.Name)
+	if localDir != "" {
+		resolver.Register(credentials.SsmScheme, credentials.DirectoryBackend{Dir: localDir})
+		resolver.Register(credentials.SecretsManagerScheme, credentials.DirectoryBackend{Dir: localDir})
+	}
+
+	return resolver
+}
+
+func newLogger() *log.Logger {
+	return log.New(os.Stderr, "[couchbase-rotate-password] ", log.LstdFlags)
+}
+
+// Custom error types
+
+type RequiredFlagErr struct {
+	Name string
+}
+
+func (err RequiredFlagErr) Error() string {
+	return fmt.Sprintf("The --%s flag is required.", err.Name)
+}
+
+type UnsupportedExecutorErr struct {
+	Name string
+}
+
+func (err UnsupportedExecutorErr) Error() string {
+	return fmt.Sprintf("Unsupported Sync Gateway executor '%s'. Must be one of: %s, %s, %s.", err.Name, executorSsm, executorDocker, executorLocal)
+}
+
+type PlaintextNewPasswordErr struct {
+	Executor string
+}
+
+func (err PlaintextNewPasswordErr) Error() string {
+	return fmt.Sprintf("The %s executor records the commands it runs on the Sync Gateway nodes, so --new-password must be a reference to a secret, such as ssm://<PARAMETER_NAME>, rather than a plaintext password.", err.Executor)
+}
+
+type AsgRequiresSsmErr struct{}
+
+func (err AsgRequiresSsmErr) Error() string {
+	return fmt.Sprintf("The --sync-gateway-asg-name flag only works with the %s executor.", executorSsm)
+}
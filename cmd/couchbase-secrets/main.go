@@ -0,0 +1,75 @@
+// couchbase-secrets resolves references to credentials, such as ssm:///couchbase/admin-password, into their plaintext
+// values. It is used by the run-couchbase-server, run-replication, and run-sync-gateway scripts so they can accept
+// references to credentials instead of plaintext credentials.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+)
+
+// This variable is set at build time using -ldflags parameters
+var VERSION string
+
+const awsRegionFlagName = "aws-region"
+const localDirFlagName = "local-dir"
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "couchbase-secrets"
+	app.Usage = "Resolve references to Couchbase credentials (env://, file://, ssm://, secretsmanager://) into their plaintext values."
+	app.Version = VERSION
+
+	app.Commands = []cli.Command{
+		{
+			Name:      "resolve",
+			Usage:     "Resolve a single reference and write its plaintext value to stdout. Values that are not references are written out unchanged.",
+			ArgsUsage: "<REFERENCE>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   awsRegionFlagName,
+					Usage:  "The AWS region to use for ssm:// and secretsmanager:// references. Default: look up the region from the AWS SDK config or EC2 metadata.",
+					EnvVar: "AWS_REGION",
+				},
+				cli.StringFlag{
+					Name:   localDirFlagName,
+					Usage:  "If set, resolve ssm:// and secretsmanager:// references from files in this folder instead of AWS. Only meant for local testing.",
+					EnvVar: "COUCHBASE_SECRETS_LOCAL_DIR",
+				},
+			},
+			Action: resolve,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func resolve(cliContext *cli.Context) error {
+	if cliContext.NArg() != 1 {
+		return cli.NewExitError("The resolve command takes exactly one argument: the reference to resolve.", 1)
+	}
+
+	resolver := credentials.NewDefaultResolver(cliContext.String(awsRegionFlagName))
+
+	localDir := cliContext.String(localDirFlagName)
+	if localDir != "" {
+		resolver.Register(credentials.SsmScheme, credentials.DirectoryBackend{Dir: localDir})
+		resolver.Register(credentials.SecretsManagerScheme, credentials.DirectoryBackend{Dir: localDir})
+	}
+
+	secret, err := resolver.Resolve(cliContext.Args().First())
+	if err != nil {
+		return err
+	}
+
+	// Intentionally no trailing new line, so callers can use the output as-is
+	_, err = fmt.Fprint(os.Stdout, secret)
+	return err
+}
@@ -58,14 +58,19 @@ module "couchbase_data_nodes" {
   # replaced with a new one.
   health_check_type = "ELB"
 
-  # An example of custom tags
-  tags = [
-    {
-      key                 = "Environment"
-      value               = "development"
-      propagate_at_launch = true
-    },
-  ]
+  # An example of custom tags, along with the tags in var.custom_tags
+  tags = concat(
+    [
+      {
+        key                 = "Environment"
+        value               = "development"
+        propagate_at_launch = true
+      },
+    ],
+    [for key, value in var.custom_tags : { key = key, value = value, propagate_at_launch = true }]
+  )
+
+  security_group_tags = var.custom_tags
 }
 
 # ---------------------------------------------------------------------------------------------------------------------
@@ -115,14 +120,19 @@ module "couchbase_index_query_search_nodes" {
   # replaced with a new one.
   health_check_type = "ELB"
 
-  # An example of custom tags
-  tags = [
-    {
-      key                 = "Environment"
-      value               = "development"
-      propagate_at_launch = true
-    },
-  ]
+  # An example of custom tags, along with the tags in var.custom_tags
+  tags = concat(
+    [
+      {
+        key                 = "Environment"
+        value               = "development"
+        propagate_at_launch = true
+      },
+    ],
+    [for key, value in var.custom_tags : { key = key, value = value, propagate_at_launch = true }]
+  )
+
+  security_group_tags = var.custom_tags
 }
 
 # ---------------------------------------------------------------------------------------------------------------------
@@ -163,14 +173,19 @@ module "sync_gateway" {
   # replaced with a new one.
   health_check_type = "ELB"
 
-  # An example of custom tags
-  tags = [
-    {
-      key                 = "Environment"
-      value               = "development"
-      propagate_at_launch = true
-    },
-  ]
+  # An example of custom tags, along with the tags in var.custom_tags
+  tags = concat(
+    [
+      {
+        key                 = "Environment"
+        value               = "development"
+        propagate_at_launch = true
+      },
+    ],
+    [for key, value in var.custom_tags : { key = key, value = value, propagate_at_launch = true }]
+  )
+
+  security_group_tags = var.custom_tags
 }
 
 # ---------------------------------------------------------------------------------------------------------------------
@@ -183,6 +198,11 @@ data "template_file" "user_data_couchbase_data_nodes" {
   vars = {
     cluster_asg_name = var.couchbase_data_node_cluster_name
     cluster_port     = module.couchbase_data_nodes_security_group_rules.rest_port
+    cluster_username = var.cluster_username
+    cluster_password = var.cluster_password
+
+    # The password of the user the User Data script creates for testing
+    test_user_password = var.test_user_password
 
     # Pass in the data about the EBS volumes so they can be mounted
     data_volume_device_name = var.data_volume_device_name
@@ -203,6 +223,8 @@ data "template_file" "user_data_couchbase_index_query_search_nodes" {
   vars = {
     cluster_asg_name = var.couchbase_data_node_cluster_name
     cluster_port     = module.couchbase_data_nodes_security_group_rules.rest_port
+    cluster_username = var.cluster_username
+    cluster_password = var.cluster_password
 
     # Pass in the data about the EBS volumes so they can be mounted
     index_volume_device_name = var.index_volume_device_name
@@ -215,8 +237,9 @@ data "template_file" "user_data_sync_gateway" {
   template = file("${path.module}/user-data/user-data-sync-gateway.sh")
 
   vars = {
-    cluster_asg_name = var.couchbase_data_node_cluster_name
-    cluster_port     = module.couchbase_data_nodes_security_group_rules.rest_port
+    cluster_asg_name   = var.couchbase_data_node_cluster_name
+    cluster_port       = module.couchbase_data_nodes_security_group_rules.rest_port
+    test_user_password = var.test_user_password
 
     # We expose the Sync Gateway on all IPs but the Sync Gateway Admin should ONLY be accessible from localhost, as it
     # provides admin access to ALL Sync Gateway data.
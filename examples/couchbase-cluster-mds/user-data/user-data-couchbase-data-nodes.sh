@@ -42,6 +42,11 @@ function run_couchbase {
     --data-ramsize "$data_ramsize" \
     --index-ramsize "$index_ramsize" \
     --fts-ramsize "$fts_ramsize" \
+    --auto-failover-timeout 30 \
+    --auto-failover-max-count 2 \
+    --auto-failover-server-groups \
+    --auto-failover-on-disk-issues 60 \
+    --auto-reprovision-max-nodes 2 \
     --wait-for-all-nodes
 }
 
@@ -100,11 +105,11 @@ function run {
   local readonly index_ramsize="$7"
   local readonly fts_ramsize="$8"
 
-  # To keep this example simple, we are hard-coding all credentials in this file in plain text. You should NOT do this
-  # in production usage!!! Instead, you should use tools such as Vault, Keywhiz, or KMS to fetch the credentials at
-  # runtime and only ever have the plaintext version in memory.
-  local readonly cluster_username="admin"
-  local readonly cluster_password="password"
+  # The cluster credentials are filled in via Terraform interpolation. The password may be a reference to a secret, such
+  # as ssm:///couchbase/cluster-password, rather than plaintext, in which case it is only resolved at runtime, so the
+  # plaintext password never ends up in User Data.
+  local readonly cluster_username="${cluster_username}"
+  local readonly cluster_password="${cluster_password}"
 
   mount_volumes "$data_volume_device_name" "$data_volume_mount_point" "$volume_owner"
   run_couchbase "$cluster_asg_name" "$cluster_username" "$cluster_password" "$cluster_port" "$data_volume_mount_point" "$data_ramsize" "$index_ramsize" "$fts_ramsize"
@@ -116,14 +121,19 @@ function run {
   if [[ "$node_hostname" == "$rally_point_hostname" ]]; then
     echo "This node is the rally point for this cluster"
 
-    # To keep this example simple, we are hard-coding all credentials in this file in plain text. You should NOT do this
-    # in production usage!!! Instead, you should use tools such as Vault, Keywhiz, or KMS to fetch the credentials at
-    # runtime and only ever have the plaintext version in memory.
+    # The password of the test user is filled in via Terraform interpolation, so each test run can use its own. Unlike
+    # the cluster password, it's plaintext, which is only OK because this example creates this user for testing. You
+    # should NOT do this in production usage!!! Instead, fetch the credentials at runtime, e.g., via resolve_secret, and
+    # only ever have the plaintext version in memory.
     local readonly test_user_name="test-user"
-    local readonly test_user_password="password"
+    local readonly test_user_password="${test_user_password}"
     local readonly test_bucket_name="test-bucket"
 
-    create_test_resources "$cluster_username" "$cluster_password" "$cluster_port" "$test_user_name" "$test_user_password" "$test_bucket_name"
+    # The couchbase-cli calls in create_test_resources need the plaintext cluster password
+    local cluster_password_plaintext
+    cluster_password_plaintext=$(resolve_secret "$cluster_password")
+
+    create_test_resources "$cluster_username" "$cluster_password_plaintext" "$cluster_port" "$test_user_name" "$test_user_password" "$test_bucket_name"
   fi
 }
 
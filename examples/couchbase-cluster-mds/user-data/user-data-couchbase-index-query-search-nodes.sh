@@ -43,11 +43,11 @@ function run {
   local readonly index_volume_mount_point="$4"
   local readonly volume_owner="$5"
 
-  # To keep this example simple, we are hard-coding all credentials in this file in plain text. You should NOT do this
-  # in production usage!!! Instead, you should use tools such as Vault, Keywhiz, or KMS to fetch the credentials at
-  # runtime and only ever have the plaintext version in memory.
-  local readonly cluster_username="admin"
-  local readonly cluster_password="password"
+  # The cluster credentials are filled in via Terraform interpolation. The password may be a reference to a secret, such
+  # as ssm:///couchbase/cluster-password, rather than plaintext, in which case it is only resolved at runtime, so the
+  # plaintext password never ends up in User Data.
+  local readonly cluster_username="${cluster_username}"
+  local readonly cluster_password="${cluster_password}"
 
   mount_volumes "$index_volume_device_name" "$index_volume_mount_point" "$volume_owner"
   run_couchbase "$cluster_asg_name" "$cluster_username" "$cluster_password" "$cluster_port" "$index_volume_mount_point"
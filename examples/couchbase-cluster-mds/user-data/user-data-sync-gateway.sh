@@ -34,13 +34,10 @@ function run {
   local readonly sync_gateway_interface="$3"
   local readonly sync_gateway_admin_interface="$4"
 
-  # To keep this example simple, we are hard-coding all credentials in this file in plain text. You should NOT do this
-  # in production usage!!! Instead, you should use tools such as Vault, Keywhiz, or KMS to fetch the credentials at
-  # runtime and only ever have the plaintext version in memory.
-  local readonly cluster_username="admin"
-  local readonly cluster_password="password"
+  # The password of the test user, which the data nodes create, is filled in via Terraform interpolation, so each test
+  # run can use its own. It's plaintext, which is only OK because this example creates this user for testing.
   local readonly test_user_name="test-user"
-  local readonly test_user_password="password"
+  local readonly test_user_password="${test_user_password}"
   local readonly test_bucket_name="test-bucket"
 
   run_sync_gateway "$cluster_asg_name" "$cluster_port" "$sync_gateway_interface" "$sync_gateway_admin_interface" "$test_bucket_name" "$test_user_name" "$test_user_password"
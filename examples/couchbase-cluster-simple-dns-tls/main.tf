@@ -65,14 +65,19 @@ module "couchbase" {
   # replaced with a new one.
   health_check_type = "ELB"
 
-  # An example of custom tags
-  tags = [
-    {
-      key                 = "Environment"
-      value               = "development"
-      propagate_at_launch = true
-    },
-  ]
+  # An example of custom tags, along with the tags in var.custom_tags
+  tags = concat(
+    [
+      {
+        key                 = "Environment"
+        value               = "development"
+        propagate_at_launch = true
+      },
+    ],
+    [for key, value in var.custom_tags : { key = key, value = value, propagate_at_launch = true }]
+  )
+
+  security_group_tags = var.custom_tags
 }
 
 # ---------------------------------------------------------------------------------------------------------------------
@@ -86,6 +91,19 @@ data "template_file" "user_data_server" {
   vars = {
     cluster_asg_name = var.cluster_name
     cluster_port     = module.couchbase_security_group_rules.rest_port
+    cluster_username = var.cluster_username
+    cluster_password = var.cluster_password
+
+    # The passwords of the users the User Data script creates for testing
+    test_user_password         = var.test_user_password
+    test_app_user_password     = var.test_app_user_password
+    test_monitor_user_password = var.test_monitor_user_password
+
+    # References to the CA in SSM Parameter Store, so the CA private key never ends up in User Data
+    ca_cert = var.enable_node_certificates ? "ssm://${aws_ssm_parameter.ca_cert[0].name}" : ""
+    ca_key  = var.enable_node_certificates ? "ssm://${aws_ssm_parameter.ca_key[0].name}" : ""
+
+    cluster_encryption_level = var.cluster_encryption_level
 
     # We expose the Sync Gateway on all IPs but the Sync Gateway Admin should ONLY be accessible from localhost, as it
     # provides admin access to ALL Sync Gateway data.
@@ -101,6 +119,75 @@ data "template_file" "user_data_server" {
   }
 }
 
+# ---------------------------------------------------------------------------------------------------------------------
+# GENERATE A CA FOR THE NODE CERTIFICATES
+# Each Couchbase node uses this CA to sign its own node certificate on boot. We store the CA in SSM Parameter Store,
+# with the private key as a SecureString, and only pass references to the parameters to the User Data script. Note that
+# the private key is still stored in the Terraform state, so in production usage, you may prefer to generate the CA
+# outside of Terraform and only pass references to it in here.
+# ---------------------------------------------------------------------------------------------------------------------
+
+resource "tls_private_key" "ca" {
+  count = var.enable_node_certificates ? 1 : 0
+
+  algorithm = "RSA"
+  rsa_bits  = 2048
+}
+
+resource "tls_self_signed_cert" "ca" {
+  count = var.enable_node_certificates ? 1 : 0
+
+  key_algorithm         = tls_private_key.ca[0].algorithm
+  private_key_pem       = tls_private_key.ca[0].private_key_pem
+  is_ca_certificate     = true
+  validity_period_hours = 87600
+
+  allowed_uses = [
+    "cert_signing",
+    "crl_signing",
+    "digital_signature",
+  ]
+
+  subject {
+    common_name = "${var.cluster_name} CA"
+  }
+}
+
+resource "aws_ssm_parameter" "ca_cert" {
+  count = var.enable_node_certificates ? 1 : 0
+
+  name  = "/${var.cluster_name}/couchbase/ca-cert"
+  type  = "String"
+  value = tls_self_signed_cert.ca[0].cert_pem
+}
+
+resource "aws_ssm_parameter" "ca_key" {
+  count = var.enable_node_certificates ? 1 : 0
+
+  name  = "/${var.cluster_name}/couchbase/ca-key"
+  type  = "SecureString"
+  value = tls_private_key.ca[0].private_key_pem
+}
+
+# Allow the Couchbase nodes to read the CA parameters, so they can sign their node certificates on boot
+resource "aws_iam_role_policy" "read_ca" {
+  count = var.enable_node_certificates ? 1 : 0
+
+  name   = "read-couchbase-ca"
+  role   = module.couchbase.iam_role_id
+  policy = data.aws_iam_policy_document.read_ca[0].json
+}
+
+data "aws_iam_policy_document" "read_ca" {
+  count = var.enable_node_certificates ? 1 : 0
+
+  statement {
+    effect    = "Allow"
+    actions   = ["ssm:GetParameter"]
+    resources = [aws_ssm_parameter.ca_cert[0].arn, aws_ssm_parameter.ca_key[0].arn]
+  }
+}
+
 # ---------------------------------------------------------------------------------------------------------------------
 # DEPLOY A LOAD BALANCER FOR COUCHBASE
 # We use this load balancer to (1) perform health checks and (2) route traffic to the Couchbase Web Console. Note that
@@ -164,10 +251,14 @@ module "couchbase_target_group" {
 
   target_group_name = "${var.cluster_name}-cb"
   asg_name          = module.couchbase.asg_name
-  port              = module.couchbase_security_group_rules.rest_port
   health_check_path = "/ui/index.html"
   vpc_id            = data.aws_vpc.default.id
 
+  # In TLS-only mode, the load balancer can only talk to the Couchbase nodes over TLS. Note that the load balancer does
+  # not verify the certificates of the nodes.
+  port     = var.tls_only ? module.couchbase_security_group_rules.ssl_rest_port : module.couchbase_security_group_rules.rest_port
+  protocol = var.tls_only ? "HTTPS" : "HTTP"
+
   listener_arns                   = [module.load_balancer.https_listener_arns[var.couchbase_load_balancer_port]]
   num_listener_arns               = 1
   listener_rule_starting_priority = 100
@@ -207,6 +298,12 @@ module "couchbase_security_group_rules" {
 
   security_group_id = module.couchbase.security_group_id
 
+  # The nodes only serve certificates clients can verify if node certificates are enabled
+  enable_ssl_ports = var.enable_node_certificates
+
+  # In TLS-only mode, clients can only use the TLS ports, which also enables them
+  tls_only = var.tls_only
+
   # To keep this example simple, we allow these client-facing ports to be accessed from any IP. In a production
   # deployment, you may want to lock these down just to trusted servers.
 
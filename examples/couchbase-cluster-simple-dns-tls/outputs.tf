@@ -10,3 +10,6 @@ output "couchbase_cluster_asg_name" {
   value = module.couchbase.asg_name
 }
 
+output "couchbase_ca_cert_pem" {
+  value = join("", tls_self_signed_cert.ca.*.cert_pem)
+}
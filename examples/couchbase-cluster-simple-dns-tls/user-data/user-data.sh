@@ -21,6 +21,37 @@ function mount_volumes {
   mount_volume "$index_volume_device_name" "$index_volume_mount_point" "$volume_owner"
 }
 
+# Write a config file that defines the RBAC users run-couchbase-server should create in the cluster. We use the * wildcard
+# for the bucket-scoped role, as the test bucket is only created after run-couchbase-server has finished.
+function write_rbac_users_config {
+  local readonly rbac_users_config="$1"
+  local readonly app_user_name="$2"
+  local readonly app_user_password="$3"
+  local readonly monitor_user_name="$4"
+  local readonly monitor_user_password="$5"
+
+  echo "Writing RBAC users config to $rbac_users_config"
+
+  cat > "$rbac_users_config" <<EOF
+{
+  "users": [
+    {
+      "username": "$app_user_name",
+      "password": "$app_user_password",
+      "roles": ["bucket_full_access[*]"]
+    },
+    {
+      "username": "$monitor_user_name",
+      "password": "$monitor_user_password",
+      "roles": ["ro_admin"]
+    }
+  ]
+}
+EOF
+
+  chmod 600 "$rbac_users_config"
+}
+
 function run_couchbase {
   local readonly cluster_asg_name="$1"
   local readonly cluster_username="$2"
@@ -28,6 +59,11 @@ function run_couchbase {
   local readonly cluster_port="$4"
   local readonly data_dir="$5"
   local readonly index_dir="$6"
+  local readonly rbac_users_config="$7"
+  local readonly ca_cert="$8"
+  local readonly ca_key="$9"
+  shift 9
+  local readonly cluster_encryption_level="$1"
 
   echo "Starting Couchbase"
 
@@ -38,6 +74,10 @@ function run_couchbase {
     --rest-port "$cluster_port" \
     --data-dir "$data_dir" \
     --index-dir "$index_dir" \
+    --rbac-users-config "$rbac_users_config" \
+    --ca-cert "$ca_cert" \
+    --ca-key "$ca_key" \
+    --cluster-encryption-level "$cluster_encryption_level" \
     --wait-for-all-nodes
 }
 
@@ -118,17 +158,36 @@ function run {
   local readonly index_volume_mount_point="$8"
   local readonly volume_owner="$9"
 
-  # To keep this example simple, we are hard-coding all credentials in this file in plain text. You should NOT do this
-  # in production usage!!! Instead, you should use tools such as Vault, Keywhiz, or KMS to fetch the credentials at
-  # runtime and only ever have the plaintext version in memory.
-  local readonly cluster_username="admin"
-  local readonly cluster_password="password"
+  # The cluster credentials are filled in via Terraform interpolation. The password may be a reference to a secret, such
+  # as ssm:///couchbase/cluster-password, rather than plaintext, in which case it is only resolved at runtime, so the
+  # plaintext password never ends up in User Data.
+  local readonly cluster_username="${cluster_username}"
+  local readonly cluster_password="${cluster_password}"
+
+  # The CA for the node certificates is also filled in via Terraform interpolation, as references to secrets. If they
+  # are empty, the nodes only serve the self-signed certificate Couchbase generates on its own.
+  local readonly ca_cert="${ca_cert}"
+  local readonly ca_key="${ca_key}"
+
+  # If set, enable node-to-node encryption at this level
+  local readonly cluster_encryption_level="${cluster_encryption_level}"
+
+  # The passwords of the test users are filled in via Terraform interpolation too, so each test run can use its own.
+  # Unlike the cluster password, they are plaintext, which is only OK because this example creates these users for
+  # testing. You should NOT do this in production usage!!! Instead, fetch the credentials at runtime, e.g., via
+  # resolve_secret, and only ever have the plaintext version in memory.
   local readonly test_user_name="test-user"
-  local readonly test_user_password="password"
+  local readonly test_user_password="${test_user_password}"
   local readonly test_bucket_name="test-bucket"
+  local readonly test_app_user_name="test-app"
+  local readonly test_app_user_password="${test_app_user_password}"
+  local readonly test_monitor_user_name="test-monitor"
+  local readonly test_monitor_user_password="${test_monitor_user_password}"
+  local readonly rbac_users_config="/tmp/rbac-users.json"
 
   mount_volumes "$data_volume_device_name" "$data_volume_mount_point" "$index_volume_device_name" "$index_volume_mount_point" "$volume_owner"
-  run_couchbase "$cluster_asg_name" "$cluster_username" "$cluster_password" "$cluster_port" "$data_volume_mount_point" "$index_volume_mount_point"
+  write_rbac_users_config "$rbac_users_config" "$test_app_user_name" "$test_app_user_password" "$test_monitor_user_name" "$test_monitor_user_password"
+  run_couchbase "$cluster_asg_name" "$cluster_username" "$cluster_password" "$cluster_port" "$data_volume_mount_point" "$index_volume_mount_point" "$rbac_users_config" "$ca_cert" "$ca_key" "$cluster_encryption_level"
 
   local node_hostname
   local rally_point_hostname
@@ -136,7 +195,11 @@ function run {
 
   if [[ "$node_hostname" == "$rally_point_hostname" ]]; then
     echo "This node is the rally point for this cluster"
-    create_test_resources "$cluster_username" "$cluster_password" "$cluster_port" "$test_user_name" "$test_user_password" "$test_bucket_name"
+    # The couchbase-cli calls in create_test_resources need the plaintext cluster password
+    local cluster_password_plaintext
+    cluster_password_plaintext=$(resolve_secret "$cluster_password")
+
+    create_test_resources "$cluster_username" "$cluster_password_plaintext" "$cluster_port" "$test_user_name" "$test_user_password" "$test_bucket_name"
   fi
 
   run_sync_gateway "$cluster_asg_name" "$cluster_port" "$sync_gateway_interface" "$sync_gateway_admin_interface" "$test_bucket_name" "$test_user_name" "$test_user_password"
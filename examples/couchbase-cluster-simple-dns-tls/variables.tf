@@ -88,3 +88,56 @@ variable "domain_name_tags" {
   default     = {}
 }
 
+variable "cluster_username" {
+  description = "The username for the Couchbase cluster's admin account."
+  type        = string
+  default     = "admin"
+}
+
+variable "cluster_password" {
+  description = "The password for the Couchbase cluster's admin account. This may be a reference to a secret, such as ssm:///couchbase/cluster-password or secretsmanager://couchbase-credentials#password, which is resolved at runtime on each EC2 Instance, so the plaintext password never ends up in User Data. The default plaintext password is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "test_user_password" {
+  description = "The password of test-user, which the User Data script creates for testing, along with test-bucket, and which Sync Gateway uses to connect to Couchbase. It's passed to the EC2 Instances in plaintext, so the default is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "test_app_user_password" {
+  description = "The password of the RBAC user test-app, which the User Data script creates for testing. It's passed to the EC2 Instances in plaintext, so the default is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "test_monitor_user_password" {
+  description = "The password of the RBAC user test-monitor, which the User Data script creates for testing. It's passed to the EC2 Instances in plaintext, so the default is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "enable_node_certificates" {
+  description = "If set to true, generate a CA for the cluster, store it in SSM Parameter Store, and configure each Couchbase node to serve a certificate signed by that CA on its TLS ports (e.g., 18091 and 18092), so traffic to the nodes is encrypted too, and not just traffic to the load balancer. Requires the enterprise edition of Couchbase."
+  type        = bool
+  default     = false
+}
+
+variable "cluster_encryption_level" {
+  description = "If set, enable node-to-node encryption and encrypt the traffic between the Couchbase nodes at this level. Must be one of: control, all. If empty, node-to-node encryption is disabled. Requires the enterprise edition of Couchbase."
+  type        = string
+  default     = ""
+}
+
+variable "tls_only" {
+  description = "If set to true, only allow connections from outside the cluster, including from the load balancer, to the TLS ports of the Couchbase nodes (e.g., 18091 and 11207). Use this with var.enable_node_certificates, so clients can verify the nodes."
+  type        = bool
+  default     = false
+}
+
+variable "custom_tags" {
+  description = "Custom tags to add to the Auto Scaling Groups and security groups of the Couchbase clusters, and to the EC2 Instances they launch. The automated tests use these to mark the resources they create."
+  type        = map(string)
+  default     = {}
+}
@@ -55,6 +55,9 @@ module "couchbase_primary" {
   # replaced with a new one.
   health_check_type = "ELB"
 
+  tags                = [for key, value in var.custom_tags_primary : { key = key, value = value, propagate_at_launch = true }]
+  security_group_tags = var.custom_tags_primary
+
   providers = {
     aws = aws.primary
   }
@@ -95,6 +98,9 @@ module "couchbase_replica" {
   # replaced with a new one.
   health_check_type = "ELB"
 
+  tags                = [for key, value in var.custom_tags_replica : { key = key, value = value, propagate_at_launch = true }]
+  security_group_tags = var.custom_tags_replica
+
   providers = {
     aws = aws.replica
   }
@@ -111,8 +117,14 @@ data "template_file" "user_data_primary" {
   vars = {
     cluster_asg_name                    = var.cluster_name_primary
     cluster_port                        = module.couchbase_security_group_rules_primary.rest_port
+    cluster_username                    = var.cluster_username
+    cluster_password                    = var.cluster_password
+    test_user_password                  = var.test_user_password
     replication_dest_cluster_name       = var.cluster_name_replica
     replication_dest_cluster_aws_region = data.aws_region.replica.name
+    ca_cert                             = var.ca_cert
+    ca_key                              = var.ca_key
+    xdcr_encryption_type                = var.xdcr_encryption_type
   }
 }
 
@@ -125,8 +137,13 @@ data "template_file" "user_data_replica" {
   template = file("${path.module}/user-data/user-data-replica.sh")
 
   vars = {
-    cluster_asg_name = var.cluster_name_replica
-    cluster_port     = module.couchbase_security_group_rules_replica.rest_port
+    cluster_asg_name   = var.cluster_name_replica
+    cluster_port       = module.couchbase_security_group_rules_replica.rest_port
+    cluster_username   = var.cluster_username
+    cluster_password   = var.cluster_password
+    test_user_password = var.test_user_password
+    ca_cert            = var.ca_cert
+    ca_key             = var.ca_key
   }
 }
 
@@ -253,6 +270,9 @@ module "couchbase_security_group_rules_primary" {
 
   security_group_id = module.couchbase_primary.security_group_id
 
+  # The nodes only serve certificates clients can verify if node certificates are enabled
+  enable_ssl_ports = var.ca_cert != ""
+
   # To keep this example simple, we allow these client-facing ports to be accessed from any IP. In a production
   # deployment, you may want to lock these down just to trusted servers.
 
@@ -280,6 +300,9 @@ module "couchbase_security_group_rules_replica" {
 
   security_group_id = module.couchbase_replica.security_group_id
 
+  # The nodes only serve certificates clients can verify if node certificates are enabled
+  enable_ssl_ports = var.ca_cert != ""
+
   # To keep this example simple, we allow these client-facing ports to be accessed from any IP. In a production
   # deployment, you may want to lock these down just to trusted servers.
 
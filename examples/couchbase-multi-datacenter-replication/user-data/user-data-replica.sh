@@ -13,6 +13,8 @@ function run_couchbase {
   local readonly cluster_username="$2"
   local readonly cluster_password="$3"
   local readonly cluster_port="$4"
+  local readonly ca_cert="$5"
+  local readonly ca_key="$6"
 
   echo "Starting Couchbase data nodes"
 
@@ -24,6 +26,9 @@ function run_couchbase {
     --node-services "data" \
     --cluster-services "data" \
     --use-public-hostname \
+    --ca-cert "$ca_cert" \
+    --ca-key "$ca_key" \
+    --use-availability-zone-server-groups \
     --wait-for-all-nodes
 }
 
@@ -76,13 +81,19 @@ function run {
   local readonly cluster_asg_name="$1"
   local readonly cluster_port="$2"
 
-  # To keep this example simple, we are hard-coding all credentials in this file in plain text. You should NOT do this
-  # in production usage!!! Instead, you should use tools such as Vault, Keywhiz, or KMS to fetch the credentials at
-  # runtime and only ever have the plaintext version in memory.
-  local readonly cluster_username="admin"
-  local readonly cluster_password="password"
+  # The cluster credentials are filled in via Terraform interpolation. The password may be a reference to a secret, such
+  # as ssm:///couchbase/cluster-password, rather than plaintext, in which case it is only resolved at runtime, so the
+  # plaintext password never ends up in User Data.
+  local readonly cluster_username="${cluster_username}"
+  local readonly cluster_password="${cluster_password}"
 
-  run_couchbase "$cluster_asg_name" "$cluster_username" "$cluster_password" "$cluster_port"
+  # The CA for the node certificates is also filled in via Terraform interpolation, as references to secrets. Both
+  # clusters use the same CA, so the primary cluster can verify the replica cluster when XDCR uses full encryption. If
+  # they are empty, the nodes only serve the self-signed certificate Couchbase generates on its own.
+  local readonly ca_cert="${ca_cert}"
+  local readonly ca_key="${ca_key}"
+
+  run_couchbase "$cluster_asg_name" "$cluster_username" "$cluster_password" "$cluster_port" "$ca_cert" "$ca_key"
 
   local node_hostname
   local rally_point_hostname
@@ -91,14 +102,19 @@ function run {
   if [[ "$node_hostname" == "$rally_point_hostname" ]]; then
     echo "This node is the rally point for this cluster"
 
-    # To keep this example simple, we are hard-coding all credentials in this file in plain text. You should NOT do this
-    # in production usage!!! Instead, you should use tools such as Vault, Keywhiz, or KMS to fetch the credentials at
-    # runtime and only ever have the plaintext version in memory.
+    # The password of the test user is filled in via Terraform interpolation, so each test run can use its own. Unlike
+    # the cluster password, it's plaintext, which is only OK because this example creates this user for testing. You
+    # should NOT do this in production usage!!! Instead, fetch the credentials at runtime, e.g., via resolve_secret, and
+    # only ever have the plaintext version in memory.
     local readonly test_user_name="test-user"
-    local readonly test_user_password="password"
+    local readonly test_user_password="${test_user_password}"
     local readonly test_bucket_name="test-bucket-replica"
 
-    create_test_resources "$cluster_username" "$cluster_password" "$cluster_port" "$test_user_name" "$test_user_password" "$test_bucket_name"
+    # The couchbase-cli calls in create_test_resources need the plaintext cluster password
+    local cluster_password_plaintext
+    cluster_password_plaintext=$(resolve_secret "$cluster_password")
+
+    create_test_resources "$cluster_username" "$cluster_password_plaintext" "$cluster_port" "$test_user_name" "$test_user_password" "$test_bucket_name"
   fi
 }
 
@@ -68,3 +68,50 @@ variable "couchbase_load_balancer_port" {
   default     = 8091
 }
 
+variable "cluster_username" {
+  description = "The username for the Couchbase cluster's admin account."
+  type        = string
+  default     = "admin"
+}
+
+variable "cluster_password" {
+  description = "The password for the Couchbase cluster's admin account. This may be a reference to a secret, such as ssm:///couchbase/cluster-password or secretsmanager://couchbase-credentials#password, which is resolved at runtime on each EC2 Instance, so the plaintext password never ends up in User Data. The default plaintext password is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "test_user_password" {
+  description = "The password of test-user, which the User Data script creates for testing, along with test-bucket, in each cluster. It's passed to the EC2 Instances in plaintext, so the default is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "ca_cert" {
+  description = "A reference to the CA certificate, in PEM format, that each Couchbase node in both clusters should use to sign its node certificate, such as ssm:///couchbase/ca-cert. The reference is resolved in the region of each cluster, so the secret must exist in both regions, and the IAM roles of both clusters must be allowed to read it. If empty, the nodes only serve the self-signed certificate Couchbase generates on its own. Requires the enterprise edition of Couchbase."
+  type        = string
+  default     = ""
+}
+
+variable "ca_key" {
+  description = "A reference to the private key, in PEM format, of the CA in var.ca_cert, such as ssm:///couchbase/ca-key. Must be set if var.ca_cert is set."
+  type        = string
+  default     = ""
+}
+
+variable "xdcr_encryption_type" {
+  description = "How to encrypt XDCR traffic from the primary cluster to the replica cluster. Must be one of: none, half, full. Full encryption requires var.ca_cert and var.ca_key to be set."
+  type        = string
+  default     = "none"
+}
+
+variable "custom_tags_primary" {
+  description = "Custom tags to add to the Auto Scaling Group and security group of the primary Couchbase cluster, and to the EC2 Instances it launches. The automated tests use these to mark the resources they create."
+  type        = map(string)
+  default     = {}
+}
+
+variable "custom_tags_replica" {
+  description = "Custom tags to add to the Auto Scaling Group and security group of the replica Couchbase cluster, and to the EC2 Instances it launches. The automated tests use these to mark the resources they create."
+  type        = map(string)
+  default     = {}
+}
@@ -64,14 +64,19 @@ module "couchbase" {
   # replaced with a new one.
   health_check_type = "ELB"
 
-  # An example of custom tags
-  tags = [
-    {
-      key                 = "Environment"
-      value               = "development"
-      propagate_at_launch = true
-    },
-  ]
+  # An example of custom tags, along with the tags in var.custom_tags
+  tags = concat(
+    [
+      {
+        key                 = "Environment"
+        value               = "development"
+        propagate_at_launch = true
+      },
+    ],
+    [for key, value in var.custom_tags : { key = key, value = value, propagate_at_launch = true }]
+  )
+
+  security_group_tags = var.custom_tags
 }
 
 # ---------------------------------------------------------------------------------------------------------------------
@@ -85,6 +90,13 @@ data "template_file" "user_data_server" {
   vars = {
     cluster_asg_name = var.cluster_name
     cluster_port     = module.couchbase_security_group_rules.rest_port
+    cluster_username = var.cluster_username
+    cluster_password = var.cluster_password
+
+    # The passwords of the users the User Data script creates for testing
+    test_user_password         = var.test_user_password
+    test_app_user_password     = var.test_app_user_password
+    test_monitor_user_password = var.test_monitor_user_password
 
     # We expose the Sync Gateway on all IPs but the Sync Gateway Admin should ONLY be accessible from localhost, as it
     # provides admin access to ALL Sync Gateway data.
@@ -97,6 +109,11 @@ data "template_file" "user_data_server" {
     index_volume_device_name = var.index_volume_device_name
     index_volume_mount_point = var.index_volume_mount_point
     volume_owner             = var.volume_owner
+
+    # The nodes use their public hostnames, so clients outside the VPC don't need alternate addresses. These are only
+    # set in the Docker Compose file for this example.
+    alternate_address = ""
+    alternate_ports   = ""
   }
 }
 
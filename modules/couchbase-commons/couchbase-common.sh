@@ -11,9 +11,13 @@ readonly COUCHBASE_BASE_DIR="/opt/couchbase"
 readonly COUCHBASE_BIN_DIR="$COUCHBASE_BASE_DIR/bin"
 readonly COUCHBASE_CLI="$COUCHBASE_BIN_DIR/couchbase-cli"
 
+readonly COUCHBASE_COMMONS_BIN_DIR="/opt/couchbase-commons/bin"
+readonly COUCHBASE_SECRETS="$COUCHBASE_COMMONS_BIN_DIR/couchbase-secrets"
+readonly COUCHBASE_CERTS="$COUCHBASE_COMMONS_BIN_DIR/couchbase-certs"
+
 # Run the Couchbase CLI
 function run_couchbase_cli {
-  local readonly args=($@)
+  local readonly args=("$@")
 
   # The Couchbase CLI may exit with an error, but we almost always want to ignore that and make decision based on
   # stdout instead, so we temporarily disable exit on error
@@ -30,7 +34,7 @@ function run_couchbase_cli_with_retry {
   local readonly max_retries="$3"
   local readonly sleep_between_retries_sec="$4"
   shift 4
-  local readonly args=($@)
+  local readonly args=("$@")
 
   for (( i=0; i<"$max_retries"; i++ )); do
     local out
@@ -49,6 +53,39 @@ function run_couchbase_cli_with_retry {
   exit 1
 }
 
+# Returns true (0) if the given value is a reference to a secret, such as ssm:///couchbase/admin-password, and false
+# otherwise. Only the schemes couchbase-secrets supports count, so other URLs, such as http://... or couchbase://...,
+# are plain values.
+function is_secret_reference {
+  local readonly value="$1"
+  [[ "$value" =~ ^(env|file|ssm|secretsmanager):// ]]
+}
+
+# If the given value is a reference to a secret (env://, file://, ssm://, or secretsmanager://), fetch the secret and
+# write its plaintext value to stdout. Otherwise, write the value to stdout unchanged, so plaintext values continue to
+# work. Exits with an error if the secret can't be fetched.
+function resolve_secret {
+  local readonly value="$1"
+
+  if ! is_secret_reference "$value"; then
+    echo -n "$value"
+    return
+  fi
+
+  if [[ ! -x "$COUCHBASE_SECRETS" ]]; then
+    log_error "Cannot resolve secret reference $value: $COUCHBASE_SECRETS is not installed."
+    exit 1
+  fi
+
+  local secret
+  if ! secret=$("$COUCHBASE_SECRETS" resolve "$value"); then
+    log_error "Failed to resolve secret reference $value."
+    exit 1
+  fi
+
+  echo -n "$secret"
+}
+
 # Returns true (0) if the Couchbase cluster has already been initialized and false otherwise.
 function cluster_is_initialized {
   local readonly cluster_url="$1"
@@ -130,6 +167,19 @@ function node_is_active_in_cluster {
   string_multiline_contains "$cluster_status" "$node_url healthy active"
 }
 
+# Returns true if the node with the given hostname is a member of the Couchbase cluster that has been failed over
+function node_is_failed_over_in_cluster {
+  local readonly cluster_url="$1"
+  local readonly cluster_username="$2"
+  local readonly cluster_password="$3"
+  local readonly node_url="$4"
+
+  local cluster_status
+  cluster_status=$(get_cluster_status "$cluster_url" "$cluster_username" "$cluster_password")
+
+  string_multiline_contains "$cluster_status" " $node_url [a-z]* inactiveFailed$"
+}
+
 # Returns true (0) if the cluster is balanced and false (1) otherwise
 function cluster_is_balanced {
   local readonly cluster_url="$1"
@@ -254,6 +304,39 @@ function wait_for_bucket {
   exit 1
 }
 
+# Create or update an RBAC user in the local auth domain with the given roles. The roles should be a comma-separated
+# list of role names, where bucket-scoped roles specify the bucket in square brackets (e.g., bucket_full_access[foo]).
+# Note that this function is idempotent: if the user already exists, its password and roles will be updated.
+function create_rbac_user {
+  local readonly cluster_url="$1"
+  local readonly cluster_username="$2"
+  local readonly cluster_password="$3"
+  local readonly user_name="$4"
+  local readonly user_password="$5"
+  local readonly user_display_name="$6"
+  local readonly user_roles="$7"
+
+  local readonly max_retries=60
+  local readonly sleep_between_retries_sec=5
+
+  log_info "Creating RBAC user $user_name with roles $user_roles in cluster $cluster_url"
+
+  run_couchbase_cli_with_retry \
+    "create RBAC user $user_name" \
+    "SUCCESS:" \
+    "$max_retries" \
+    "$sleep_between_retries_sec" \
+    "user-manage" \
+    "--cluster=$cluster_url" \
+    "--username=$cluster_username" \
+    "--password=$cluster_password" \
+    "--set" \
+    "--rbac-username=$user_name" \
+    "--rbac-password=$user_password" \
+    "--rbac-name=$user_display_name" \
+    "--roles=$user_roles" \
+    "--auth-domain=local"
+}
 
 # Identify the server to use as a "rally point." This is the "leader" of the cluster that can be used to initialize
 # the cluster and kick off replication. We use a simple technique to identify a unique rally point in each ASG: look
@@ -286,3 +369,70 @@ function get_rally_point_hostname {
 
   echo -n "$hostname"
 }
+
+# Create an environment file for a service that only root can read. systemd reads the file as root before starting the
+# service, and the file may hold a password, or a reference to one, so no one else needs to read it.
+function create_env_file {
+  local readonly path="$1"
+
+  sudo mkdir -p "$(dirname "$path")"
+  sudo touch "$path"
+  sudo chmod 600 "$path"
+}
+
+# Set a setting in the environment file of a service, keeping the other settings in the file, which may have been
+# written by another install script if Couchbase Server and Sync Gateway run on the same node
+function set_env_file_setting {
+  local readonly path="$1"
+  local readonly name="$2"
+  local readonly value="$3"
+
+  sudo sed -i "/^$name=/d" "$path"
+  echo "$name=$value" | sudo tee -a "$path" > /dev/null
+}
+
+# Install one of the Go binaries in the couchbase-commons bin folder, such as couchbase-exporter, as a systemd service
+# that runs as the given user, reads its settings from the given environment file, and starts on boot. The user must be
+# able to read any file:// password references in the settings. If Couchbase Server and Sync Gateway run on the same
+# node, they share the service and its environment file, so the install script that runs first picks the user.
+function install_couchbase_commons_service {
+  local readonly name="$1"
+  local readonly description="$2"
+  local readonly binary_path="$3"
+  local readonly env_file_path="$4"
+  local readonly unit_path="$5"
+  local readonly user="$6"
+
+  if [[ ! -x "$binary_path" ]]; then
+    log_error "Cannot install the $name service, as $binary_path does not exist. Build the Go tools into the bin folder of couchbase-commons before running the install script."
+    exit 1
+  fi
+
+  local service_user="$user"
+  if [[ -f "$unit_path" ]]; then
+    local existing_user
+    existing_user=$(sed -n 's/^User=//p' "$unit_path")
+    service_user="${existing_user:-$user}"
+    log_info "The $name service is already installed, so it keeps running as user $service_user."
+  fi
+
+  log_info "Creating systemd unit for $name in $unit_path"
+  sudo tee "$unit_path" > /dev/null << EOF
+[Unit]
+Description=$description
+After=network.target
+
+[Service]
+Type=simple
+User=$service_user
+EnvironmentFile=$env_file_path
+ExecStart=$binary_path run
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+  sudo systemctl enable "$name"
+}
@@ -5,8 +5,10 @@ set -e
 
 source "/opt/gruntwork/bash-commons/log.sh"
 
-# This method is used to configure a new EBS volume. It formats the specified device name using ext4 and mounts it at
-# the given mount point, with the given OS user as owner.
+# This method is used to configure an EBS volume. It formats the specified device name using ext4 and mounts it at
+# the given mount point, with the given OS user as owner. If the device already has a file system, such as when a data
+# volume from a previous instance is reattached to a new one, it mounts the volume without formatting it, so the data
+# on the volume is kept.
 function mount_volume {
   local readonly device_name="$1"
   local readonly mount_point="$2"
@@ -15,25 +17,36 @@ function mount_volume {
   local readonly mount_options="${5:-defaults,nofail}"
   local readonly fs_tab_path="/etc/fstab"
 
-  case "$file_system_type" in
-    "ext4")
-      log_info "Creating $file_system_type file system on $device_name..."
-      mkfs.ext4 -F "$device_name"
-      ;;
-    "xfs")
-      log_info "Creating $file_system_type file system on $device_name..."
-      mkfs.xfs -f "$device_name"
-      ;;
-    *)
-      log_error "The file system type '$file_system_type' is not currently supported by this script."
-      exit 1
-  esac
+  local existing_file_system_type
+  existing_file_system_type=$(blkid -o value -s TYPE "$device_name" || true)
+
+  if [[ ! -z "$existing_file_system_type" ]]; then
+    log_info "Device $device_name already has a $existing_file_system_type file system. Will not format it."
+  else
+    case "$file_system_type" in
+      "ext4")
+        log_info "Creating $file_system_type file system on $device_name..."
+        mkfs.ext4 -F "$device_name"
+        ;;
+      "xfs")
+        log_info "Creating $file_system_type file system on $device_name..."
+        mkfs.xfs -f "$device_name"
+        ;;
+      *)
+        log_error "The file system type '$file_system_type' is not currently supported by this script."
+        exit 1
+    esac
+  fi
 
   log_info "Creating mount point $mount_point..."
-  mkdir "$mount_point"
-
-  log_info "Adding device $device_name to $fs_tab_path with mount point $mount_point..."
-  echo "$device_name       $mount_point   $file_system_type    $mount_options  0 2" >> "$fs_tab_path"
+  mkdir -p "$mount_point"
+
+  if grep -q "^$device_name[[:space:]]" "$fs_tab_path"; then
+    log_info "Device $device_name is already in $fs_tab_path."
+  else
+    log_info "Adding device $device_name to $fs_tab_path with mount point $mount_point..."
+    echo "$device_name       $mount_point   ${existing_file_system_type:-$file_system_type}    $mount_options  0 2" >> "$fs_tab_path"
+  fi
 
   log_info "Mounting volumes..."
   mount -a
@@ -36,3 +36,44 @@ data "aws_iam_policy_document" "auto_discover_cluster" {
   }
 }
 
+
+# ---------------------------------------------------------------------------------------------------------------------
+# OPTIONALLY ATTACH AN IAM POLICY THAT ALLOWS THE COUCHBASE NODES TO STORE BACKUPS IN S3
+# couchbase-backup uploads backups to, lists, downloads, and deletes backups from this bucket
+# ---------------------------------------------------------------------------------------------------------------------
+
+resource "aws_iam_role_policy" "backup_to_s3" {
+  count  = var.backup_s3_bucket_arn == null ? 0 : 1
+  name   = "backup-to-s3"
+  role   = var.iam_role_id
+  policy = data.aws_iam_policy_document.backup_to_s3[0].json
+}
+
+data "aws_iam_policy_document" "backup_to_s3" {
+  count = var.backup_s3_bucket_arn == null ? 0 : 1
+
+  statement {
+    effect = "Allow"
+
+    actions = [
+      "s3:PutObject",
+      "s3:GetObject",
+      "s3:DeleteObject",
+      "s3:AbortMultipartUpload",
+    ]
+
+    resources = ["${var.backup_s3_bucket_arn}/${var.backup_s3_prefix}*"]
+  }
+
+  statement {
+    effect    = "Allow"
+    actions   = ["s3:ListBucket"]
+    resources = [var.backup_s3_bucket_arn]
+
+    condition {
+      test     = "StringLike"
+      variable = "s3:prefix"
+      values   = ["${var.backup_s3_prefix}*"]
+    }
+  }
+}
@@ -8,3 +8,20 @@ variable "iam_role_id" {
   type        = string
 }
 
+
+# ---------------------------------------------------------------------------------------------------------------------
+# OPTIONAL PARAMETERS
+# These parameters have reasonable defaults.
+# ---------------------------------------------------------------------------------------------------------------------
+
+variable "backup_s3_bucket_arn" {
+  description = "The ARN of the S3 bucket where couchbase-backup stores backups. If set, the nodes get permission to read, write, list, and delete the backups under var.backup_s3_prefix in this bucket. Set to null to not grant any S3 permissions."
+  type        = string
+  default     = null
+}
+
+variable "backup_s3_prefix" {
+  description = "The prefix in var.backup_s3_bucket_arn under which couchbase-backup stores backups, with a trailing slash: e.g., prod/couchbase/. The nodes only get access to objects under this prefix. Leave empty to allow access to the whole bucket. Only used if var.backup_s3_bucket_arn is set."
+  type        = string
+  default     = ""
+}
@@ -12,13 +12,25 @@ terraform {
   }
 }
 
+# ---------------------------------------------------------------------------------------------------------------------
+# WORK OUT WHICH PORTS TO OPEN
+# In TLS-only mode, clients outside the cluster can only use the SSL ports. The Couchbase nodes themselves still need the
+# non SSL ports to talk to each other, as run-couchbase-server uses them to bootstrap the cluster.
+# ---------------------------------------------------------------------------------------------------------------------
+
+locals {
+  enable_non_ssl_client_ports = var.enable_non_ssl_ports && !var.tls_only
+  enable_non_ssl_self_ports   = var.enable_non_ssl_ports || var.tls_only
+  enable_ssl_ports            = var.enable_ssl_ports || var.tls_only
+}
+
 # ---------------------------------------------------------------------------------------------------------------------
 # REST PORT
 # REST/HTTP including Web UI
 # ---------------------------------------------------------------------------------------------------------------------
 
 resource "aws_security_group_rule" "rest_port_cidr_blocks" {
-  count             = length(var.rest_port_cidr_blocks) > 0 && var.enable_non_ssl_ports ? 1 : 0
+  count             = length(var.rest_port_cidr_blocks) > 0 && local.enable_non_ssl_client_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.rest_port
   to_port           = var.rest_port
@@ -28,7 +40,7 @@ resource "aws_security_group_rule" "rest_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "rest_port_security_groups" {
-  count                    = var.enable_non_ssl_ports ? var.num_rest_port_security_groups : 0
+  count                    = local.enable_non_ssl_client_ports ? var.num_rest_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.rest_port
   to_port                  = var.rest_port
@@ -38,7 +50,7 @@ resource "aws_security_group_rule" "rest_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "rest_port_self" {
-  count             = var.enable_non_ssl_ports ? 1 : 0
+  count             = local.enable_non_ssl_self_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.rest_port
   to_port           = var.rest_port
@@ -48,7 +60,7 @@ resource "aws_security_group_rule" "rest_port_self" {
 }
 
 resource "aws_security_group_rule" "ssl_rest_port_cidr_blocks" {
-  count             = length(var.rest_port_cidr_blocks) > 0 && var.enable_ssl_ports ? 1 : 0
+  count             = length(var.rest_port_cidr_blocks) > 0 && local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_rest_port
   to_port           = var.ssl_rest_port
@@ -58,7 +70,7 @@ resource "aws_security_group_rule" "ssl_rest_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "ssl_rest_port_security_groups" {
-  count                    = var.enable_ssl_ports ? var.num_rest_port_security_groups : 0
+  count                    = local.enable_ssl_ports ? var.num_rest_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.ssl_rest_port
   to_port                  = var.ssl_rest_port
@@ -68,7 +80,7 @@ resource "aws_security_group_rule" "ssl_rest_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "ssl_rest_port_self" {
-  count             = var.enable_ssl_ports ? 1 : 0
+  count             = local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_rest_port
   to_port           = var.ssl_rest_port
@@ -83,7 +95,7 @@ resource "aws_security_group_rule" "ssl_rest_port_self" {
 # ---------------------------------------------------------------------------------------------------------------------
 
 resource "aws_security_group_rule" "capi_port_cidr_blocks" {
-  count             = length(var.capi_port_cidr_blocks) > 0 && var.enable_non_ssl_ports ? 1 : 0
+  count             = length(var.capi_port_cidr_blocks) > 0 && local.enable_non_ssl_client_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.capi_port
   to_port           = var.capi_port
@@ -93,7 +105,7 @@ resource "aws_security_group_rule" "capi_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "capi_port_security_groups" {
-  count                    = var.enable_non_ssl_ports ? var.num_capi_port_security_groups : 0
+  count                    = local.enable_non_ssl_client_ports ? var.num_capi_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.capi_port
   to_port                  = var.capi_port
@@ -103,7 +115,7 @@ resource "aws_security_group_rule" "capi_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "capi_port_self" {
-  count             = var.enable_non_ssl_ports ? 1 : 0
+  count             = local.enable_non_ssl_self_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.capi_port
   to_port           = var.capi_port
@@ -113,7 +125,7 @@ resource "aws_security_group_rule" "capi_port_self" {
 }
 
 resource "aws_security_group_rule" "ssl_capi_port_cidr_blocks" {
-  count             = length(var.capi_port_cidr_blocks) > 0 && var.enable_ssl_ports ? 1 : 0
+  count             = length(var.capi_port_cidr_blocks) > 0 && local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_capi_port
   to_port           = var.ssl_capi_port
@@ -123,7 +135,7 @@ resource "aws_security_group_rule" "ssl_capi_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "ssl_capi_port_security_groups" {
-  count                    = var.enable_ssl_ports ? var.num_capi_port_security_groups : 0
+  count                    = local.enable_ssl_ports ? var.num_capi_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.ssl_capi_port
   to_port                  = var.ssl_capi_port
@@ -133,7 +145,7 @@ resource "aws_security_group_rule" "ssl_capi_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "ssl_capi_self" {
-  count             = var.enable_ssl_ports ? 1 : 0
+  count             = local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_capi_port
   to_port           = var.ssl_capi_port
@@ -148,7 +160,7 @@ resource "aws_security_group_rule" "ssl_capi_self" {
 # ---------------------------------------------------------------------------------------------------------------------
 
 resource "aws_security_group_rule" "query_port_cidr_blocks" {
-  count             = length(var.query_port_cidr_blocks) > 0 && var.enable_non_ssl_ports ? 1 : 0
+  count             = length(var.query_port_cidr_blocks) > 0 && local.enable_non_ssl_client_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.query_port
   to_port           = var.query_port
@@ -158,7 +170,7 @@ resource "aws_security_group_rule" "query_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "query_port_security_groups" {
-  count                    = var.enable_non_ssl_ports ? var.num_query_port_security_groups : 0
+  count                    = local.enable_non_ssl_client_ports ? var.num_query_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.query_port
   to_port                  = var.query_port
@@ -168,7 +180,7 @@ resource "aws_security_group_rule" "query_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "query_port_self" {
-  count             = var.enable_non_ssl_ports ? 1 : 0
+  count             = local.enable_non_ssl_self_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.query_port
   to_port           = var.query_port
@@ -178,7 +190,7 @@ resource "aws_security_group_rule" "query_port_self" {
 }
 
 resource "aws_security_group_rule" "ssl_query_port_cidr_blocks" {
-  count             = length(var.query_port_cidr_blocks) > 0 && var.enable_ssl_ports ? 1 : 0
+  count             = length(var.query_port_cidr_blocks) > 0 && local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_query_port
   to_port           = var.ssl_query_port
@@ -188,7 +200,7 @@ resource "aws_security_group_rule" "ssl_query_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "ssl_query_port_security_groups" {
-  count                    = var.enable_ssl_ports ? var.num_query_port_security_groups : 0
+  count                    = local.enable_ssl_ports ? var.num_query_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.ssl_query_port
   to_port                  = var.ssl_query_port
@@ -198,7 +210,7 @@ resource "aws_security_group_rule" "ssl_query_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "ssl_query_port_self" {
-  count             = var.enable_ssl_ports ? 1 : 0
+  count             = local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_query_port
   to_port           = var.ssl_query_port
@@ -213,7 +225,7 @@ resource "aws_security_group_rule" "ssl_query_port_self" {
 # ---------------------------------------------------------------------------------------------------------------------
 
 resource "aws_security_group_rule" "fts_port_cidr_blocks" {
-  count             = length(var.fts_port_cidr_blocks) > 0 && var.enable_non_ssl_ports ? 1 : 0
+  count             = length(var.fts_port_cidr_blocks) > 0 && local.enable_non_ssl_client_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.fts_port
   to_port           = var.fts_port
@@ -223,7 +235,7 @@ resource "aws_security_group_rule" "fts_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "fts_port_security_groups" {
-  count                    = var.enable_non_ssl_ports ? var.num_fts_port_security_groups : 0
+  count                    = local.enable_non_ssl_client_ports ? var.num_fts_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.fts_port
   to_port                  = var.fts_port
@@ -233,7 +245,7 @@ resource "aws_security_group_rule" "fts_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "fts_port_self" {
-  count             = var.enable_non_ssl_ports ? 1 : 0
+  count             = local.enable_non_ssl_self_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.fts_port
   to_port           = var.fts_port
@@ -243,7 +255,7 @@ resource "aws_security_group_rule" "fts_port_self" {
 }
 
 resource "aws_security_group_rule" "ssl_fts_port_cidr_blocks" {
-  count             = length(var.fts_port_cidr_blocks) > 0 && var.enable_ssl_ports ? 1 : 0
+  count             = length(var.fts_port_cidr_blocks) > 0 && local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_fts_port
   to_port           = var.ssl_fts_port
@@ -253,7 +265,7 @@ resource "aws_security_group_rule" "ssl_fts_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "ssl_fts_port_security_groups" {
-  count                    = var.enable_ssl_ports ? var.num_fts_port_security_groups : 0
+  count                    = local.enable_ssl_ports ? var.num_fts_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.ssl_fts_port
   to_port                  = var.ssl_fts_port
@@ -263,7 +275,7 @@ resource "aws_security_group_rule" "ssl_fts_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "ssl_fts_port_self" {
-  count             = var.enable_ssl_ports ? 1 : 0
+  count             = local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_fts_port
   to_port           = var.ssl_fts_port
@@ -278,7 +290,7 @@ resource "aws_security_group_rule" "ssl_fts_port_self" {
 # ---------------------------------------------------------------------------------------------------------------------
 
 resource "aws_security_group_rule" "memcached_port_cidr_blocks" {
-  count             = length(var.memcached_port_cidr_blocks) > 0 && var.enable_non_ssl_ports ? 1 : 0
+  count             = length(var.memcached_port_cidr_blocks) > 0 && local.enable_non_ssl_client_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.memcached_port
   to_port           = var.memcached_port
@@ -288,7 +300,7 @@ resource "aws_security_group_rule" "memcached_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "memcached_port_security_groups" {
-  count                    = var.enable_non_ssl_ports ? var.num_memcached_port_security_groups : 0
+  count                    = local.enable_non_ssl_client_ports ? var.num_memcached_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.memcached_port
   to_port                  = var.memcached_port
@@ -298,7 +310,7 @@ resource "aws_security_group_rule" "memcached_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "memcached_port_self" {
-  count             = var.enable_non_ssl_ports ? 1 : 0
+  count             = local.enable_non_ssl_self_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.memcached_port
   to_port           = var.memcached_port
@@ -308,7 +320,7 @@ resource "aws_security_group_rule" "memcached_port_self" {
 }
 
 resource "aws_security_group_rule" "ssl_memcached_port_cidr_blocks" {
-  count             = length(var.memcached_port_cidr_blocks) > 0 && var.enable_ssl_ports ? 1 : 0
+  count             = length(var.memcached_port_cidr_blocks) > 0 && local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_memcached_port
   to_port           = var.ssl_memcached_port
@@ -318,7 +330,7 @@ resource "aws_security_group_rule" "ssl_memcached_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "ssl_memcached_port_security_groups" {
-  count                    = var.enable_ssl_ports ? var.num_memcached_port_security_groups : 0
+  count                    = local.enable_ssl_ports ? var.num_memcached_port_security_groups : 0
   type                     = "ingress"
   from_port                = var.ssl_memcached_port
   to_port                  = var.ssl_memcached_port
@@ -328,7 +340,7 @@ resource "aws_security_group_rule" "ssl_memcached_port_security_groups" {
 }
 
 resource "aws_security_group_rule" "ssl_memcached_port_self" {
-  count             = var.enable_ssl_ports ? 1 : 0
+  count             = local.enable_ssl_ports ? 1 : 0
   type              = "ingress"
   from_port         = var.ssl_memcached_port
   to_port           = var.ssl_memcached_port
@@ -377,7 +389,7 @@ resource "aws_security_group_rule" "memcached_dedicated_port_self" {
 # ---------------------------------------------------------------------------------------------------------------------
 
 resource "aws_security_group_rule" "moxi_port_cidr_blocks" {
-  count             = signum(length(var.moxi_port_cidr_blocks))
+  count             = var.tls_only ? 0 : signum(length(var.moxi_port_cidr_blocks))
   type              = "ingress"
   from_port         = var.moxi_port
   to_port           = var.moxi_port
@@ -387,7 +399,7 @@ resource "aws_security_group_rule" "moxi_port_cidr_blocks" {
 }
 
 resource "aws_security_group_rule" "moxi_port_security_groups" {
-  count                    = var.num_moxi_port_security_groups
+  count                    = var.tls_only ? 0 : var.num_moxi_port_security_groups
   type                     = "ingress"
   from_port                = var.moxi_port
   to_port                  = var.moxi_port
@@ -541,3 +553,28 @@ resource "aws_security_group_rule" "internal_data_port_self" {
   self              = true
 }
 
+
+# ---------------------------------------------------------------------------------------------------------------------
+# HEALTH CHECK PORT
+# Readiness check served by couchbase-health-check for load balancers
+# ---------------------------------------------------------------------------------------------------------------------
+
+resource "aws_security_group_rule" "health_check_port_cidr_blocks" {
+  count             = signum(length(var.health_check_port_cidr_blocks))
+  type              = "ingress"
+  from_port         = var.health_check_port
+  to_port           = var.health_check_port
+  protocol          = "tcp"
+  security_group_id = var.security_group_id
+  cidr_blocks       = var.health_check_port_cidr_blocks
+}
+
+resource "aws_security_group_rule" "health_check_port_security_groups" {
+  count                    = var.num_health_check_port_security_groups
+  type                     = "ingress"
+  from_port                = var.health_check_port
+  to_port                  = var.health_check_port
+  protocol                 = "tcp"
+  security_group_id        = var.security_group_id
+  source_security_group_id = element(var.health_check_port_security_groups, count.index)
+}
@@ -25,6 +25,12 @@ variable "enable_ssl_ports" {
   default     = false
 }
 
+variable "tls_only" {
+  description = "If set to true, only allow connections from outside the cluster to the SSL ports. The non SSL ports and the moxi port are only open to the Couchbase nodes themselves, which use them to bootstrap the cluster. Overrides var.enable_non_ssl_ports and var.enable_ssl_ports."
+  type        = bool
+  default     = false
+}
+
 variable "rest_port" {
   description = "The port to use for REST/HTTP requests, including the Couchbase Web Console."
   type        = number
@@ -277,3 +283,27 @@ variable "num_internal_ports_security_groups" {
   default     = 0
 }
 
+
+variable "health_check_port" {
+  description = "The port couchbase-health-check serves its readiness check on. See the install-couchbase-server module for how to install it."
+  type        = number
+  default     = 9421
+}
+
+variable "health_check_port_cidr_blocks" {
+  description = "The list of IP address ranges in CIDR notation from which to allow connections to the health_check_port, such as the subnets of the load balancer. If Couchbase Server and Sync Gateway share a Security Group, only set this in one of the Security Group Rules modules."
+  type        = list(string)
+  default     = []
+}
+
+variable "health_check_port_security_groups" {
+  description = "The list of Security Group IDs from which to allow connections to the health_check_port, such as the Security Group of the load balancer. If Couchbase Server and Sync Gateway share a Security Group, only set this in one of the Security Group Rules modules. If you update this variable, make sure to update var.num_health_check_port_security_groups too!"
+  type        = list(string)
+  default     = []
+}
+
+variable "num_health_check_port_security_groups" {
+  description = "The number of security group IDs in var.health_check_port_security_groups. We should be able to compute this automatically, but due to a Terraform limitation, if there are any dynamic resources in var.allow_inbound_from_cidr_blocks, then we won't be able to: https://github.com/hashicorp/terraform/pull/11482"
+  type        = number
+  default     = 0
+}
@@ -25,8 +25,8 @@ resource "aws_alb_target_group" "tg" {
   deregistration_delay = var.deregistration_delay
 
   health_check {
-    port                = "traffic-port"
-    protocol            = var.protocol
+    port                = var.health_check_port
+    protocol            = var.health_check_protocol == null ? var.protocol : var.health_check_protocol
     interval            = var.health_check_interval
     path                = var.health_check_path
     timeout             = var.health_check_timeout
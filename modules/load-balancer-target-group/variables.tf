@@ -75,6 +75,18 @@ variable "deregistration_delay" {
   default     = 300
 }
 
+variable "health_check_port" {
+  description = "The port to use for health check requests. The default, traffic-port, checks the port the servers receive traffic on. To route only to nodes that are ready to serve traffic, set this to the port of couchbase-health-check, and var.health_check_path to /couchbase or /sync-gateway."
+  type        = string
+  default     = "traffic-port"
+}
+
+variable "health_check_protocol" {
+  description = "The protocol to use for health check requests. Set this to HTTP if var.protocol is HTTPS and var.health_check_port points at couchbase-health-check, which only serves HTTP. If null, use var.protocol."
+  type        = string
+  default     = null
+}
+
 variable "health_check_interval" {
   description = "The approximate amount of time, in seconds, between health checks of each server. Minimum value 5 seconds, Maximum value 300 seconds."
   type        = number
@@ -71,3 +71,28 @@ resource "aws_security_group_rule" "admin_interface_port_security_groups" {
   source_security_group_id = element(var.admin_interface_port_security_groups, count.index)
 }
 
+
+# ---------------------------------------------------------------------------------------------------------------------
+# HEALTH CHECK PORT
+# Readiness check served by couchbase-health-check for load balancers
+# ---------------------------------------------------------------------------------------------------------------------
+
+resource "aws_security_group_rule" "health_check_port_cidr_blocks" {
+  count             = signum(length(var.health_check_port_cidr_blocks))
+  type              = "ingress"
+  from_port         = var.health_check_port
+  to_port           = var.health_check_port
+  protocol          = "tcp"
+  security_group_id = var.security_group_id
+  cidr_blocks       = var.health_check_port_cidr_blocks
+}
+
+resource "aws_security_group_rule" "health_check_port_security_groups" {
+  count                    = var.num_health_check_port_security_groups
+  type                     = "ingress"
+  from_port                = var.health_check_port
+  to_port                  = var.health_check_port
+  protocol                 = "tcp"
+  security_group_id        = var.security_group_id
+  source_security_group_id = element(var.health_check_port_security_groups, count.index)
+}
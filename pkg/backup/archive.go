@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Write a gzipped tarball with a single backup to writer. Besides the backup folder itself, the tarball contains the
+// metadata files at the top of the archive and the repository, so that unpacking the tarballs of a full backup and its
+// incrementals into an empty folder gives cbbackupmgr a complete archive to restore from. Paths in the tarball are
+// relative to the archive.
+func packBackup(writer io.Writer, archive string, repo string, backupName string) error {
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, dir := range []string{"", repo} {
+		files, err := ioutil.ReadDir(filepath.Join(archive, dir))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if file.Mode().IsRegular() {
+				if err := addFileToTar(tarWriter, archive, filepath.Join(dir, file.Name()), file); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	backupDir := filepath.Join(archive, repo, backupName)
+	err := filepath.Walk(backupDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(archive, path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return tarWriter.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     filepath.ToSlash(relativePath) + "/",
+				Mode:     int64(info.Mode().Perm()),
+				ModTime:  info.ModTime(),
+			})
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return addFileToTar(tarWriter, archive, relativePath, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+func addFileToTar(tarWriter *tar.Writer, archive string, relativePath string, info os.FileInfo) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(relativePath),
+		Mode:     int64(info.Mode().Perm()),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+
+	file, err := os.Open(filepath.Join(archive, relativePath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.CopyN(tarWriter, file, info.Size())
+	return err
+}
+
+// Unpack a tarball written by packBackup into the given archive folder. Files that already exist, such as the metadata
+// files of an earlier backup in the same chain, are overwritten.
+func unpackBackup(reader io.Reader, archive string) error {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(archive, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(archive)+string(filepath.Separator)) {
+			return UnsafeTarPathErr{Path: header.Name}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFileFromTar(tarReader, path, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeFileFromTar(reader io.Reader, path string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Custom error types
+
+type UnsafeTarPathErr struct {
+	Path string
+}
+
+func (err UnsafeTarPathErr) Error() string {
+	return fmt.Sprintf("Refusing to unpack '%s', as it would end up outside the archive folder.", err.Path)
+}
@@ -0,0 +1,477 @@
+// Package backup takes full and incremental backups of a Couchbase cluster with cbbackupmgr, uploads each backup to an
+// object store such as S3, enforces a retention policy there, and restores backups from the object store to a cluster.
+//
+// Each backup is uploaded as its own gzipped tarball, named after the backup and whether it's full or incremental, so
+// the object store holds chains of a full backup followed by its incrementals. The local cbbackupmgr repository only
+// keeps the latest chain, which is all cbbackupmgr needs to take the next incremental backup.
+package backup
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const fullSuffix = ".full.tar.gz"
+const incrementalSuffix = ".incr.tar.gz"
+
+// The name Restore accepts to restore the most recent backup
+const LatestBackup = "latest"
+
+// Options configures where backups are taken and stored
+type Options struct {
+	Manager BackupManager
+	Store   ObjectStore
+
+	// The local cbbackupmgr archive folder. It must be on a volume with room for a full backup and its incrementals.
+	Archive string
+
+	// The name of the backup repository within the archive, which is also part of the object keys, so several clusters
+	// can share a bucket if each uses its own repository name
+	Repo string
+
+	// Prepended to the keys of every object in the store, without a trailing slash
+	Prefix string
+
+	Logger *log.Logger
+}
+
+// Backup is a backup in the object store
+type Backup struct {
+	// The name cbbackupmgr gave the backup, which is a timestamp, so backups sort by name in the order they were taken
+	Name string `json:"name"`
+
+	// True for a full backup, false for an incremental one
+	Full bool `json:"full"`
+
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// TakeBackup takes a backup with cbbackupmgr and uploads it to the object store. The backup is full if full is true or
+// if there is no earlier backup to build an incremental one on. Once a full backup is uploaded, the older backups are
+// deleted from the local repository, as they are no longer needed for incremental backups.
+func TakeBackup(options Options, full bool) (*Backup, error) {
+	options, err := withDefaults(options)
+	if err != nil {
+		return nil, err
+	}
+
+	repoDir := filepath.Join(options.Archive, options.Repo)
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		options.Logger.Printf("Creating backup repository %s in archive %s", options.Repo, options.Archive)
+		if err := os.MkdirAll(options.Archive, 0755); err != nil {
+			return nil, err
+		}
+		if err := options.Manager.Config(options.Archive, options.Repo); err != nil {
+			return nil, err
+		}
+	}
+
+	remote, err := ListBackups(options)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := removeBackupsNotInStore(options, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	// cbbackupmgr takes a full backup anyway if the repository is empty, so we record it as one
+	full = full || len(existing) == 0
+
+	options.Logger.Printf("Taking a %s backup into repository %s", backupType(full), options.Repo)
+	if err := options.Manager.Backup(options.Archive, options.Repo, full); err != nil {
+		return nil, err
+	}
+
+	name, err := findNewBackup(repoDir, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := Backup{Name: name, Full: full, Key: objectKey(options, name, full)}
+
+	size, err := uploadBackup(options, backup)
+	if err != nil {
+		// Remove the backup we failed to upload, so the next backup isn't an incremental on top of something the object
+		// store doesn't have
+		if removeErr := os.RemoveAll(filepath.Join(repoDir, name)); removeErr != nil {
+			options.Logger.Printf("WARNING: failed to remove backup %s after its upload failed: %v", name, removeErr)
+		}
+		return nil, err
+	}
+	backup.Size = size
+	backup.LastModified = time.Now()
+
+	options.Logger.Printf("Uploaded backup %s (%d bytes) to %s", name, size, backup.Key)
+
+	if full {
+		for _, old := range existing {
+			options.Logger.Printf("Removing backup %s from the local repository, as it's stored in the object store and no longer needed for incremental backups", old)
+			if err := os.RemoveAll(filepath.Join(repoDir, old)); err != nil {
+				return &backup, err
+			}
+		}
+	}
+
+	return &backup, nil
+}
+
+// ListBackups returns the backups in the object store, oldest first
+func ListBackups(options Options) ([]Backup, error) {
+	options, err := withDefaults(options)
+	if err != nil {
+		return nil, err
+	}
+
+	repoPrefix := path.Join(options.Prefix, options.Repo) + "/"
+
+	objects, err := options.Store.List(repoPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, repoPrefix)
+		if strings.Contains(name, "/") {
+			continue
+		}
+
+		backup := Backup{Key: object.Key, Size: object.Size, LastModified: object.LastModified}
+		switch {
+		case strings.HasSuffix(name, fullSuffix):
+			backup.Name = strings.TrimSuffix(name, fullSuffix)
+			backup.Full = true
+		case strings.HasSuffix(name, incrementalSuffix):
+			backup.Name = strings.TrimSuffix(name, incrementalSuffix)
+		default:
+			continue
+		}
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name < backups[j].Name })
+	return backups, nil
+}
+
+// ApplyRetention deletes backups from the object store so that only the most recent keepFull full backups remain,
+// along with the incremental backups taken after each of them. Returns the deleted backups. Does nothing if keepFull is
+// zero or less.
+func ApplyRetention(options Options, keepFull int) ([]Backup, error) {
+	options, err := withDefaults(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if keepFull <= 0 {
+		return nil, nil
+	}
+
+	backups, err := ListBackups(options)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := expiredBackups(backups, keepFull)
+	for _, backup := range expired {
+		options.Logger.Printf("Deleting %s backup %s from %s, as it's older than the last %d full backups", backupType(backup.Full), backup.Name, backup.Key, keepFull)
+		if err := options.Store.Delete(backup.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, nil
+}
+
+// Return the backups that come before the keepFull-th most recent full backup. The given backups must be sorted
+// oldest first.
+func expiredBackups(backups []Backup, keepFull int) []Backup {
+	fullSeen := 0
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Full {
+			fullSeen++
+			if fullSeen == keepFull {
+				return backups[:i]
+			}
+		}
+	}
+	return nil
+}
+
+// Restore downloads the given backup, along with the full backup and the incremental backups it builds on, into a
+// temporary archive next to the local archive, and restores them to the cluster with cbbackupmgr. The name may be
+// LatestBackup to restore the most recent backup. Returns the restored backup.
+func Restore(options Options, name string) (*Backup, error) {
+	options, err := withDefaults(options)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := ListBackups(options)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := restoreChain(backups, name)
+	if err != nil {
+		return nil, err
+	}
+	target := chain[len(chain)-1]
+
+	if err := os.MkdirAll(filepath.Dir(options.Archive), 0755); err != nil {
+		return nil, err
+	}
+	restoreArchive, err := ioutil.TempDir(filepath.Dir(options.Archive), filepath.Base(options.Archive)+"-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(restoreArchive)
+
+	for _, backup := range chain {
+		options.Logger.Printf("Downloading %s backup %s from %s", backupType(backup.Full), backup.Name, backup.Key)
+		if err := downloadBackup(options, backup, restoreArchive); err != nil {
+			return nil, err
+		}
+	}
+
+	options.Logger.Printf("Restoring backups %s through %s to the cluster", chain[0].Name, target.Name)
+	if err := options.Manager.Restore(restoreArchive, options.Repo, chain[0].Name, target.Name); err != nil {
+		return nil, err
+	}
+
+	return &target, nil
+}
+
+// Return the backups needed to restore the backup with the given name: the most recent full backup at or before it,
+// followed by every incremental backup up to and including it. The given backups must be sorted oldest first.
+func restoreChain(backups []Backup, name string) ([]Backup, error) {
+	if len(backups) == 0 {
+		return nil, NoBackupsErr{}
+	}
+
+	end := len(backups) - 1
+	if name != LatestBackup {
+		end = -1
+		for i, backup := range backups {
+			if backup.Name == name {
+				end = i
+			}
+		}
+		if end < 0 {
+			return nil, BackupNotFoundErr{Name: name}
+		}
+	}
+
+	for start := end; start >= 0; start-- {
+		if backups[start].Full {
+			return backups[start : end+1], nil
+		}
+	}
+
+	return nil, NoFullBackupErr{Name: backups[end].Name}
+}
+
+func withDefaults(options Options) (Options, error) {
+	if options.Manager == nil {
+		return options, MissingOptionErr{Name: "Manager"}
+	}
+	if options.Store == nil {
+		return options, MissingOptionErr{Name: "Store"}
+	}
+	if options.Archive == "" {
+		return options, MissingOptionErr{Name: "Archive"}
+	}
+	if options.Repo == "" {
+		return options, MissingOptionErr{Name: "Repo"}
+	}
+	if options.Logger == nil {
+		options.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	return options, nil
+}
+
+func objectKey(options Options, name string, full bool) string {
+	suffix := incrementalSuffix
+	if full {
+		suffix = fullSuffix
+	}
+	return path.Join(options.Prefix, options.Repo, name+suffix)
+}
+
+func backupType(full bool) string {
+	if full {
+		return "full"
+	}
+	return "incremental"
+}
+
+// Return the names of the backups in the given repository folder, oldest first. cbbackupmgr keeps each backup in a
+// folder named after the time it was taken.
+func listLocalBackups(repoDir string) ([]string, error) {
+	files, err := ioutil.ReadDir(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// Remove local backups that never made it to the object store, such as a backup whose upload was interrupted, as the
+// object store would be missing the data in any incremental backup built on top of them. Returns the remaining local
+// backups.
+func removeBackupsNotInStore(options Options, remote []Backup) ([]string, error) {
+	repoDir := filepath.Join(options.Archive, options.Repo)
+
+	local, err := listLocalBackups(repoDir)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded := map[string]bool{}
+	for _, backup := range remote {
+		uploaded[backup.Name] = true
+	}
+
+	remaining := []string{}
+	for _, name := range local {
+		if uploaded[name] {
+			remaining = append(remaining, name)
+			continue
+		}
+
+		options.Logger.Printf("WARNING: removing backup %s from the local repository, as it was never uploaded to the object store", name)
+		if err := os.RemoveAll(filepath.Join(repoDir, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return remaining, nil
+}
+
+// Return the name of the one backup in the repository folder that is not in existing
+func findNewBackup(repoDir string, existing []string) (string, error) {
+	local, err := listLocalBackups(repoDir)
+	if err != nil {
+		return "", err
+	}
+
+	known := map[string]bool{}
+	for _, name := range existing {
+		known[name] = true
+	}
+
+	added := []string{}
+	for _, name := range local {
+		if !known[name] {
+			added = append(added, name)
+		}
+	}
+
+	if len(added) != 1 {
+		return "", NewBackupNotFoundErr{RepoDir: repoDir, Added: added}
+	}
+	return added[0], nil
+}
+
+// Pack the backup into a temp file next to the archive and upload it. Returns the size of the upload.
+func uploadBackup(options Options, backup Backup) (int64, error) {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(options.Archive), fmt.Sprintf("%s.tar.gz.", backup.Name))
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if err := packBackup(tmpFile, options.Archive, options.Repo, backup.Name); err != nil {
+		return 0, err
+	}
+
+	size, err := tmpFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return size, options.Store.Put(backup.Key, tmpFile)
+}
+
+// Download the backup to a temp file next to the restore archive and unpack it into the restore archive
+func downloadBackup(options Options, backup Backup, restoreArchive string) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(restoreArchive), fmt.Sprintf("%s.tar.gz.", backup.Name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if err := options.Store.Get(backup.Key, tmpFile); err != nil {
+		return err
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return unpackBackup(tmpFile, restoreArchive)
+}
+
+// Custom error types
+
+type MissingOptionErr struct {
+	Name string
+}
+
+func (err MissingOptionErr) Error() string {
+	return fmt.Sprintf("The %s option is required.", err.Name)
+}
+
+type NewBackupNotFoundErr struct {
+	RepoDir string
+	Added   []string
+}
+
+func (err NewBackupNotFoundErr) Error() string {
+	return fmt.Sprintf("Expected cbbackupmgr to add exactly one backup to %s, but found %d new backups: %v", err.RepoDir, len(err.Added), err.Added)
+}
+
+type NoBackupsErr struct{}
+
+func (err NoBackupsErr) Error() string {
+	return "There are no backups in the object store."
+}
+
+type BackupNotFoundErr struct {
+	Name string
+}
+
+func (err BackupNotFoundErr) Error() string {
+	return fmt.Sprintf("There is no backup named %s in the object store.", err.Name)
+}
+
+type NoFullBackupErr struct {
+	Name string
+}
+
+func (err NoFullBackupErr) Error() string {
+	return fmt.Sprintf("Can't restore backup %s, as the full backup it builds on is not in the object store.", err.Name)
+}
@@ -0,0 +1,392 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Mimics how cbbackupmgr lays out an archive: a metadata file at the top of the archive and of each repository, and a
+// folder per backup, named after a timestamp, with the documents that changed since the previous backup in the
+// repository, or every document for a full backup
+type fakeBackupManager struct {
+	// The documents in the fake cluster
+	cluster map[string]string
+
+	// The documents as of the last backup, to work out what an incremental backup contains
+	lastBackup map[string]string
+
+	backups int
+}
+
+func newFakeBackupManager() *fakeBackupManager {
+	return &fakeBackupManager{cluster: map[string]string{}, lastBackup: map[string]string{}}
+}
+
+func (manager *fakeBackupManager) Config(archive string, repo string) error {
+	if err := os.MkdirAll(filepath.Join(archive, repo), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(archive, ".backup"), []byte("archive"), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(archive, repo, "backup-meta.json"), []byte(`{"repo":"`+repo+`"}`), 0644)
+}
+
+func (manager *fakeBackupManager) Backup(archive string, repo string, full bool) error {
+	documents := map[string]string{}
+	for key, value := range manager.cluster {
+		if full || manager.lastBackup[key] != value {
+			documents[key] = value
+		}
+	}
+
+	manager.backups++
+	backupDir := filepath.Join(archive, repo, fmt.Sprintf("2021-06-01T10_00_%02d.000000000Z", manager.backups), "bucket-data")
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(documents)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(backupDir, "documents.json"), contents, 0644); err != nil {
+		return err
+	}
+
+	manager.lastBackup = copyDocuments(manager.cluster)
+	return nil
+}
+
+func (manager *fakeBackupManager) Restore(archive string, repo string, start string, end string) error {
+	for _, metadataFile := range []string{filepath.Join(archive, ".backup"), filepath.Join(archive, repo, "backup-meta.json")} {
+		if _, err := os.Stat(metadataFile); err != nil {
+			return err
+		}
+	}
+
+	names, err := listLocalBackups(filepath.Join(archive, repo))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if name < start || name > end {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(archive, repo, name, "bucket-data", "documents.json"))
+		if err != nil {
+			return err
+		}
+
+		documents := map[string]string{}
+		if err := json.Unmarshal(contents, &documents); err != nil {
+			return err
+		}
+		for key, value := range documents {
+			manager.cluster[key] = value
+		}
+	}
+
+	return nil
+}
+
+func copyDocuments(documents map[string]string) map[string]string {
+	copied := map[string]string{}
+	for key, value := range documents {
+		copied[key] = value
+	}
+	return copied
+}
+
+func newOptionsForTest(t *testing.T, store ObjectStore, manager BackupManager) (Options, func()) {
+	tmpDir, err := ioutil.TempDir("", "backup-test")
+	require.NoError(t, err)
+
+	options := Options{
+		Manager: manager,
+		Store:   store,
+		Archive: filepath.Join(tmpDir, "archive"),
+		Repo:    "cluster",
+		Prefix:  "prod/couchbase",
+	}
+	return options, func() { os.RemoveAll(tmpDir) }
+}
+
+func TestBackupAndRestoreChain(t *testing.T) {
+	t.Parallel()
+
+	store, fakeServer, stop := newS3StoreForTest(t, "backups")
+	defer stop()
+
+	manager := newFakeBackupManager()
+	options, cleanup := newOptionsForTest(t, store, manager)
+	defer cleanup()
+
+	manager.cluster["a"] = "1"
+	manager.cluster["b"] = "1"
+	first, err := TakeBackup(options, false)
+	require.NoError(t, err)
+	assert.True(t, first.Full, "The first backup should be full, as there is nothing to build an incremental backup on")
+
+	manager.cluster["b"] = "2"
+	manager.cluster["c"] = "2"
+	second, err := TakeBackup(options, false)
+	require.NoError(t, err)
+	assert.False(t, second.Full)
+	stateAfterSecond := copyDocuments(manager.cluster)
+
+	manager.cluster["d"] = "3"
+	third, err := TakeBackup(options, false)
+	require.NoError(t, err)
+	stateAfterThird := copyDocuments(manager.cluster)
+
+	assert.Equal(t, []string{
+		"prod/couchbase/cluster/" + first.Name + ".full.tar.gz",
+		"prod/couchbase/cluster/" + second.Name + ".incr.tar.gz",
+		"prod/couchbase/cluster/" + third.Name + ".incr.tar.gz",
+	}, fakeServer.keys())
+
+	// Destroy the data, and restore it from the object store
+	manager.cluster = map[string]string{}
+	restored, err := Restore(options, LatestBackup)
+	require.NoError(t, err)
+	assert.Equal(t, third.Name, restored.Name)
+	assert.Equal(t, stateAfterThird, manager.cluster)
+
+	manager.cluster = map[string]string{}
+	restored, err = Restore(options, second.Name)
+	require.NoError(t, err)
+	assert.Equal(t, second.Name, restored.Name)
+	assert.Equal(t, stateAfterSecond, manager.cluster)
+
+	// The temporary restore archive is cleaned up
+	entries, err := ioutil.ReadDir(filepath.Dir(options.Archive))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "archive", entries[0].Name())
+}
+
+func TestFullBackupPrunesLocalRepository(t *testing.T) {
+	t.Parallel()
+
+	store, _, stop := newS3StoreForTest(t, "backups")
+	defer stop()
+
+	manager := newFakeBackupManager()
+	options, cleanup := newOptionsForTest(t, store, manager)
+	defer cleanup()
+
+	for _, full := range []bool{true, false, false} {
+		_, err := TakeBackup(options, full)
+		require.NoError(t, err)
+	}
+
+	local, err := listLocalBackups(filepath.Join(options.Archive, options.Repo))
+	require.NoError(t, err)
+	assert.Len(t, local, 3)
+
+	latest, err := TakeBackup(options, true)
+	require.NoError(t, err)
+
+	local, err = listLocalBackups(filepath.Join(options.Archive, options.Repo))
+	require.NoError(t, err)
+	assert.Equal(t, []string{latest.Name}, local)
+
+	remote, err := ListBackups(options)
+	require.NoError(t, err)
+	assert.Len(t, remote, 4)
+}
+
+// An ObjectStore whose uploads always fail
+type failingPutStore struct {
+	ObjectStore
+}
+
+func (store failingPutStore) Put(key string, body io.Reader) error {
+	return errors.New("fake upload error")
+}
+
+func TestFailedUploadRemovesLocalBackup(t *testing.T) {
+	t.Parallel()
+
+	store, _, stop := newS3StoreForTest(t, "backups")
+	defer stop()
+
+	manager := newFakeBackupManager()
+	options, cleanup := newOptionsForTest(t, store, manager)
+	defer cleanup()
+
+	first, err := TakeBackup(options, true)
+	require.NoError(t, err)
+
+	failingOptions := options
+	failingOptions.Store = failingPutStore{ObjectStore: store}
+	_, err = TakeBackup(failingOptions, false)
+	require.Error(t, err)
+
+	local, err := listLocalBackups(filepath.Join(options.Archive, options.Repo))
+	require.NoError(t, err)
+	assert.Equal(t, []string{first.Name}, local)
+}
+
+func TestBackupRemovesLocalBackupsNotInStore(t *testing.T) {
+	t.Parallel()
+
+	store, _, stop := newS3StoreForTest(t, "backups")
+	defer stop()
+
+	manager := newFakeBackupManager()
+	options, cleanup := newOptionsForTest(t, store, manager)
+	defer cleanup()
+
+	// A backup that was taken, but never uploaded, such as when the node was shut down in the middle of the upload
+	require.NoError(t, manager.Config(options.Archive, options.Repo))
+	require.NoError(t, manager.Backup(options.Archive, options.Repo, true))
+
+	backup, err := TakeBackup(options, false)
+	require.NoError(t, err)
+	assert.True(t, backup.Full, "The stray backup should be removed, so there's nothing to build an incremental backup on")
+
+	local, err := listLocalBackups(filepath.Join(options.Archive, options.Repo))
+	require.NoError(t, err)
+	assert.Equal(t, []string{backup.Name}, local)
+}
+
+func TestApplyRetention(t *testing.T) {
+	t.Parallel()
+
+	store, fakeServer, stop := newS3StoreForTest(t, "backups")
+	defer stop()
+
+	manager := newFakeBackupManager()
+	options, cleanup := newOptionsForTest(t, store, manager)
+	defer cleanup()
+
+	taken := []*Backup{}
+	for _, full := range []bool{true, false, true, false, false, true, false} {
+		backup, err := TakeBackup(options, full)
+		require.NoError(t, err)
+		taken = append(taken, backup)
+	}
+
+	deleted, err := ApplyRetention(options, 2)
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	assert.Equal(t, taken[0].Name, deleted[0].Name)
+	assert.Equal(t, taken[1].Name, deleted[1].Name)
+	assert.Len(t, fakeServer.keys(), 5)
+
+	// Keeping more full backups than there are deletes nothing
+	deleted, err = ApplyRetention(options, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+}
+
+func TestRestoreChain(t *testing.T) {
+	t.Parallel()
+
+	backups := []Backup{
+		{Name: "1", Full: false},
+		{Name: "2", Full: true},
+		{Name: "3", Full: false},
+		{Name: "4", Full: true},
+		{Name: "5", Full: false},
+	}
+
+	chain, err := restoreChain(backups, LatestBackup)
+	require.NoError(t, err)
+	assert.Equal(t, backups[3:], chain)
+
+	chain, err = restoreChain(backups, "3")
+	require.NoError(t, err)
+	assert.Equal(t, backups[1:3], chain)
+
+	_, err = restoreChain(backups, "1")
+	assert.Equal(t, NoFullBackupErr{Name: "1"}, err)
+
+	_, err = restoreChain(backups, "6")
+	assert.Equal(t, BackupNotFoundErr{Name: "6"}, err)
+
+	_, err = restoreChain(nil, LatestBackup)
+	assert.Equal(t, NoBackupsErr{}, err)
+}
+
+func TestNextBackup(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	schedule := ScheduleOptions{FullInterval: 24 * time.Hour, IncrementalInterval: time.Hour}
+
+	wait, full := nextBackup(nil, schedule, now)
+	assert.Equal(t, time.Duration(0), wait)
+	assert.True(t, full)
+
+	backups := []Backup{
+		{Full: true, LastModified: now.Add(-10 * time.Hour)},
+		{Full: false, LastModified: now.Add(-20 * time.Minute)},
+	}
+	wait, full = nextBackup(backups, schedule, now)
+	assert.Equal(t, 40*time.Minute, wait)
+	assert.False(t, full)
+
+	// The full backup is due before the next incremental one
+	backups[0].LastModified = now.Add(-23*time.Hour - 50*time.Minute)
+	wait, full = nextBackup(backups, schedule, now)
+	assert.Equal(t, 10*time.Minute, wait)
+	assert.True(t, full)
+
+	// Overdue backups run right away
+	backups[0].LastModified = now.Add(-48 * time.Hour)
+	wait, full = nextBackup(backups, schedule, now)
+	assert.Equal(t, time.Duration(0), wait)
+	assert.True(t, full)
+
+	// Without incremental backups, only full backups are scheduled
+	backups[0].LastModified = now.Add(-10 * time.Hour)
+	wait, full = nextBackup(backups, ScheduleOptions{FullInterval: 24 * time.Hour}, now)
+	assert.Equal(t, 14*time.Hour, wait)
+	assert.True(t, full)
+}
+
+func TestUnpackRejectsPathsOutsideArchive(t *testing.T) {
+	t.Parallel()
+
+	var tarball bytes.Buffer
+	gzipWriter := gzip.NewWriter(&tarball)
+	tarWriter := tar.NewWriter(gzipWriter)
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../escape", Mode: 0644, Size: 1}))
+	_, err := tarWriter.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+
+	tmpDir, err := ioutil.TempDir("", "backup-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	err = unpackBackup(&tarball, filepath.Join(tmpDir, "archive"))
+	assert.Equal(t, UnsafeTarPathErr{Path: "../escape"}, err)
+}
+
+func TestRedactPassword(t *testing.T) {
+	t.Parallel()
+
+	args := []string{"backup", "--username", "admin", "--password", "secret", "--full-backup"}
+	assert.Equal(t, []string{"backup", "--username", "admin", "--password", "REDACTED", "--full-backup"}, redactPassword(args))
+	assert.Equal(t, "secret", args[4], "redactPassword should not modify its input")
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const DefaultCbbackupmgrPath = "/opt/couchbase/bin/cbbackupmgr"
+
+// BackupManager takes and restores backups in a cbbackupmgr archive. CbBackupMgr implements it.
+type BackupManager interface {
+	// Create the given backup repository in the given archive
+	Config(archive string, repo string) error
+
+	// Take a backup of the cluster into the given repository. If full is false, the backup only contains what changed
+	// since the last backup in the repository.
+	Backup(archive string, repo string, full bool) error
+
+	// Restore the backups from start through end, inclusive, in the given repository to the cluster
+	Restore(archive string, repo string, start string, end string) error
+}
+
+// CbBackupMgr runs the cbbackupmgr tool that ships with Couchbase Server Enterprise Edition
+type CbBackupMgr struct {
+	// The path to the cbbackupmgr binary. If empty, use DefaultCbbackupmgrPath.
+	Path string
+
+	// The cluster to back up and restore to: e.g., http://localhost:8091
+	ClusterUrl string
+	Username   string
+	Password   string
+
+	// How many concurrent clients cbbackupmgr uses to transfer data. If zero, use the cbbackupmgr default.
+	Threads int
+}
+
+func (manager CbBackupMgr) Config(archive string, repo string) error {
+	return manager.run("config", "--archive", archive, "--repo", repo)
+}
+
+func (manager CbBackupMgr) Backup(archive string, repo string, full bool) error {
+	args := append([]string{"backup", "--archive", archive, "--repo", repo}, manager.clusterArgs()...)
+	if full {
+		args = append(args, "--full-backup")
+	}
+	return manager.run(args...)
+}
+
+func (manager CbBackupMgr) Restore(archive string, repo string, start string, end string) error {
+	args := append([]string{"restore", "--archive", archive, "--repo", repo}, manager.clusterArgs()...)
+	// Overwrite documents that already exist in the cluster, so restoring over a partially restored bucket works
+	args = append(args, "--start", start, "--end", end, "--force-updates")
+	return manager.run(args...)
+}
+
+func (manager CbBackupMgr) clusterArgs() []string {
+	args := []string{"--cluster", manager.ClusterUrl, "--username", manager.Username, "--password", manager.Password}
+	if manager.Threads > 0 {
+		args = append(args, "--threads", strconv.Itoa(manager.Threads))
+	}
+	return args
+}
+
+func (manager CbBackupMgr) run(args ...string) error {
+	path := manager.Path
+	if path == "" {
+		path = DefaultCbbackupmgrPath
+	}
+
+	output, err := exec.Command(path, args...).CombinedOutput()
+	if err != nil {
+		return CommandErr{Command: fmt.Sprintf("%s %s", path, strings.Join(redactPassword(args), " ")), Output: string(output), Underlying: err}
+	}
+	return nil
+}
+
+// Replace the value of the --password arg, so it doesn't end up in error messages and logs
+func redactPassword(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+
+	for i := 0; i < len(redacted)-1; i++ {
+		if redacted[i] == "--password" {
+			redacted[i+1] = "REDACTED"
+		}
+	}
+
+	return redacted
+}
+
+// Custom error types
+
+type CommandErr struct {
+	Command    string
+	Output     string
+	Underlying error
+}
+
+func (err CommandErr) Error() string {
+	return fmt.Sprintf("Command '%s' failed: %v. Output:\n%s", err.Command, err.Underlying, err.Output)
+}
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScheduleOptions configures how often Schedule takes backups
+type ScheduleOptions struct {
+	// How often to take a full backup
+	FullInterval time.Duration
+
+	// How often to take an incremental backup in between full backups. If zero, only take full backups.
+	IncrementalInterval time.Duration
+
+	// After each backup, keep only this many full backups, along with their incrementals, in the object store. If
+	// zero, keep every backup.
+	KeepFull int
+
+	// How long to wait before trying again after a backup fails
+	RetryInterval time.Duration
+
+	// Closing this channel stops Schedule after the current backup, if any, finishes. If nil, Schedule runs forever.
+	Stop <-chan struct{}
+}
+
+// Schedule takes full and incremental backups at the given intervals until the Stop channel is closed. It picks up the
+// schedule from the backups already in the object store, so restarting it doesn't trigger an extra backup. Failed
+// backups are logged and retried after RetryInterval, so a transient error doesn't stop the schedule.
+func Schedule(options Options, schedule ScheduleOptions) error {
+	options, err := withDefaults(options)
+	if err != nil {
+		return err
+	}
+	if schedule.FullInterval <= 0 {
+		return InvalidIntervalErr{Name: "FullInterval"}
+	}
+	if schedule.RetryInterval <= 0 {
+		schedule.RetryInterval = 5 * time.Minute
+	}
+
+	for {
+		wait, full, err := nextScheduledBackup(options, schedule, time.Now())
+		if err != nil {
+			options.Logger.Printf("ERROR: failed to list backups: %v. Trying again in %s.", err, schedule.RetryInterval)
+			wait = schedule.RetryInterval
+		} else {
+			options.Logger.Printf("Next %s backup in %s", backupType(full), wait)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-schedule.Stop:
+			return nil
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if _, err := TakeBackup(options, full); err != nil {
+			options.Logger.Printf("ERROR: %s backup failed: %v. Trying again in %s.", backupType(full), err, schedule.RetryInterval)
+
+			select {
+			case <-time.After(schedule.RetryInterval):
+			case <-schedule.Stop:
+				return nil
+			}
+			continue
+		}
+
+		if _, err := ApplyRetention(options, schedule.KeepFull); err != nil {
+			options.Logger.Printf("ERROR: failed to apply the retention policy: %v", err)
+		}
+	}
+}
+
+func nextScheduledBackup(options Options, schedule ScheduleOptions, now time.Time) (time.Duration, bool, error) {
+	backups, err := ListBackups(options)
+	if err != nil {
+		return 0, false, err
+	}
+
+	wait, full := nextBackup(backups, schedule, now)
+	return wait, full, nil
+}
+
+// Return how long to wait until the next backup, and whether it should be full, given the backups taken so far, oldest
+// first
+func nextBackup(backups []Backup, schedule ScheduleOptions, now time.Time) (time.Duration, bool) {
+	var lastFull, last time.Time
+	for _, backup := range backups {
+		if backup.Full && backup.LastModified.After(lastFull) {
+			lastFull = backup.LastModified
+		}
+		if backup.LastModified.After(last) {
+			last = backup.LastModified
+		}
+	}
+
+	if lastFull.IsZero() {
+		return 0, true
+	}
+
+	nextFull := lastFull.Add(schedule.FullInterval)
+	if schedule.IncrementalInterval <= 0 {
+		return untilOrZero(nextFull, now), true
+	}
+
+	nextIncremental := last.Add(schedule.IncrementalInterval)
+	if !nextFull.After(nextIncremental) {
+		return untilOrZero(nextFull, now), true
+	}
+	return untilOrZero(nextIncremental, now), false
+}
+
+func untilOrZero(next time.Time, now time.Time) time.Duration {
+	if next.Before(now) {
+		return 0
+	}
+	return next.Sub(now)
+}
+
+// Custom error types
+
+type InvalidIntervalErr struct {
+	Name string
+}
+
+func (err InvalidIntervalErr) Error() string {
+	return fmt.Sprintf("The %s must be greater than zero.", err.Name)
+}
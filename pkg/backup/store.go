@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+)
+
+// Object is an object in an ObjectStore
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ObjectStore stores the backup archives. S3Store implements it.
+type ObjectStore interface {
+	// Write the contents of body to the object with the given key
+	Put(key string, body io.Reader) error
+
+	// Copy the contents of the object with the given key to writer
+	Get(key string, writer io.Writer) error
+
+	// Return every object whose key starts with prefix
+	List(prefix string) ([]Object, error)
+
+	Delete(key string) error
+}
+
+// S3Store stores objects in an S3 bucket, or a bucket in any server that speaks the S3 API, such as MinIO
+type S3Store struct {
+	Bucket  string
+	Session *credentials.LazyAwsSession
+
+	// If set, talk to the S3 API at this URL instead of AWS, using path-style requests: e.g., http://minio:9000
+	Endpoint string
+
+	// If set, this client is used instead of one created from Session. Primarily useful for testing.
+	Client s3iface.S3API
+}
+
+// ParseS3Url splits a URL of the form s3://<BUCKET>/<PREFIX> into the bucket and the prefix, without a trailing slash
+func ParseS3Url(s3Url string) (string, string, error) {
+	parsed, err := url.Parse(s3Url)
+	if err != nil || parsed.Scheme != "s3" || parsed.Host == "" {
+		return "", "", InvalidS3UrlErr{Url: s3Url}
+	}
+
+	return parsed.Host, strings.Trim(parsed.Path, "/"), nil
+}
+
+func (store *S3Store) Put(key string, body io.Reader) error {
+	client, err := store.client()
+	if err != nil {
+		return err
+	}
+
+	// The uploader splits large archives into a multipart upload, so they don't have to fit in memory
+	_, err = s3manager.NewUploaderWithClient(client).Upload(&s3manager.UploadInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	if err != nil {
+		return S3Err{Operation: "upload", Bucket: store.Bucket, Key: key, Underlying: err}
+	}
+	return nil
+}
+
+func (store *S3Store) Get(key string, writer io.Writer) error {
+	client, err := store.client()
+	if err != nil {
+		return err
+	}
+
+	output, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String(store.Bucket), Key: aws.String(key)})
+	if err != nil {
+		return S3Err{Operation: "download", Bucket: store.Bucket, Key: key, Underlying: err}
+	}
+	defer output.Body.Close()
+
+	if _, err := io.Copy(writer, output.Body); err != nil {
+		return S3Err{Operation: "download", Bucket: store.Bucket, Key: key, Underlying: err}
+	}
+	return nil
+}
+
+func (store *S3Store) List(prefix string) ([]Object, error) {
+	client, err := store.client()
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []Object{}
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(store.Bucket), Prefix: aws.String(prefix)}
+
+	err = client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, Object{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, S3Err{Operation: "list", Bucket: store.Bucket, Key: prefix, Underlying: err}
+	}
+
+	return objects, nil
+}
+
+func (store *S3Store) Delete(key string) error {
+	client, err := store.client()
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(store.Bucket), Key: aws.String(key)}); err != nil {
+		return S3Err{Operation: "delete", Bucket: store.Bucket, Key: key, Underlying: err}
+	}
+	return nil
+}
+
+func (store *S3Store) client() (s3iface.S3API, error) {
+	if store.Client != nil {
+		return store.Client, nil
+	}
+
+	sess, err := store.Session.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &aws.Config{}
+	if store.Endpoint != "" {
+		config.Endpoint = aws.String(store.Endpoint)
+		// Most S3-compatible servers don't support virtual-hosted-style requests, where the bucket is in the hostname
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	store.Client = s3.New(sess, config)
+	return store.Client, nil
+}
+
+// Custom error types
+
+type InvalidS3UrlErr struct {
+	Url string
+}
+
+func (err InvalidS3UrlErr) Error() string {
+	return fmt.Sprintf("Invalid S3 URL '%s'. Must be of the form s3://<BUCKET> or s3://<BUCKET>/<PREFIX>.", err.Url)
+}
+
+type S3Err struct {
+	Operation  string
+	Bucket     string
+	Key        string
+	Underlying error
+}
+
+func (err S3Err) Error() string {
+	return fmt.Sprintf("Failed to %s s3://%s/%s: %v", err.Operation, err.Bucket, err.Key, err.Underlying)
+}
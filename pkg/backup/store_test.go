@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscredentials "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A stand-in for an S3-compatible server that keeps a single bucket in memory and supports just enough of the S3 API
+// for S3Store: path-style PutObject, GetObject, DeleteObject, and ListObjectsV2
+type fakeS3Server struct {
+	bucket  string
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+type fakeS3ListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	IsTruncated bool
+	Contents    []fakeS3Object
+}
+
+type fakeS3Object struct {
+	Key          string
+	Size         int
+	LastModified string
+}
+
+func newFakeS3Server(bucket string) *fakeS3Server {
+	return &fakeS3Server{bucket: bucket, objects: map[string][]byte{}}
+}
+
+func (server *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path != server.bucket && !strings.HasPrefix(path, server.bucket+"/") {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(path, server.bucket), "/")
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		server.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		server.objects[key] = body
+		w.Header().Set("ETag", `"fake-etag"`)
+	case r.Method == http.MethodGet:
+		body, exists := server.objects[key]
+		if !exists {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write(body)
+	case r.Method == http.MethodDelete:
+		delete(server.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (server *fakeS3Server) list(w http.ResponseWriter, prefix string) {
+	keys := []string{}
+	for key := range server.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := fakeS3ListResult{Name: server.bucket, Prefix: prefix, KeyCount: len(keys)}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, fakeS3Object{Key: key, Size: len(server.objects[key]), LastModified: time.Now().UTC().Format(time.RFC3339)})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (server *fakeS3Server) keys() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	keys := []string{}
+	for key := range server.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte("<Error><Code>" + code + "</Code><Message>fake error</Message></Error>"))
+}
+
+// Start a fake S3 server and return an S3Store that talks to it
+func newS3StoreForTest(t *testing.T, bucket string) (*S3Store, *fakeS3Server, func()) {
+	fakeServer := newFakeS3Server(bucket)
+	httpServer := httptest.NewServer(fakeServer)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(httpServer.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      awscredentials.NewStaticCredentials("fake-access-key", "fake-secret-key", ""),
+	})
+	require.NoError(t, err)
+
+	return &S3Store{Bucket: bucket, Client: s3.New(sess)}, fakeServer, httpServer.Close
+}
+
+func TestS3StorePutGetListDelete(t *testing.T) {
+	t.Parallel()
+
+	store, fakeServer, stop := newS3StoreForTest(t, "backups")
+	defer stop()
+
+	require.NoError(t, store.Put("prod/cluster/a.full.tar.gz", strings.NewReader("first")))
+	require.NoError(t, store.Put("prod/cluster/b.incr.tar.gz", strings.NewReader("second")))
+	require.NoError(t, store.Put("other/c.full.tar.gz", strings.NewReader("third")))
+
+	var contents bytes.Buffer
+	require.NoError(t, store.Get("prod/cluster/b.incr.tar.gz", &contents))
+	assert.Equal(t, "second", contents.String())
+
+	objects, err := store.List("prod/")
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	assert.Equal(t, "prod/cluster/a.full.tar.gz", objects[0].Key)
+	assert.Equal(t, int64(len("first")), objects[0].Size)
+	assert.False(t, objects[0].LastModified.IsZero())
+
+	require.NoError(t, store.Delete("prod/cluster/a.full.tar.gz"))
+	assert.Equal(t, []string{"other/c.full.tar.gz", "prod/cluster/b.incr.tar.gz"}, fakeServer.keys())
+
+	err = store.Get("prod/cluster/a.full.tar.gz", &contents)
+	assert.IsType(t, S3Err{}, err)
+}
+
+func TestParseS3Url(t *testing.T) {
+	t.Parallel()
+
+	bucket, prefix, err := ParseS3Url("s3://my-backups/prod/couchbase/")
+	require.NoError(t, err)
+	assert.Equal(t, "my-backups", bucket)
+	assert.Equal(t, "prod/couchbase", prefix)
+
+	bucket, prefix, err = ParseS3Url("s3://my-backups")
+	require.NoError(t, err)
+	assert.Equal(t, "my-backups", bucket)
+	assert.Equal(t, "", prefix)
+
+	for _, invalid := range []string{"my-backups/prod", "https://my-backups/prod", "s3:///prod"} {
+		_, _, err := ParseS3Url(invalid)
+		assert.Equal(t, InvalidS3UrlErr{Url: invalid}, err)
+	}
+}
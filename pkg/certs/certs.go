@@ -0,0 +1,327 @@
+// Package certs generates and loads the certificate authority (CA) and per-node certificates Couchbase uses for TLS.
+// Couchbase serves every node certificate on its TLS ports (e.g., 18091 and 18092) and checks that each node
+// certificate was signed by the cluster CA, so all the nodes in a cluster must use node certificates signed by the same
+// CA. XDCR with full encryption uses the same CA to verify the destination cluster.
+package certs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const DefaultCaValidity = 10 * 365 * 24 * time.Hour
+const DefaultNodeValidity = 825 * 24 * time.Hour
+
+const rsaKeyBits = 2048
+
+// Allow for some clock skew between the machine that generates a certificate and the machines that verify it
+const notBeforeSkew = 5 * time.Minute
+
+// KeyPair is a certificate along with its private key
+type KeyPair struct {
+	Cert *x509.Certificate
+	Key  *rsa.PrivateKey
+}
+
+// CertPem returns the certificate in PEM format
+func (pair *KeyPair) CertPem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Cert.Raw})
+}
+
+// KeyPem returns the private key in PKCS #1 PEM format, which is the format every Couchbase version accepts for
+// pkey.key
+func (pair *KeyPair) KeyPem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pair.Key)})
+}
+
+// GenerateCa generates a new self-signed CA with the given common name that is valid for the given duration
+func GenerateCa(commonName string, validity time.Duration) (*KeyPair, error) {
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := newTemplate(commonName, validity)
+	if err != nil {
+		return nil, err
+	}
+
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	return createKeyPair(template, template, key, key)
+}
+
+// GenerateNodeCert generates a new node certificate signed by the given CA that is valid for the given duration. The
+// hosts are the hostnames and IP addresses the node is reachable at. The first host is used as the common name and
+// every host is added as a subject alternative name, so clients can verify the node by whichever host they use.
+func GenerateNodeCert(ca *KeyPair, hosts []string, validity time.Duration) (*KeyPair, error) {
+	if len(hosts) == 0 {
+		return nil, NoHostsErr{}
+	}
+
+	if !ca.Cert.IsCA {
+		return nil, NotCaErr{Subject: ca.Cert.Subject.String()}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := newTemplate(hosts[0], validity)
+	if err != nil {
+		return nil, err
+	}
+
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	return createKeyPair(template, ca.Cert, key, ca.Key)
+}
+
+// ParseCa parses a CA certificate and its private key from PEM and checks that they belong together. This is how you
+// import an existing CA, rather than generating a new one with GenerateCa.
+func ParseCa(certPem []byte, keyPem []byte) (*KeyPair, error) {
+	cert, err := ParseCertificate(certPem)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cert.IsCA {
+		return nil, NotCaErr{Subject: cert.Subject.String()}
+	}
+
+	key, err := parsePrivateKey(keyPem)
+	if err != nil {
+		return nil, err
+	}
+
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		return nil, KeyMismatchErr{Subject: cert.Subject.String()}
+	}
+
+	return &KeyPair{Cert: cert, Key: key}, nil
+}
+
+// ParseCertificate parses the first certificate in the given PEM
+func ParseCertificate(certPem []byte) (*x509.Certificate, error) {
+	certs, err := ParseCertificates(certPem)
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// ParseCertificates parses all the certificates in the given PEM, such as a chain.pem file
+func ParseCertificates(certPem []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+
+	rest := certPem
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, NoPemBlockErr{Type: "CERTIFICATE"}
+	}
+
+	return certs, nil
+}
+
+// VerifyChain checks that the first certificate in the given chain was issued by the CA in caPem, using the rest of the
+// chain as intermediates. If hostname is not empty, also check that the certificate is valid for that hostname.
+func VerifyChain(chain []*x509.Certificate, caPem []byte, hostname string) error {
+	if len(chain) == 0 {
+		return EmptyChainErr{}
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPem) {
+		return NoPemBlockErr{Type: "CERTIFICATE"}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return ChainVerificationErr{Subject: chain[0].Subject.String(), Underlying: err}
+	}
+
+	return nil
+}
+
+// FetchChain connects to the given address (<HOST>:<PORT>) over TLS and returns the certificate chain the server
+// serves. The chain is NOT verified, so pass it to VerifyChain to check it against the expected CA.
+func FetchChain(address string, timeout time.Duration) ([]*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	// We verify the chain ourselves in VerifyChain, against a specific CA rather than the system roots
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	chain := conn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, EmptyChainErr{}
+	}
+
+	return chain, nil
+}
+
+func newTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	// Serial numbers must be unique per CA, so use 128 random bits, as recommended by RFC 5280
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-notBeforeSkew),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func createKeyPair(template *x509.Certificate, parent *x509.Certificate, key *rsa.PrivateKey, signer *rsa.PrivateKey) (*KeyPair, error) {
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyPair{Cert: cert, Key: key}, nil
+}
+
+// Parse an RSA private key in either PKCS #1 or PKCS #8 format, as both are common for imported CAs
+func parsePrivateKey(keyPem []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPem)
+	if block == nil {
+		return nil, NoPemBlockErr{Type: "PRIVATE KEY"}
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, isRsa := key.(*rsa.PrivateKey)
+	if !isRsa {
+		return nil, UnsupportedKeyTypeErr{Type: fmt.Sprintf("%T", key)}
+	}
+
+	return rsaKey, nil
+}
+
+func publicKeysEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+	rsaA, isRsaA := a.(*rsa.PublicKey)
+	rsaB, isRsaB := b.(*rsa.PublicKey)
+	return isRsaA && isRsaB && rsaA.N.Cmp(rsaB.N) == 0 && rsaA.E == rsaB.E
+}
+
+// Custom error types
+
+type NoHostsErr struct{}
+
+func (err NoHostsErr) Error() string {
+	return "A node certificate needs at least one hostname or IP address."
+}
+
+type NotCaErr struct {
+	Subject string
+}
+
+func (err NotCaErr) Error() string {
+	return fmt.Sprintf("Certificate %s is not a CA certificate.", err.Subject)
+}
+
+type KeyMismatchErr struct {
+	Subject string
+}
+
+func (err KeyMismatchErr) Error() string {
+	return fmt.Sprintf("The private key does not match the public key in certificate %s.", err.Subject)
+}
+
+type NoPemBlockErr struct {
+	Type string
+}
+
+func (err NoPemBlockErr) Error() string {
+	return fmt.Sprintf("Did not find a PEM block of type %s.", err.Type)
+}
+
+type UnsupportedKeyTypeErr struct {
+	Type string
+}
+
+func (err UnsupportedKeyTypeErr) Error() string {
+	return fmt.Sprintf("Unsupported private key type %s. Only RSA keys are supported.", err.Type)
+}
+
+type EmptyChainErr struct{}
+
+func (err EmptyChainErr) Error() string {
+	return "The certificate chain is empty."
+}
+
+type ChainVerificationErr struct {
+	Subject    string
+	Underlying error
+}
+
+func (err ChainVerificationErr) Error() string {
+	return fmt.Sprintf("Certificate %s was not issued by the expected CA: %v", err.Subject, err.Underlying)
+}
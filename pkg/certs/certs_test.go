@@ -0,0 +1,162 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateNodeCertIsVerifiedByCa(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, ca.Cert.IsCA)
+
+	node, err := GenerateNodeCert(ca, []string{"couchbase-0.example.com", "10.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, "couchbase-0.example.com", node.Cert.Subject.CommonName)
+	assert.Equal(t, []string{"couchbase-0.example.com"}, node.Cert.DNSNames)
+	require.Len(t, node.Cert.IPAddresses, 1)
+	assert.True(t, node.Cert.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")))
+
+	chain := []*x509.Certificate{node.Cert}
+	assert.NoError(t, VerifyChain(chain, ca.CertPem(), ""))
+	assert.NoError(t, VerifyChain(chain, ca.CertPem(), "couchbase-0.example.com"))
+	assert.NoError(t, VerifyChain(chain, ca.CertPem(), "10.0.0.1"))
+	assert.IsType(t, ChainVerificationErr{}, VerifyChain(chain, ca.CertPem(), "couchbase-1.example.com"))
+}
+
+func TestVerifyChainRejectsOtherCa(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+
+	otherCa, err := GenerateCa("Other CA", time.Hour)
+	require.NoError(t, err)
+
+	node, err := GenerateNodeCert(otherCa, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+
+	err = VerifyChain([]*x509.Certificate{node.Cert}, ca.CertPem(), "")
+	assert.IsType(t, ChainVerificationErr{}, err)
+}
+
+func TestGenerateNodeCertRequiresHostsAndCa(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+
+	_, err = GenerateNodeCert(ca, nil, time.Hour)
+	assert.IsType(t, NoHostsErr{}, err)
+
+	node, err := GenerateNodeCert(ca, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+
+	_, err = GenerateNodeCert(node, []string{"localhost"}, time.Hour)
+	assert.IsType(t, NotCaErr{}, err)
+}
+
+func TestParseCaRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+
+	parsed, err := ParseCa(ca.CertPem(), ca.KeyPem())
+	require.NoError(t, err)
+	assert.Equal(t, ca.Cert.Raw, parsed.Cert.Raw)
+	assert.Equal(t, 0, ca.Key.N.Cmp(parsed.Key.N))
+}
+
+func TestParseCaAcceptsPkcs8Keys(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(ca.Key)
+	require.NoError(t, err)
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+
+	_, err = ParseCa(ca.CertPem(), keyPem)
+	assert.NoError(t, err)
+}
+
+func TestParseCaRejectsMismatchedKey(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+
+	otherCa, err := GenerateCa("Other CA", time.Hour)
+	require.NoError(t, err)
+
+	_, err = ParseCa(ca.CertPem(), otherCa.KeyPem())
+	assert.IsType(t, KeyMismatchErr{}, err)
+}
+
+func TestParseCaRejectsNodeCert(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+
+	node, err := GenerateNodeCert(ca, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+
+	_, err = ParseCa(node.CertPem(), node.KeyPem())
+	assert.IsType(t, NotCaErr{}, err)
+}
+
+func TestParseCertificatesReadsWholeChain(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+
+	node, err := GenerateNodeCert(ca, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+
+	chain, err := ParseCertificates(append(node.CertPem(), ca.CertPem()...))
+	require.NoError(t, err)
+	require.Len(t, chain, 2)
+	assert.Equal(t, node.Cert.Raw, chain[0].Raw)
+	assert.Equal(t, ca.Cert.Raw, chain[1].Raw)
+
+	_, err = ParseCertificates([]byte("not a certificate"))
+	assert.IsType(t, NoPemBlockErr{}, err)
+}
+
+func TestFetchChainFromTlsServer(t *testing.T) {
+	t.Parallel()
+
+	ca, err := GenerateCa("Test CA", time.Hour)
+	require.NoError(t, err)
+
+	node, err := GenerateNodeCert(ca, []string{"127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+
+	tlsCert, err := tls.X509KeyPair(node.CertPem(), node.KeyPem())
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{tlsCert}}
+	server.StartTLS()
+	defer server.Close()
+
+	chain, err := FetchChain(server.Listener.Addr().String(), 5*time.Second)
+	require.NoError(t, err)
+	assert.NoError(t, VerifyChain(chain, ca.CertPem(), "127.0.0.1"))
+}
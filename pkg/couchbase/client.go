@@ -0,0 +1,354 @@
+// Package couchbase contains a minimal client for the Couchbase Server REST API. It only covers the handful of
+// endpoints the tools in this repo need, such as changing passwords and managing XDCR remote cluster references. For
+// the Data Service and the Query service, it connects to buckets via the Couchbase Go SDK instead.
+package couchbase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// By default, Go does not impose a timeout, so an HTTP connection attempt can hang for a LONG time
+const defaultTimeout = 30 * time.Second
+
+// Client makes authenticated calls to the REST API of a Couchbase cluster
+type Client struct {
+	// The URL of any node in the cluster, including the protocol and port: e.g., http://10.0.0.1:8091
+	BaseUrl  string
+	Username string
+	Password string
+
+	HttpClient *http.Client
+}
+
+// NewClient creates a Client for the cluster at the given URL that authenticates with the given credentials
+func NewClient(baseUrl string, username string, password string) *Client {
+	return &Client{
+		BaseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		Username:   username,
+		Password:   password,
+		HttpClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// WithCredentials returns a copy of this Client that authenticates with the given credentials instead
+func (client *Client) WithCredentials(username string, password string) *Client {
+	clientCopy := *client
+	clientCopy.Username = username
+	clientCopy.Password = password
+	return &clientCopy
+}
+
+// WhoAmI returns the ID of the user the Client authenticates as. This is the cheapest way to check that the Client's
+// credentials are valid, as it works for users with any roles.
+func (client *Client) WhoAmI() (string, error) {
+	var response struct {
+		Id string `json:"id"`
+	}
+
+	// https://docs.couchbase.com/server/current/rest-api/rbac.html
+	if err := client.getJson("/whoami", &response); err != nil {
+		return "", err
+	}
+
+	return response.Id, nil
+}
+
+// ClusterUuid returns the UUID of the cluster, which XDCR remote cluster references use to identify the cluster they
+// point to
+func (client *Client) ClusterUuid() (string, error) {
+	var response struct {
+		Uuid string `json:"uuid"`
+	}
+
+	if err := client.getJson("/pools", &response); err != nil {
+		return "", err
+	}
+
+	if response.Uuid == "" {
+		return "", ClusterNotInitializedErr{Url: client.BaseUrl}
+	}
+
+	return response.Uuid, nil
+}
+
+// ChangeAdminPassword changes the password of the cluster's full admin account, which is the account created when the
+// cluster was initialized. The Client must authenticate as that account.
+func (client *Client) ChangeAdminPassword(newPassword string) error {
+	// https://docs.couchbase.com/server/current/rest-api/rest-node-set-username.html
+	params := url.Values{
+		"username": {client.Username},
+		"password": {newPassword},
+		"port":     {"SAME"},
+	}
+
+	_, err := client.do("POST", "/settings/web", params, http.StatusOK)
+	return err
+}
+
+// A local (i.e., managed by Couchbase, rather than LDAP) RBAC user
+type LocalUser struct {
+	Id    string      `json:"id"`
+	Name  string      `json:"name"`
+	Roles []LocalRole `json:"roles"`
+}
+
+type LocalRole struct {
+	Role       string `json:"role"`
+	BucketName string `json:"bucket_name"`
+}
+
+// String formats the role the way the REST API and couchbase-cli expect it: e.g., bucket_full_access[my-bucket]
+func (role LocalRole) String() string {
+	if role.BucketName == "" {
+		return role.Role
+	}
+	return fmt.Sprintf("%s[%s]", role.Role, role.BucketName)
+}
+
+// GetLocalUser looks up the local RBAC user with the given username
+func (client *Client) GetLocalUser(username string) (*LocalUser, error) {
+	var user LocalUser
+
+	// https://docs.couchbase.com/server/current/rest-api/rbac.html
+	if err := client.getJson(fmt.Sprintf("/settings/rbac/users/local/%s", url.PathEscape(username)), &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SetLocalUserPassword changes the password of the given local RBAC user. The REST API replaces the entire user, so the
+// user's current name and roles are sent along with the new password.
+func (client *Client) SetLocalUserPassword(user *LocalUser, newPassword string) error {
+	roles := []string{}
+	for _, role := range user.Roles {
+		roles = append(roles, role.String())
+	}
+
+	params := url.Values{
+		"password": {newPassword},
+		"roles":    {strings.Join(roles, ",")},
+	}
+	if user.Name != "" {
+		params.Set("name", user.Name)
+	}
+
+	_, err := client.do("PUT", fmt.Sprintf("/settings/rbac/users/local/%s", url.PathEscape(user.Id)), params, http.StatusOK)
+	return err
+}
+
+// An XDCR remote cluster reference
+type RemoteClusterReference struct {
+	Name             string `json:"name"`
+	Hostname         string `json:"hostname"`
+	Username         string `json:"username"`
+	Uuid             string `json:"uuid"`
+	Deleted          bool   `json:"deleted"`
+	DemandEncryption bool   `json:"demandEncryption"`
+	EncryptionType   string `json:"encryptionType"`
+	Certificate      string `json:"certificate"`
+}
+
+// ListRemoteClusterReferences returns all the XDCR remote cluster references in the cluster that have not been deleted
+func (client *Client) ListRemoteClusterReferences() ([]RemoteClusterReference, error) {
+	var references []RemoteClusterReference
+
+	// https://docs.couchbase.com/server/current/rest-api/rest-xdcr-get-ref.html
+	if err := client.getJson("/pools/default/remoteClusters", &references); err != nil {
+		return nil, err
+	}
+
+	active := []RemoteClusterReference{}
+	for _, reference := range references {
+		if !reference.Deleted {
+			active = append(active, reference)
+		}
+	}
+
+	return active, nil
+}
+
+// UpdateRemoteClusterReferencePassword changes the password the given XDCR remote cluster reference uses to connect to
+// the remote cluster, keeping all its other settings, including encryption. Couchbase connects to the remote cluster
+// before saving the change, so this returns an error if the new password doesn't work.
+func (client *Client) UpdateRemoteClusterReferencePassword(reference RemoteClusterReference, newPassword string) error {
+	params := url.Values{
+		"name":     {reference.Name},
+		"hostname": {reference.Hostname},
+		"username": {reference.Username},
+		"password": {newPassword},
+	}
+
+	if reference.DemandEncryption {
+		params.Set("demandEncryption", "1")
+		if reference.EncryptionType != "" {
+			params.Set("encryptionType", reference.EncryptionType)
+		}
+		if reference.Certificate != "" {
+			params.Set("certificate", reference.Certificate)
+		}
+	}
+
+	// https://docs.couchbase.com/server/current/rest-api/rest-xdcr-create-ref.html
+	_, err := client.do("POST", fmt.Sprintf("/pools/default/remoteClusters/%s", url.PathEscape(reference.Name)), params, http.StatusOK)
+	return err
+}
+
+// An XDCR replication, as listed by the cluster tasks API:
+// https://docs.couchbase.com/server/current/rest-api/rest-get-cluster-tasks.html
+type XdcrReplication struct {
+	Id     string      `json:"id"`
+	Type   string      `json:"type"`
+	Source string      `json:"source"`
+	Target string      `json:"target"`
+	Status string      `json:"status"`
+	Errors []XdcrError `json:"errors"`
+}
+
+// RemoteClusterUuid returns the UUID of the remote cluster the replication writes to, which is part of its target of
+// the format /remoteClusters/<UUID>/buckets/<BUCKET>
+func (replication XdcrReplication) RemoteClusterUuid() string {
+	parts := strings.Split(strings.TrimPrefix(replication.Target, "/"), "/")
+	if len(parts) < 2 || parts[0] != "remoteClusters" {
+		return ""
+	}
+	return parts[1]
+}
+
+// An error an XDCR replication reported. Time is empty if the cluster did not say when the error happened.
+type XdcrError struct {
+	Time    string `json:"time"`
+	Message string `json:"errorMsg"`
+}
+
+// UnmarshalJSON accepts both the {"time": ..., "errorMsg": ...} objects and the plain strings different versions of
+// Couchbase use for XDCR errors
+func (xdcrError *XdcrError) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		*xdcrError = XdcrError{Message: message}
+		return nil
+	}
+
+	type xdcrErrorObject XdcrError
+	var object xdcrErrorObject
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	*xdcrError = XdcrError(object)
+	return nil
+}
+
+// ListXdcrReplications returns all the XDCR replications in the cluster, along with their status and recent errors
+func (client *Client) ListXdcrReplications() ([]XdcrReplication, error) {
+	var tasks []XdcrReplication
+
+	if err := client.getJson("/pools/default/tasks", &tasks); err != nil {
+		return nil, err
+	}
+
+	replications := []XdcrReplication{}
+	for _, task := range tasks {
+		if task.Type == "xdcr" {
+			replications = append(replications, task)
+		}
+	}
+
+	return replications, nil
+}
+
+func (client *Client) getJson(path string, out interface{}) error {
+	body, err := client.do("GET", path, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(body), out); err != nil {
+		return InvalidResponseErr{Url: client.BaseUrl + path, Body: body, Underlying: err}
+	}
+
+	return nil
+}
+
+func (client *Client) do(method string, path string, params url.Values, expectedStatus int) (string, error) {
+	reqUrl := client.BaseUrl + path
+
+	var reqBody io.Reader
+	if params != nil {
+		reqBody = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequest(method, reqUrl, reqBody)
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth(client.Username, client.Password)
+	if params != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	body := strings.TrimSpace(string(respBody))
+
+	if resp.StatusCode != expectedStatus {
+		return "", UnexpectedStatusErr{Method: method, Url: reqUrl, Expected: expectedStatus, Actual: resp.StatusCode, Body: body}
+	}
+
+	return body, nil
+}
+
+// IsUnauthorized returns true if the given error is because the Client's credentials were rejected
+func IsUnauthorized(err error) bool {
+	statusErr, isStatusErr := err.(UnexpectedStatusErr)
+	return isStatusErr && statusErr.Actual == http.StatusUnauthorized
+}
+
+// Custom error types
+
+type UnexpectedStatusErr struct {
+	Method   string
+	Url      string
+	Expected int
+	Actual   int
+	Body     string
+}
+
+func (err UnexpectedStatusErr) Error() string {
+	return fmt.Sprintf("Expected status code %d from %s %s but got %d. Response body: %s", err.Expected, err.Method, err.Url, err.Actual, err.Body)
+}
+
+type InvalidResponseErr struct {
+	Url        string
+	Body       string
+	Underlying error
+}
+
+func (err InvalidResponseErr) Error() string {
+	return fmt.Sprintf("Failed to parse response from %s: %v. Response body: %s", err.Url, err.Underlying, err.Body)
+}
+
+type ClusterNotInitializedErr struct {
+	Url string
+}
+
+func (err ClusterNotInitializedErr) Error() string {
+	return fmt.Sprintf("The Couchbase cluster at %s has not been initialized.", err.Url)
+}
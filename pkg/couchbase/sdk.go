@@ -0,0 +1,69 @@
+package couchbase
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const defaultSdkConnectTimeout = 30 * time.Second
+
+// SdkOptions configures a connection to a bucket via the Couchbase Go SDK, which talks to the Data Service and the
+// Query service directly, the same way applications do, rather than via the REST API
+type SdkOptions struct {
+	// A Couchbase connection string, such as couchbase://10.0.0.1 or couchbase://localhost:11210?network=external
+	ConnectionString string
+	Username         string
+	Password         string
+	BucketName       string
+
+	// How long to wait for the connection to the bucket to be ready. If zero, wait up to 30 seconds.
+	ConnectTimeout time.Duration
+
+	// How long each key-value operation may take before it fails. If zero, use the SDK's default.
+	OpTimeout time.Duration
+}
+
+// ConnectBucket connects to the bucket in the given options and waits until the bucket is ready for key-value
+// operations. Callers must close the returned Cluster when they are done with the bucket.
+func ConnectBucket(options SdkOptions) (*gocb.Cluster, *gocb.Bucket, error) {
+	connectTimeout := options.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultSdkConnectTimeout
+	}
+
+	cluster, err := gocb.Connect(options.ConnectionString, gocb.ClusterOptions{
+		Username: options.Username,
+		Password: options.Password,
+		TimeoutsConfig: gocb.TimeoutsConfig{
+			ConnectTimeout: connectTimeout,
+			KVTimeout:      options.OpTimeout,
+		},
+	})
+	if err != nil {
+		return nil, nil, SdkConnectErr{ConnectionString: options.ConnectionString, BucketName: options.BucketName, Underlying: err}
+	}
+
+	bucket := cluster.Bucket(options.BucketName)
+
+	waitOptions := &gocb.WaitUntilReadyOptions{ServiceTypes: []gocb.ServiceType{gocb.ServiceTypeKeyValue}}
+	if err := bucket.WaitUntilReady(connectTimeout, waitOptions); err != nil {
+		cluster.Close(nil)
+		return nil, nil, SdkConnectErr{ConnectionString: options.ConnectionString, BucketName: options.BucketName, Underlying: err}
+	}
+
+	return cluster, bucket, nil
+}
+
+// Custom error types
+
+type SdkConnectErr struct {
+	ConnectionString string
+	BucketName       string
+	Underlying       error
+}
+
+func (err SdkConnectErr) Error() string {
+	return fmt.Sprintf("Failed to connect to bucket %s at %s: %v", err.BucketName, err.ConnectionString, err.Underlying)
+}
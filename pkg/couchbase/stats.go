@@ -0,0 +1,169 @@
+package couchbase
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// The cluster-wide details and per-node stats returned by /pools/default. Only the fields the tools in this repo use are
+// included.
+type PoolDetails struct {
+	Name            string        `json:"name"`
+	Balanced        bool          `json:"balanced"`
+	RebalanceStatus string        `json:"rebalanceStatus"`
+	StorageTotals   StorageTotals `json:"storageTotals"`
+	Nodes           []NodeDetails `json:"nodes"`
+}
+
+type StorageTotals struct {
+	Ram struct {
+		Total      float64 `json:"total"`
+		Used       float64 `json:"used"`
+		UsedByData float64 `json:"usedByData"`
+		QuotaTotal float64 `json:"quotaTotal"`
+		QuotaUsed  float64 `json:"quotaUsed"`
+	} `json:"ram"`
+	Hdd struct {
+		Total      float64 `json:"total"`
+		Used       float64 `json:"used"`
+		UsedByData float64 `json:"usedByData"`
+		Free       float64 `json:"free"`
+	} `json:"hdd"`
+}
+
+type NodeDetails struct {
+	Hostname          string       `json:"hostname"`
+	OtpNode           string       `json:"otpNode"`
+	Status            string       `json:"status"`
+	ClusterMembership string       `json:"clusterMembership"`
+	Version           string       `json:"version"`
+	Services          []string     `json:"services"`
+	Uptime            string       `json:"uptime"`
+	ThisNode          bool         `json:"thisNode"`
+	SystemStats       NumericStats `json:"systemStats"`
+	InterestingStats  NumericStats `json:"interestingStats"`
+}
+
+// A set of named stats. Couchbase mixes in the odd non-numeric value with the numeric stats, and which ones varies by
+// version, so anything that isn't a number is skipped rather than failing the whole response.
+type NumericStats map[string]float64
+
+func (stats *NumericStats) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*stats = NumericStats{}
+	for name, value := range raw {
+		if number, isNumber := value.(float64); isNumber {
+			(*stats)[name] = number
+		}
+	}
+
+	return nil
+}
+
+// A bucket, as returned by /pools/default/buckets
+type BucketDetails struct {
+	Name       string       `json:"name"`
+	BucketType string       `json:"bucketType"`
+	Quota      NumericStats `json:"quota"`
+	BasicStats NumericStats `json:"basicStats"`
+	// The nodes the bucket is on. Each node's status is the status of the bucket on that node: e.g., "warmup" while the
+	// node loads the bucket's data from disk.
+	Nodes []NodeDetails `json:"nodes"`
+}
+
+// The stats of a bucket, as returned by /pools/default/buckets/<BUCKET>/stats. Each stat is a list of samples, oldest
+// first.
+type BucketStats struct {
+	Op struct {
+		Samples      map[string][]float64 `json:"samples"`
+		SamplesCount int                  `json:"samplesCount"`
+	} `json:"op"`
+}
+
+// Latest returns the most recent sample of the given stat, and false if there are no samples for it
+func (stats BucketStats) Latest(stat string) (float64, bool) {
+	samples := stats.Op.Samples[stat]
+	if len(samples) == 0 {
+		return 0, false
+	}
+	return samples[len(samples)-1], true
+}
+
+// A cluster task, as returned by /pools/default/tasks, such as a rebalance or an XDCR replication. Which fields are set
+// depends on the type of task.
+type Task struct {
+	Type        string        `json:"type"`
+	Id          string        `json:"id"`
+	Status      string        `json:"status"`
+	Source      string        `json:"source"`
+	Target      string        `json:"target"`
+	ChangesLeft float64       `json:"changesLeft"`
+	DocsChecked float64       `json:"docsChecked"`
+	Errors      []interface{} `json:"errors"`
+}
+
+// The type of the tasks for XDCR replications
+const XdcrTaskType = "xdcr"
+
+// The values of NodeDetails.Status, NodeDetails.ClusterMembership, and PoolDetails.RebalanceStatus that the tools in this
+// repo check for
+const (
+	NodeStatusHealthy       = "healthy"
+	NodeStatusWarmup        = "warmup"
+	ClusterMembershipActive = "active"
+	RebalanceStatusRunning  = "running"
+)
+
+// GetPoolDetails returns the cluster-wide details of the cluster and the stats of each of its nodes
+func (client *Client) GetPoolDetails() (*PoolDetails, error) {
+	var details PoolDetails
+
+	// https://docs.couchbase.com/server/current/rest-api/rest-cluster-details.html
+	if err := client.getJson("/pools/default", &details); err != nil {
+		return nil, err
+	}
+
+	return &details, nil
+}
+
+// ListBuckets returns all the buckets in the cluster, along with their basic stats
+func (client *Client) ListBuckets() ([]BucketDetails, error) {
+	var buckets []BucketDetails
+
+	// https://docs.couchbase.com/server/current/rest-api/rest-buckets-summary.html
+	if err := client.getJson("/pools/default/buckets", &buckets); err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
+
+// GetBucketStats returns the detailed stats of the given bucket over the last minute, which include the stats of the
+// XDCR replications from the bucket
+func (client *Client) GetBucketStats(bucket string) (*BucketStats, error) {
+	var stats BucketStats
+
+	// https://docs.couchbase.com/server/current/rest-api/rest-bucket-stats.html
+	if err := client.getJson(fmt.Sprintf("/pools/default/buckets/%s/stats", url.PathEscape(bucket)), &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// ListTasks returns the tasks running in the cluster, including one for each XDCR replication
+func (client *Client) ListTasks() ([]Task, error) {
+	var tasks []Task
+
+	// https://docs.couchbase.com/server/current/rest-api/rest-get-cluster-tasks.html
+	if err := client.getJson("/pools/default/tasks", &tasks); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
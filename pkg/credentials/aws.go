@@ -0,0 +1,218 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// LazyAwsSession creates an AWS session the first time it's needed. This way, resolving env:// and file:// references
+// never requires AWS credentials or a region.
+type LazyAwsSession struct {
+	region  string
+	once    sync.Once
+	session *session.Session
+	err     error
+}
+
+// NewLazyAwsSession creates a LazyAwsSession for the given region. If the region is empty, it will be looked up from
+// the standard AWS SDK environment variables and config files, falling back to EC2 metadata.
+func NewLazyAwsSession(region string) *LazyAwsSession {
+	return &LazyAwsSession{region: region}
+}
+
+// Get returns the AWS session, creating it if necessary
+func (lazySession *LazyAwsSession) Get() (*session.Session, error) {
+	lazySession.once.Do(func() {
+		lazySession.session, lazySession.err = newAwsSession(lazySession.region)
+	})
+	return lazySession.session, lazySession.err
+}
+
+func newAwsSession(region string) (*session.Session, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{Region: aws.String(region)},
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if aws.StringValue(sess.Config.Region) != "" {
+		return sess, nil
+	}
+
+	metadataRegion, err := ec2metadata.New(sess).Region()
+	if err != nil {
+		return nil, NoAwsRegionErr{Underlying: err}
+	}
+
+	return sess.Copy(&aws.Config{Region: aws.String(metadataRegion)}), nil
+}
+
+// SsmBackend fetches secrets from the AWS Systems Manager Parameter Store, decrypting SecureString parameters. The
+// location is the name of the parameter, so hierarchical parameters need three slashes, as in
+// ssm:///couchbase/admin-password.
+type SsmBackend struct {
+	Session *LazyAwsSession
+
+	// If set, this client is used instead of one created from Session. Primarily useful for testing.
+	Client ssmiface.SSMAPI
+}
+
+func (backend *SsmBackend) Fetch(location string) (string, error) {
+	client, err := backend.client()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := client.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(location),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return "", EmptySecretErr{Location: location}
+	}
+
+	return aws.StringValue(output.Parameter.Value), nil
+}
+
+func (backend *SsmBackend) client() (ssmiface.SSMAPI, error) {
+	if backend.Client != nil {
+		return backend.Client, nil
+	}
+
+	sess, err := backend.Session.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	backend.Client = ssm.New(sess)
+	return backend.Client, nil
+}
+
+// SecretsManagerBackend fetches secrets from AWS Secrets Manager. The location is the name or ARN of the secret. If the
+// secret is a JSON object, you can select a single key from it with a fragment, as in
+// secretsmanager://couchbase-credentials#password.
+type SecretsManagerBackend struct {
+	Session *LazyAwsSession
+
+	// If set, this client is used instead of one created from Session. Primarily useful for testing.
+	Client secretsmanageriface.SecretsManagerAPI
+}
+
+func (backend *SecretsManagerBackend) Fetch(location string) (string, error) {
+	client, err := backend.client()
+	if err != nil {
+		return "", err
+	}
+
+	secretId, jsonKey := splitJsonKey(location)
+
+	output, err := client.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretId),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if output.SecretString == nil {
+		return "", EmptySecretErr{Location: location}
+	}
+
+	if jsonKey == "" {
+		return aws.StringValue(output.SecretString), nil
+	}
+
+	return extractJsonKey(secretId, aws.StringValue(output.SecretString), jsonKey)
+}
+
+func (backend *SecretsManagerBackend) client() (secretsmanageriface.SecretsManagerAPI, error) {
+	if backend.Client != nil {
+		return backend.Client, nil
+	}
+
+	sess, err := backend.Session.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	backend.Client = secretsmanager.New(sess)
+	return backend.Client, nil
+}
+
+// Split a location of the format <SECRET_ID>#<JSON_KEY> into its secret ID and JSON key. The JSON key is empty if the
+// location doesn't contain a fragment.
+func splitJsonKey(location string) (string, string) {
+	hashIndex := strings.LastIndex(location, "#")
+	if hashIndex < 0 {
+		return location, ""
+	}
+	return location[:hashIndex], location[hashIndex+1:]
+}
+
+func extractJsonKey(secretId string, secretString string, jsonKey string) (string, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(secretString), &values); err != nil {
+		return "", SecretNotJsonErr{SecretId: secretId, Underlying: err}
+	}
+
+	value, hasKey := values[jsonKey]
+	if !hasKey {
+		return "", JsonKeyNotFoundErr{SecretId: secretId, Key: jsonKey}
+	}
+
+	if valueString, isString := value.(string); isString {
+		return valueString, nil
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+// Custom error types
+
+type NoAwsRegionErr struct {
+	Underlying error
+}
+
+func (err NoAwsRegionErr) Error() string {
+	return fmt.Sprintf("Could not determine the AWS region. Set it explicitly or run on an EC2 Instance. Looking it up in EC2 metadata failed with: %v", err.Underlying)
+}
+
+type EmptySecretErr struct {
+	Location string
+}
+
+func (err EmptySecretErr) Error() string {
+	return fmt.Sprintf("Secret %s has no string value.", err.Location)
+}
+
+type SecretNotJsonErr struct {
+	SecretId   string
+	Underlying error
+}
+
+func (err SecretNotJsonErr) Error() string {
+	return fmt.Sprintf("Secret %s is not a JSON object, so a key cannot be selected from it: %v", err.SecretId, err.Underlying)
+}
+
+type JsonKeyNotFoundErr struct {
+	SecretId string
+	Key      string
+}
+
+func (err JsonKeyNotFoundErr) Error() string {
+	return fmt.Sprintf("Secret %s does not contain the key %s.", err.SecretId, err.Key)
+}
@@ -0,0 +1,61 @@
+package credentials
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvBackend fetches secrets from environment variables. The location is the name of the environment variable, as in
+// env://COUCHBASE_PASSWORD.
+type EnvBackend struct{}
+
+func (backend EnvBackend) Fetch(location string) (string, error) {
+	value, isSet := os.LookupEnv(location)
+	if !isSet {
+		return "", EnvVarNotSetErr{Name: location}
+	}
+	return value, nil
+}
+
+// FileBackend fetches secrets from files on local disk. The location is the absolute path of the file, as in
+// file:///run/secrets/couchbase-password. Trailing new lines are stripped, as most tools that write secrets to disk add
+// one.
+type FileBackend struct{}
+
+func (backend FileBackend) Fetch(location string) (string, error) {
+	return readSecretFile(location)
+}
+
+// DirectoryBackend is a local stand-in for the AWS Backends: it looks up each secret in a file named after the
+// location within Dir. For example, if you register a DirectoryBackend for the ssm scheme with Dir set to
+// /opt/mock-secrets, then ssm:///couchbase/password resolves to the contents of /opt/mock-secrets/couchbase/password.
+// This allows code that uses ssm:// and secretsmanager:// references to be tested in Docker, without talking to AWS.
+type DirectoryBackend struct {
+	Dir string
+}
+
+func (backend DirectoryBackend) Fetch(location string) (string, error) {
+	path := filepath.Join(backend.Dir, filepath.FromSlash(strings.TrimPrefix(location, "/")))
+	return readSecretFile(path)
+}
+
+func readSecretFile(path string) (string, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(bytes), "\r\n"), nil
+}
+
+// Custom error types
+
+type EnvVarNotSetErr struct {
+	Name string
+}
+
+func (err EnvVarNotSetErr) Error() string {
+	return fmt.Sprintf("Environment variable %s is not set.", err.Name)
+}
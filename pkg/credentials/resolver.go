@@ -0,0 +1,141 @@
+// Package credentials resolves references to secrets, such as ssm:///couchbase/admin-password, into their plaintext
+// values at runtime. This allows the run-couchbase-server, run-replication, and run-sync-gateway scripts to accept
+// references to credentials rather than the plaintext credentials themselves, so that the plaintext never ends up in
+// User Data or in process listings.
+package credentials
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The schemes supported by NewDefaultResolver
+const (
+	EnvScheme            = "env"
+	FileScheme           = "file"
+	SsmScheme            = "ssm"
+	SecretsManagerScheme = "secretsmanager"
+)
+
+// A reference is of the format <SCHEME>://<LOCATION>, where SCHEME is one of the schemes above. Any other value, such as
+// a password that happens to look like a URL, is plaintext. This must match is_secret_reference in couchbase-common.sh.
+var referenceRegex = regexp.MustCompile(`^(env|file|ssm|secretsmanager)://(.*)$`)
+
+// Backend is a source of secrets. Each Backend is registered with a Resolver under a scheme, and the Resolver passes
+// everything after <SCHEME>:// in a reference to the Backend as the location of the secret.
+type Backend interface {
+	Fetch(location string) (string, error)
+}
+
+// Resolver maps the scheme of each reference to the Backend that can fetch it
+type Resolver struct {
+	backends map[string]Backend
+}
+
+// NewResolver creates a Resolver with no Backends registered
+func NewResolver() *Resolver {
+	return &Resolver{backends: map[string]Backend{}}
+}
+
+// NewDefaultResolver creates a Resolver with Backends registered for the env://, file://, ssm://, and
+// secretsmanager:// schemes. The AWS Backends use the given region, or if it's empty, look up the region using the
+// standard AWS SDK mechanisms and EC2 metadata.
+func NewDefaultResolver(awsRegion string) *Resolver {
+	awsSession := NewLazyAwsSession(awsRegion)
+
+	resolver := NewResolver()
+	resolver.Register(EnvScheme, EnvBackend{})
+	resolver.Register(FileScheme, FileBackend{})
+	resolver.Register(SsmScheme, &SsmBackend{Session: awsSession})
+	resolver.Register(SecretsManagerScheme, &SecretsManagerBackend{Session: awsSession})
+	return resolver
+}
+
+// Register the given Backend for the given scheme, replacing any Backend previously registered for that scheme. This is
+// useful, for example, to replace the AWS Backends with a local stand-in when running in Docker.
+func (resolver *Resolver) Register(scheme string, backend Backend) {
+	resolver.backends[scheme] = backend
+}
+
+// Schemes returns the schemes that have a Backend registered, sorted alphabetically
+func (resolver *Resolver) Schemes() []string {
+	schemes := []string{}
+	for scheme := range resolver.backends {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Resolve the given value. If the value is a reference to a secret, fetch the secret from the Backend registered for
+// the reference's scheme and return it. Otherwise, return the value unchanged, so that plaintext values continue to
+// work.
+func (resolver *Resolver) Resolve(value string) (string, error) {
+	scheme, location, isReference := ParseReference(value)
+	if !isReference {
+		return value, nil
+	}
+
+	backend, hasBackend := resolver.backends[scheme]
+	if !hasBackend {
+		return "", UnsupportedSchemeErr{Scheme: scheme, Supported: resolver.Schemes()}
+	}
+
+	if location == "" {
+		return "", EmptyLocationErr{Reference: value}
+	}
+
+	secret, err := backend.Fetch(location)
+	if err != nil {
+		return "", FetchErr{Reference: value, Underlying: err}
+	}
+
+	return secret, nil
+}
+
+// IsReference returns true if the given value is of the format <SCHEME>://<LOCATION> with one of the supported schemes
+func IsReference(value string) bool {
+	_, _, isReference := ParseReference(value)
+	return isReference
+}
+
+// ParseReference splits a reference of the format <SCHEME>://<LOCATION> into its scheme and location. The last return
+// value is false if the given value is not a reference, including if its scheme is not one of the supported schemes.
+func ParseReference(value string) (string, string, bool) {
+	matches := referenceRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return "", "", false
+	}
+
+	return matches[1], matches[2], true
+}
+
+// Custom error types
+
+type UnsupportedSchemeErr struct {
+	Scheme    string
+	Supported []string
+}
+
+func (err UnsupportedSchemeErr) Error() string {
+	return fmt.Sprintf("Unsupported secret reference scheme '%s'. Must be one of: %s.", err.Scheme, strings.Join(err.Supported, ", "))
+}
+
+type EmptyLocationErr struct {
+	Reference string
+}
+
+func (err EmptyLocationErr) Error() string {
+	return fmt.Sprintf("Secret reference '%s' does not specify a location.", err.Reference)
+}
+
+type FetchErr struct {
+	Reference  string
+	Underlying error
+}
+
+func (err FetchErr) Error() string {
+	return fmt.Sprintf("Failed to fetch secret '%s': %v", err.Reference, err.Underlying)
+}
@@ -0,0 +1,198 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		value               string
+		expectedScheme      string
+		expectedLocation    string
+		expectedIsReference bool
+	}{
+		{"password", "", "", false},
+		{"", "", "", false},
+		{"env://FOO", "env", "FOO", true},
+		{"file:///run/secrets/foo", "file", "/run/secrets/foo", true},
+		{"ssm:///couchbase/password", "ssm", "/couchbase/password", true},
+		{"secretsmanager://creds#password", "secretsmanager", "creds#password", true},
+		{"SSM://foo", "", "", false},
+		{"file://word://", "file", "word://", true},
+		{"pass://word://", "", "", false},
+		{"http://example.com", "", "", false},
+		{"vault://secret/couchbase", "", "", false},
+	}
+
+	for _, testCase := range testCases {
+		scheme, location, isReference := ParseReference(testCase.value)
+		assert.Equal(t, testCase.expectedScheme, scheme, testCase.value)
+		assert.Equal(t, testCase.expectedLocation, location, testCase.value)
+		assert.Equal(t, testCase.expectedIsReference, isReference, testCase.value)
+	}
+}
+
+func TestResolvePlaintextIsUnchanged(t *testing.T) {
+	t.Parallel()
+
+	secret, err := NewResolver().Resolve("my-password")
+	require.NoError(t, err)
+	assert.Equal(t, "my-password", secret)
+}
+
+func TestResolveUnsupportedScheme(t *testing.T) {
+	t.Parallel()
+
+	resolver := NewResolver()
+	resolver.Register(EnvScheme, EnvBackend{})
+
+	_, err := resolver.Resolve("ssm:///couchbase/password")
+	require.Error(t, err)
+	assert.IsType(t, UnsupportedSchemeErr{}, err)
+}
+
+// Values that look like URLs, but don't use one of the supported schemes, are plaintext, just like in the scripts
+func TestResolveUrlLikePlaintextIsUnchanged(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{"http://example.com/password", "vault://secret/couchbase"} {
+		secret, err := NewDefaultResolver("us-east-1").Resolve(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, value, secret)
+	}
+}
+
+func TestResolveEmptyLocation(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewDefaultResolver("us-east-1").Resolve("env://")
+	require.Error(t, err)
+	assert.IsType(t, EmptyLocationErr{}, err)
+}
+
+func TestResolveEnv(t *testing.T) {
+	os.Setenv("COUCHBASE_CREDENTIALS_TEST_PASSWORD", "from-env")
+	defer os.Unsetenv("COUCHBASE_CREDENTIALS_TEST_PASSWORD")
+
+	resolver := NewDefaultResolver("us-east-1")
+
+	secret, err := resolver.Resolve("env://COUCHBASE_CREDENTIALS_TEST_PASSWORD")
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", secret)
+
+	_, err = resolver.Resolve("env://COUCHBASE_CREDENTIALS_TEST_NOT_SET")
+	require.Error(t, err)
+	assert.IsType(t, FetchErr{}, err)
+}
+
+func TestResolveFile(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", "credentials-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	secretPath := filepath.Join(tmpDir, "password")
+	require.NoError(t, ioutil.WriteFile(secretPath, []byte("from-file\n"), 0600))
+
+	secret, err := NewDefaultResolver("us-east-1").Resolve("file://" + secretPath)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", secret)
+}
+
+func TestResolveWithDirectoryBackendStandIn(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", "credentials-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "couchbase"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "couchbase", "password"), []byte("from-dir"), 0600))
+
+	resolver := NewDefaultResolver("us-east-1")
+	resolver.Register(SsmScheme, DirectoryBackend{Dir: tmpDir})
+
+	secret, err := resolver.Resolve("ssm:///couchbase/password")
+	require.NoError(t, err)
+	assert.Equal(t, "from-dir", secret)
+}
+
+type fakeSsmClient struct {
+	ssmiface.SSMAPI
+	parameters map[string]string
+}
+
+func (client fakeSsmClient) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	value, hasParameter := client.parameters[aws.StringValue(input.Name)]
+	if !hasParameter {
+		return nil, &ssm.ParameterNotFound{}
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+}
+
+func TestResolveSsm(t *testing.T) {
+	t.Parallel()
+
+	resolver := NewResolver()
+	resolver.Register(SsmScheme, &SsmBackend{Client: fakeSsmClient{parameters: map[string]string{"/couchbase/password": "from-ssm"}}})
+
+	secret, err := resolver.Resolve("ssm:///couchbase/password")
+	require.NoError(t, err)
+	assert.Equal(t, "from-ssm", secret)
+
+	_, err = resolver.Resolve("ssm:///couchbase/does-not-exist")
+	require.Error(t, err)
+}
+
+type fakeSecretsManagerClient struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]string
+}
+
+func (client fakeSecretsManagerClient) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	value, hasSecret := client.secrets[aws.StringValue(input.SecretId)]
+	if !hasSecret {
+		return nil, &secretsmanager.ResourceNotFoundException{}
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+}
+
+func TestResolveSecretsManager(t *testing.T) {
+	t.Parallel()
+
+	client := fakeSecretsManagerClient{secrets: map[string]string{
+		"plain":       "from-secrets-manager",
+		"credentials": `{"username": "admin", "password": "from-json"}`,
+	}}
+
+	resolver := NewResolver()
+	resolver.Register(SecretsManagerScheme, &SecretsManagerBackend{Client: client})
+
+	secret, err := resolver.Resolve("secretsmanager://plain")
+	require.NoError(t, err)
+	assert.Equal(t, "from-secrets-manager", secret)
+
+	secret, err = resolver.Resolve("secretsmanager://credentials#password")
+	require.NoError(t, err)
+	assert.Equal(t, "from-json", secret)
+
+	_, err = resolver.Resolve("secretsmanager://credentials#missing")
+	require.Error(t, err)
+
+	_, err = resolver.Resolve("secretsmanager://plain#password")
+	require.Error(t, err)
+}
@@ -0,0 +1,149 @@
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+// Couchbase treats expiry values longer than this as absolute Unix timestamps rather than as durations
+const maxRelativeExpiry = 30 * 24 * time.Hour
+
+// Pages through the bucket in key order, using the primary index. META(d).expiration is zero for documents that
+// never expire.
+const scanQueryTemplate = "SELECT META(d).id AS `key`, META(d).expiration AS expiration, META(d).flags AS flags, d AS `value` FROM `%s` AS d WHERE META(d).id > $after ORDER BY META(d).id LIMIT $limit"
+
+// CouchbaseBucket reads and writes documents in the default collection of a Couchbase bucket via the Couchbase Go SDK.
+// Writes go straight to the Data Service. Scans use the Query service, so the bucket needs a primary index, which
+// CreatePrimaryIndex can create. Only JSON documents can be scanned.
+type CouchbaseBucket struct {
+	cluster    *gocb.Cluster
+	collection *gocb.Collection
+	bucketName string
+}
+
+// NewCouchbaseBucket connects to the bucket in the given options and waits until the bucket is ready for key-value
+// operations
+func NewCouchbaseBucket(options couchbase.SdkOptions) (*CouchbaseBucket, error) {
+	cluster, bucket, err := couchbase.ConnectBucket(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CouchbaseBucket{
+		cluster:    cluster,
+		collection: bucket.DefaultCollection(),
+		bucketName: options.BucketName,
+	}, nil
+}
+
+// Upsert creates or replaces the given document, along with its expiration and flags
+func (bucket *CouchbaseBucket) Upsert(document Document) error {
+	var transcoder gocb.Transcoder = gocb.NewRawJSONTranscoder()
+	if document.Flags != 0 {
+		transcoder = rawTranscoder{flags: document.Flags}
+	}
+
+	_, err := bucket.collection.Upsert(document.Key, document.Value, &gocb.UpsertOptions{
+		Transcoder: transcoder,
+		Expiry:     expiryFor(document.Expiration),
+	})
+	return err
+}
+
+// Scan returns up to limit documents whose keys sort after afterKey, in key order
+func (bucket *CouchbaseBucket) Scan(afterKey string, limit int) ([]Document, error) {
+	result, err := bucket.cluster.Query(fmt.Sprintf(scanQueryTemplate, bucket.bucketName), &gocb.QueryOptions{
+		NamedParameters: map[string]interface{}{"after": afterKey, "limit": limit},
+		// Make sure the index includes every write that happened before the export started
+		ScanConsistency: gocb.QueryScanConsistencyRequestPlus,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	documents := []Document{}
+	for result.Next() {
+		var row envelope
+		if err := result.Row(&row); err != nil {
+			return nil, err
+		}
+		documents = append(documents, row.toDocument())
+	}
+
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// CreatePrimaryIndex creates the primary index Scan needs, unless the bucket already has one
+func (bucket *CouchbaseBucket) CreatePrimaryIndex() error {
+	return bucket.cluster.QueryIndexes().CreatePrimaryIndex(bucket.bucketName, &gocb.CreatePrimaryQueryIndexOptions{IgnoreIfExists: true})
+}
+
+// Close the connection to the cluster
+func (bucket *CouchbaseBucket) Close() error {
+	return bucket.cluster.Close(nil)
+}
+
+// Convert an expiration time to the expiry the SDK expects. The SDK sends durations to the server as a number of
+// seconds, and the server treats anything over 30 days as a Unix timestamp, so for expirations further out than that,
+// we pass the timestamp itself.
+func expiryFor(expiration time.Time) time.Duration {
+	if expiration.IsZero() {
+		return 0
+	}
+
+	remaining := time.Until(expiration)
+	if remaining <= maxRelativeExpiry {
+		return remaining
+	}
+
+	return time.Duration(expiration.Unix()) * time.Second
+}
+
+// A gocb.Transcoder that stores values as-is with the given flags, so documents keep the flags they were exported with
+type rawTranscoder struct {
+	flags uint32
+}
+
+func (transcoder rawTranscoder) Encode(value interface{}) ([]byte, uint32, error) {
+	switch typedValue := value.(type) {
+	case json.RawMessage:
+		return typedValue, transcoder.flags, nil
+	case []byte:
+		return typedValue, transcoder.flags, nil
+	default:
+		return nil, 0, UnsupportedValueTypeErr{Value: value}
+	}
+}
+
+func (transcoder rawTranscoder) Decode(bytes []byte, flags uint32, out interface{}) error {
+	switch typedOut := out.(type) {
+	case *json.RawMessage:
+		*typedOut = bytes
+		return nil
+	case *[]byte:
+		*typedOut = bytes
+		return nil
+	default:
+		return UnsupportedValueTypeErr{Value: out}
+	}
+}
+
+// Custom error types
+
+type UnsupportedValueTypeErr struct {
+	Value interface{}
+}
+
+func (err UnsupportedValueTypeErr) Error() string {
+	return fmt.Sprintf("Values of type %T are not supported. Use json.RawMessage or []byte.", err.Value)
+}
@@ -0,0 +1,182 @@
+// Package dataset seeds Couchbase buckets from JSON Lines files, where each line is one document, and exports buckets
+// back to JSON Lines. It is meant for loading realistic data into test and demo environments.
+package dataset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Document is a single document in a bucket
+type Document struct {
+	Key   string
+	Value json.RawMessage
+
+	// When the document expires. The zero value means the document never expires.
+	Expiration time.Time
+
+	// The flags Couchbase stores alongside the document, which SDKs use to tell how the value is encoded. Zero means
+	// the flags the SDKs use for JSON documents.
+	Flags uint32
+}
+
+// The JSON Lines format Export writes and Seed reads with Envelopes set, which keeps the key, expiration, and flags of
+// each document alongside its value, so a bucket can be exported and seeded again without losing anything
+type envelope struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+
+	// The expiration as a Unix timestamp in seconds, or zero if the document never expires
+	Expiration int64  `json:"expiration,omitempty"`
+	Flags      uint32 `json:"flags,omitempty"`
+}
+
+func (document Document) toEnvelope() envelope {
+	envelope := envelope{Key: document.Key, Value: document.Value, Flags: document.Flags}
+	if !document.Expiration.IsZero() {
+		envelope.Expiration = document.Expiration.Unix()
+	}
+	return envelope
+}
+
+func (envelope envelope) toDocument() Document {
+	document := Document{Key: envelope.Key, Value: envelope.Value, Flags: envelope.Flags}
+	if envelope.Expiration > 0 {
+		document.Expiration = time.Unix(envelope.Expiration, 0)
+	}
+	return document
+}
+
+// Matches the {field} placeholders in a key template. Nested fields are separated by dots: e.g., {address.city}.
+var placeholderRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// KeyTemplate builds the key of a document from the values of its fields
+type KeyTemplate struct {
+	template string
+	fields   []string
+}
+
+// ParseKeyTemplate parses a key template such as user::{id} or {country}::{address.city}, where each {field} is
+// replaced by the value of that field in the document. Fields must be strings, numbers, or booleans.
+func ParseKeyTemplate(template string) (*KeyTemplate, error) {
+	matches := placeholderRegex.FindAllStringSubmatch(template, -1)
+	if len(matches) == 0 {
+		return nil, InvalidKeyTemplateErr{Template: template}
+	}
+
+	fields := []string{}
+	for _, match := range matches {
+		fields = append(fields, match[1])
+	}
+
+	return &KeyTemplate{template: template, fields: fields}, nil
+}
+
+// KeyTemplateForField returns a KeyTemplate that uses the value of the given field as the key as-is
+func KeyTemplateForField(field string) *KeyTemplate {
+	return &KeyTemplate{template: fmt.Sprintf("{%s}", field), fields: []string{field}}
+}
+
+// Render the key for the given document
+func (keyTemplate *KeyTemplate) Render(value json.RawMessage) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil || object == nil {
+		return "", NotAnObjectErr{}
+	}
+
+	var renderErr error
+	key := placeholderRegex.ReplaceAllStringFunc(keyTemplate.template, func(placeholder string) string {
+		field := placeholder[1 : len(placeholder)-1]
+
+		fieldValue, err := lookupField(object, field)
+		if err != nil && renderErr == nil {
+			renderErr = err
+		}
+		return fieldValue
+	})
+
+	if renderErr != nil {
+		return "", renderErr
+	}
+	if key == "" {
+		return "", EmptyKeyErr{Template: keyTemplate.template}
+	}
+
+	return key, nil
+}
+
+// Look up the value of the given field, where nested fields are separated by dots, and format it for use in a key
+func lookupField(object map[string]interface{}, field string) (string, error) {
+	var current interface{} = object
+
+	for _, part := range strings.Split(field, ".") {
+		currentObject, isObject := current.(map[string]interface{})
+		if !isObject {
+			return "", MissingFieldErr{Field: field}
+		}
+
+		next, hasField := currentObject[part]
+		if !hasField {
+			return "", MissingFieldErr{Field: field}
+		}
+		current = next
+	}
+
+	switch value := current.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return fmt.Sprintf("%t", value), nil
+	default:
+		return "", UnsupportedFieldTypeErr{Field: field}
+	}
+}
+
+// Custom error types
+
+type InvalidKeyTemplateErr struct {
+	Template string
+}
+
+func (err InvalidKeyTemplateErr) Error() string {
+	return fmt.Sprintf("Invalid key template '%s'. It must contain at least one {field} placeholder: e.g., user::{id}.", err.Template)
+}
+
+type NotAnObjectErr struct{}
+
+func (err NotAnObjectErr) Error() string {
+	return "The document is not a JSON object, so its key can't be built from its fields."
+}
+
+type MissingFieldErr struct {
+	Field string
+}
+
+func (err MissingFieldErr) Error() string {
+	return fmt.Sprintf("The document does not have the field %s, which the key template needs.", err.Field)
+}
+
+type UnsupportedFieldTypeErr struct {
+	Field string
+}
+
+func (err UnsupportedFieldTypeErr) Error() string {
+	return fmt.Sprintf("The field %s must be a string, number, or boolean to be used in a key.", err.Field)
+}
+
+type EmptyKeyErr struct {
+	Template string
+}
+
+func (err EmptyKeyErr) Error() string {
+	return fmt.Sprintf("The key template '%s' rendered an empty key.", err.Template)
+}
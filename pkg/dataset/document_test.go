@@ -0,0 +1,120 @@
+package dataset
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyTemplate(t *testing.T) {
+	t.Parallel()
+
+	keyTemplate, err := ParseKeyTemplate("user::{id}")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id"}, keyTemplate.fields)
+
+	_, err = ParseKeyTemplate("user::id")
+	assert.Equal(t, InvalidKeyTemplateErr{Template: "user::id"}, err)
+}
+
+func TestKeyTemplateRender(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		template string
+		value    string
+		expected string
+	}{
+		{"string field", "user::{id}", `{"id": "abc"}`, "user::abc"},
+		{"integer field", "user::{id}", `{"id": 12345678901234567890}`, "user::12345678901234567890"},
+		{"float field", "{score}", `{"score": 1.5}`, "1.5"},
+		{"bool field", "active::{active}", `{"active": true}`, "active::true"},
+		{"nested field", "{country}::{address.city}", `{"country": "us", "address": {"city": "nyc"}}`, "us::nyc"},
+		{"field as-is", "{id}", `{"id": "abc", "other": 1}`, "abc"},
+	}
+
+	for _, testCase := range testCases {
+		keyTemplate, err := ParseKeyTemplate(testCase.template)
+		require.NoError(t, err, testCase.name)
+
+		key, err := keyTemplate.Render(json.RawMessage(testCase.value))
+		require.NoError(t, err, testCase.name)
+		assert.Equal(t, testCase.expected, key, testCase.name)
+	}
+
+	key, err := KeyTemplateForField("id").Render(json.RawMessage(`{"id": "abc"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", key)
+}
+
+func TestKeyTemplateRenderErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		template string
+		value    string
+		expected error
+	}{
+		{"array", "{id}", `[1, 2]`, NotAnObjectErr{}},
+		{"null", "{id}", `null`, NotAnObjectErr{}},
+		{"missing field", "{id}", `{"name": "abc"}`, MissingFieldErr{Field: "id"}},
+		{"missing nested field", "{address.city}", `{"address": "nyc"}`, MissingFieldErr{Field: "address.city"}},
+		{"object field", "{address}", `{"address": {"city": "nyc"}}`, UnsupportedFieldTypeErr{Field: "address"}},
+		{"null field", "{id}", `{"id": null}`, UnsupportedFieldTypeErr{Field: "id"}},
+		{"empty key", "{id}", `{"id": ""}`, EmptyKeyErr{Template: "{id}"}},
+	}
+
+	for _, testCase := range testCases {
+		keyTemplate, err := ParseKeyTemplate(testCase.template)
+		require.NoError(t, err, testCase.name)
+
+		_, err = keyTemplate.Render(json.RawMessage(testCase.value))
+		assert.Equal(t, testCase.expected, err, testCase.name)
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	document := Document{
+		Key:        "user::1",
+		Value:      json.RawMessage(`{"name":"abc"}`),
+		Expiration: time.Unix(2000000000, 0),
+		Flags:      0x2000000,
+	}
+
+	encoded, err := json.Marshal(document.toEnvelope())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"key":"user::1","value":{"name":"abc"},"expiration":2000000000,"flags":33554432}`, string(encoded))
+
+	var decoded envelope
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, document.Key, decoded.toDocument().Key)
+	assert.Equal(t, document.Flags, decoded.toDocument().Flags)
+	assert.True(t, document.Expiration.Equal(decoded.toDocument().Expiration))
+	assert.JSONEq(t, string(document.Value), string(decoded.toDocument().Value))
+
+	// Documents that never expire and have the default flags leave those fields out
+	encoded, err = json.Marshal(Document{Key: "a", Value: json.RawMessage(`1`)}.toEnvelope())
+	require.NoError(t, err)
+	assert.Equal(t, `{"key":"a","value":1}`, string(encoded))
+	assert.True(t, envelope{Key: "a", Value: json.RawMessage(`1`)}.toDocument().Expiration.IsZero())
+}
+
+func TestExpiryFor(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Duration(0), expiryFor(time.Time{}))
+
+	soon := expiryFor(time.Now().Add(time.Hour))
+	assert.True(t, soon > 59*time.Minute && soon <= time.Hour, "Expected about an hour, but got %s", soon)
+
+	// Beyond 30 days, Couchbase expects an absolute Unix timestamp
+	later := time.Unix(time.Now().Add(60*24*time.Hour).Unix(), 0)
+	assert.Equal(t, time.Duration(later.Unix())*time.Second, expiryFor(later))
+}
@@ -0,0 +1,143 @@
+package dataset
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+const defaultBatchSize = 1000
+
+// DocumentScanner pages through the documents in a bucket in key order. CouchbaseBucket implements it.
+type DocumentScanner interface {
+	// Return up to limit documents whose keys sort after afterKey, in key order. An empty afterKey means start from the
+	// beginning. Fewer than limit documents means there are no more.
+	Scan(afterKey string, limit int) ([]Document, error)
+}
+
+// ExportOptions configures a single Export run
+type ExportOptions struct {
+	Scanner DocumentScanner
+
+	// Where to write the JSON Lines output
+	Output io.Writer
+
+	// How many documents to fetch per page
+	BatchSize int
+
+	// If empty, write each document as an envelope with its key, value, expiration, and flags, which Seed can read
+	// back with Envelopes set. Otherwise, write the value of each document as-is, with its key added as this field,
+	// which Seed can read back with KeyTemplateForField(KeyField). Only JSON objects can be written this way.
+	KeyField string
+
+	// How often to log progress
+	ProgressInterval time.Duration
+
+	Logger *log.Logger
+}
+
+// Export writes every document in a bucket to the output as JSON Lines, in key order, and returns how many documents
+// it wrote
+func Export(options ExportOptions) (int64, error) {
+	if options.Scanner == nil {
+		return 0, NoScannerErr{}
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.ProgressInterval <= 0 {
+		options.ProgressInterval = defaultProgressInterval
+	}
+	if options.Logger == nil {
+		options.Logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	writer := bufio.NewWriter(options.Output)
+
+	var exported int64
+	afterKey := ""
+	lastProgress := time.Now()
+
+	for {
+		documents, err := options.Scanner.Scan(afterKey, options.BatchSize)
+		if err != nil {
+			return exported, ScanErr{AfterKey: afterKey, Underlying: err}
+		}
+
+		for _, document := range documents {
+			line, err := exportLine(document, options.KeyField)
+			if err != nil {
+				return exported, err
+			}
+			if _, err := writer.Write(append(line, '\n')); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+
+		if time.Since(lastProgress) >= options.ProgressInterval {
+			options.Logger.Printf("Exported %d documents", exported)
+			lastProgress = time.Now()
+		}
+
+		if len(documents) < options.BatchSize {
+			break
+		}
+		afterKey = documents[len(documents)-1].Key
+	}
+
+	if err := writer.Flush(); err != nil {
+		return exported, err
+	}
+
+	options.Logger.Printf("Exported %d documents", exported)
+	return exported, nil
+}
+
+func exportLine(document Document, keyField string) ([]byte, error) {
+	if keyField == "" {
+		return json.Marshal(document.toEnvelope())
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(document.Value, &object); err != nil || object == nil {
+		return nil, NotAnObjectExportErr{Key: document.Key}
+	}
+
+	key, err := json.Marshal(document.Key)
+	if err != nil {
+		return nil, err
+	}
+	object[keyField] = key
+
+	return json.Marshal(object)
+}
+
+// Custom error types
+
+type NoScannerErr struct{}
+
+func (err NoScannerErr) Error() string {
+	return "No DocumentScanner was specified to export the documents from."
+}
+
+type ScanErr struct {
+	AfterKey   string
+	Underlying error
+}
+
+func (err ScanErr) Error() string {
+	return fmt.Sprintf("Failed to fetch the documents after key '%s': %v", err.AfterKey, err.Underlying)
+}
+
+type NotAnObjectExportErr struct {
+	Key string
+}
+
+func (err NotAnObjectExportErr) Error() string {
+	return fmt.Sprintf("The document %s is not a JSON object, so its key can't be added as a field. Export envelopes instead.", err.Key)
+}
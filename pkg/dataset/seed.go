@@ -0,0 +1,502 @@
+package dataset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultConcurrency = 8
+const defaultProgressInterval = 10 * time.Second
+
+// The longest line Seed can read. Couchbase documents can be up to 20 MB, plus some room for the envelope.
+const maxLineSize = 21 * 1024 * 1024
+
+// DocumentWriter writes documents to a bucket. CouchbaseBucket implements it. Implementations must be safe for
+// concurrent use.
+type DocumentWriter interface {
+	// Create or replace the given document
+	Upsert(document Document) error
+}
+
+// SeedOptions configures a single Seed run
+type SeedOptions struct {
+	Writer DocumentWriter
+
+	// The JSON Lines input, with one document per line. Blank lines are skipped.
+	Input io.Reader
+
+	// A name for the input, such as its path, which is stored in the checkpoint, so a checkpoint can't be used to
+	// resume seeding from a different input by accident
+	Source string
+
+	// If true, each line is an envelope, as written by Export, with the key, value, expiration, and flags of the
+	// document. Otherwise, each line is the value of the document itself, and KeyTemplate builds its key.
+	Envelopes bool
+
+	// Builds the key of each document from its fields. Required unless Envelopes is set.
+	KeyTemplate *KeyTemplate
+
+	// If Envelopes is not set, each document expires this long after it is written. Zero means never.
+	Expiry time.Duration
+
+	// If Envelopes is not set, the flags to store with each document. Zero means the flags for JSON documents.
+	Flags uint32
+
+	// How many documents to write concurrently
+	Concurrency int
+
+	// If set, Seed records the number of lines it has written in this file as it goes, and skips those lines when it
+	// runs again with the same checkpoint file, so an interrupted run can pick up where it left off
+	CheckpointPath string
+
+	// How often to log progress and update the checkpoint
+	ProgressInterval time.Duration
+
+	Logger *log.Logger
+}
+
+// SeedResult summarizes a Seed run
+type SeedResult struct {
+	// How many documents were written
+	Written int64
+
+	// How many lines were skipped because the checkpoint said they had already been written
+	Resumed int64
+
+	// How many envelopes were skipped because their expiration had already passed
+	Expired int64
+}
+
+// The contents of a checkpoint file
+type checkpoint struct {
+	Source    string `json:"source"`
+	LinesDone int64  `json:"lines_done"`
+}
+
+// A line to write, along with its line number, starting at 1
+type seedJob struct {
+	line     int64
+	document Document
+}
+
+// Seed streams the documents in the input into a bucket, as specified in the given options, and returns how many it
+// wrote. If writing any document fails, Seed stops, records the progress so far in the checkpoint file, if any, and
+// returns the error.
+func Seed(options SeedOptions) (*SeedResult, error) {
+	options = withSeedDefaults(options)
+
+	if options.Writer == nil {
+		return nil, NoWriterErr{}
+	}
+	if !options.Envelopes && options.KeyTemplate == nil {
+		return nil, NoKeyTemplateErr{}
+	}
+
+	linesDone, err := loadCheckpoint(options.CheckpointPath, options.Source)
+	if err != nil {
+		return nil, err
+	}
+	if linesDone > 0 {
+		options.Logger.Printf("Resuming from checkpoint %s: skipping the first %d lines of %s", options.CheckpointPath, linesDone, options.Source)
+	}
+
+	tracker := newProgressTracker(options, linesDone)
+	jobs := make(chan seedJob, options.Concurrency*2)
+
+	var workers sync.WaitGroup
+	for i := 0; i < options.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				// Once something has failed, drain the remaining jobs without writing them, so the checkpoint stays
+				// before them
+				if tracker.failed() {
+					continue
+				}
+				if err := options.Writer.Upsert(job.document); err != nil {
+					tracker.fail(SeedLineErr{Line: job.line, Key: job.document.Key, Underlying: err})
+					continue
+				}
+				tracker.written(job.line)
+			}
+		}()
+	}
+
+	go tracker.run()
+
+	readErr := readLines(options, linesDone, tracker, jobs)
+	if readErr != nil {
+		tracker.fail(readErr)
+	}
+
+	close(jobs)
+	workers.Wait()
+
+	result, err := tracker.finish()
+	if err != nil {
+		return result, err
+	}
+
+	options.Logger.Printf("Wrote %d documents from %s", result.Written, options.Source)
+	return result, nil
+}
+
+func withSeedDefaults(options SeedOptions) SeedOptions {
+	if options.Concurrency <= 0 {
+		options.Concurrency = defaultConcurrency
+	}
+	if options.ProgressInterval <= 0 {
+		options.ProgressInterval = defaultProgressInterval
+	}
+	if options.Logger == nil {
+		options.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	return options
+}
+
+// Read the input line by line and send each document to the workers, skipping the lines the checkpoint says are done
+func readLines(options SeedOptions, linesDone int64, tracker *progressTracker, jobs chan<- seedJob) error {
+	scanner := bufio.NewScanner(options.Input)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	var line int64
+	for scanner.Scan() {
+		line++
+
+		if line <= linesDone {
+			tracker.resumed()
+			continue
+		}
+
+		if tracker.failed() {
+			return nil
+		}
+
+		text := scanner.Bytes()
+		if len(bytes.TrimSpace(text)) == 0 {
+			tracker.skipped(line)
+			continue
+		}
+
+		document, err := parseLine(options, text)
+		if err != nil {
+			return SeedLineErr{Line: line, Underlying: err}
+		}
+
+		if !document.Expiration.IsZero() && !document.Expiration.After(time.Now()) {
+			tracker.expired(line)
+			continue
+		}
+
+		jobs <- seedJob{line: line, document: document}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return ReadInputErr{Source: options.Source, Line: line + 1, Underlying: err}
+	}
+
+	return nil
+}
+
+func parseLine(options SeedOptions, text []byte) (Document, error) {
+	// The scanner reuses its buffer, so we copy the line before handing it to a worker
+	value := make([]byte, len(text))
+	copy(value, text)
+
+	if options.Envelopes {
+		var envelope envelope
+		if err := json.Unmarshal(value, &envelope); err != nil {
+			return Document{}, err
+		}
+		if envelope.Key == "" {
+			return Document{}, MissingEnvelopeKeyErr{}
+		}
+		if len(envelope.Value) == 0 {
+			return Document{}, MissingEnvelopeValueErr{Key: envelope.Key}
+		}
+		return envelope.toDocument(), nil
+	}
+
+	if !json.Valid(value) {
+		return Document{}, InvalidJsonErr{}
+	}
+
+	key, err := options.KeyTemplate.Render(value)
+	if err != nil {
+		return Document{}, err
+	}
+
+	document := Document{Key: key, Value: value, Flags: options.Flags}
+	if options.Expiry > 0 {
+		document.Expiration = time.Now().Add(options.Expiry)
+	}
+	return document, nil
+}
+
+// Tracks which lines are done, and from that, the highest line number up to which every line is done, which is what
+// goes in the checkpoint. As the workers finish lines out of order, the lines done beyond that point are kept in a set
+// until the gap before them closes.
+type progressTracker struct {
+	options SeedOptions
+
+	mutex     sync.Mutex
+	linesDone int64
+	pending   map[int64]bool
+	result    SeedResult
+	err       error
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newProgressTracker(options SeedOptions, linesDone int64) *progressTracker {
+	return &progressTracker{
+		options:   options,
+		linesDone: linesDone,
+		pending:   map[int64]bool{},
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// Log progress and update the checkpoint every ProgressInterval until finish is called
+func (tracker *progressTracker) run() {
+	defer close(tracker.stopped)
+
+	ticker := time.NewTicker(tracker.options.ProgressInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			tracker.mutex.Lock()
+			written := tracker.result.Written
+			linesDone := tracker.linesDone
+			tracker.mutex.Unlock()
+
+			tracker.options.Logger.Printf("Wrote %d documents (%.1f/sec); every line up to %d is done", written, float64(written)/time.Since(start).Seconds(), linesDone)
+
+			if err := writeCheckpoint(tracker.options.CheckpointPath, tracker.options.Source, linesDone); err != nil {
+				tracker.options.Logger.Printf("WARNING: failed to update checkpoint %s: %v", tracker.options.CheckpointPath, err)
+			}
+		case <-tracker.stop:
+			return
+		}
+	}
+}
+
+// Stop the progress loop, write the final checkpoint, and return the result along with the first error, if any
+func (tracker *progressTracker) finish() (*SeedResult, error) {
+	close(tracker.stop)
+	<-tracker.stopped
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	result := tracker.result
+
+	if err := writeCheckpoint(tracker.options.CheckpointPath, tracker.options.Source, tracker.linesDone); err != nil && tracker.err == nil {
+		return &result, err
+	}
+
+	return &result, tracker.err
+}
+
+func (tracker *progressTracker) written(line int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.result.Written++
+	tracker.markDone(line)
+}
+
+func (tracker *progressTracker) expired(line int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.result.Expired++
+	tracker.markDone(line)
+}
+
+func (tracker *progressTracker) skipped(line int64) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.markDone(line)
+}
+
+func (tracker *progressTracker) resumed() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.result.Resumed++
+}
+
+// Record the first error, which stops the run
+func (tracker *progressTracker) fail(err error) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if tracker.err == nil {
+		tracker.err = err
+	}
+}
+
+func (tracker *progressTracker) failed() bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	return tracker.err != nil
+}
+
+// Must be called with the mutex held
+func (tracker *progressTracker) markDone(line int64) {
+	tracker.pending[line] = true
+	for tracker.pending[tracker.linesDone+1] {
+		delete(tracker.pending, tracker.linesDone+1)
+		tracker.linesDone++
+	}
+}
+
+// Return how many lines the checkpoint at the given path says are done, or zero if there is no checkpoint yet
+func loadCheckpoint(path string, source string) (int64, error) {
+	if path == "" {
+		return 0, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var existing checkpoint
+	if err := json.Unmarshal(contents, &existing); err != nil {
+		return 0, InvalidCheckpointErr{Path: path, Underlying: err}
+	}
+
+	if existing.Source != source {
+		return 0, CheckpointMismatchErr{Path: path, CheckpointSource: existing.Source, Source: source}
+	}
+
+	return existing.LinesDone, nil
+}
+
+// Write the checkpoint to a temp file first and then move it into place, so an interrupted write never leaves a
+// corrupt checkpoint behind
+func writeCheckpoint(path string, source string, linesDone int64) error {
+	if path == "" {
+		return nil
+	}
+
+	contents, err := json.Marshal(checkpoint{Source: source, LinesDone: linesDone})
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(contents); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// Custom error types
+
+type NoWriterErr struct{}
+
+func (err NoWriterErr) Error() string {
+	return "No DocumentWriter was specified to seed the documents into."
+}
+
+type NoKeyTemplateErr struct{}
+
+func (err NoKeyTemplateErr) Error() string {
+	return "A key template is required to build the key of each document, unless the input consists of envelopes."
+}
+
+type SeedLineErr struct {
+	Line       int64
+	Key        string
+	Underlying error
+}
+
+func (err SeedLineErr) Error() string {
+	if err.Key == "" {
+		return fmt.Sprintf("Failed to seed line %d: %v", err.Line, err.Underlying)
+	}
+	return fmt.Sprintf("Failed to seed line %d (key %s): %v", err.Line, err.Key, err.Underlying)
+}
+
+type ReadInputErr struct {
+	Source     string
+	Line       int64
+	Underlying error
+}
+
+func (err ReadInputErr) Error() string {
+	return fmt.Sprintf("Failed to read line %d of %s: %v", err.Line, err.Source, err.Underlying)
+}
+
+type InvalidJsonErr struct{}
+
+func (err InvalidJsonErr) Error() string {
+	return "The line is not valid JSON."
+}
+
+type MissingEnvelopeKeyErr struct{}
+
+func (err MissingEnvelopeKeyErr) Error() string {
+	return "The envelope does not have a key."
+}
+
+type MissingEnvelopeValueErr struct {
+	Key string
+}
+
+func (err MissingEnvelopeValueErr) Error() string {
+	return fmt.Sprintf("The envelope for key %s does not have a value.", err.Key)
+}
+
+type InvalidCheckpointErr struct {
+	Path       string
+	Underlying error
+}
+
+func (err InvalidCheckpointErr) Error() string {
+	return fmt.Sprintf("Failed to parse checkpoint %s: %v", err.Path, err.Underlying)
+}
+
+type CheckpointMismatchErr struct {
+	Path             string
+	CheckpointSource string
+	Source           string
+}
+
+func (err CheckpointMismatchErr) Error() string {
+	return fmt.Sprintf("Checkpoint %s is for %s, not %s. Delete the checkpoint or use a different one to seed %s.", err.Path, err.CheckpointSource, err.Source, err.Source)
+}
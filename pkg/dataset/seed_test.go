@@ -0,0 +1,242 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An in-memory bucket that implements both DocumentWriter and DocumentScanner
+type fakeBucket struct {
+	mutex     sync.Mutex
+	documents map[string]Document
+	failKey   string
+}
+
+func newFakeBucket() *fakeBucket {
+	return &fakeBucket{documents: map[string]Document{}}
+}
+
+func (bucket *fakeBucket) Upsert(document Document) error {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	if document.Key == bucket.failKey {
+		return errors.New("fake error")
+	}
+	bucket.documents[document.Key] = document
+	return nil
+}
+
+func (bucket *fakeBucket) Scan(afterKey string, limit int) ([]Document, error) {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	keys := []string{}
+	for key := range bucket.documents {
+		if key > afterKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	documents := []Document{}
+	for _, key := range keys {
+		if len(documents) == limit {
+			break
+		}
+		documents = append(documents, bucket.documents[key])
+	}
+	return documents, nil
+}
+
+func userLines(count int) string {
+	lines := []string{}
+	for i := 1; i <= count; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id": %d, "name": "user %d"}`, i, i))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestSeedWithKeyTemplate(t *testing.T) {
+	t.Parallel()
+
+	keyTemplate, err := ParseKeyTemplate("user::{id}")
+	require.NoError(t, err)
+
+	bucket := newFakeBucket()
+	result, err := Seed(SeedOptions{
+		Writer:      bucket,
+		Input:       strings.NewReader(userLines(100) + "\n   \n"),
+		Source:      "users.jsonl",
+		KeyTemplate: keyTemplate,
+		Expiry:      time.Hour,
+		Flags:       42,
+		Concurrency: 4,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(100), result.Written)
+	assert.Len(t, bucket.documents, 100)
+
+	document := bucket.documents["user::7"]
+	assert.JSONEq(t, `{"id": 7, "name": "user 7"}`, string(document.Value))
+	assert.Equal(t, uint32(42), document.Flags)
+	assert.True(t, time.Until(document.Expiration) > 59*time.Minute)
+}
+
+func TestSeedReportsLineOfInvalidDocument(t *testing.T) {
+	t.Parallel()
+
+	input := userLines(3) + `{"name": "no id"}` + "\n" + userLines(3)
+
+	_, err := Seed(SeedOptions{Writer: newFakeBucket(), Input: strings.NewReader(input), KeyTemplate: KeyTemplateForField("id")})
+	assert.Equal(t, SeedLineErr{Line: 4, Underlying: MissingFieldErr{Field: "id"}}, err)
+
+	_, err = Seed(SeedOptions{Writer: newFakeBucket(), Input: strings.NewReader("{not json"), KeyTemplate: KeyTemplateForField("id")})
+	assert.Equal(t, SeedLineErr{Line: 1, Underlying: InvalidJsonErr{}}, err)
+}
+
+func TestSeedResumesFromCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", "dataset-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	checkpointPath := filepath.Join(tmpDir, "checkpoint.json")
+	input := userLines(50)
+
+	// The first run fails on the document on line 30, so every line before it is done, but none after it
+	bucket := newFakeBucket()
+	bucket.failKey = "30"
+
+	_, err = Seed(SeedOptions{
+		Writer:         bucket,
+		Input:          strings.NewReader(input),
+		Source:         "users.jsonl",
+		KeyTemplate:    KeyTemplateForField("id"),
+		Concurrency:    1,
+		CheckpointPath: checkpointPath,
+	})
+	require.Error(t, err)
+	assert.Equal(t, "30", err.(SeedLineErr).Key)
+
+	contents, err := ioutil.ReadFile(checkpointPath)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"source": "users.jsonl", "lines_done": 29}`, string(contents))
+
+	// The second run skips the lines the first run wrote
+	bucket.failKey = ""
+	bucket.documents = map[string]Document{}
+
+	result, err := Seed(SeedOptions{
+		Writer:         bucket,
+		Input:          strings.NewReader(input),
+		Source:         "users.jsonl",
+		KeyTemplate:    KeyTemplateForField("id"),
+		Concurrency:    4,
+		CheckpointPath: checkpointPath,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(29), result.Resumed)
+	assert.Equal(t, int64(21), result.Written)
+	assert.Len(t, bucket.documents, 21)
+	assert.Contains(t, bucket.documents, "30")
+	assert.NotContains(t, bucket.documents, "29")
+
+	// A checkpoint for one input can't be used for another
+	_, err = Seed(SeedOptions{
+		Writer:         bucket,
+		Input:          strings.NewReader(input),
+		Source:         "orders.jsonl",
+		KeyTemplate:    KeyTemplateForField("id"),
+		CheckpointPath: checkpointPath,
+	})
+	assert.Equal(t, CheckpointMismatchErr{Path: checkpointPath, CheckpointSource: "users.jsonl", Source: "orders.jsonl"}, err)
+}
+
+func TestProgressTrackerWatermark(t *testing.T) {
+	t.Parallel()
+
+	tracker := newProgressTracker(withSeedDefaults(SeedOptions{}), 10)
+
+	tracker.written(12)
+	tracker.written(13)
+	assert.Equal(t, int64(10), tracker.linesDone)
+
+	tracker.skipped(11)
+	assert.Equal(t, int64(13), tracker.linesDone)
+	assert.Empty(t, tracker.pending)
+}
+
+func TestSeedRejectsInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	_, err := Seed(SeedOptions{Input: strings.NewReader("")})
+	assert.Equal(t, NoWriterErr{}, err)
+
+	_, err = Seed(SeedOptions{Writer: newFakeBucket(), Input: strings.NewReader("")})
+	assert.Equal(t, NoKeyTemplateErr{}, err)
+}
+
+func TestExportAndSeedEnvelopesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	source := newFakeBucket()
+	source.documents["a"] = Document{Key: "a", Value: json.RawMessage(`{"x":1}`)}
+	source.documents["b"] = Document{Key: "b", Value: json.RawMessage(`"just a string"`), Flags: 0x4000000}
+	source.documents["c"] = Document{Key: "c", Value: json.RawMessage(`[1,2,3]`), Expiration: time.Unix(time.Now().Add(time.Hour).Unix(), 0)}
+	source.documents["d"] = Document{Key: "d", Value: json.RawMessage(`{"x":2}`), Expiration: time.Unix(time.Now().Add(-time.Hour).Unix(), 0)}
+
+	output := &bytes.Buffer{}
+	exported, err := Export(ExportOptions{Scanner: source, Output: output, BatchSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), exported)
+	assert.Equal(t, 4, strings.Count(output.String(), "\n"))
+
+	destination := newFakeBucket()
+	result, err := Seed(SeedOptions{Writer: destination, Input: output, Envelopes: true})
+	require.NoError(t, err)
+
+	// The document that had already expired is skipped
+	assert.Equal(t, int64(3), result.Written)
+	assert.Equal(t, int64(1), result.Expired)
+	assert.NotContains(t, destination.documents, "d")
+
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Equal(t, source.documents[key].Flags, destination.documents[key].Flags, key)
+		assert.True(t, source.documents[key].Expiration.Equal(destination.documents[key].Expiration), key)
+		assert.JSONEq(t, string(source.documents[key].Value), string(destination.documents[key].Value), key)
+	}
+}
+
+func TestExportWithKeyField(t *testing.T) {
+	t.Parallel()
+
+	source := newFakeBucket()
+	source.documents["user::1"] = Document{Key: "user::1", Value: json.RawMessage(`{"name":"abc"}`)}
+
+	output := &bytes.Buffer{}
+	_, err := Export(ExportOptions{Scanner: source, Output: output, KeyField: "_key"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"_key": "user::1", "name": "abc"}`, output.String())
+
+	// Documents that are not objects have nowhere to put the key
+	source.documents["user::2"] = Document{Key: "user::2", Value: json.RawMessage(`42`)}
+	_, err = Export(ExportOptions{Scanner: source, Output: &bytes.Buffer{}, KeyField: "_key"})
+	assert.Equal(t, NotAnObjectExportErr{Key: "user::2"}, err)
+}
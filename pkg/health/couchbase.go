@@ -0,0 +1,90 @@
+package health
+
+import (
+	"fmt"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+// CouchbaseChecker checks whether the Couchbase Server node the client connects to is ready to serve traffic. The
+// client must connect to the node being checked, such as via http://localhost:8091, as Couchbase reports the state of
+// the whole cluster from any node, and the checker finds the current node by the thisNode flag Couchbase sets on it.
+//
+// The node is ready if it's healthy and an active member of the cluster, which rules out nodes that are down, have
+// been failed over, or were added but not yet rebalanced in. Optionally, the node must also have finished warming up
+// all its buckets, and there must be no rebalance running.
+type CouchbaseChecker struct {
+	Client *couchbase.Client
+	// If true, the node is not ready while it's loading the data of any bucket from disk, such as after a restart
+	WaitForWarmup bool
+	// If true, no node is ready while a rebalance is running in the cluster. Note that this takes every node in the
+	// cluster out of the load balancer at once, so only use it if clients can tolerate that.
+	WaitForRebalance bool
+}
+
+func (checker CouchbaseChecker) Check() ([]string, error) {
+	pool, err := checker.Client.GetPoolDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	node, found := findThisNode(pool.Nodes)
+	if !found {
+		return []string{"This node is not part of the cluster."}, nil
+	}
+
+	reasons := []string{}
+
+	if node.Status != couchbase.NodeStatusHealthy {
+		reasons = append(reasons, fmt.Sprintf("Node %s has status %s.", node.Hostname, node.Status))
+	}
+
+	if node.ClusterMembership != couchbase.ClusterMembershipActive {
+		reasons = append(reasons, fmt.Sprintf("Node %s has cluster membership %s.", node.Hostname, node.ClusterMembership))
+	}
+
+	if checker.WaitForRebalance && pool.RebalanceStatus == couchbase.RebalanceStatusRunning {
+		reasons = append(reasons, "A rebalance is running.")
+	}
+
+	if checker.WaitForWarmup {
+		buckets, err := checker.Client.ListBuckets()
+		if err != nil {
+			return reasons, err
+		}
+
+		reasons = append(reasons, checkBucketWarmup(buckets)...)
+	}
+
+	return reasons, nil
+}
+
+// checkBucketWarmup returns a reason for each bucket that isn't healthy on the current node. Buckets that aren't on the
+// current node at all, such as right after it joined the cluster, have nothing to warm up there, so they are skipped.
+func checkBucketWarmup(buckets []couchbase.BucketDetails) []string {
+	reasons := []string{}
+
+	for _, bucket := range buckets {
+		node, found := findThisNode(bucket.Nodes)
+		if !found || node.Status == couchbase.NodeStatusHealthy {
+			continue
+		}
+
+		if node.Status == couchbase.NodeStatusWarmup {
+			reasons = append(reasons, fmt.Sprintf("Bucket %s is warming up.", bucket.Name))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("Bucket %s has status %s.", bucket.Name, node.Status))
+		}
+	}
+
+	return reasons
+}
+
+func findThisNode(nodes []couchbase.NodeDetails) (couchbase.NodeDetails, bool) {
+	for _, node := range nodes {
+		if node.ThisNode {
+			return node, true
+		}
+	}
+	return couchbase.NodeDetails{}, false
+}
@@ -0,0 +1,153 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+const testUsername = "health-check"
+const testPassword = "health-check-password"
+
+// A stand-in for a REST API that responds to each path with a fixed JSON body. A path that isn't in the map gets a 404.
+func newFakeServer(t *testing.T, checkAuth bool, responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if checkAuth {
+			username, password, _ := r.BasicAuth()
+			if username != testUsername || password != testPassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		body, exists := responses[r.URL.Path]
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+}
+
+const healthyPool = `{
+  "rebalanceStatus": "none",
+  "nodes": [
+    {"hostname": "10.0.0.1:8091", "status": "healthy", "clusterMembership": "active", "thisNode": true},
+    {"hostname": "10.0.0.2:8091", "status": "healthy", "clusterMembership": "active"}
+  ]
+}`
+
+const warmingUpBuckets = `[
+  {"name": "ready-bucket", "nodes": [{"hostname": "10.0.0.1:8091", "status": "healthy", "thisNode": true}]},
+  {"name": "warming-bucket", "nodes": [
+    {"hostname": "10.0.0.1:8091", "status": "warmup", "thisNode": true},
+    {"hostname": "10.0.0.2:8091", "status": "healthy"}
+  ]},
+  {"name": "other-node-bucket", "nodes": [{"hostname": "10.0.0.2:8091", "status": "warmup"}]}
+]`
+
+func checkCouchbase(t *testing.T, checker CouchbaseChecker, responses map[string]string) ([]string, error) {
+	server := newFakeServer(t, true, responses)
+	defer server.Close()
+
+	checker.Client = couchbase.NewClient(server.URL, testUsername, testPassword)
+	return checker.Check()
+}
+
+func TestCouchbaseCheckerHealthyNode(t *testing.T) {
+	t.Parallel()
+
+	reasons, err := checkCouchbase(t, CouchbaseChecker{WaitForWarmup: true, WaitForRebalance: true}, map[string]string{
+		"/pools/default":         healthyPool,
+		"/pools/default/buckets": `[{"name": "test-bucket", "nodes": [{"status": "healthy", "thisNode": true}]}]`,
+	})
+
+	require.NoError(t, err)
+	assert.Empty(t, reasons)
+}
+
+func TestCouchbaseCheckerUnhealthyNode(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		status          string
+		membership      string
+		expectedReasons []string
+	}{
+		{"down", "unhealthy", "active", []string{"Node 10.0.0.1:8091 has status unhealthy."}},
+		{"warmup", "warmup", "active", []string{"Node 10.0.0.1:8091 has status warmup."}},
+		{"failed-over", "healthy", "inactiveFailed", []string{"Node 10.0.0.1:8091 has cluster membership inactiveFailed."}},
+		{"not-rebalanced-in", "healthy", "inactiveAdded", []string{"Node 10.0.0.1:8091 has cluster membership inactiveAdded."}},
+		{"down-and-failed-over", "unhealthy", "inactiveFailed", []string{"Node 10.0.0.1:8091 has status unhealthy.", "Node 10.0.0.1:8091 has cluster membership inactiveFailed."}},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase // capture range variable; otherwise, only the very last test case will run!
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			pool := `{"rebalanceStatus": "none", "nodes": [{"hostname": "10.0.0.1:8091", "status": "` + testCase.status + `", "clusterMembership": "` + testCase.membership + `", "thisNode": true}]}`
+			reasons, err := checkCouchbase(t, CouchbaseChecker{}, map[string]string{"/pools/default": pool})
+
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedReasons, reasons)
+		})
+	}
+}
+
+func TestCouchbaseCheckerNodeNotInCluster(t *testing.T) {
+	t.Parallel()
+
+	pool := `{"nodes": [{"hostname": "10.0.0.2:8091", "status": "healthy", "clusterMembership": "active"}]}`
+	reasons, err := checkCouchbase(t, CouchbaseChecker{}, map[string]string{"/pools/default": pool})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"This node is not part of the cluster."}, reasons)
+}
+
+func TestCouchbaseCheckerClusterNotInitialized(t *testing.T) {
+	t.Parallel()
+
+	// Until the cluster is initialized, /pools/default returns a 404
+	_, err := checkCouchbase(t, CouchbaseChecker{}, map[string]string{})
+	assert.Error(t, err)
+}
+
+func TestCouchbaseCheckerWarmup(t *testing.T) {
+	t.Parallel()
+
+	responses := map[string]string{"/pools/default": healthyPool, "/pools/default/buckets": warmingUpBuckets}
+
+	reasons, err := checkCouchbase(t, CouchbaseChecker{}, responses)
+	require.NoError(t, err)
+	assert.Empty(t, reasons, "Warmup should be ignored unless WaitForWarmup is set")
+
+	reasons, err = checkCouchbase(t, CouchbaseChecker{WaitForWarmup: true}, responses)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bucket warming-bucket is warming up."}, reasons)
+}
+
+func TestCouchbaseCheckerRebalance(t *testing.T) {
+	t.Parallel()
+
+	responses := map[string]string{
+		"/pools/default": `{"rebalanceStatus": "running", "nodes": [{"hostname": "10.0.0.1:8091", "status": "healthy", "clusterMembership": "active", "thisNode": true}]}`,
+	}
+
+	reasons, err := checkCouchbase(t, CouchbaseChecker{}, responses)
+	require.NoError(t, err)
+	assert.Empty(t, reasons, "Rebalances should be ignored unless WaitForRebalance is set")
+
+	reasons, err = checkCouchbase(t, CouchbaseChecker{WaitForRebalance: true}, responses)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A rebalance is running."}, reasons)
+}
@@ -0,0 +1,61 @@
+// Package health checks whether Couchbase Server and Sync Gateway on the current node are ready to serve traffic, and
+// serves the result over HTTP so load balancer health checks can route only to ready nodes. Unlike a plain HTTP check
+// against the Couchbase Web Console or the Sync Gateway REST API, which pass as soon as the process is listening, these
+// checks fail while a node is warming up, rebalancing, failed over, or otherwise not serving data.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Checker checks whether one service on the current node is ready to serve traffic
+type Checker interface {
+	// Check returns the reasons the service is not ready, or an empty list if it's ready. An error means the check
+	// itself failed, such as when the service isn't running, and is treated as not ready.
+	Check() ([]string, error)
+}
+
+// The result of a check, as served by Handler
+type Status struct {
+	Ready   bool     `json:"ready"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Handler runs a check on every request, and responds with 200 OK if the service is ready and 503 Service Unavailable
+// if it isn't, along with the Status as JSON, so a person debugging a failing health check can see why it fails
+type Handler struct {
+	Checker Checker
+}
+
+// RunCheck runs the given check and returns its result as a Status
+func RunCheck(checker Checker) Status {
+	reasons, err := checker.Check()
+	if err != nil {
+		reasons = append(reasons, err.Error())
+	}
+
+	return Status{Ready: len(reasons) == 0, Reasons: reasons}
+}
+
+func (handler Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := RunCheck(handler.Checker)
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Load balancers may check a node every few seconds, so make sure no proxy serves a stale result
+	w.Header().Set("Cache-Control", "no-store")
+
+	if status.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,54 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedChecker struct {
+	reasons []string
+	err     error
+}
+
+func (checker fixedChecker) Check() ([]string, error) {
+	return checker.reasons, checker.err
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		checker        fixedChecker
+		expectedStatus int
+		expectedBody   Status
+	}{
+		{"ready", fixedChecker{}, http.StatusOK, Status{Ready: true}},
+		{"not-ready", fixedChecker{reasons: []string{"Bucket test-bucket is warming up."}}, http.StatusServiceUnavailable, Status{Ready: false, Reasons: []string{"Bucket test-bucket is warming up."}}},
+		{"check-failed", fixedChecker{err: errors.New("connection refused")}, http.StatusServiceUnavailable, Status{Ready: false, Reasons: []string{"connection refused"}}},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase // capture range variable; otherwise, only the very last test case will run!
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			Handler{Checker: testCase.checker}.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/couchbase", nil))
+
+			assert.Equal(t, testCase.expectedStatus, recorder.Code)
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+			var status Status
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+			assert.Equal(t, testCase.expectedBody, status)
+		})
+	}
+}
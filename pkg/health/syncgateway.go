@@ -0,0 +1,118 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The state Sync Gateway reports for a database that is serving traffic. A database is Offline while it's being
+// configured or resynced, and Starting while it connects to its Couchbase bucket.
+const syncGatewayDatabaseOnline = "Online"
+
+// SyncGatewayChecker checks whether Sync Gateway is ready to serve traffic, using its admin REST API. Sync Gateway is
+// ready if the API responds and every database is Online.
+type SyncGatewayChecker struct {
+	// The URL of the Sync Gateway admin REST API, including the protocol and port: e.g., http://localhost:4985
+	Url        string
+	HttpClient *http.Client
+	// The databases that must be Online. If empty, every database Sync Gateway is configured with must be Online.
+	Databases []string
+}
+
+// The parts of the response of /<DATABASE>/ the checker uses
+type syncGatewayDatabase struct {
+	Name  string `json:"db_name"`
+	State string `json:"state"`
+}
+
+func (checker SyncGatewayChecker) Check() ([]string, error) {
+	// The root of the API responds as soon as Sync Gateway is up, even if it has no databases
+	if err := checker.getJson("/", nil); err != nil {
+		return nil, err
+	}
+
+	databases := checker.Databases
+	if len(databases) == 0 {
+		// https://docs.couchbase.com/sync-gateway/current/rest-api-admin.html
+		if err := checker.getJson("/_all_dbs", &databases); err != nil {
+			return nil, err
+		}
+	}
+
+	reasons := []string{}
+
+	for _, name := range databases {
+		var database syncGatewayDatabase
+		err := checker.getJson("/"+url.PathEscape(name)+"/", &database)
+
+		if statusErr, isStatusErr := err.(SyncGatewayStatusErr); isStatusErr && statusErr.Status == http.StatusNotFound {
+			reasons = append(reasons, fmt.Sprintf("Database %s does not exist.", name))
+			continue
+		}
+		if err != nil {
+			return reasons, err
+		}
+
+		if database.State != syncGatewayDatabaseOnline {
+			reasons = append(reasons, fmt.Sprintf("Database %s is %s.", name, database.State))
+		}
+	}
+
+	return reasons, nil
+}
+
+// getJson makes a GET request to the given path on the admin REST API and parses the JSON response into out, unless
+// out is nil
+func (checker SyncGatewayChecker) getJson(path string, out interface{}) error {
+	reqUrl := strings.TrimSuffix(checker.Url, "/") + path
+
+	resp, err := checker.HttpClient.Get(reqUrl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return SyncGatewayStatusErr{Url: reqUrl, Status: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return InvalidResponseErr{Url: reqUrl, Underlying: err}
+	}
+
+	return nil
+}
+
+// Custom error types
+
+type SyncGatewayStatusErr struct {
+	Url    string
+	Status int
+	Body   string
+}
+
+func (err SyncGatewayStatusErr) Error() string {
+	return fmt.Sprintf("Expected status code 200 from %s but got %d. Response body: %s", err.Url, err.Status, err.Body)
+}
+
+type InvalidResponseErr struct {
+	Url        string
+	Underlying error
+}
+
+func (err InvalidResponseErr) Error() string {
+	return fmt.Sprintf("Failed to parse the response from %s: %v", err.Url, err.Underlying)
+}
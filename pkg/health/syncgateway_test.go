@@ -0,0 +1,71 @@
+package health
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkSyncGateway(t *testing.T, databases []string, responses map[string]string) ([]string, error) {
+	// The Sync Gateway admin REST API usually only listens on localhost and doesn't require auth
+	server := newFakeServer(t, false, responses)
+	defer server.Close()
+
+	checker := SyncGatewayChecker{Url: server.URL + "/", HttpClient: http.DefaultClient, Databases: databases}
+	return checker.Check()
+}
+
+func TestSyncGatewayCheckerAllDatabasesOnline(t *testing.T) {
+	t.Parallel()
+
+	reasons, err := checkSyncGateway(t, nil, map[string]string{
+		"/":         `{"ADMIN": true, "couchdb": "Welcome", "version": "Couchbase Sync Gateway/2.8.0(376;4e79b3a) EE"}`,
+		"/_all_dbs": `["db", "db2"]`,
+		"/db/":      `{"db_name": "db", "state": "Online"}`,
+		"/db2/":     `{"db_name": "db2", "state": "Online"}`,
+	})
+
+	require.NoError(t, err)
+	assert.Empty(t, reasons)
+}
+
+func TestSyncGatewayCheckerDatabaseOffline(t *testing.T) {
+	t.Parallel()
+
+	reasons, err := checkSyncGateway(t, nil, map[string]string{
+		"/":         `{"ADMIN": true, "couchdb": "Welcome"}`,
+		"/_all_dbs": `["db", "db2"]`,
+		"/db/":      `{"db_name": "db", "state": "Online"}`,
+		"/db2/":     `{"db_name": "db2", "state": "Offline"}`,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Database db2 is Offline."}, reasons)
+}
+
+func TestSyncGatewayCheckerOnlyChecksGivenDatabases(t *testing.T) {
+	t.Parallel()
+
+	responses := map[string]string{
+		"/":     `{"ADMIN": true, "couchdb": "Welcome"}`,
+		"/db/":  `{"db_name": "db", "state": "Online"}`,
+		"/db2/": `{"db_name": "db2", "state": "Starting"}`,
+	}
+
+	reasons, err := checkSyncGateway(t, []string{"db"}, responses)
+	require.NoError(t, err)
+	assert.Empty(t, reasons)
+
+	reasons, err = checkSyncGateway(t, []string{"db", "db2", "missing"}, responses)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Database db2 is Starting.", "Database missing does not exist."}, reasons)
+}
+
+func TestSyncGatewayCheckerNotRunning(t *testing.T) {
+	t.Parallel()
+
+	_, err := checkSyncGateway(t, nil, map[string]string{})
+	assert.IsType(t, SyncGatewayStatusErr{}, err)
+}
@@ -0,0 +1,62 @@
+package loadgen
+
+import (
+	"errors"
+
+	"github.com/couchbase/gocb/v2"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+// CouchbaseStore runs key-value operations against the default collection of a Couchbase bucket via the Couchbase Go
+// SDK, which talks to the Data Service directly, the same way applications do
+type CouchbaseStore struct {
+	cluster    *gocb.Cluster
+	collection *gocb.Collection
+	transcoder gocb.Transcoder
+}
+
+// NewCouchbaseStore connects to the bucket in the given options and waits until the bucket is ready for key-value
+// operations
+func NewCouchbaseStore(options couchbase.SdkOptions) (*CouchbaseStore, error) {
+	cluster, bucket, err := couchbase.ConnectBucket(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CouchbaseStore{
+		cluster:    cluster,
+		collection: bucket.DefaultCollection(),
+		transcoder: gocb.NewRawJSONTranscoder(),
+	}, nil
+}
+
+// Get the document with the given key and return false if there is no such document
+func (store *CouchbaseStore) Get(key string) (bool, error) {
+	_, err := store.collection.Get(key, &gocb.GetOptions{Transcoder: store.transcoder})
+	return checkNotFound(err)
+}
+
+// Create or replace the document with the given key. The value must be JSON.
+func (store *CouchbaseStore) Upsert(key string, value []byte) error {
+	_, err := store.collection.Upsert(key, value, &gocb.UpsertOptions{Transcoder: store.transcoder})
+	return err
+}
+
+// Delete the document with the given key and return false if there was no such document
+func (store *CouchbaseStore) Delete(key string) (bool, error) {
+	_, err := store.collection.Remove(key, nil)
+	return checkNotFound(err)
+}
+
+// Close the connection to the cluster
+func (store *CouchbaseStore) Close() error {
+	return store.cluster.Close(nil)
+}
+
+func checkNotFound(err error) (bool, error) {
+	if errors.Is(err, gocb.ErrDocumentNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,157 @@
+package loadgen
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Each power of two is split into this many linear buckets, which bounds the error of the percentiles a Histogram
+// reports to 1/16, or about 6%, of the actual value
+const subBucketBits = 4
+const subBuckets = 1 << subBucketBits
+
+// Enough buckets to cover every non-negative time.Duration
+const numBuckets = (64 - subBucketBits) * subBuckets
+
+// Histogram records latencies in log-linear buckets, so it uses the same, small amount of memory no matter how many
+// latencies it records, while still reporting percentiles with a bounded relative error. A Histogram is not safe for
+// concurrent use: each worker records into its own Histogram, and the Histograms are merged at the end.
+type Histogram struct {
+	counts [numBuckets]int64
+	count  int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// NewHistogram creates an empty Histogram
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+// Record a single latency. Negative latencies are recorded as zero.
+func (histogram *Histogram) Record(latency time.Duration) {
+	if latency < 0 {
+		latency = 0
+	}
+
+	histogram.counts[bucketIndex(latency)]++
+
+	if histogram.count == 0 || latency < histogram.min {
+		histogram.min = latency
+	}
+	if latency > histogram.max {
+		histogram.max = latency
+	}
+
+	histogram.count++
+	histogram.sum += latency
+}
+
+// Merge adds all the latencies recorded in the other Histogram to this one
+func (histogram *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+
+	for i, count := range other.counts {
+		histogram.counts[i] += count
+	}
+
+	if histogram.count == 0 || other.min < histogram.min {
+		histogram.min = other.min
+	}
+	if other.max > histogram.max {
+		histogram.max = other.max
+	}
+
+	histogram.count += other.count
+	histogram.sum += other.sum
+}
+
+// Count returns how many latencies have been recorded
+func (histogram *Histogram) Count() int64 {
+	return histogram.count
+}
+
+// Min returns the lowest latency recorded, or zero if none have been recorded
+func (histogram *Histogram) Min() time.Duration {
+	return histogram.min
+}
+
+// Max returns the highest latency recorded, or zero if none have been recorded
+func (histogram *Histogram) Max() time.Duration {
+	return histogram.max
+}
+
+// Mean returns the average of the latencies recorded, or zero if none have been recorded
+func (histogram *Histogram) Mean() time.Duration {
+	if histogram.count == 0 {
+		return 0
+	}
+	return histogram.sum / time.Duration(histogram.count)
+}
+
+// Percentile returns the latency that the given percentage (0-100) of the recorded latencies are at or below. The
+// result is the upper bound of the bucket the percentile falls in, capped at the highest latency recorded, so it may
+// overestimate the actual latency by up to 1/16 of its value. Returns zero if no latencies have been recorded.
+func (histogram *Histogram) Percentile(percentile float64) time.Duration {
+	if histogram.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(percentile / 100 * float64(histogram.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > histogram.count {
+		rank = histogram.count
+	}
+
+	var seen int64
+	for i, count := range histogram.counts {
+		seen += count
+		if seen >= rank {
+			upperBound := bucketUpperBound(i)
+			if upperBound > histogram.max {
+				return histogram.max
+			}
+			if upperBound < histogram.min {
+				return histogram.min
+			}
+			return upperBound
+		}
+	}
+
+	return histogram.max
+}
+
+// Return the index of the bucket the given latency falls in. Latencies below subBuckets nanoseconds each get their own
+// bucket. Above that, each power of two gets subBuckets buckets of equal width.
+func bucketIndex(latency time.Duration) int {
+	value := uint64(latency)
+	if value < subBuckets {
+		return int(value)
+	}
+
+	exponent := bits.Len64(value) - subBucketBits - 1
+	subBucket := value >> uint(exponent)
+	return (exponent+1)*subBuckets + int(subBucket-subBuckets)
+}
+
+// Return the highest latency that falls in the bucket with the given index
+func bucketUpperBound(index int) time.Duration {
+	if index < subBuckets {
+		return time.Duration(index)
+	}
+
+	exponent := uint(index/subBuckets - 1)
+	subBucket := uint64(index%subBuckets + subBuckets)
+	upperBound := (subBucket+1)<<exponent - 1
+
+	if upperBound > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(upperBound)
+}
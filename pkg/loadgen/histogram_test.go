@@ -0,0 +1,98 @@
+package loadgen
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketUpperBoundContainsValue(t *testing.T) {
+	t.Parallel()
+
+	values := []time.Duration{0, 1, 15, 16, 17, 31, 32, 33, 100, 1000, time.Millisecond, time.Second, time.Hour, math.MaxInt64}
+
+	for _, value := range values {
+		index := bucketIndex(value)
+		assert.True(t, index >= 0 && index < numBuckets, "index %d of value %d out of range", index, value)
+		assert.True(t, bucketUpperBound(index) >= value, "upper bound of bucket %d is below value %d", index, value)
+
+		if index > 0 {
+			assert.True(t, bucketUpperBound(index-1) < value, "value %d should not fit in bucket %d", value, index-1)
+		}
+	}
+}
+
+func TestBucketIndexIsMonotonic(t *testing.T) {
+	t.Parallel()
+
+	previous := bucketIndex(0)
+	for value := time.Duration(1); value < 100000; value++ {
+		index := bucketIndex(value)
+		assert.True(t, index == previous || index == previous+1, "bucket index jumped from %d to %d at value %d", previous, index, value)
+		previous = index
+	}
+}
+
+func TestEmptyHistogram(t *testing.T) {
+	t.Parallel()
+
+	histogram := NewHistogram()
+	assert.Equal(t, int64(0), histogram.Count())
+	assert.Equal(t, time.Duration(0), histogram.Mean())
+	assert.Equal(t, time.Duration(0), histogram.Percentile(99))
+	assert.Equal(t, time.Duration(0), histogram.Max())
+}
+
+func TestHistogramPercentilesWithinRelativeError(t *testing.T) {
+	t.Parallel()
+
+	random := rand.New(rand.NewSource(1))
+	histogram := NewHistogram()
+
+	values := []time.Duration{}
+	for i := 0; i < 10000; i++ {
+		value := time.Duration(random.ExpFloat64() * float64(time.Millisecond))
+		values = append(values, value)
+		histogram.Record(value)
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, percentile := range []float64{50, 95, 99} {
+		expected := values[int(math.Ceil(percentile/100*float64(len(values))))-1]
+		actual := histogram.Percentile(percentile)
+
+		assert.True(t, actual >= expected, "p%.0f: %s should not be below the actual value %s", percentile, actual, expected)
+		assert.True(t, float64(actual) <= float64(expected)*(1+1.0/subBuckets), "p%.0f: %s is too far above the actual value %s", percentile, actual, expected)
+	}
+
+	assert.Equal(t, values[0], histogram.Min())
+	assert.Equal(t, values[len(values)-1], histogram.Max())
+	assert.Equal(t, values[len(values)-1], histogram.Percentile(100))
+}
+
+func TestHistogramMerge(t *testing.T) {
+	t.Parallel()
+
+	first := NewHistogram()
+	first.Record(2 * time.Millisecond)
+	first.Record(4 * time.Millisecond)
+
+	second := NewHistogram()
+	second.Record(1 * time.Millisecond)
+	second.Record(9 * time.Millisecond)
+
+	merged := NewHistogram()
+	merged.Merge(first)
+	merged.Merge(second)
+	merged.Merge(NewHistogram())
+
+	assert.Equal(t, int64(4), merged.Count())
+	assert.Equal(t, 1*time.Millisecond, merged.Min())
+	assert.Equal(t, 9*time.Millisecond, merged.Max())
+	assert.Equal(t, 4*time.Millisecond, merged.Mean())
+}
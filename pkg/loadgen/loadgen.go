@@ -0,0 +1,516 @@
+// Package loadgen puts a configurable key-value workload on a Couchbase cluster, or on any other KeyValueStore, and
+// reports the throughput and latency percentiles of each type of operation. This is useful for sizing instance types
+// and validating memory quota settings before going to production.
+package loadgen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultWorkers = 8
+const defaultDuration = 60 * time.Second
+const defaultNumKeys = 10000
+const defaultKeyPrefix = "loadgen-"
+const defaultDocSize = 1024
+const defaultZipfExponent = 1.1
+
+// The types of operations the load generator runs
+const (
+	OpGet    = "get"
+	OpUpsert = "upsert"
+	OpDelete = "delete"
+)
+
+var allOps = []string{OpGet, OpUpsert, OpDelete}
+
+// The distributions the load generator can pick keys from
+const (
+	KeyDistributionUniform = "uniform"
+	KeyDistributionZipf    = "zipf"
+)
+
+// KeyValueStore is the minimal set of key-value operations the load generator needs. CouchbaseStore implements it for
+// a Couchbase bucket. Implementations must be safe for concurrent use.
+type KeyValueStore interface {
+	// Get the document with the given key and return false if there is no such document
+	Get(key string) (bool, error)
+
+	// Create or replace the document with the given key
+	Upsert(key string, value []byte) error
+
+	// Delete the document with the given key and return false if there was no such document
+	Delete(key string) (bool, error)
+}
+
+// Mix is the relative weight of each type of operation in the workload: e.g., Get 80, Upsert 15, Delete 5
+type Mix struct {
+	Get    int
+	Upsert int
+	Delete int
+}
+
+// Options configures a single load generation run
+type Options struct {
+	Store KeyValueStore
+
+	// How many workers run operations concurrently
+	Workers int
+
+	// The total number of operations per second to aim for across all workers. If zero, each worker runs operations
+	// as fast as it can.
+	TargetOpsPerSec float64
+
+	// How long to run the workload for. If Operations is set, the run stops at whichever limit comes first.
+	Duration time.Duration
+
+	// If greater than zero, stop after this many operations in total
+	Operations int64
+
+	Mix Mix
+
+	// The workload uses NumKeys keys, from <KeyPrefix>0 to <KeyPrefix><NumKeys-1>
+	NumKeys   int
+	KeyPrefix string
+
+	// How to pick the key for each operation: KeyDistributionUniform, or KeyDistributionZipf, which makes a few keys
+	// much hotter than the rest, like most real workloads. With zipf, ZipfExponent controls how skewed the workload is
+	// and must be greater than 1.
+	KeyDistribution string
+	ZipfExponent    float64
+
+	// The size of each document the workload writes is picked uniformly at random between MinDocSize and MaxDocSize
+	// bytes. Set both to the same value for fixed size documents.
+	MinDocSize int
+	MaxDocSize int
+
+	// If true, write every key once before starting the workload, so get and delete operations find documents. The
+	// writes are not included in the Report.
+	Populate bool
+
+	// Seed the random numbers that pick operations, keys, and document sizes, so runs are repeatable
+	Seed int64
+
+	Logger *log.Logger
+}
+
+// OpReport summarizes all the operations of one type
+type OpReport struct {
+	Count  int64 `json:"count"`
+	Errors int64 `json:"errors"`
+
+	// How many get and delete operations did not find the document
+	Misses int64 `json:"misses"`
+
+	OpsPerSec float64       `json:"ops_per_sec"`
+	Latency   LatencyReport `json:"latency"`
+}
+
+// LatencyReport contains the latency percentiles of a type of operation, in milliseconds
+type LatencyReport struct {
+	Min  float64 `json:"min_ms"`
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P95  float64 `json:"p95_ms"`
+	P99  float64 `json:"p99_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+// Report summarizes a load generation run. It is meant to be written out as JSON.
+type Report struct {
+	Workers         int                 `json:"workers"`
+	TargetOpsPerSec float64             `json:"target_ops_per_sec"`
+	DurationSec     float64             `json:"duration_sec"`
+	Total           OpReport            `json:"total"`
+	Ops             map[string]OpReport `json:"ops"`
+}
+
+// Run the workload as specified in the given Options and return a Report of how it went. Errors from individual
+// operations are counted in the Report rather than returned, so Run only returns an error if the Options are invalid or
+// populating the keys fails.
+func Run(options Options) (*Report, error) {
+	options = withDefaults(options)
+
+	if err := validate(options); err != nil {
+		return nil, err
+	}
+
+	if options.Populate {
+		if err := populate(options); err != nil {
+			return nil, err
+		}
+	}
+
+	options.Logger.Printf("Running workload with %d workers for %s (mix: get %d, upsert %d, delete %d; keys: %d, %s)", options.Workers, options.Duration, options.Mix.Get, options.Mix.Upsert, options.Mix.Delete, options.NumKeys, options.KeyDistribution)
+
+	// The workers count this down together, so we keep it separate from options, which each worker gets a copy of
+	var remaining *int64
+	if options.Operations > 0 {
+		operations := options.Operations
+		remaining = &operations
+	}
+
+	deadline := time.Now().Add(options.Duration)
+	results := make([]*workerResult, options.Workers)
+
+	start := time.Now()
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < options.Workers; i++ {
+		waitGroup.Add(1)
+		go func(workerId int) {
+			defer waitGroup.Done()
+			results[workerId] = runWorker(options, workerId, start, deadline, remaining)
+		}(i)
+	}
+	waitGroup.Wait()
+
+	elapsed := time.Since(start)
+
+	report := newReport(options, results, elapsed)
+	options.Logger.Printf("Ran %d operations in %s (%.1f ops/sec, %d errors)", report.Total.Count, elapsed, report.Total.OpsPerSec, report.Total.Errors)
+
+	return report, nil
+}
+
+func withDefaults(options Options) Options {
+	if options.Workers <= 0 {
+		options.Workers = defaultWorkers
+	}
+	if options.Duration <= 0 {
+		options.Duration = defaultDuration
+	}
+	if options.Mix == (Mix{}) {
+		options.Mix = Mix{Get: 80, Upsert: 20}
+	}
+	if options.NumKeys <= 0 {
+		options.NumKeys = defaultNumKeys
+	}
+	if options.KeyPrefix == "" {
+		options.KeyPrefix = defaultKeyPrefix
+	}
+	if options.KeyDistribution == "" {
+		options.KeyDistribution = KeyDistributionUniform
+	}
+	if options.ZipfExponent == 0 {
+		options.ZipfExponent = defaultZipfExponent
+	}
+	if options.MinDocSize <= 0 && options.MaxDocSize <= 0 {
+		options.MinDocSize = defaultDocSize
+		options.MaxDocSize = defaultDocSize
+	}
+	if options.MaxDocSize < options.MinDocSize {
+		options.MaxDocSize = options.MinDocSize
+	}
+	if options.Seed == 0 {
+		options.Seed = time.Now().UnixNano()
+	}
+	if options.Logger == nil {
+		options.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	return options
+}
+
+func validate(options Options) error {
+	if options.Store == nil {
+		return NoStoreErr{}
+	}
+
+	if options.Mix.Get < 0 || options.Mix.Upsert < 0 || options.Mix.Delete < 0 {
+		return InvalidMixErr{Mix: options.Mix}
+	}
+
+	if options.TargetOpsPerSec < 0 {
+		return InvalidTargetOpsPerSecErr{Target: options.TargetOpsPerSec}
+	}
+
+	switch options.KeyDistribution {
+	case KeyDistributionUniform:
+	case KeyDistributionZipf:
+		if options.ZipfExponent <= 1 {
+			return InvalidZipfExponentErr{Exponent: options.ZipfExponent}
+		}
+	default:
+		return UnsupportedKeyDistributionErr{Name: options.KeyDistribution}
+	}
+
+	return nil
+}
+
+// Write every key once, splitting the keys evenly across the workers
+func populate(options Options) error {
+	options.Logger.Printf("Populating %d keys with %d workers", options.NumKeys, options.Workers)
+
+	errs := make([]error, options.Workers)
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < options.Workers; i++ {
+		waitGroup.Add(1)
+		go func(workerId int) {
+			defer waitGroup.Done()
+
+			random := rand.New(rand.NewSource(options.Seed + int64(workerId)))
+			for keyIndex := workerId; keyIndex < options.NumKeys; keyIndex += options.Workers {
+				key := formatKey(options.KeyPrefix, keyIndex)
+				if err := options.Store.Upsert(key, newDocument(key, pickDocSize(options, random), random)); err != nil {
+					errs[workerId] = PopulateErr{Key: key, Underlying: err}
+					return
+				}
+			}
+		}(i)
+	}
+	waitGroup.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The results of a single worker, which only that worker writes to until it is done
+type workerResult struct {
+	histograms map[string]*Histogram
+	errors     map[string]int64
+	misses     map[string]int64
+}
+
+func runWorker(options Options, workerId int, start time.Time, deadline time.Time, remaining *int64) *workerResult {
+	result := &workerResult{
+		histograms: map[string]*Histogram{},
+		errors:     map[string]int64{},
+		misses:     map[string]int64{},
+	}
+	for _, op := range allOps {
+		result.histograms[op] = NewHistogram()
+	}
+
+	random := rand.New(rand.NewSource(options.Seed + int64(workerId)))
+	pickKey := newKeyPicker(options, random)
+
+	// Each worker runs its share of the target rate on a fixed schedule, staggered so the workers don't all fire at once
+	var interval time.Duration
+	if options.TargetOpsPerSec > 0 {
+		interval = time.Duration(float64(time.Second) * float64(options.Workers) / options.TargetOpsPerSec)
+	}
+	next := start.Add(interval * time.Duration(workerId) / time.Duration(options.Workers))
+
+	for {
+		if interval > 0 {
+			if next.After(deadline) {
+				return result
+			}
+			if wait := time.Until(next); wait > 0 {
+				time.Sleep(wait)
+			}
+			next = next.Add(interval)
+		} else if !time.Now().Before(deadline) {
+			return result
+		}
+
+		if remaining != nil && atomic.AddInt64(remaining, -1) < 0 {
+			return result
+		}
+
+		op := pickOp(options.Mix, random)
+		key := formatKey(options.KeyPrefix, pickKey())
+
+		found, latency, err := runOp(options, op, key, random)
+
+		result.histograms[op].Record(latency)
+		if err != nil {
+			result.errors[op]++
+		} else if !found {
+			result.misses[op]++
+		}
+	}
+}
+
+func runOp(options Options, op string, key string, random *rand.Rand) (bool, time.Duration, error) {
+	switch op {
+	case OpGet:
+		opStart := time.Now()
+		found, err := options.Store.Get(key)
+		return found, time.Since(opStart), err
+	case OpUpsert:
+		// Generate the document before starting the clock, so it doesn't count towards the latency
+		document := newDocument(key, pickDocSize(options, random), random)
+		opStart := time.Now()
+		err := options.Store.Upsert(key, document)
+		return true, time.Since(opStart), err
+	default:
+		opStart := time.Now()
+		found, err := options.Store.Delete(key)
+		return found, time.Since(opStart), err
+	}
+}
+
+func pickOp(mix Mix, random *rand.Rand) string {
+	value := random.Intn(mix.Get + mix.Upsert + mix.Delete)
+	if value < mix.Get {
+		return OpGet
+	}
+	if value < mix.Get+mix.Upsert {
+		return OpUpsert
+	}
+	return OpDelete
+}
+
+// Return a function that picks the index of the key for the next operation according to the key distribution
+func newKeyPicker(options Options, random *rand.Rand) func() int {
+	if options.KeyDistribution == KeyDistributionZipf {
+		zipf := rand.NewZipf(random, options.ZipfExponent, 1, uint64(options.NumKeys-1))
+		return func() int {
+			return int(zipf.Uint64())
+		}
+	}
+
+	return func() int {
+		return random.Intn(options.NumKeys)
+	}
+}
+
+func pickDocSize(options Options, random *rand.Rand) int {
+	return options.MinDocSize + random.Intn(options.MaxDocSize-options.MinDocSize+1)
+}
+
+func formatKey(prefix string, index int) string {
+	return fmt.Sprintf("%s%d", prefix, index)
+}
+
+const documentLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Return a JSON document for the given key that is size bytes long, or as close to it as possible for sizes too small
+// to fit the key
+func newDocument(key string, size int, random *rand.Rand) []byte {
+	prefix := fmt.Sprintf(`{"key":%q,"padding":"`, key)
+	suffix := `"}`
+
+	paddingLen := size - len(prefix) - len(suffix)
+	if paddingLen < 0 {
+		paddingLen = 0
+	}
+
+	document := make([]byte, 0, len(prefix)+paddingLen+len(suffix))
+	document = append(document, prefix...)
+	for i := 0; i < paddingLen; i++ {
+		document = append(document, documentLetters[random.Intn(len(documentLetters))])
+	}
+	document = append(document, suffix...)
+
+	return document
+}
+
+func newReport(options Options, results []*workerResult, elapsed time.Duration) *Report {
+	report := &Report{
+		Workers:         options.Workers,
+		TargetOpsPerSec: options.TargetOpsPerSec,
+		DurationSec:     elapsed.Seconds(),
+		Ops:             map[string]OpReport{},
+	}
+
+	totalHistogram := NewHistogram()
+	var totalErrors, totalMisses int64
+
+	for _, op := range allOps {
+		histogram := NewHistogram()
+		var errors, misses int64
+
+		for _, result := range results {
+			histogram.Merge(result.histograms[op])
+			errors += result.errors[op]
+			misses += result.misses[op]
+		}
+
+		totalHistogram.Merge(histogram)
+		totalErrors += errors
+		totalMisses += misses
+
+		report.Ops[op] = summarize(histogram, errors, misses, elapsed)
+	}
+
+	report.Total = summarize(totalHistogram, totalErrors, totalMisses, elapsed)
+	return report
+}
+
+func summarize(histogram *Histogram, errors int64, misses int64, elapsed time.Duration) OpReport {
+	opReport := OpReport{
+		Count:  histogram.Count(),
+		Errors: errors,
+		Misses: misses,
+		Latency: LatencyReport{
+			Min:  toMillis(histogram.Min()),
+			Mean: toMillis(histogram.Mean()),
+			P50:  toMillis(histogram.Percentile(50)),
+			P95:  toMillis(histogram.Percentile(95)),
+			P99:  toMillis(histogram.Percentile(99)),
+			Max:  toMillis(histogram.Max()),
+		},
+	}
+
+	if elapsed > 0 {
+		opReport.OpsPerSec = float64(opReport.Count) / elapsed.Seconds()
+	}
+
+	return opReport
+}
+
+func toMillis(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+// Custom error types
+
+type NoStoreErr struct{}
+
+func (err NoStoreErr) Error() string {
+	return "No KeyValueStore was specified to run the workload against."
+}
+
+type InvalidMixErr struct {
+	Mix Mix
+}
+
+func (err InvalidMixErr) Error() string {
+	return fmt.Sprintf("The weights of the operation mix must not be negative, but got get %d, upsert %d, delete %d.", err.Mix.Get, err.Mix.Upsert, err.Mix.Delete)
+}
+
+type InvalidTargetOpsPerSecErr struct {
+	Target float64
+}
+
+func (err InvalidTargetOpsPerSecErr) Error() string {
+	return fmt.Sprintf("The target ops/sec must not be negative, but got %f.", err.Target)
+}
+
+type InvalidZipfExponentErr struct {
+	Exponent float64
+}
+
+func (err InvalidZipfExponentErr) Error() string {
+	return fmt.Sprintf("The zipf exponent must be greater than 1, but got %f.", err.Exponent)
+}
+
+type UnsupportedKeyDistributionErr struct {
+	Name string
+}
+
+func (err UnsupportedKeyDistributionErr) Error() string {
+	return fmt.Sprintf("Unsupported key distribution '%s'. Must be one of: %s, %s.", err.Name, KeyDistributionUniform, KeyDistributionZipf)
+}
+
+type PopulateErr struct {
+	Key        string
+	Underlying error
+}
+
+func (err PopulateErr) Error() string {
+	return fmt.Sprintf("Failed to populate key %s: %v", err.Key, err.Underlying)
+}
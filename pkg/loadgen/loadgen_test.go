@@ -0,0 +1,214 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An in-memory KeyValueStore that records how often each key was used
+type fakeStore struct {
+	mutex     sync.Mutex
+	documents map[string][]byte
+	keyCounts map[string]int
+	failKey   string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{documents: map[string][]byte{}, keyCounts: map[string]int{}}
+}
+
+func (store *fakeStore) Get(key string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.keyCounts[key]++
+	if key == store.failKey {
+		return false, errors.New("fake error")
+	}
+	_, found := store.documents[key]
+	return found, nil
+}
+
+func (store *fakeStore) Upsert(key string, value []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.keyCounts[key]++
+	if key == store.failKey {
+		return errors.New("fake error")
+	}
+	store.documents[key] = value
+	return nil
+}
+
+func (store *fakeStore) Delete(key string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.keyCounts[key]++
+	if key == store.failKey {
+		return false, errors.New("fake error")
+	}
+	_, found := store.documents[key]
+	delete(store.documents, key)
+	return found, nil
+}
+
+func TestRunStopsAfterOperations(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeStore()
+	report, err := Run(Options{
+		Store:      store,
+		Workers:    4,
+		Operations: 1000,
+		Duration:   time.Minute,
+		Mix:        Mix{Get: 50, Upsert: 40, Delete: 10},
+		NumKeys:    100,
+		Seed:       1,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1000), report.Total.Count)
+	assert.Equal(t, int64(0), report.Total.Errors)
+	assert.Equal(t, report.Total.Count, report.Ops[OpGet].Count+report.Ops[OpUpsert].Count+report.Ops[OpDelete].Count)
+	assert.Equal(t, report.Total.Misses, report.Ops[OpGet].Misses+report.Ops[OpDelete].Misses)
+	assert.Equal(t, int64(0), report.Ops[OpUpsert].Misses)
+
+	// With a 50/40/10 mix over 1000 operations, every type of operation should have run
+	for _, op := range allOps {
+		assert.True(t, report.Ops[op].Count > 0, "Expected some %s operations", op)
+	}
+
+	assert.True(t, report.Total.Latency.P50 <= report.Total.Latency.P95)
+	assert.True(t, report.Total.Latency.P95 <= report.Total.Latency.P99)
+	assert.True(t, report.Total.Latency.P99 <= report.Total.Latency.Max)
+}
+
+func TestRunPopulatesKeys(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeStore()
+	report, err := Run(Options{
+		Store:      store,
+		Workers:    3,
+		Operations: 200,
+		Mix:        Mix{Get: 1},
+		NumKeys:    50,
+		MinDocSize: 100,
+		MaxDocSize: 200,
+		Populate:   true,
+		Seed:       1,
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, store.documents, 50)
+	assert.Equal(t, int64(200), report.Ops[OpGet].Count)
+	assert.Equal(t, int64(0), report.Total.Misses)
+
+	for key, document := range store.documents {
+		assert.True(t, len(document) >= 100 && len(document) <= 200, "Document %s has size %d", key, len(document))
+
+		var parsed map[string]string
+		require.NoError(t, json.Unmarshal(document, &parsed), "Document %s is not valid JSON", key)
+		assert.Equal(t, key, parsed["key"])
+	}
+}
+
+func TestRunCountsErrors(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeStore()
+	store.failKey = "loadgen-0"
+
+	report, err := Run(Options{
+		Store:      store,
+		Workers:    2,
+		Operations: 100,
+		Mix:        Mix{Upsert: 1},
+		NumKeys:    1,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(100), report.Total.Count)
+	assert.Equal(t, int64(100), report.Ops[OpUpsert].Errors)
+	assert.Equal(t, int64(100), report.Total.Errors)
+}
+
+func TestRunRespectsTargetRate(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeStore()
+	report, err := Run(Options{
+		Store:           store,
+		Workers:         4,
+		TargetOpsPerSec: 200,
+		Duration:        time.Second,
+		Mix:             Mix{Get: 1},
+	})
+	require.NoError(t, err)
+
+	// Allow for timer imprecision, but the rate limit should keep us far below what the fake store can handle
+	assert.InDelta(t, 200, report.Total.Count, 30)
+}
+
+func TestZipfMakesLowKeysHotter(t *testing.T) {
+	t.Parallel()
+
+	store := newFakeStore()
+	_, err := Run(Options{
+		Store:           store,
+		Workers:         1,
+		Operations:      5000,
+		Mix:             Mix{Get: 1},
+		NumKeys:         1000,
+		KeyDistribution: KeyDistributionZipf,
+		Seed:            1,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, store.keyCounts["loadgen-0"] > 10*store.keyCounts["loadgen-500"], "Expected loadgen-0 (%d gets) to be much hotter than loadgen-500 (%d gets)", store.keyCounts["loadgen-0"], store.keyCounts["loadgen-500"])
+}
+
+func TestRunRejectsInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		options  Options
+		expected error
+	}{
+		{"no store", Options{}, NoStoreErr{}},
+		{"negative mix", Options{Store: newFakeStore(), Mix: Mix{Get: 1, Delete: -1}}, InvalidMixErr{Mix: Mix{Get: 1, Delete: -1}}},
+		{"negative rate", Options{Store: newFakeStore(), TargetOpsPerSec: -1}, InvalidTargetOpsPerSecErr{Target: -1}},
+		{"zipf exponent", Options{Store: newFakeStore(), KeyDistribution: KeyDistributionZipf, ZipfExponent: 0.5}, InvalidZipfExponentErr{Exponent: 0.5}},
+		{"distribution", Options{Store: newFakeStore(), KeyDistribution: "gaussian"}, UnsupportedKeyDistributionErr{Name: "gaussian"}},
+	}
+
+	for _, testCase := range testCases {
+		_, err := Run(testCase.options)
+		assert.Equal(t, testCase.expected, err, testCase.name)
+	}
+}
+
+func TestNewDocumentSize(t *testing.T) {
+	t.Parallel()
+
+	random := rand.New(rand.NewSource(1))
+
+	document := newDocument("my-key", 256, random)
+	assert.Len(t, document, 256)
+	assert.True(t, strings.HasPrefix(string(document), `{"key":"my-key","padding":"`))
+
+	// Too small to fit the key, so the document is as small as it can be
+	tiny := newDocument("my-key", 1, random)
+	assert.Equal(t, `{"key":"my-key","padding":""}`, string(tiny))
+}
@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+// The prefix of every metric scraped from Couchbase Server
+const couchbaseNamespace = "couchbase"
+
+// A stat to export and how to export it
+type statMetric struct {
+	Stat   string
+	Metric string
+	Help   string
+	Type   string
+}
+
+// The per-node stats in the systemStats and interestingStats of /pools/default that we export
+var nodeSystemStats = []statMetric{
+	{"cpu_utilization_rate", "node_cpu_utilization_percent", "CPU utilization of the node.", Gauge},
+	{"mem_total", "node_memory_total_bytes", "Total memory of the node.", Gauge},
+	{"mem_free", "node_memory_free_bytes", "Free memory on the node.", Gauge},
+	{"swap_total", "node_swap_total_bytes", "Total swap space of the node.", Gauge},
+	{"swap_used", "node_swap_used_bytes", "Swap space used on the node.", Gauge},
+}
+
+var nodeInterestingStats = []statMetric{
+	{"curr_items", "node_items", "Number of active items on the node.", Gauge},
+	{"vb_replica_curr_items", "node_replica_items", "Number of replica items on the node.", Gauge},
+	{"mem_used", "node_memory_used_bytes", "Memory used by the Data service on the node.", Gauge},
+	{"couch_docs_actual_disk_size", "node_docs_disk_size_bytes", "Disk space used by documents on the node.", Gauge},
+	{"couch_docs_data_size", "node_docs_data_size_bytes", "Size of the document data on the node.", Gauge},
+	{"ops", "node_ops_per_second", "Operations per second on the node.", Gauge},
+	{"cmd_get", "node_gets_per_second", "Gets per second on the node.", Gauge},
+	{"ep_bg_fetched", "node_disk_fetches_per_second", "Items fetched from disk per second on the node.", Gauge},
+}
+
+// The basicStats of each bucket in /pools/default/buckets that we export
+var bucketBasicStats = []statMetric{
+	{"quotaPercentUsed", "bucket_ram_quota_used_percent", "Percentage of the RAM quota of the bucket in use.", Gauge},
+	{"opsPerSec", "bucket_ops_per_second", "Operations per second on the bucket.", Gauge},
+	{"diskFetches", "bucket_disk_fetches_per_second", "Items fetched from disk per second for the bucket.", Gauge},
+	{"itemCount", "bucket_items", "Number of items in the bucket.", Gauge},
+	{"diskUsed", "bucket_disk_used_bytes", "Disk space used by the bucket.", Gauge},
+	{"dataUsed", "bucket_data_used_bytes", "Disk space used by the data of the bucket.", Gauge},
+	{"memUsed", "bucket_memory_used_bytes", "Memory used by the bucket.", Gauge},
+}
+
+// The detailed stats of each bucket in /pools/default/buckets/<BUCKET>/stats that we export, using the latest sample
+var bucketDetailedStats = []statMetric{
+	{"cmd_get", "bucket_gets_per_second", "Gets per second on the bucket.", Gauge},
+	{"cmd_set", "bucket_sets_per_second", "Sets per second on the bucket.", Gauge},
+	{"delete_hits", "bucket_deletes_per_second", "Deletes per second on the bucket.", Gauge},
+	{"ep_cache_miss_rate", "bucket_cache_miss_ratio", "Percentage of reads from the bucket served from disk rather than memory.", Gauge},
+	{"vb_active_resident_items_ratio", "bucket_resident_items_percent", "Percentage of the active items of the bucket cached in memory.", Gauge},
+	{"ep_mem_high_wat", "bucket_memory_high_watermark_bytes", "Memory use at which the bucket starts ejecting items.", Gauge},
+	{"ep_mem_low_wat", "bucket_memory_low_watermark_bytes", "Memory use at which the bucket stops ejecting items.", Gauge},
+	{"ep_queue_size", "bucket_disk_write_queue_items", "Number of items waiting to be written to disk.", Gauge},
+	{"ep_tmp_oom_errors", "bucket_temporary_oom_errors_per_second", "Temporary out of memory errors per second on the bucket.", Gauge},
+	{"ep_dcp_replica_items_remaining", "bucket_replication_items_remaining", "Number of items waiting to be sent to replicas.", Gauge},
+	{"couch_docs_fragmentation", "bucket_docs_fragmentation_percent", "Fragmentation of the document data on disk.", Gauge},
+	{"curr_connections", "bucket_connections", "Number of connections to the bucket.", Gauge},
+}
+
+// The stats of each outbound XDCR replication, which Couchbase reports among the detailed stats of the source bucket
+// as replications/<REMOTE_CLUSTER_UUID>/<SOURCE_BUCKET>/<TARGET_BUCKET>/<STAT>
+var xdcrStats = []statMetric{
+	{"docs_written", "xdcr_docs_written_total", "Number of documents written to the target bucket.", Counter},
+	{"docs_failed_cr_source", "xdcr_docs_failed_conflict_resolution_total", "Number of documents not replicated because the target won conflict resolution.", Counter},
+	{"docs_filtered", "xdcr_docs_filtered_total", "Number of documents filtered out of the replication.", Counter},
+	{"data_replicated", "xdcr_data_replicated_bytes_total", "Bytes of data replicated to the target bucket.", Counter},
+	{"rate_replicated", "xdcr_docs_replicated_per_second", "Documents replicated per second.", Gauge},
+	{"bandwidth_usage", "xdcr_bandwidth_bytes_per_second", "Bytes replicated per second.", Gauge},
+	{"wtavg_docs_latency", "xdcr_docs_latency_milliseconds", "Weighted average latency of sending documents to the target.", Gauge},
+}
+
+const xdcrStatPrefix = "replications/"
+
+// CouchbaseCollector scrapes the cluster, node, bucket, and XDCR stats of a Couchbase cluster from its REST API
+type CouchbaseCollector struct {
+	Client *couchbase.Client
+}
+
+func (collector CouchbaseCollector) Name() string {
+	return couchbaseNamespace
+}
+
+func (collector CouchbaseCollector) Collect(metrics *MetricSet) error {
+	pool, err := collector.Client.GetPoolDetails()
+	if err != nil {
+		return err
+	}
+	collectPoolMetrics(metrics, pool)
+
+	buckets, err := collector.Client.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		collectBucketMetrics(metrics, bucket)
+
+		// Memcached buckets don't have detailed stats worth exporting or XDCR replications
+		if bucket.BucketType == "memcached" {
+			continue
+		}
+
+		stats, err := collector.Client.GetBucketStats(bucket.Name)
+		if err != nil {
+			return err
+		}
+		collectBucketStatsMetrics(metrics, bucket.Name, stats)
+	}
+
+	tasks, err := collector.Client.ListTasks()
+	if err != nil {
+		return err
+	}
+	collectXdcrTaskMetrics(metrics, tasks)
+
+	return nil
+}
+
+func collectPoolMetrics(metrics *MetricSet, pool *couchbase.PoolDetails) {
+	addCouchbase(metrics, "cluster_ram_total_bytes", "Total RAM of all the nodes in the cluster.", Gauge, pool.StorageTotals.Ram.Total)
+	addCouchbase(metrics, "cluster_ram_used_bytes", "RAM used on all the nodes in the cluster.", Gauge, pool.StorageTotals.Ram.Used)
+	addCouchbase(metrics, "cluster_ram_used_by_data_bytes", "RAM used by the Data service across the cluster.", Gauge, pool.StorageTotals.Ram.UsedByData)
+	addCouchbase(metrics, "cluster_ram_quota_total_bytes", "Total RAM quota of the Data service across the cluster.", Gauge, pool.StorageTotals.Ram.QuotaTotal)
+	addCouchbase(metrics, "cluster_ram_quota_used_bytes", "RAM quota assigned to buckets across the cluster.", Gauge, pool.StorageTotals.Ram.QuotaUsed)
+	addCouchbase(metrics, "cluster_disk_total_bytes", "Total disk space of all the nodes in the cluster.", Gauge, pool.StorageTotals.Hdd.Total)
+	addCouchbase(metrics, "cluster_disk_used_bytes", "Disk space used on all the nodes in the cluster.", Gauge, pool.StorageTotals.Hdd.Used)
+	addCouchbase(metrics, "cluster_disk_used_by_data_bytes", "Disk space used by Couchbase data across the cluster.", Gauge, pool.StorageTotals.Hdd.UsedByData)
+	addCouchbase(metrics, "cluster_balanced", "Whether the data in the cluster is balanced across the nodes (1) or the cluster needs a rebalance (0).", Gauge, boolToFloat(pool.Balanced))
+	addCouchbase(metrics, "cluster_rebalance_running", "Whether a rebalance is running (1) or not (0).", Gauge, boolToFloat(pool.RebalanceStatus == "running"))
+	addCouchbase(metrics, "cluster_nodes", "Number of nodes in the cluster.", Gauge, float64(len(pool.Nodes)))
+
+	for _, node := range pool.Nodes {
+		labels := []Label{{"node", node.Hostname}}
+
+		addCouchbase(metrics, "node_healthy", "Whether the node is healthy (1) or not (0).", Gauge, boolToFloat(node.Status == "healthy"), labels...)
+		addCouchbase(metrics, "node_active", "Whether the node is an active member of the cluster (1), or has failed over or not been rebalanced in yet (0).", Gauge, boolToFloat(node.ClusterMembership == "active"), labels...)
+		addCouchbase(metrics, "node_info", "Information about the node. Always 1.", Gauge, 1, append(labels, Label{"version", node.Version}, Label{"services", strings.Join(node.Services, ",")}, Label{"this_node", strconv.FormatBool(node.ThisNode)})...)
+
+		if uptime, err := strconv.ParseFloat(node.Uptime, 64); err == nil {
+			addCouchbase(metrics, "node_uptime_seconds", "How long Couchbase has been running on the node.", Gauge, uptime, labels...)
+		}
+
+		addStats(metrics, nodeSystemStats, node.SystemStats, labels)
+		addStats(metrics, nodeInterestingStats, node.InterestingStats, labels)
+	}
+}
+
+func collectBucketMetrics(metrics *MetricSet, bucket couchbase.BucketDetails) {
+	labels := []Label{{"bucket", bucket.Name}}
+
+	if ram, hasRam := bucket.Quota["ram"]; hasRam {
+		addCouchbase(metrics, "bucket_ram_quota_bytes", "RAM quota of the bucket across the cluster.", Gauge, ram, labels...)
+	}
+
+	addStats(metrics, bucketBasicStats, bucket.BasicStats, labels)
+}
+
+func collectBucketStatsMetrics(metrics *MetricSet, bucketName string, stats *couchbase.BucketStats) {
+	labels := []Label{{"bucket", bucketName}}
+
+	for _, stat := range bucketDetailedStats {
+		if value, exists := stats.Latest(stat.Stat); exists {
+			addCouchbase(metrics, stat.Metric, stat.Help, stat.Type, value, labels...)
+		}
+	}
+
+	for _, name := range sortedKeys(stats.Op.Samples) {
+		id, statName, isXdcrStat := parseXdcrStatName(name)
+		if !isXdcrStat {
+			continue
+		}
+
+		for _, stat := range xdcrStats {
+			if stat.Stat != statName {
+				continue
+			}
+			if value, exists := stats.Latest(name); exists {
+				addCouchbase(metrics, stat.Metric, stat.Help, stat.Type, value, xdcrLabels(id)...)
+			}
+		}
+	}
+}
+
+func collectXdcrTaskMetrics(metrics *MetricSet, tasks []couchbase.Task) {
+	for _, task := range tasks {
+		if task.Type != couchbase.XdcrTaskType {
+			continue
+		}
+
+		labels := xdcrLabels(task.Id)
+
+		addCouchbase(metrics, "xdcr_running", "Whether the XDCR replication is running (1) or paused (0).", Gauge, boolToFloat(task.Status == "running"), labels...)
+		addCouchbase(metrics, "xdcr_changes_left", "Number of mutations waiting to be replicated.", Gauge, task.ChangesLeft, labels...)
+		addCouchbase(metrics, "xdcr_docs_checked_total", "Number of documents checked for changes to replicate.", Counter, task.DocsChecked, labels...)
+		addCouchbase(metrics, "xdcr_errors", "Number of recent errors reported by the XDCR replication.", Gauge, float64(len(task.Errors)), labels...)
+	}
+}
+
+// Parse the name of an XDCR stat of the form replications/<REMOTE_CLUSTER_UUID>/<SOURCE_BUCKET>/<TARGET_BUCKET>/<STAT>
+// into the ID of the replication, which is everything up to the stat, and the name of the stat
+func parseXdcrStatName(name string) (string, string, bool) {
+	if !strings.HasPrefix(name, xdcrStatPrefix) {
+		return "", "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(name, xdcrStatPrefix), "/")
+	if len(parts) != 4 {
+		return "", "", false
+	}
+
+	return strings.Join(parts[:3], "/"), parts[3], true
+}
+
+// Label an XDCR replication by its ID, which has the form <REMOTE_CLUSTER_UUID>/<SOURCE_BUCKET>/<TARGET_BUCKET>, and by
+// its source and target buckets, so you don't have to parse the ID in queries
+func xdcrLabels(id string) []Label {
+	labels := []Label{{"replication", id}}
+
+	parts := strings.Split(id, "/")
+	if len(parts) == 3 {
+		labels = append(labels, Label{"remote_cluster_uuid", parts[0]}, Label{"source_bucket", parts[1]}, Label{"target_bucket", parts[2]})
+	}
+
+	return labels
+}
+
+func addStats(metrics *MetricSet, statMetrics []statMetric, stats couchbase.NumericStats, labels []Label) {
+	for _, stat := range statMetrics {
+		if value, exists := stats[stat.Stat]; exists {
+			addCouchbase(metrics, stat.Metric, stat.Help, stat.Type, value, labels...)
+		}
+	}
+}
+
+func addCouchbase(metrics *MetricSet, name string, help string, metricType string, value float64, labels ...Label) {
+	metrics.Add(couchbaseNamespace+"_"+name, help, metricType, value, labels...)
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(samples map[string][]float64) []string {
+	keys := []string{}
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+const testUsername = "exporter"
+const testPassword = "exporter-password"
+
+// A stand-in for the REST API of Couchbase Server or Sync Gateway that responds to each path with a file of stats
+// recorded from a real server
+type fakeStatsServer struct {
+	t         *testing.T
+	responses map[string]string
+	requests  []string
+}
+
+func (server *fakeStatsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.requests = append(server.requests, r.URL.Path)
+
+	username, password, _ := r.BasicAuth()
+	if username != testUsername || password != testPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	file, exists := server.responses[r.URL.Path]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	require.NoError(server.t, err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func newFakeCouchbaseServer(t *testing.T) (*fakeStatsServer, *httptest.Server) {
+	fake := &fakeStatsServer{
+		t: t,
+		responses: map[string]string{
+			"/pools/default":                           "pools-default.json",
+			"/pools/default/buckets":                   "buckets.json",
+			"/pools/default/buckets/test-bucket/stats": "bucket-stats.json",
+			"/pools/default/tasks":                     "tasks.json",
+		},
+	}
+	return fake, httptest.NewServer(fake)
+}
+
+func collectToString(t *testing.T, collector Collector) string {
+	metrics := NewMetricSet()
+	require.NoError(t, collector.Collect(metrics))
+
+	var output bytes.Buffer
+	require.NoError(t, metrics.Write(&output))
+	return output.String()
+}
+
+func assertHasLines(t *testing.T, output string, expectedLines ...string) {
+	lines := strings.Split(output, "\n")
+	for _, expected := range expectedLines {
+		assert.Contains(t, lines, expected)
+	}
+}
+
+func TestCouchbaseCollector(t *testing.T) {
+	t.Parallel()
+
+	fake, server := newFakeCouchbaseServer(t)
+	defer server.Close()
+
+	output := collectToString(t, CouchbaseCollector{Client: couchbase.NewClient(server.URL, testUsername, testPassword)})
+
+	assertHasLines(t, output,
+		// Cluster stats from /pools/default
+		"# HELP couchbase_cluster_ram_total_bytes Total RAM of all the nodes in the cluster.",
+		"# TYPE couchbase_cluster_ram_total_bytes gauge",
+		"couchbase_cluster_ram_total_bytes 8201912320",
+		"couchbase_cluster_ram_quota_used_bytes 209715200",
+		"couchbase_cluster_disk_used_by_data_bytes 12459183",
+		"couchbase_cluster_balanced 1",
+		"couchbase_cluster_rebalance_running 0",
+		"couchbase_cluster_nodes 2",
+
+		// Node stats from /pools/default
+		`couchbase_node_healthy{node="10.0.0.1:8091"} 1`,
+		`couchbase_node_healthy{node="10.0.0.2:8091"} 0`,
+		`couchbase_node_active{node="10.0.0.2:8091"} 0`,
+		`couchbase_node_info{node="10.0.0.1:8091",services="index,kv,n1ql",this_node="true",version="6.6.0-7909-enterprise"} 1`,
+		`couchbase_node_uptime_seconds{node="10.0.0.1:8091"} 3605`,
+		`couchbase_node_cpu_utilization_percent{node="10.0.0.2:8091"} 7.25`,
+		`couchbase_node_swap_used_bytes{node="10.0.0.2:8091"} 1048576`,
+		`couchbase_node_items{node="10.0.0.1:8091"} 1200`,
+		`couchbase_node_disk_fetches_per_second{node="10.0.0.2:8091"} 0.5`,
+
+		// Bucket stats from /pools/default/buckets
+		`couchbase_bucket_ram_quota_bytes{bucket="test-bucket"} 209715200`,
+		`couchbase_bucket_ram_quota_used_percent{bucket="test-bucket"} 38.79`,
+		`couchbase_bucket_items{bucket="test-bucket"} 2400`,
+		`couchbase_bucket_items{bucket="sessions"} 10`,
+
+		// The latest samples of the detailed bucket stats
+		`couchbase_bucket_gets_per_second{bucket="test-bucket"} 3.5`,
+		`couchbase_bucket_sets_per_second{bucket="test-bucket"} 6`,
+		`couchbase_bucket_disk_write_queue_items{bucket="test-bucket"} 1`,
+		`couchbase_bucket_connections{bucket="test-bucket"} 25`,
+
+		// XDCR stats from the detailed bucket stats
+		"# TYPE couchbase_xdcr_docs_written_total counter",
+		`couchbase_xdcr_docs_written_total{remote_cluster_uuid="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3",replication="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3/test-bucket/test-bucket",source_bucket="test-bucket",target_bucket="test-bucket"} 1200`,
+		`couchbase_xdcr_docs_latency_milliseconds{remote_cluster_uuid="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3",replication="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3/test-bucket/test-bucket",source_bucket="test-bucket",target_bucket="test-bucket"} 3`,
+
+		// XDCR stats from /pools/default/tasks
+		`couchbase_xdcr_running{remote_cluster_uuid="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3",replication="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3/test-bucket/test-bucket",source_bucket="test-bucket",target_bucket="test-bucket"} 1`,
+		`couchbase_xdcr_changes_left{remote_cluster_uuid="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3",replication="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3/test-bucket/test-bucket",source_bucket="test-bucket",target_bucket="test-bucket"} 42`,
+		`couchbase_xdcr_errors{remote_cluster_uuid="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3",replication="9ff3b29d1c4b8dfa0e0b4c2e1f79a4b3/test-bucket/test-bucket",source_bucket="test-bucket",target_bucket="test-bucket"} 1`,
+	)
+
+	// Stats we don't export, and the detailed stats of memcached buckets, which aren't fetched at all
+	assert.NotContains(t, output, "ep_num_value_ejects")
+	assert.NotContains(t, output, "docs_opt_repd")
+	assert.NotContains(t, output, `couchbase_bucket_gets_per_second{bucket="sessions"}`)
+	assert.NotContains(t, fake.requests, "/pools/default/buckets/sessions/stats")
+}
+
+func TestCouchbaseCollectorFailsOnUnauthorized(t *testing.T) {
+	t.Parallel()
+
+	_, server := newFakeCouchbaseServer(t)
+	defer server.Close()
+
+	err := CouchbaseCollector{Client: couchbase.NewClient(server.URL, testUsername, "wrong-password")}.Collect(NewMetricSet())
+	assert.True(t, couchbase.IsUnauthorized(err), "Expected an unauthorized error, but got %v", err)
+}
+
+func TestParseXdcrStatName(t *testing.T) {
+	t.Parallel()
+
+	id, stat, isXdcrStat := parseXdcrStatName("replications/abc123/source/target/docs_written")
+	assert.True(t, isXdcrStat)
+	assert.Equal(t, "abc123/source/target", id)
+	assert.Equal(t, "docs_written", stat)
+
+	for _, name := range []string{"cmd_get", "replications/abc123/docs_written", "replications/abc123/source/target/extra/docs_written"} {
+		_, _, isXdcrStat := parseXdcrStatName(name)
+		assert.False(t, isXdcrStat, name)
+	}
+}
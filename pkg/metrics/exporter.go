@@ -0,0 +1,97 @@
+// Package metrics exports the stats of Couchbase Server and Sync Gateway as Prometheus metrics. On every scrape, it
+// fetches the stats from the REST APIs of the node it runs on, so it doesn't keep any state of its own, and converts
+// them to the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The prefix of the metrics about the exporter itself
+const exporterNamespace = "couchbase_exporter"
+
+// Collector fetches stats from one source, such as Couchbase Server or Sync Gateway, and adds them to a MetricSet
+type Collector interface {
+	// The name of the source, used as the prefix of its up metric: e.g., couchbase_up
+	Name() string
+
+	// Add the current stats of the source to the given MetricSet. If this returns an error, the metrics it added are
+	// discarded.
+	Collect(metrics *MetricSet) error
+}
+
+// Exporter is an http.Handler that runs all its collectors in parallel on every request and responds with their
+// metrics. If a collector fails, its metrics are left out, and its <NAME>_up metric is set to 0, so the exporter keeps
+// working while Couchbase or Sync Gateway is down, and you can alert on it.
+type Exporter struct {
+	Collectors []Collector
+	Logger     *log.Logger
+}
+
+type collectorResult struct {
+	name     string
+	metrics  *MetricSet
+	err      error
+	duration time.Duration
+}
+
+// NewExporter creates an Exporter for the given collectors that logs failed scrapes to stderr
+func NewExporter(collectors ...Collector) *Exporter {
+	return &Exporter{
+		Collectors: collectors,
+		Logger:     log.New(os.Stderr, "[couchbase-exporter] ", log.LstdFlags),
+	}
+}
+
+// Scrape runs all the collectors and returns their metrics, along with an up metric and a scrape duration metric for
+// each collector
+func (exporter *Exporter) Scrape() *MetricSet {
+	results := make([]collectorResult, len(exporter.Collectors))
+
+	var waitGroup sync.WaitGroup
+	for i, collector := range exporter.Collectors {
+		waitGroup.Add(1)
+		go func(i int, collector Collector) {
+			defer waitGroup.Done()
+
+			start := time.Now()
+			metrics := NewMetricSet()
+			err := collector.Collect(metrics)
+			results[i] = collectorResult{name: collector.Name(), metrics: metrics, err: err, duration: time.Since(start)}
+		}(i, collector)
+	}
+	waitGroup.Wait()
+
+	all := NewMetricSet()
+	for _, result := range results {
+		up := 1.0
+		if result.err != nil {
+			exporter.Logger.Printf("ERROR: failed to collect %s metrics: %v", result.name, result.err)
+			up = 0
+		} else {
+			all.Merge(result.metrics)
+		}
+
+		all.Add(result.name+"_up", "Whether the last scrape of "+result.name+" succeeded (1) or not (0).", Gauge, up)
+		all.Add(exporterNamespace+"_scrape_duration_seconds", "How long the last scrape of each collector took.", Gauge, result.duration.Seconds(), Label{"collector", result.name})
+	}
+
+	return all
+}
+
+func (exporter *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Render the whole response before sending it, so a failure doesn't leave Prometheus with half the metrics
+	var body bytes.Buffer
+	if err := exporter.Scrape().Write(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Write(body.Bytes())
+}
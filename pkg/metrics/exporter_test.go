@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+type failingCollector struct{}
+
+func (collector failingCollector) Name() string {
+	return "failing"
+}
+
+func (collector failingCollector) Collect(metrics *MetricSet) error {
+	metrics.Add("failing_partial_metric", "Should never be exported.", Gauge, 1)
+	return errors.New("collector failed")
+}
+
+func TestExporterServesMetricsAndUpForEachCollector(t *testing.T) {
+	t.Parallel()
+
+	_, couchbaseServer := newFakeCouchbaseServer(t)
+	defer couchbaseServer.Close()
+
+	exporter := NewExporter(CouchbaseCollector{Client: couchbase.NewClient(couchbaseServer.URL, testUsername, testPassword)}, failingCollector{})
+	exporter.Logger = log.New(ioutil.Discard, "", 0)
+
+	server := httptest.NewServer(exporter)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+
+	output := string(body)
+	assertHasLines(t, output,
+		"couchbase_up 1",
+		"failing_up 0",
+		"couchbase_cluster_nodes 2",
+		"# TYPE couchbase_exporter_scrape_duration_seconds gauge",
+	)
+	assert.Contains(t, output, `couchbase_exporter_scrape_duration_seconds{collector="couchbase"} `)
+	assert.Contains(t, output, `couchbase_exporter_scrape_duration_seconds{collector="failing"} `)
+	assert.NotContains(t, output, "failing_partial_metric")
+}
+
+func TestMetricSetWrite(t *testing.T) {
+	t.Parallel()
+
+	metrics := NewMetricSet()
+	metrics.Add("zeta.metric", "Written last.", Gauge, 2.5)
+	metrics.Add("alpha", "Line one\nline two with a \\.", Counter, 10, Label{"path", `C:\data "quoted"`}, Label{"bucket", "b"})
+	metrics.Add("alpha", "Ignored, as the help of the first sample wins.", Gauge, math.Inf(1), Label{"bucket", "c"})
+	metrics.Add("9lives", "", Untyped, math.NaN())
+
+	var output bytes.Buffer
+	require.NoError(t, metrics.Write(&output))
+
+	expected := `# TYPE _9lives untyped
+_9lives NaN
+# HELP alpha Line one\nline two with a \\.
+# TYPE alpha counter
+alpha{bucket="b",path="C:\\data \"quoted\""} 10
+alpha{bucket="c"} +Inf
+# HELP zeta_metric Written last.
+# TYPE zeta_metric gauge
+zeta_metric 2.5
+`
+	assert.Equal(t, expected, output.String())
+}
+
+func TestSanitizeName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "sync_gateway_per_db", SanitizeName("sync_gateway.per_db"))
+	assert.Equal(t, "a_b_c", SanitizeName("a-b  c"))
+	assert.Equal(t, "_1st", SanitizeName("1st"))
+	assert.Equal(t, "already:valid_name", SanitizeName("already:valid_name"))
+}
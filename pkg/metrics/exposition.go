@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The metric types of the Prometheus text exposition format. We don't know whether most of the stats Couchbase and Sync
+// Gateway report are counters or gauges, so those are untyped.
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+	Untyped = "untyped"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Label is a single name/value pair attached to a Sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single value of a metric, identified by its labels
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Family is a metric and all its samples
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// MetricSet collects samples, grouping them into families by name. It is not safe for concurrent use.
+type MetricSet struct {
+	families map[string]*Family
+}
+
+// NewMetricSet creates an empty MetricSet
+func NewMetricSet() *MetricSet {
+	return &MetricSet{families: map[string]*Family{}}
+}
+
+// Add adds a sample to the metric with the given name, creating the metric if it doesn't exist yet. The help text and
+// type are taken from the first sample added for each name. Names are sanitized to be valid Prometheus metric names.
+func (set *MetricSet) Add(name string, help string, metricType string, value float64, labels ...Label) {
+	name = SanitizeName(name)
+
+	family, exists := set.families[name]
+	if !exists {
+		family = &Family{Name: name, Help: help, Type: metricType}
+		set.families[name] = family
+	}
+
+	family.Samples = append(family.Samples, Sample{Labels: labels, Value: value})
+}
+
+// Merge adds all the samples in the other MetricSet to this one
+func (set *MetricSet) Merge(other *MetricSet) {
+	for _, family := range other.families {
+		for _, sample := range family.Samples {
+			set.Add(family.Name, family.Help, family.Type, sample.Value, sample.Labels...)
+		}
+	}
+}
+
+// Families returns the metrics in this set, sorted by name
+func (set *MetricSet) Families() []*Family {
+	families := []*Family{}
+	for _, family := range set.families {
+		families = append(families, family)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+
+	return families
+}
+
+// Write writes the metrics in this set to the given writer in the Prometheus text exposition format:
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+func (set *MetricSet) Write(writer io.Writer) error {
+	buffered := bufio.NewWriter(writer)
+
+	for _, family := range set.Families() {
+		if family.Help != "" {
+			fmt.Fprintf(buffered, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		}
+		fmt.Fprintf(buffered, "# TYPE %s %s\n", family.Name, family.Type)
+
+		for _, sample := range family.Samples {
+			fmt.Fprintf(buffered, "%s%s %s\n", family.Name, formatLabels(sample.Labels), formatValue(sample.Value))
+		}
+	}
+
+	return buffered.Flush()
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+var repeatedUnderscores = regexp.MustCompile(`__+`)
+
+// SanitizeName turns the given string into a valid Prometheus metric or label name by replacing every invalid character
+// with an underscore: e.g., sync_gateway.per_db becomes sync_gateway_per_db
+func SanitizeName(name string) string {
+	name = invalidNameChars.ReplaceAllString(name, "_")
+	name = repeatedUnderscores.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	sorted := append([]Label{}, labels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	formatted := []string{}
+	for _, label := range sorted {
+		formatted = append(formatted, fmt.Sprintf(`%s="%s"`, SanitizeName(label.Name), escapeLabelValue(label.Value)))
+	}
+
+	return "{" + strings.Join(formatted, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case value == math.Trunc(value) && math.Abs(value) < 1e15:
+		// Write whole numbers, such as byte counts, in full rather than in exponent notation, which is easier to read
+		return strconv.FormatFloat(value, 'f', 0, 64)
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The prefix of every metric scraped from Sync Gateway
+const syncGatewayNamespace = "sync_gateway"
+
+// SyncGatewayCollector scrapes the stats Sync Gateway publishes at /_expvar on its admin REST API. Sync Gateway reports
+// hundreds of stats, and adds more with each release, so rather than picking a fixed list, this exports every numeric
+// stat under syncgateway in the expvar output:
+//
+// - syncgateway.global.<GROUP>.<STAT> becomes sync_gateway_<GROUP>_<STAT>
+// - syncgateway.per_db.<DATABASE>.<GROUP>.<STAT> becomes sync_gateway_<GROUP>_<STAT>{database="<DATABASE>"}
+// - syncgateway.per_replication.<ID>.<STAT> becomes sync_gateway_replication_<STAT>{replication="<ID>"}
+type SyncGatewayCollector struct {
+	// The URL of the Sync Gateway admin REST API, including the protocol and port: e.g., http://localhost:4985
+	Url        string
+	HttpClient *http.Client
+}
+
+// The parts of the Sync Gateway expvar output we export
+type syncGatewayExpvars struct {
+	SyncGateway struct {
+		Global         map[string]interface{}            `json:"global"`
+		PerDb          map[string]map[string]interface{} `json:"per_db"`
+		PerReplication map[string]map[string]interface{} `json:"per_replication"`
+	} `json:"syncgateway"`
+}
+
+func (collector SyncGatewayCollector) Name() string {
+	return syncGatewayNamespace
+}
+
+func (collector SyncGatewayCollector) Collect(metrics *MetricSet) error {
+	expvarUrl := strings.TrimSuffix(collector.Url, "/") + "/_expvar"
+
+	resp, err := collector.HttpClient.Get(expvarUrl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return SyncGatewayStatusErr{Url: expvarUrl, Status: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	var expvars syncGatewayExpvars
+	if err := json.Unmarshal(body, &expvars); err != nil {
+		return InvalidExpvarsErr{Url: expvarUrl, Underlying: err}
+	}
+
+	collectSyncGatewayMetrics(metrics, expvars)
+	return nil
+}
+
+func collectSyncGatewayMetrics(metrics *MetricSet, expvars syncGatewayExpvars) {
+	addNumericLeaves(metrics, syncGatewayNamespace, "syncgateway.global", expvars.SyncGateway.Global, nil)
+
+	for _, database := range sortedGroupKeys(expvars.SyncGateway.PerDb) {
+		labels := []Label{{"database", database}}
+		addNumericLeaves(metrics, syncGatewayNamespace, "syncgateway.per_db", expvars.SyncGateway.PerDb[database], labels)
+	}
+
+	for _, replication := range sortedGroupKeys(expvars.SyncGateway.PerReplication) {
+		labels := []Label{{"replication", replication}}
+		addNumericLeaves(metrics, syncGatewayNamespace+"_replication", "syncgateway.per_replication", expvars.SyncGateway.PerReplication[replication], labels)
+	}
+}
+
+// Add a metric for every numeric value in the given tree of stats, named after the path to the value, and skip
+// everything else, such as strings and lists
+func addNumericLeaves(metrics *MetricSet, prefix string, expvarPath string, stats map[string]interface{}, labels []Label) {
+	names := []string{}
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch value := stats[name].(type) {
+		case float64:
+			help := fmt.Sprintf("Sync Gateway stat %s.%s.", expvarPath, name)
+			metrics.Add(prefix+"_"+name, help, Untyped, value, labels...)
+		case bool:
+			help := fmt.Sprintf("Sync Gateway stat %s.%s.", expvarPath, name)
+			metrics.Add(prefix+"_"+name, help, Gauge, boolToFloat(value), labels...)
+		case map[string]interface{}:
+			addNumericLeaves(metrics, prefix+"_"+name, expvarPath+"."+name, value, labels)
+		}
+	}
+}
+
+func sortedGroupKeys(groups map[string]map[string]interface{}) []string {
+	keys := []string{}
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Custom error types
+
+type SyncGatewayStatusErr struct {
+	Url    string
+	Status int
+	Body   string
+}
+
+func (err SyncGatewayStatusErr) Error() string {
+	return fmt.Sprintf("Expected status code 200 from %s but got %d. Response body: %s", err.Url, err.Status, err.Body)
+}
+
+type InvalidExpvarsErr struct {
+	Url        string
+	Underlying error
+}
+
+func (err InvalidExpvarsErr) Error() string {
+	return fmt.Sprintf("Failed to parse the Sync Gateway stats from %s: %v", err.Url, err.Underlying)
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncGatewayCollector(t *testing.T) {
+	t.Parallel()
+
+	// The Sync Gateway admin REST API usually only listens on localhost and doesn't require auth
+	fake := &fakeStatsServer{t: t, responses: map[string]string{"/_expvar": "expvar.json"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetBasicAuth(testUsername, testPassword)
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	output := collectToString(t, SyncGatewayCollector{Url: server.URL + "/", HttpClient: http.DefaultClient})
+
+	assertHasLines(t, output,
+		// Global stats
+		"# HELP sync_gateway_resource_utilization_num_goroutines Sync Gateway stat syncgateway.global.resource_utilization.num_goroutines.",
+		"# TYPE sync_gateway_resource_utilization_num_goroutines untyped",
+		"sync_gateway_resource_utilization_num_goroutines 42",
+		"sync_gateway_resource_utilization_process_cpu_percent_utilization 1.5",
+
+		// Per-database stats, including nested ones
+		`sync_gateway_cache_chan_cache_hits{database="db"} 100`,
+		`sync_gateway_database_num_doc_writes{database="db"} 120`,
+		`sync_gateway_database_num_doc_writes{database="db2"} 1`,
+		`sync_gateway_database_cache_feed_dcp_backfill_completed{database="db"} 1024`,
+		`sync_gateway_security_auth_failed_count{database="db"} 2`,
+		`sync_gateway_cbl_replication_push_doc_push_count{database="db"} 12`,
+
+		// Per-replication stats
+		`sync_gateway_replication_sgr_num_docs_pushed{replication="replication-to-backup"} 20`,
+		"# TYPE sync_gateway_replication_sgr_active gauge",
+		`sync_gateway_replication_sgr_active{replication="replication-to-backup"} 1`,
+	)
+
+	// Only the stats under syncgateway are exported
+	assert.NotContains(t, output, "NumGC")
+	assert.NotContains(t, output, "cmdline")
+}
+
+func TestSyncGatewayCollectorFailsOnErrorStatus(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	err := SyncGatewayCollector{Url: server.URL, HttpClient: http.DefaultClient}.Collect(NewMetricSet())
+	assert.IsType(t, SyncGatewayStatusErr{}, err)
+}
@@ -0,0 +1,188 @@
+package rotation
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/credentials"
+)
+
+// NodeExecutor runs a command on a node and returns its combined stdout and stderr. What a node is depends on the
+// implementation: e.g., an EC2 Instance ID or a Docker container name.
+type NodeExecutor interface {
+	Run(node string, args []string) (string, error)
+}
+
+// LocalExecutor runs commands on the current machine, ignoring the node. Only useful with a single node.
+type LocalExecutor struct{}
+
+func (executor LocalExecutor) Run(node string, args []string) (string, error) {
+	return runCommand(args[0], args[1:]...)
+}
+
+// DockerExecutor runs commands in Docker containers using docker exec, where the node is the name of the container.
+// This is a local stand-in for SsmExecutor that makes it possible to test rotation against the Docker examples.
+type DockerExecutor struct{}
+
+func (executor DockerExecutor) Run(node string, args []string) (string, error) {
+	return runCommand("docker", append([]string{"exec", node}, args...)...)
+}
+
+func runCommand(command string, args ...string) (string, error) {
+	output, err := exec.Command(command, args...).CombinedOutput()
+	if err != nil {
+		return string(output), CommandErr{Command: strings.Join(append([]string{command}, args...), " "), Output: string(output), Underlying: err}
+	}
+	return string(output), nil
+}
+
+// SsmExecutor runs commands on EC2 Instances using SSM Run Command, where the node is the ID of the EC2 Instance. The
+// Instances must run the SSM Agent and have an IAM role that allows SSM to manage them.
+type SsmExecutor struct {
+	Session *credentials.LazyAwsSession
+
+	// If set, this client is used instead of one created from Session. Primarily useful for testing.
+	Client ssmiface.SSMAPI
+
+	Timeout           time.Duration
+	SleepBetweenPolls time.Duration
+}
+
+func (executor *SsmExecutor) Run(node string, args []string) (string, error) {
+	client, err := executor.client()
+	if err != nil {
+		return "", err
+	}
+
+	quotedArgs := []string{}
+	for _, arg := range args {
+		quotedArgs = append(quotedArgs, shellQuote(arg))
+	}
+
+	output, err := client.SendCommand(&ssm.SendCommandInput{
+		DocumentName: aws.String("AWS-RunShellScript"),
+		InstanceIds:  []*string{aws.String(node)},
+		Parameters: map[string][]*string{
+			"commands": {aws.String(strings.Join(quotedArgs, " "))},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return executor.waitForCommand(client, aws.StringValue(output.Command.CommandId), node)
+}
+
+func (executor *SsmExecutor) waitForCommand(client ssmiface.SSMAPI, commandId string, instanceId string) (string, error) {
+	timeout := executor.Timeout
+	if timeout <= 0 {
+		timeout = 15 * time.Minute
+	}
+
+	sleepBetweenPolls := executor.SleepBetweenPolls
+	if sleepBetweenPolls <= 0 {
+		sleepBetweenPolls = 5 * time.Second
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		// The invocation may not exist right after SendCommand returns, so treat errors as "not done yet"
+		invocation, err := client.GetCommandInvocation(&ssm.GetCommandInvocationInput{
+			CommandId:  aws.String(commandId),
+			InstanceId: aws.String(instanceId),
+		})
+
+		if err == nil {
+			output := aws.StringValue(invocation.StandardOutputContent) + aws.StringValue(invocation.StandardErrorContent)
+
+			switch aws.StringValue(invocation.Status) {
+			case ssm.CommandInvocationStatusSuccess:
+				return output, nil
+			case ssm.CommandInvocationStatusPending, ssm.CommandInvocationStatusInProgress, ssm.CommandInvocationStatusDelayed:
+			default:
+				return output, SsmCommandErr{CommandId: commandId, InstanceId: instanceId, Status: aws.StringValue(invocation.Status)}
+			}
+		}
+
+		time.Sleep(sleepBetweenPolls)
+	}
+
+	return "", SsmCommandErr{CommandId: commandId, InstanceId: instanceId, Status: "timed out"}
+}
+
+func (executor *SsmExecutor) client() (ssmiface.SSMAPI, error) {
+	if executor.Client != nil {
+		return executor.Client, nil
+	}
+
+	sess, err := executor.Session.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	executor.Client = ssm.New(sess)
+	return executor.Client, nil
+}
+
+// LookupAsgInstanceIds returns the IDs of the InService EC2 Instances in the given Auto Scaling Group
+func LookupAsgInstanceIds(session *credentials.LazyAwsSession, asgName string) ([]string, error) {
+	sess, err := session.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := autoscaling.New(sess).DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	instanceIds := []string{}
+	for _, group := range output.AutoScalingGroups {
+		for _, instance := range group.Instances {
+			if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+				instanceIds = append(instanceIds, aws.StringValue(instance.InstanceId))
+			}
+		}
+	}
+
+	if len(instanceIds) == 0 {
+		return nil, NoInstancesInAsgErr{AsgName: asgName}
+	}
+
+	return instanceIds, nil
+}
+
+// Quote the given argument so the shell passes it through as-is
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'"'"'`, -1) + "'"
+}
+
+// Custom error types
+
+type SsmCommandErr struct {
+	CommandId  string
+	InstanceId string
+	Status     string
+}
+
+func (err SsmCommandErr) Error() string {
+	return fmt.Sprintf("SSM command %s on Instance %s did not succeed. Status: %s.", err.CommandId, err.InstanceId, err.Status)
+}
+
+type NoInstancesInAsgErr struct {
+	AsgName string
+}
+
+func (err NoInstancesInAsgErr) Error() string {
+	return fmt.Sprintf("Found no InService Instances in Auto Scaling Group %s.", err.AsgName)
+}
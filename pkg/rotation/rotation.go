@@ -0,0 +1,387 @@
+// Package rotation rotates the password of a Couchbase cluster's admin account or of one of its RBAC users, along with
+// everything that depends on that password: the XDCR remote cluster references in other clusters that use it to
+// replicate into this cluster and the Sync Gateway configs that use it to connect to this cluster. Each step is
+// verified before moving on to the next, so a failed rotation stops as early as possible.
+package rotation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+const defaultMaxRetries = 30
+const defaultSleepBetweenRetries = 5 * time.Second
+
+// How many times in a row the XDCR replications that use an updated remote cluster reference must be running without
+// new authentication errors before we consider the reference verified
+const healthyXdcrChecks = 3
+
+// Options configures a single password rotation
+type Options struct {
+	// A client for the cluster in which to rotate the password, authenticated as the cluster's admin account with its
+	// current password
+	Cluster *couchbase.Client
+
+	// The RBAC user whose password to rotate. If empty or equal to the admin username of Cluster, rotate the password
+	// of the admin account itself.
+	Username string
+
+	NewPassword string
+
+	// Clients for other clusters, authenticated as their admin accounts, that may have XDCR remote cluster references
+	// pointing to Cluster. Every reference to Cluster that connects as Username is updated to use NewPassword.
+	XdcrSources []*couchbase.Client
+
+	// If not empty, only update the XDCR remote cluster references with these names, rather than every reference that
+	// points to Cluster
+	XdcrReferenceNames []string
+
+	// The Sync Gateway nodes to update, one at a time, by running SyncGatewayCommand on each of them with
+	// SyncGatewayExecutor. The command must update the Sync Gateway config, restart Sync Gateway, and only exit
+	// successfully once Sync Gateway is back online.
+	SyncGatewayNodes    []string
+	SyncGatewayExecutor NodeExecutor
+	SyncGatewayCommand  []string
+
+	MaxRetries          int
+	SleepBetweenRetries time.Duration
+	Logger              *log.Logger
+}
+
+// Rotate the password as specified in the given Options
+func Rotate(options Options) error {
+	options = withDefaults(options)
+	logger := options.Logger
+
+	if options.NewPassword == "" {
+		return EmptyPasswordErr{}
+	}
+
+	if len(options.SyncGatewayNodes) > 0 && (options.SyncGatewayExecutor == nil || len(options.SyncGatewayCommand) == 0) {
+		return NoExecutorErr{}
+	}
+
+	if _, err := options.Cluster.WhoAmI(); err != nil {
+		return VerifyCredentialsErr{Username: options.Cluster.Username, Url: options.Cluster.BaseUrl, Underlying: err}
+	}
+
+	// Look up the UUID before the password changes, in case the password being rotated is the one in options.Cluster
+	clusterUuid, err := options.Cluster.ClusterUuid()
+	if err != nil {
+		return err
+	}
+
+	username, err := rotateClusterPassword(options)
+	if err != nil {
+		return err
+	}
+
+	if err := waitForCredentials(options, options.Cluster.WithCredentials(username, options.NewPassword)); err != nil {
+		return err
+	}
+
+	for _, source := range options.XdcrSources {
+		if err := updateXdcrReferences(options, source, clusterUuid, username); err != nil {
+			return err
+		}
+	}
+
+	if err := rollSyncGateways(options); err != nil {
+		return err
+	}
+
+	logger.Printf("Successfully rotated the password of user %s in %s", username, options.Cluster.BaseUrl)
+	return nil
+}
+
+func withDefaults(options Options) Options {
+	if options.MaxRetries <= 0 {
+		options.MaxRetries = defaultMaxRetries
+	}
+	if options.SleepBetweenRetries <= 0 {
+		options.SleepBetweenRetries = defaultSleepBetweenRetries
+	}
+	if options.Logger == nil {
+		options.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	return options
+}
+
+// Change the password in the cluster itself and return the username whose password was changed
+func rotateClusterPassword(options Options) (string, error) {
+	cluster := options.Cluster
+
+	if options.Username == "" || options.Username == cluster.Username {
+		options.Logger.Printf("Changing the password of admin user %s in %s", cluster.Username, cluster.BaseUrl)
+		if err := cluster.ChangeAdminPassword(options.NewPassword); err != nil {
+			return "", err
+		}
+		return cluster.Username, nil
+	}
+
+	user, err := cluster.GetLocalUser(options.Username)
+	if err != nil {
+		return "", err
+	}
+
+	options.Logger.Printf("Changing the password of RBAC user %s in %s", user.Id, cluster.BaseUrl)
+	if err := cluster.SetLocalUserPassword(user, options.NewPassword); err != nil {
+		return "", err
+	}
+
+	return user.Id, nil
+}
+
+// Wait until the given client can authenticate. Password changes are not always visible on every node immediately.
+func waitForCredentials(options Options, client *couchbase.Client) error {
+	var lastErr error
+
+	for i := 0; i < options.MaxRetries; i++ {
+		if _, lastErr = client.WhoAmI(); lastErr == nil {
+			options.Logger.Printf("Verified that user %s can log into %s with the new password", client.Username, client.BaseUrl)
+			return nil
+		}
+
+		options.Logger.Printf("User %s cannot log into %s with the new password yet. Will sleep for %s and try again. Error: %v", client.Username, client.BaseUrl, options.SleepBetweenRetries, lastErr)
+		time.Sleep(options.SleepBetweenRetries)
+	}
+
+	return VerifyCredentialsErr{Username: client.Username, Url: client.BaseUrl, Underlying: lastErr}
+}
+
+func updateXdcrReferences(options Options, source *couchbase.Client, clusterUuid string, username string) error {
+	references, err := source.ListRemoteClusterReferences()
+	if err != nil {
+		return err
+	}
+
+	updated := 0
+
+	for _, reference := range references {
+		if !referenceMatches(options, reference, clusterUuid, username) {
+			continue
+		}
+
+		options.Logger.Printf("Updating the password in XDCR remote cluster reference %s in %s", reference.Name, source.BaseUrl)
+		updatedAt := time.Now()
+		if err := source.UpdateRemoteClusterReferencePassword(reference, options.NewPassword); err != nil {
+			return XdcrReferenceUpdateErr{Reference: reference.Name, Url: source.BaseUrl, Underlying: err}
+		}
+
+		if err := verifyXdcrReference(options, source, reference, updatedAt); err != nil {
+			return err
+		}
+
+		updated++
+	}
+
+	if updated == 0 {
+		options.Logger.Printf("WARNING: found no XDCR remote cluster references in %s that connect to %s as user %s", source.BaseUrl, options.Cluster.BaseUrl, username)
+	}
+
+	return nil
+}
+
+func referenceMatches(options Options, reference couchbase.RemoteClusterReference, clusterUuid string, username string) bool {
+	if reference.Username != username {
+		return false
+	}
+
+	if len(options.XdcrReferenceNames) == 0 {
+		return reference.Uuid == clusterUuid
+	}
+
+	for _, name := range options.XdcrReferenceNames {
+		if reference.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check that the given reference still exists after the update and that the XDCR replications that use it can still
+// authenticate: that is, that they keep running without reporting any authentication errors since the update. If no
+// replication uses the reference, there is nothing more to check than Couchbase's own check of the password when it
+// saved the reference.
+func verifyXdcrReference(options Options, source *couchbase.Client, reference couchbase.RemoteClusterReference, updatedAt time.Time) error {
+	references, err := source.ListRemoteClusterReferences()
+	if err != nil {
+		return err
+	}
+
+	if !containsReference(references, reference.Name) {
+		return XdcrReferenceUpdateErr{Reference: reference.Name, Url: source.BaseUrl, Underlying: fmt.Errorf("reference no longer exists after the update")}
+	}
+
+	healthyChecks := 0
+	unhealthyChecks := 0
+
+	for {
+		replications, err := replicationsUsingReference(source, reference)
+		if err != nil {
+			return err
+		}
+
+		if len(replications) == 0 {
+			options.Logger.Printf("No XDCR replications in %s use remote cluster reference %s, so there are no replications to check", source.BaseUrl, reference.Name)
+			return nil
+		}
+
+		authErrors := authErrorsSince(replications, updatedAt)
+		if len(authErrors) > 0 {
+			return XdcrReplicationErr{Reference: reference.Name, Url: source.BaseUrl, Errors: authErrors}
+		}
+
+		if allRunning(replications) {
+			healthyChecks++
+			if healthyChecks >= healthyXdcrChecks {
+				options.Logger.Printf("The %d XDCR replications in %s that use remote cluster reference %s are running without authentication errors", len(replications), source.BaseUrl, reference.Name)
+				return nil
+			}
+		} else {
+			healthyChecks = 0
+			unhealthyChecks++
+			if unhealthyChecks >= options.MaxRetries {
+				return XdcrReplicationErr{Reference: reference.Name, Url: source.BaseUrl, Errors: []string{"replications did not return to the running state after the update"}}
+			}
+		}
+
+		options.Logger.Printf("Checking the XDCR replications in %s that use remote cluster reference %s again in %s", source.BaseUrl, reference.Name, options.SleepBetweenRetries)
+		time.Sleep(options.SleepBetweenRetries)
+	}
+}
+
+func containsReference(references []couchbase.RemoteClusterReference, name string) bool {
+	for _, reference := range references {
+		if reference.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func replicationsUsingReference(source *couchbase.Client, reference couchbase.RemoteClusterReference) ([]couchbase.XdcrReplication, error) {
+	replications, err := source.ListXdcrReplications()
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []couchbase.XdcrReplication{}
+	for _, replication := range replications {
+		if replication.RemoteClusterUuid() == reference.Uuid {
+			matching = append(matching, replication)
+		}
+	}
+
+	return matching, nil
+}
+
+// Return the authentication errors the given replications reported after the given time. Errors without a time we can
+// parse may be from after the update, so they count too.
+func authErrorsSince(replications []couchbase.XdcrReplication, since time.Time) []string {
+	authErrors := []string{}
+
+	for _, replication := range replications {
+		for _, replicationErr := range replication.Errors {
+			if !strings.Contains(strings.ToLower(replicationErr.Message), "auth") {
+				continue
+			}
+
+			if errTime, err := time.Parse(time.RFC3339Nano, replicationErr.Time); err == nil && errTime.Before(since) {
+				continue
+			}
+
+			authErrors = append(authErrors, fmt.Sprintf("%s: %s", replication.Id, replicationErr.Message))
+		}
+	}
+
+	return authErrors
+}
+
+// Paused replications don't connect to the remote cluster, so only the others have to be running
+func allRunning(replications []couchbase.XdcrReplication) bool {
+	for _, replication := range replications {
+		if replication.Status != "running" && replication.Status != "paused" {
+			return false
+		}
+	}
+	return true
+}
+
+// Update the Sync Gateway nodes one at a time, stopping at the first failure, so that a bad update never takes down
+// more than one node
+func rollSyncGateways(options Options) error {
+	for i, node := range options.SyncGatewayNodes {
+		options.Logger.Printf("Updating Sync Gateway node %s (%d of %d)", node, i+1, len(options.SyncGatewayNodes))
+
+		output, err := options.SyncGatewayExecutor.Run(node, options.SyncGatewayCommand)
+		if output != "" {
+			options.Logger.Printf("Output from Sync Gateway node %s:\n%s", node, output)
+		}
+		if err != nil {
+			return SyncGatewayUpdateErr{Node: node, Updated: options.SyncGatewayNodes[:i], Underlying: err}
+		}
+	}
+
+	return nil
+}
+
+// Custom error types
+
+type EmptyPasswordErr struct{}
+
+func (err EmptyPasswordErr) Error() string {
+	return "The new password must not be empty."
+}
+
+type NoExecutorErr struct{}
+
+func (err NoExecutorErr) Error() string {
+	return "Sync Gateway nodes were specified, but no executor or command to run on them."
+}
+
+type VerifyCredentialsErr struct {
+	Username   string
+	Url        string
+	Underlying error
+}
+
+func (err VerifyCredentialsErr) Error() string {
+	return fmt.Sprintf("User %s failed to log into %s: %v", err.Username, err.Url, err.Underlying)
+}
+
+type XdcrReferenceUpdateErr struct {
+	Reference  string
+	Url        string
+	Underlying error
+}
+
+func (err XdcrReferenceUpdateErr) Error() string {
+	return fmt.Sprintf("Failed to update XDCR remote cluster reference %s in %s: %v", err.Reference, err.Url, err.Underlying)
+}
+
+type XdcrReplicationErr struct {
+	Reference string
+	Url       string
+	Errors    []string
+}
+
+func (err XdcrReplicationErr) Error() string {
+	return fmt.Sprintf("XDCR replications in %s that use remote cluster reference %s failed after the update: %s", err.Url, err.Reference, strings.Join(err.Errors, "; "))
+}
+
+type SyncGatewayUpdateErr struct {
+	Node       string
+	Updated    []string
+	Underlying error
+}
+
+func (err SyncGatewayUpdateErr) Error() string {
+	return fmt.Sprintf("Failed to update Sync Gateway node %s: %v. Stopped the rolling update. Nodes updated so far: %v.", err.Node, err.Underlying, err.Updated)
+}
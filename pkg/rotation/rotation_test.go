@@ -0,0 +1,308 @@
+package rotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/pkg/couchbase"
+)
+
+// A fake Couchbase cluster that implements just enough of the REST API to test rotation
+type fakeCluster struct {
+	mutex      sync.Mutex
+	uuid       string
+	adminUser  string
+	passwords  map[string]string
+	roles      map[string][]couchbase.LocalRole
+	references []couchbase.RemoteClusterReference
+
+	// The password each reference uses, keyed by reference name
+	referencePasswords map[string]string
+
+	// The remote clusters that references can point to, keyed by hostname, used to validate reference passwords
+	remotes map[string]*fakeCluster
+
+	replications []couchbase.XdcrReplication
+
+	// If set, the replications that use a reference report an authentication error once the reference is updated
+	failAuthAfterUpdate bool
+}
+
+func newFakeCluster(uuid string, adminPassword string) *fakeCluster {
+	return &fakeCluster{
+		uuid:               uuid,
+		adminUser:          "admin",
+		passwords:          map[string]string{"admin": adminPassword},
+		roles:              map[string][]couchbase.LocalRole{},
+		referencePasswords: map[string]string{},
+		remotes:            map[string]*fakeCluster{},
+	}
+}
+
+func (cluster *fakeCluster) checkPassword(username string, password string) bool {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	expected, hasUser := cluster.passwords[username]
+	return hasUser && expected == password
+}
+
+func (cluster *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, _ := r.BasicAuth()
+	if !cluster.checkPassword(username, password) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	const usersPath = "/settings/rbac/users/local/"
+	const referencesPath = "/pools/default/remoteClusters"
+
+	switch {
+	case r.URL.Path == "/whoami":
+		writeJson(w, map[string]string{"id": username})
+	case r.URL.Path == "/pools":
+		writeJson(w, map[string]string{"uuid": cluster.uuid})
+	case r.URL.Path == "/settings/web" && r.Method == "POST":
+		if r.PostForm.Get("username") != cluster.adminUser || r.PostForm.Get("port") != "SAME" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cluster.passwords[cluster.adminUser] = r.PostForm.Get("password")
+		writeJson(w, map[string]string{})
+	case strings.HasPrefix(r.URL.Path, usersPath) && r.Method == "GET":
+		user := strings.TrimPrefix(r.URL.Path, usersPath)
+		if _, hasUser := cluster.passwords[user]; !hasUser {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJson(w, couchbase.LocalUser{Id: user, Name: user, Roles: cluster.roles[user]})
+	case strings.HasPrefix(r.URL.Path, usersPath) && r.Method == "PUT":
+		user := strings.TrimPrefix(r.URL.Path, usersPath)
+		roles := []string{}
+		for _, role := range cluster.roles[user] {
+			roles = append(roles, role.String())
+		}
+		// The real API replaces the roles, so make sure they are passed along unchanged
+		if r.PostForm.Get("roles") != strings.Join(roles, ",") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cluster.passwords[user] = r.PostForm.Get("password")
+		writeJson(w, map[string]string{})
+	case r.URL.Path == referencesPath && r.Method == "GET":
+		writeJson(w, cluster.references)
+	case strings.HasPrefix(r.URL.Path, referencesPath+"/") && r.Method == "POST":
+		remote, hasRemote := cluster.remotes[r.PostForm.Get("hostname")]
+		// Like the real API, check the credentials against the remote cluster before saving them
+		if !hasRemote || !remote.checkPassword(r.PostForm.Get("username"), r.PostForm.Get("password")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cluster.referencePasswords[r.PostForm.Get("name")] = r.PostForm.Get("password")
+		if cluster.failAuthAfterUpdate {
+			for i := range cluster.replications {
+				cluster.replications[i].Errors = append(cluster.replications[i].Errors, couchbase.XdcrError{
+					Time:    time.Now().Format(time.RFC3339Nano),
+					Message: "Failed to authenticate with the remote cluster",
+				})
+			}
+		}
+		writeJson(w, map[string]string{})
+	case r.URL.Path == "/pools/default/tasks" && r.Method == "GET":
+		tasks := []interface{}{map[string]string{"type": "rebalance", "status": "notRunning"}}
+		for _, replication := range cluster.replications {
+			tasks = append(tasks, replication)
+		}
+		writeJson(w, tasks)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	bytes, _ := json.Marshal(value)
+	w.Write(bytes)
+}
+
+type fakeExecutor struct {
+	failOn string
+	nodes  []string
+}
+
+func (executor *fakeExecutor) Run(node string, args []string) (string, error) {
+	executor.nodes = append(executor.nodes, node)
+	if node == executor.failOn {
+		return "", fmt.Errorf("fake failure on %s", node)
+	}
+	return fmt.Sprintf("ran %s", strings.Join(args, " ")), nil
+}
+
+func TestRotateAdminPasswordUpdatesXdcrReferencesAndSyncGateways(t *testing.T) {
+	t.Parallel()
+
+	dest := newFakeCluster("dest-uuid", "old-password")
+	destServer := httptest.NewServer(dest)
+	defer destServer.Close()
+
+	source := newFakeCluster("source-uuid", "source-password")
+	source.remotes["dest:8091"] = dest
+	source.references = []couchbase.RemoteClusterReference{
+		{Name: "to-dest", Hostname: "dest:8091", Username: "admin", Uuid: "dest-uuid"},
+		{Name: "to-dest-other-user", Hostname: "dest:8091", Username: "replicator", Uuid: "dest-uuid"},
+		{Name: "to-elsewhere", Hostname: "elsewhere:8091", Username: "admin", Uuid: "elsewhere-uuid"},
+	}
+	source.replications = []couchbase.XdcrReplication{
+		{
+			Id:     "dest-uuid/test-bucket/test-bucket",
+			Type:   "xdcr",
+			Source: "test-bucket",
+			Target: "/remoteClusters/dest-uuid/buckets/test-bucket",
+			Status: "running",
+			// Errors from before the rotation don't count
+			Errors: []couchbase.XdcrError{{Time: "2020-01-01T00:00:00Z", Message: "Failed to authenticate with the remote cluster"}},
+		},
+	}
+	sourceServer := httptest.NewServer(source)
+	defer sourceServer.Close()
+
+	executor := &fakeExecutor{}
+
+	err := Rotate(Options{
+		Cluster:             couchbase.NewClient(destServer.URL, "admin", "old-password"),
+		NewPassword:         "new-password",
+		XdcrSources:         []*couchbase.Client{couchbase.NewClient(sourceServer.URL, "admin", "source-password")},
+		SyncGatewayNodes:    []string{"sg-0", "sg-1"},
+		SyncGatewayExecutor: executor,
+		SyncGatewayCommand:  []string{"update-sync-gateway"},
+		MaxRetries:          1,
+		SleepBetweenRetries: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, dest.checkPassword("admin", "new-password"))
+	assert.Equal(t, map[string]string{"to-dest": "new-password"}, source.referencePasswords)
+	assert.Equal(t, []string{"sg-0", "sg-1"}, executor.nodes)
+}
+
+func TestRotateFailsWhenXdcrReplicationCannotAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	dest := newFakeCluster("dest-uuid", "old-password")
+	destServer := httptest.NewServer(dest)
+	defer destServer.Close()
+
+	source := newFakeCluster("source-uuid", "source-password")
+	source.remotes["dest:8091"] = dest
+	source.references = []couchbase.RemoteClusterReference{
+		{Name: "to-dest", Hostname: "dest:8091", Username: "admin", Uuid: "dest-uuid"},
+	}
+	source.replications = []couchbase.XdcrReplication{
+		{Id: "dest-uuid/test-bucket/test-bucket", Type: "xdcr", Target: "/remoteClusters/dest-uuid/buckets/test-bucket", Status: "running"},
+	}
+	source.failAuthAfterUpdate = true
+	sourceServer := httptest.NewServer(source)
+	defer sourceServer.Close()
+
+	executor := &fakeExecutor{}
+
+	err := Rotate(Options{
+		Cluster:             couchbase.NewClient(destServer.URL, "admin", "old-password"),
+		NewPassword:         "new-password",
+		XdcrSources:         []*couchbase.Client{couchbase.NewClient(sourceServer.URL, "admin", "source-password")},
+		SyncGatewayNodes:    []string{"sg-0"},
+		SyncGatewayExecutor: executor,
+		SyncGatewayCommand:  []string{"update-sync-gateway"},
+		MaxRetries:          1,
+		SleepBetweenRetries: time.Millisecond,
+	})
+	require.Error(t, err)
+	require.IsType(t, XdcrReplicationErr{}, err)
+	assert.Equal(t, "to-dest", err.(XdcrReplicationErr).Reference)
+	assert.Empty(t, executor.nodes)
+}
+
+func TestRotateRbacUserPasswordKeepsRoles(t *testing.T) {
+	t.Parallel()
+
+	cluster := newFakeCluster("uuid", "admin-password")
+	cluster.passwords["test-user"] = "old-password"
+	cluster.roles["test-user"] = []couchbase.LocalRole{{Role: "bucket_full_access", BucketName: "test-bucket"}, {Role: "ro_admin"}}
+	server := httptest.NewServer(cluster)
+	defer server.Close()
+
+	err := Rotate(Options{
+		Cluster:             couchbase.NewClient(server.URL, "admin", "admin-password"),
+		Username:            "test-user",
+		NewPassword:         "new-password",
+		MaxRetries:          1,
+		SleepBetweenRetries: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, cluster.checkPassword("test-user", "new-password"))
+	assert.True(t, cluster.checkPassword("admin", "admin-password"))
+}
+
+func TestRotateFailsWithWrongAdminPassword(t *testing.T) {
+	t.Parallel()
+
+	cluster := newFakeCluster("uuid", "admin-password")
+	server := httptest.NewServer(cluster)
+	defer server.Close()
+
+	err := Rotate(Options{
+		Cluster:     couchbase.NewClient(server.URL, "admin", "wrong-password"),
+		NewPassword: "new-password",
+	})
+	require.Error(t, err)
+	assert.IsType(t, VerifyCredentialsErr{}, err)
+	assert.True(t, cluster.checkPassword("admin", "admin-password"))
+}
+
+func TestRotateStopsRollingUpdateAtFirstFailure(t *testing.T) {
+	t.Parallel()
+
+	cluster := newFakeCluster("uuid", "old-password")
+	server := httptest.NewServer(cluster)
+	defer server.Close()
+
+	executor := &fakeExecutor{failOn: "sg-1"}
+
+	err := Rotate(Options{
+		Cluster:             couchbase.NewClient(server.URL, "admin", "old-password"),
+		NewPassword:         "new-password",
+		SyncGatewayNodes:    []string{"sg-0", "sg-1", "sg-2"},
+		SyncGatewayExecutor: executor,
+		SyncGatewayCommand:  []string{"update-sync-gateway"},
+		MaxRetries:          1,
+		SleepBetweenRetries: time.Millisecond,
+	})
+	require.Error(t, err)
+	require.IsType(t, SyncGatewayUpdateErr{}, err)
+	assert.Equal(t, []string{"sg-0"}, err.(SyncGatewayUpdateErr).Updated)
+	assert.Equal(t, []string{"sg-0", "sg-1"}, executor.nodes)
+}
+
+func TestShellQuote(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `'ssm:///couchbase/password'`, shellQuote("ssm:///couchbase/password"))
+	assert.Equal(t, `'it'"'"'s'`, shellQuote("it's"))
+}
@@ -0,0 +1,233 @@
+package rotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncGatewayOptions configures updating the password in the Sync Gateway on the current node
+type SyncGatewayOptions struct {
+	// The path of the Sync Gateway config file, as rendered by run-sync-gateway
+	ConfigPath string
+
+	// Update the password of every database in the config that connects to Couchbase as this user
+	Username    string
+	NewPassword string
+
+	// If not empty, only update these databases
+	Databases []string
+
+	// The name of the systemd service that runs Sync Gateway
+	ServiceName string
+
+	// The URL of the Sync Gateway REST API, used to check that each database is back online after the restart
+	SyncGatewayUrl string
+
+	MaxRetries          int
+	SleepBetweenRetries time.Duration
+	Logger              *log.Logger
+}
+
+// UpdateSyncGateway re-renders the Sync Gateway config on the current node with the new password, restarts Sync Gateway,
+// and waits for each updated database to come back online
+func UpdateSyncGateway(options SyncGatewayOptions) error {
+	if options.NewPassword == "" {
+		return EmptyPasswordErr{}
+	}
+
+	rotationOptions := withDefaults(Options{MaxRetries: options.MaxRetries, SleepBetweenRetries: options.SleepBetweenRetries, Logger: options.Logger})
+	logger := rotationOptions.Logger
+
+	databases, err := UpdateSyncGatewayConfig(options.ConfigPath, options.Username, options.NewPassword, options.Databases)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("Updated the password for databases %v in %s", databases, options.ConfigPath)
+
+	if err := restartService(options.ServiceName); err != nil {
+		return err
+	}
+
+	logger.Printf("Restarted %s", options.ServiceName)
+
+	for _, database := range databases {
+		if err := waitForSyncGatewayDatabase(options.SyncGatewayUrl, database, rotationOptions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateSyncGatewayConfig sets the password of every database in the Sync Gateway config file at the given path that
+// connects to Couchbase as the given username, optionally limited to the given databases, and returns the names of the
+// updated databases. The file is rewritten in place, so it keeps its owner and permissions.
+func UpdateSyncGatewayConfig(configPath string, username string, newPassword string, onlyDatabases []string) ([]string, error) {
+	configBytes, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return nil, InvalidSyncGatewayConfigErr{Path: configPath, Underlying: err}
+	}
+
+	databases, isMap := config["databases"].(map[string]interface{})
+	if !isMap {
+		return nil, InvalidSyncGatewayConfigErr{Path: configPath, Underlying: fmt.Errorf("no databases object found")}
+	}
+
+	updated := []string{}
+
+	for name, value := range databases {
+		database, isMap := value.(map[string]interface{})
+		if !isMap || database["username"] != username || !includeDatabase(name, onlyDatabases) {
+			continue
+		}
+
+		database["password"] = newPassword
+		updated = append(updated, name)
+	}
+
+	if len(updated) == 0 {
+		return nil, NoMatchingDatabasesErr{Path: configPath, Username: username}
+	}
+
+	sort.Strings(updated)
+
+	updatedBytes, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(configPath, append(updatedBytes, '\n'), fileInfo.Mode()); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func includeDatabase(name string, onlyDatabases []string) bool {
+	if len(onlyDatabases) == 0 {
+		return true
+	}
+
+	for _, database := range onlyDatabases {
+		if database == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func restartService(serviceName string) error {
+	output, err := exec.Command("systemctl", "restart", serviceName).CombinedOutput()
+	if err != nil {
+		return CommandErr{Command: fmt.Sprintf("systemctl restart %s", serviceName), Output: string(output), Underlying: err}
+	}
+	return nil
+}
+
+func waitForSyncGatewayDatabase(syncGatewayUrl string, database string, options Options) error {
+	databaseUrl := fmt.Sprintf("%s/%s/", strings.TrimSuffix(syncGatewayUrl, "/"), database)
+	client := http.Client{Timeout: 10 * time.Second}
+
+	var lastErr error
+
+	for i := 0; i < options.MaxRetries; i++ {
+		if lastErr = checkSyncGatewayDatabaseOnline(client, databaseUrl); lastErr == nil {
+			options.Logger.Printf("Sync Gateway database %s is online", database)
+			return nil
+		}
+
+		options.Logger.Printf("Sync Gateway database %s is not online yet. Will sleep for %s and try again. Error: %v", database, options.SleepBetweenRetries, lastErr)
+		time.Sleep(options.SleepBetweenRetries)
+	}
+
+	return SyncGatewayOfflineErr{Url: databaseUrl, Underlying: lastErr}
+}
+
+func checkSyncGatewayDatabaseOnline(client http.Client, databaseUrl string) error {
+	resp, err := client.Get(databaseUrl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected status code 200 but got %d. Response body: %s", resp.StatusCode, string(body))
+	}
+
+	var status struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return err
+	}
+
+	if status.State != "Online" {
+		return fmt.Errorf("database is in state %s", status.State)
+	}
+
+	return nil
+}
+
+// Custom error types
+
+type InvalidSyncGatewayConfigErr struct {
+	Path       string
+	Underlying error
+}
+
+func (err InvalidSyncGatewayConfigErr) Error() string {
+	return fmt.Sprintf("Failed to parse Sync Gateway config %s: %v", err.Path, err.Underlying)
+}
+
+type NoMatchingDatabasesErr struct {
+	Path     string
+	Username string
+}
+
+func (err NoMatchingDatabasesErr) Error() string {
+	return fmt.Sprintf("Found no databases in Sync Gateway config %s that connect to Couchbase as user %s.", err.Path, err.Username)
+}
+
+type CommandErr struct {
+	Command    string
+	Output     string
+	Underlying error
+}
+
+func (err CommandErr) Error() string {
+	return fmt.Sprintf("Command '%s' failed: %v. Output:\n%s", err.Command, err.Underlying, err.Output)
+}
+
+type SyncGatewayOfflineErr struct {
+	Url        string
+	Underlying error
+}
+
+func (err SyncGatewayOfflineErr) Error() string {
+	return fmt.Sprintf("Sync Gateway database at %s did not come back online: %v", err.Url, err.Underlying)
+}
@@ -0,0 +1,101 @@
+package rotation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const syncGatewayConfigForTest = `{
+  "adminInterface": "127.0.0.1:4985",
+  "interface": ":4984",
+  "databases": {
+    "db-a": {"server": "http://10.0.0.1:8091", "username": "test-user", "password": "old-password", "bucket": "a"},
+    "db-b": {"server": "http://10.0.0.1:8091", "username": "test-user", "password": "old-password", "bucket": "b"},
+    "db-c": {"server": "http://10.0.0.1:8091", "username": "other-user", "password": "other-password", "bucket": "c"}
+  }
+}`
+
+func writeSyncGatewayConfigForTest(t *testing.T, contents string) string {
+	tmpDir, err := ioutil.TempDir("", "sync-gateway-test")
+	require.NoError(t, err)
+
+	configPath := filepath.Join(tmpDir, "sync_gateway.json")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(contents), 0640))
+
+	return configPath
+}
+
+func readPasswords(t *testing.T, configPath string) map[string]string {
+	bytes, err := ioutil.ReadFile(configPath)
+	require.NoError(t, err)
+
+	var config struct {
+		Databases map[string]struct {
+			Password string `json:"password"`
+		} `json:"databases"`
+	}
+	require.NoError(t, json.Unmarshal(bytes, &config))
+
+	passwords := map[string]string{}
+	for name, database := range config.Databases {
+		passwords[name] = database.Password
+	}
+	return passwords
+}
+
+func TestUpdateSyncGatewayConfig(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeSyncGatewayConfigForTest(t, syncGatewayConfigForTest)
+	defer os.RemoveAll(filepath.Dir(configPath))
+
+	updated, err := UpdateSyncGatewayConfig(configPath, "test-user", "new-password", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db-a", "db-b"}, updated)
+	assert.Equal(t, map[string]string{"db-a": "new-password", "db-b": "new-password", "db-c": "other-password"}, readPasswords(t, configPath))
+
+	fileInfo, err := os.Stat(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fileInfo.Mode().Perm())
+}
+
+func TestUpdateSyncGatewayConfigOnlySelectedDatabases(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeSyncGatewayConfigForTest(t, syncGatewayConfigForTest)
+	defer os.RemoveAll(filepath.Dir(configPath))
+
+	updated, err := UpdateSyncGatewayConfig(configPath, "test-user", "new-password", []string{"db-b"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db-b"}, updated)
+	assert.Equal(t, map[string]string{"db-a": "old-password", "db-b": "new-password", "db-c": "other-password"}, readPasswords(t, configPath))
+}
+
+func TestUpdateSyncGatewayConfigNoMatchingDatabases(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeSyncGatewayConfigForTest(t, syncGatewayConfigForTest)
+	defer os.RemoveAll(filepath.Dir(configPath))
+
+	_, err := UpdateSyncGatewayConfig(configPath, "no-such-user", "new-password", nil)
+	require.Error(t, err)
+	assert.IsType(t, NoMatchingDatabasesErr{}, err)
+	assert.Equal(t, "old-password", readPasswords(t, configPath)["db-a"])
+}
+
+func TestUpdateSyncGatewayConfigInvalidJson(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeSyncGatewayConfigForTest(t, "not json")
+	defer os.RemoveAll(filepath.Dir(configPath))
+
+	_, err := UpdateSyncGatewayConfig(configPath, "test-user", "new-password", nil)
+	require.Error(t, err)
+	assert.IsType(t, InvalidSyncGatewayConfigErr{}, err)
+}
@@ -0,0 +1,75 @@
+// reap-test-resources deletes the AWS resources, such as AMIs, Auto Scaling Groups, and load balancers, that the tests
+// in this repo created but did not clean up, e.g. because a test run was aborted before its teardown stage ran. It
+// looks in all the regions the tests deploy to, prints a plan, and only deletes anything if you pass --delete.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/reaper"
+)
+
+const regionsFlagName = "regions"
+const minAgeFlagName = "min-age"
+const deleteFlagName = "delete"
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "reap-test-resources"
+	app.Usage = "Find the AWS resources the tests left behind, and delete them in dependency order."
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  regionsFlagName,
+			Usage: "A comma separated list of the regions to look in.",
+			Value: strings.Join(reaper.TestRegions, ","),
+		},
+		cli.DurationFlag{
+			Name:  minAgeFlagName,
+			Usage: "Only delete the resources of tests that started at least this long ago, so we don't delete the resources of tests that are still running. Make it longer than the test timeout.",
+			Value: 6 * time.Hour,
+		},
+		cli.BoolFlag{
+			Name:  deleteFlagName,
+			Usage: "Delete the resources in the plan. Default: only print the plan.",
+		},
+	}
+	app.Action = run
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(cliContext *cli.Context) error {
+	regions := []string{}
+	for _, region := range strings.Split(cliContext.String(regionsFlagName), ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			regions = append(regions, region)
+		}
+	}
+
+	cloud := reaper.NewAwsCloud()
+
+	log.Printf("Looking for resources the tests left behind in %s", strings.Join(regions, ", "))
+	resources, err := reaper.Find(cloud, regions)
+	if err != nil {
+		return err
+	}
+
+	plan := reaper.MakePlan(resources, time.Now(), cliContext.Duration(minAgeFlagName))
+	plan.Format(os.Stdout)
+
+	if !cliContext.Bool(deleteFlagName) {
+		log.Printf("Not deleting anything, as --%s is not set", deleteFlagName)
+		return nil
+	}
+
+	return reaper.Execute(cloud, plan, log.Printf)
+}
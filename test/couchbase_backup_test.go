@@ -0,0 +1,121 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+// The settings for the MinIO server in docker-compose.object-store.yml, which stands in for S3
+const objectStoreBucket = "couchbase-backups"
+const objectStoreEndpoint = "http://object-store:9000"
+
+func TestUnitCouchbaseBackupInDocker(t *testing.T) {
+	t.Parallel()
+
+	// Note that these ports are different from the ones in the other Docker tests, as all these tests run in parallel.
+	// cbbackupmgr only ships with the enterprise edition.
+	t.Run("TestUnitCouchbaseEnterpriseBackupAndRestoreUbuntu16InDocker", func(t *testing.T) {
+		t.Parallel()
+		skipInCircleCi(t)
+		testBackupAndRestoreInDocker(t, "ubuntu", "enterprise", 1691, 1684, CouchbaseAlternatePorts{Memcached: 1611, WebConsoleNode1: 1692, MemcachedNode1: 1612})
+	})
+}
+
+// Seed a bucket in the couchbase-cluster-simple example, take a full backup and an incremental backup with
+// couchbase-backup into a MinIO server standing in for S3, destroy the bucket, restore the latest backup, and check
+// that every document came back
+func testBackupAndRestoreInDocker(t *testing.T, osName string, edition string, couchbaseWebConsolePort int, syncGatewayWebConsolePort int, alternatePorts CouchbaseAlternatePorts) {
+	example := setupExampleInDocker(t, dockerExampleSettings{
+		Example:         "couchbase-cluster-simple",
+		Os:              osName,
+		Edition:         edition,
+		WebConsolePort:  couchbaseWebConsolePort,
+		SyncGatewayPort: syncGatewayWebConsolePort,
+		AlternatePorts:  &alternatePorts,
+		EnvVars: map[string]string{
+			// Add the MinIO server to the example
+			"COMPOSE_FILE":            "docker-compose.yml:docker-compose.object-store.yml",
+			"OBJECT_STORE_BUCKET":     objectStoreBucket,
+			"OBJECT_STORE_ACCESS_KEY": fmt.Sprintf("access-%s", random.UniqueId()),
+			"OBJECT_STORE_SECRET_KEY": fmt.Sprintf("secret-%s", random.UniqueId()),
+		},
+	})
+
+	runTestStage(t, "validation", func() {
+		dataNodesUrl := example.DataNodesUrl
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrl, 2)
+
+		bucketName := "test-bucket"
+		backupNode := fmt.Sprintf("%s-0", example.ContainerBaseName)
+
+		// Use a different expiry and flags for each document, so we can check that the backup keeps them
+		firstBatch := backupTestDocuments(example.UniqueId, "first", 5)
+		secondBatch := backupTestDocuments(example.UniqueId, "second", 5)
+
+		for _, document := range firstBatch {
+			writeDocumentToBucket(t, dataNodesUrl, bucketName, document)
+		}
+		runCouchbaseBackupInDocker(t, backupNode, example.EnvVars, example.Credentials, "backup", "--full")
+
+		for _, document := range secondBatch {
+			writeDocumentToBucket(t, dataNodesUrl, bucketName, document)
+		}
+		runCouchbaseBackupInDocker(t, backupNode, example.EnvVars, example.Credentials, "backup")
+
+		deleteBucket(t, dataNodesUrl, example.Credentials, bucketName)
+		createBucket(t, dataNodesUrl, bucketName)
+		checkDocumentNotInBucket(t, dataNodesUrl, bucketName, firstBatch[0].Key)
+
+		runCouchbaseBackupInDocker(t, backupNode, example.EnvVars, example.Credentials, "restore", "--backup", "latest")
+
+		for _, expected := range append(firstBatch, secondBatch...) {
+			var actual map[string]interface{}
+			meta := readDocumentFromBucket(t, dataNodesUrl, bucketName, expected.Key, &actual)
+
+			assert.Equal(t, expected.Value, actual, "Value of %s", expected.Key)
+			assert.Equal(t, expected.Flags, meta.Flags, "Flags of %s", expected.Key)
+			assert.NotZero(t, meta.Expiration, "Expected %s to keep its expiration", expected.Key)
+		}
+	})
+}
+
+func backupTestDocuments(uniqueId string, batch string, count int) []TestDocument {
+	documents := []TestDocument{}
+	for i := 0; i < count; i++ {
+		documents = append(documents, TestDocument{
+			Key:    fmt.Sprintf("backup-%s-%s-%d", uniqueId, batch, i),
+			Value:  map[string]interface{}{"batch": batch, "index": float64(i)},
+			Expiry: 3600 + i,
+			Flags:  0x02000000 + i,
+		})
+	}
+	return documents
+}
+
+// Run couchbase-backup with the given args in the given container, storing backups in the MinIO server
+func runCouchbaseBackupInDocker(t *testing.T, container string, envVars map[string]string, credentials CouchbaseCredentials, args ...string) {
+	commonArgs := []string{
+		"--s3-url", fmt.Sprintf("s3://%s/%s", objectStoreBucket, envVars["CONTAINER_BASE_NAME"]),
+		"--s3-endpoint", objectStoreEndpoint,
+		"--aws-region", "us-east-1",
+		"--archive", "/couchbase-data/backups",
+		"--username", credentials.Username,
+		"--password", credentials.Password,
+	}
+
+	dockerArgs := []string{
+		"exec",
+		"-e", fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", envVars["OBJECT_STORE_ACCESS_KEY"]),
+		"-e", fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", envVars["OBJECT_STORE_SECRET_KEY"]),
+		container,
+		"/opt/couchbase-commons/bin/couchbase-backup",
+	}
+	dockerArgs = append(dockerArgs, args...)
+	dockerArgs = append(dockerArgs, commonArgs...)
+
+	shell.RunCommand(t, shell.Command{Command: "docker", Args: dockerArgs})
+}
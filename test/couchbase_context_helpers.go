@@ -0,0 +1,396 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/timeline"
+	"github.com/gruntwork-io/terratest/modules/logger"
+)
+
+// The helpers that talk to a cluster over the network, including the ones that run the tools in cmd against it, such
+// as checkLoadGeneratorWorking, come in pairs: an E variant, such as createBucketE, that takes a context and returns
+// one of the error types at the bottom of this file, and a variant with the original name, such as createBucket, that
+// takes a *testing.T and fails the test on error. The E variants never call t.Fatal, so you can run them in goroutines
+// (e.g., via runInParallelE) or outside of tests altogether. The helpers that drive Terraform, Packer, Docker, or the
+// AWS APIs via Terratest, such as checkPortsAreListeningInDocker, checkNodeCertificatesInAsg, and the
+// collectDiagnostics helpers, only take a *testing.T, as do HttpPostForm and HttpDoWithBasicAuth, which return the
+// error of the request for the test to check.
+
+type contextKey int
+
+const (
+	testNameContextKey contextKey = iota
+	logfContextKey
+)
+
+// Return the context to pass to the E variants of the helpers when calling them from the given test. The helpers log
+// via the terratest logger of the test and record their polls in the test timeline under the name of the test.
+func testContext(t *testing.T) context.Context {
+	ctx := context.WithValue(context.Background(), testNameContextKey, t.Name())
+	return context.WithValue(ctx, logfContextKey, func(format string, args ...interface{}) {
+		// Skip this function and logf, so the log line points to the helper that called logf
+		logger.DoLog(t, 3, os.Stdout, fmt.Sprintf(format, args...))
+	})
+}
+
+// Return the name of the test the given context is for, or an empty string if it's not for a test
+func testNameFromContext(ctx context.Context) string {
+	testName, _ := ctx.Value(testNameContextKey).(string)
+	return testName
+}
+
+// Log via the logger of the test the given context is for, or via the standard logger if it's not for a test
+func logf(ctx context.Context, format string, args ...interface{}) {
+	if ctxLogf, ok := ctx.Value(logfContextKey).(func(string, ...interface{})); ok {
+		ctxLogf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// Sleep for the given duration, or until the given context is done, in which case return the error of the context
+func sleepE(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Run action until it succeeds, at most maxRetries + 1 times, sleeping sleepBetweenRetries between attempts, and return
+// its output. Stop early if the given context is done. If the context is for a test, also record how long the poll
+// took, how many times it retried, and its final error in the timeline. Returns a TimeoutErr if action never succeeds.
+func pollE(ctx context.Context, description string, maxRetries int, sleepBetweenRetries time.Duration, action func() (string, error)) (string, error) {
+	start := time.Now()
+	out, attempts, err := retryE(ctx, description, maxRetries, sleepBetweenRetries, action)
+
+	if testName := testNameFromContext(ctx); testName != "" {
+		event := timeline.Event{
+			Test:            testName,
+			Kind:            timeline.KindPoll,
+			Name:            description,
+			Start:           start,
+			DurationSeconds: time.Since(start).Seconds(),
+		}
+		if attempts > 0 {
+			event.Retries = attempts - 1
+		}
+		if err != nil {
+			event.Failed = true
+			event.Error = err.Error()
+		}
+		testTimeline.Record(event)
+	}
+
+	return out, err
+}
+
+func retryE(ctx context.Context, description string, maxRetries int, sleepBetweenRetries time.Duration, action func() (string, error)) (string, int, error) {
+	var lastErr error
+
+	for attempts := 0; attempts <= maxRetries; attempts++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if lastErr == nil {
+				lastErr = ctxErr
+			}
+			return "", attempts, TimeoutErr{Description: description, Attempts: attempts, LastErr: lastErr}
+		}
+
+		logf(ctx, description)
+
+		out, err := action()
+		if err == nil {
+			return out, attempts + 1, nil
+		}
+		lastErr = err
+
+		if attempts < maxRetries {
+			logf(ctx, "%s returned an error: %s. Sleeping for %s and will try again.", description, err, sleepBetweenRetries)
+			if err := sleepE(ctx, sleepBetweenRetries); err != nil {
+				return "", attempts + 1, TimeoutErr{Description: description, Attempts: attempts + 1, LastErr: lastErr}
+			}
+		}
+	}
+
+	return "", maxRetries + 1, TimeoutErr{Description: description, Attempts: maxRetries + 1, LastErr: lastErr}
+}
+
+// Run the given actions concurrently and wait for all of them to finish. If any of them fails, cancel the context
+// passed to the others and return the first error.
+func runInParallelE(ctx context.Context, actions ...func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for _, action := range actions {
+		wg.Add(1)
+		go func(action func(ctx context.Context) error) {
+			defer wg.Done()
+			if err := action(ctx); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(action)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// Make an HTTP request with the given method to the given URL and return the status code and body of the response. If
+// tlsConfig is not nil, use it for HTTPS connections. If credentials is not nil, authenticate with them via HTTP basic
+// auth. If params is not nil, send it as a URL-encoded form body. The request is cancelled if the context is done.
+func httpDoE(ctx context.Context, method string, reqUrl string, tlsConfig *tls.Config, credentials *CouchbaseCredentials, params url.Values) (int, string, error) {
+	if params == nil {
+		return httpDoWithBodyE(ctx, method, reqUrl, tlsConfig, credentials, "", "")
+	}
+	return httpDoWithBodyE(ctx, method, reqUrl, tlsConfig, credentials, "application/x-www-form-urlencoded", params.Encode())
+}
+
+// Like httpDoE, but send the given body with the given content type. If contentType is empty, send no body.
+func httpDoWithBodyE(ctx context.Context, method string, reqUrl string, tlsConfig *tls.Config, credentials *CouchbaseCredentials, contentType string, body string) (int, string, error) {
+	if credentials != nil {
+		logf(ctx, "Making an HTTP %s call to URL %s as user %s", method, reqUrl, credentials.Username)
+	} else {
+		logf(ctx, "Making an HTTP %s call to URL %s", method, reqUrl)
+	}
+
+	client := http.Client{
+		// By default, Go does not impose a timeout, so an HTTP connection attempt can hang for a LONG time.
+		Timeout: 10 * time.Second,
+	}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	var reqBody io.Reader
+	if contentType != "" {
+		reqBody = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, reqBody)
+	if err != nil {
+		return -1, "", err
+	}
+
+	if credentials != nil {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1, "", err
+	}
+
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return -1, "", err
+	}
+
+	return resp.StatusCode, strings.TrimSpace(string(respBody)), nil
+}
+
+// Make an HTTP GET request to the given URL and return the status code and body of the response
+func httpGetE(ctx context.Context, url string, tlsConfig *tls.Config) (int, string, error) {
+	return httpDoE(ctx, "GET", url, tlsConfig, nil, nil)
+}
+
+// Make HTTP GET requests to the given URL until validateResponse returns nil for the status code and body of the
+// response, and return the body
+func httpGetWithRetryE(ctx context.Context, url string, tlsConfig *tls.Config, maxRetries int, sleepBetweenRetries time.Duration, validateResponse func(int, string) error) (string, error) {
+	return pollE(ctx, fmt.Sprintf("HTTP GET to URL %s", url), maxRetries, sleepBetweenRetries, func() (string, error) {
+		statusCode, body, err := httpGetE(ctx, url, tlsConfig)
+		if err != nil {
+			return "", err
+		}
+		if err := validateResponse(statusCode, body); err != nil {
+			return "", err
+		}
+		return body, nil
+	})
+}
+
+// Run the given command in the given working directory, or the current one if workingDir is empty, and return its
+// stdout. Log its stderr, which is where the tools in cmd write their progress. The command is killed if the context is
+// done. Returns a CommandErr if the command fails.
+func runCommandE(ctx context.Context, workingDir string, command string, args ...string) (string, error) {
+	logf(ctx, "Running command %s", command)
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = workingDir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		logf(ctx, "%s", stderr.String())
+	}
+	if err != nil {
+		return stdout.String(), CommandErr{Command: command, Stderr: stderr.String(), Underlying: err}
+	}
+
+	return stdout.String(), nil
+}
+
+// Custom error types
+
+// Returned when a poll gives up, either because it ran out of retries or because its context was done. LastErr is the
+// error of the last attempt, or the error of the context if the poll never got to make an attempt.
+type TimeoutErr struct {
+	Description string
+	Attempts    int
+	LastErr     error
+}
+
+func (err TimeoutErr) Error() string {
+	return fmt.Sprintf("'%s' unsuccessful after %d attempts. Last error: %v", err.Description, err.Attempts, err.LastErr)
+}
+
+func (err TimeoutErr) Unwrap() error {
+	return err.LastErr
+}
+
+// Returned when an HTTP request fails before we get a response, e.g., because the connection is refused
+type HttpRequestErr struct {
+	Url        string
+	Underlying error
+}
+
+func (err HttpRequestErr) Error() string {
+	return fmt.Sprintf("Failed to call %s: %v", err.Url, err.Underlying)
+}
+
+func (err HttpRequestErr) Unwrap() error {
+	return err.Underlying
+}
+
+type HttpStatusErr struct {
+	Url      string
+	Expected int
+	Actual   int
+	Body     string
+}
+
+func (err HttpStatusErr) Error() string {
+	return fmt.Sprintf("Expected status code %d from %s, but got %d. Response body: %s", err.Expected, err.Url, err.Actual, err.Body)
+}
+
+type ParseErr struct {
+	Url        string
+	Body       string
+	Underlying error
+}
+
+func (err ParseErr) Error() string {
+	return fmt.Sprintf("Failed to parse response from %s due to error %v. Response body:\n%s", err.Url, err.Underlying, err.Body)
+}
+
+func (err ParseErr) Unwrap() error {
+	return err.Underlying
+}
+
+type RebalanceInProgressErr struct {
+	Url string
+}
+
+func (err RebalanceInProgressErr) Error() string {
+	return fmt.Sprintf("Cluster is currently rebalancing, so %s cannot process the request right now", err.Url)
+}
+
+// Returned when a response parses fine, but says something other than what we expected, e.g., that a node is not
+// healthy yet
+type UnexpectedResponseErr struct {
+	Url    string
+	Reason string
+	Body   string
+}
+
+func (err UnexpectedResponseErr) Error() string {
+	return fmt.Sprintf("Unexpected response from %s: %s. Response body:\n%s", err.Url, err.Reason, err.Body)
+}
+
+// Returned when a check finds a value, such as a setting or a document, that differs from the one we expected
+type UnexpectedValueErr struct {
+	Description string
+	Expected    interface{}
+	Actual      interface{}
+}
+
+func (err UnexpectedValueErr) Error() string {
+	return fmt.Sprintf("Unexpected %s. Expected: %+v. Actual: %+v", err.Description, err.Expected, err.Actual)
+}
+
+// Returned when a certificate, either the CA certificate we check against or one a server serves, is missing or
+// invalid. Source says where the certificate came from, e.g., the address of the server.
+type CertificateErr struct {
+	Source     string
+	Reason     string
+	Underlying error
+}
+
+func (err CertificateErr) Error() string {
+	if err.Underlying != nil {
+		return fmt.Sprintf("Invalid certificate from %s: %s: %v", err.Source, err.Reason, err.Underlying)
+	}
+	return fmt.Sprintf("Invalid certificate from %s: %s", err.Source, err.Reason)
+}
+
+func (err CertificateErr) Unwrap() error {
+	return err.Underlying
+}
+
+// Returned when the vBucket map of a bucket doesn't place the copies of its vBuckets the way we expected
+type VBucketMapErr struct {
+	Bucket string
+	Reason string
+}
+
+func (err VBucketMapErr) Error() string {
+	return fmt.Sprintf("Unexpected vBucket map of bucket %s: %s", err.Bucket, err.Reason)
+}
+
+// Returned when a command, such as one of the tools in cmd, can't be started or exits with an error. We leave out the
+// arguments of the command, as they may include a password.
+type CommandErr struct {
+	Command    string
+	Stderr     string
+	Underlying error
+}
+
+func (err CommandErr) Error() string {
+	return fmt.Sprintf("Command %s failed: %v. Stderr:\n%s", err.Command, err.Underlying, err.Stderr)
+}
+
+func (err CommandErr) Unwrap() error {
+	return err.Underlying
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitCouchbaseDeltaRecoveryInDocker(t *testing.T) {
+	t.Parallel()
+
+	// Note that these ports are different from the ones in the other Docker tests, as all these tests run in parallel.
+	// Delta recovery is only available in the Enterprise Edition.
+	t.Run("TestUnitCouchbaseDeltaRecoveryUbuntu16InDocker", func(t *testing.T) {
+		t.Parallel()
+		skipInCircleCi(t)
+		testCouchbaseDeltaRecoveryInDocker(t, "ubuntu", "enterprise", 2591, 2684, CouchbaseAlternatePorts{Memcached: 2710, WebConsoleNode1: 2691, MemcachedNode1: 2810})
+	})
+}
+
+// Stop the couchbase-1 container of the couchbase-cluster-simple example, fail it over, write more data, and start it
+// again, and check that run-couchbase-server adds the node back into the cluster with delta recovery
+func testCouchbaseDeltaRecoveryInDocker(t *testing.T, osName string, edition string, couchbaseWebConsolePort int, syncGatewayWebConsolePort int, alternatePorts CouchbaseAlternatePorts) {
+	example := setupExampleInDocker(t, dockerExampleSettings{
+		Example:         "couchbase-cluster-simple",
+		Os:              osName,
+		Edition:         edition,
+		WebConsolePort:  couchbaseWebConsolePort,
+		SyncGatewayPort: syncGatewayWebConsolePort,
+		AlternatePorts:  &alternatePorts,
+	})
+
+	node1ContainerName := fmt.Sprintf("%s-1", example.ContainerBaseName)
+	dataNodesUrl := example.DataNodesUrl
+	dataBeforeFailover := TestData{Foo: "before-failover", Bar: 42}
+	dataAfterFailover := TestData{Foo: "after-failover", Bar: 43}
+
+	var node1OtpNode string
+
+	runTestStage(t, "validate_cluster", func() {
+		checkCouchbaseConsoleIsRunning(t, example.ConsoleUrl)
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrl, 2)
+		writeToBucket(t, dataNodesUrl, "test-bucket", "before-failover", dataBeforeFailover)
+
+		node1OtpNode = getOtpNodeForIp(t, dataNodesUrl, getDockerContainerIp(t, node1ContainerName))
+	})
+
+	var failoverTimestamp int64
+
+	runTestStage(t, "fail_over_node", func() {
+		example.compose(t, "stop", "couchbase-1")
+
+		failoverTimestamp = time.Now().UnixNano() / int64(time.Millisecond)
+		failOverNode(t, dataNodesUrl, node1OtpNode)
+
+		// Write more data while the node is out of the cluster, so delta recovery has mutations to catch up on
+		writeToBucket(t, dataNodesUrl, "test-bucket", "after-failover", dataAfterFailover)
+	})
+
+	runTestStage(t, "recover_node", func() {
+		example.compose(t, "start", "couchbase-1")
+	})
+
+	runTestStage(t, "validate_recovery", func() {
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrl, 2)
+		checkLastRebalanceUsedDeltaRecovery(t, dataNodesUrl, node1OtpNode, failoverTimestamp)
+
+		assert.Equal(t, dataBeforeFailover, readFromBucket(t, dataNodesUrl, "test-bucket", "before-failover"))
+		assert.Equal(t, dataAfterFailover, readFromBucket(t, dataNodesUrl, "test-bucket", "after-failover"))
+	})
+}
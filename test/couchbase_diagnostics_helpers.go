@@ -0,0 +1,297 @@
+package test
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/diagnostics"
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/gruntwork-io/terratest/modules/ssh"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+)
+
+var (
+	diagnosticsDirFlag       = flag.String("diagnostics.dir", "test-diagnostics", "When a test fails, write the diagnostics collected from its nodes to a folder named after the test in this folder")
+	diagnosticsCbcollectFlag = flag.Bool("diagnostics.cbcollect", true, "When a test fails, run cbcollect_info on each Couchbase node, which can take several minutes per node")
+)
+
+// How many findings to print in the summary of the diagnostics. The summary file in the bundle has all of them.
+const maxDiagnosticsFindingsToLog = 10
+
+// A file to collect from each node, by running a command on the node as root. If base64Encoded is true, the command
+// writes the contents of the file as base64, so binary files survive being passed around as strings.
+type diagnosticsCommand struct {
+	file          string
+	command       string
+	base64Encoded bool
+}
+
+// The services we collect diagnostics for. run-couchbase-server and run-sync-gateway enable the systemd unit of the
+// service they run, and every image has both installed, so enabled units tell us which services a node runs.
+const (
+	couchbaseServerUnit = "couchbase-server"
+	syncGatewayUnit     = "sync_gateway"
+)
+
+func couchbaseDiagnosticsCommands(credentials CouchbaseCredentials) []diagnosticsCommand {
+	commands := []diagnosticsCommand{
+		{diagnostics.PoolsNodesFile, couchbaseRestCommand(credentials, "/pools/nodes"), false},
+		{diagnostics.TasksFile, couchbaseRestCommand(credentials, "/pools/default/tasks"), false},
+		{diagnostics.RemoteClusterFile, couchbaseRestCommand(credentials, "/pools/default/remoteClusters"), false},
+		{"couchbase-error.log", "tail -n 2000 /opt/couchbase/var/lib/couchbase/logs/error.log", false},
+		{"couchbase-info.log", "tail -n 2000 /opt/couchbase/var/lib/couchbase/logs/info.log", false},
+		{"couchbase-logs.tar.gz", "tar czf - -C /opt/couchbase/var/lib/couchbase logs | base64 -w0", true},
+	}
+
+	if *diagnosticsCbcollectFlag {
+		commands = append(commands, diagnosticsCommand{"cbcollect_info.zip", "/opt/couchbase/bin/cbcollect_info /tmp/cbcollect_info.zip > /dev/null && base64 -w0 /tmp/cbcollect_info.zip && rm -f /tmp/cbcollect_info.zip", true})
+	}
+
+	return commands
+}
+
+var syncGatewayDiagnosticsCommands = []diagnosticsCommand{
+	{"sync-gateway-error.log", "tail -n 2000 /home/sync_gateway/logs/sync_gateway_error.log", false},
+	{"sync-gateway-logs.tar.gz", "tar czf - -C /home/sync_gateway logs | base64 -w0", true},
+}
+
+var nodeDiagnosticsCommands = []diagnosticsCommand{
+	{"dmesg.txt", "dmesg | tail -n 500", false},
+}
+
+// Return a command that calls the given path of the Couchbase REST API on the node itself, over HTTPS if HTTP is
+// disabled, e.g. because the cluster only allows TLS
+func couchbaseRestCommand(credentials CouchbaseCredentials, path string) string {
+	user := shellQuote(fmt.Sprintf("%s:%s", credentials.Username, credentials.Password))
+	return fmt.Sprintf("curl --silent --show-error --fail --user %s http://localhost:8091%s || curl --silent --show-error --fail --insecure --user %s https://localhost:18091%s", user, path, user, path)
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
+
+// A node to collect diagnostics from, which runs commands as root
+type diagnosticsNode struct {
+	name string
+	run  func(command string) (string, error)
+	// Files we already have for the node, such as its syslog
+	files map[string]string
+}
+
+// Collect diagnostics from all the containers with the given base name, if the test failed
+func collectDiagnosticsInDockerOnFailure(t *testing.T, containerBaseName string, credentials CouchbaseCredentials) {
+	if !t.Failed() {
+		return
+	}
+
+	out, err := shell.RunCommandAndGetOutputE(t, shell.Command{
+		Command: "docker",
+		Args:    []string{"ps", "--all", "--filter", fmt.Sprintf("name=%s", containerBaseName), "--format", "{{.Names}}"},
+	})
+	if err != nil {
+		logger.Logf(t, "Failed to list the containers to collect diagnostics from: %v", err)
+		return
+	}
+
+	nodes := []diagnosticsNode{}
+	for _, containerName := range strings.Fields(out) {
+		containerName := containerName
+
+		files := map[string]string{}
+		if dockerLogs, err := shell.RunCommandAndGetOutputE(t, shell.Command{Command: "docker", Args: []string{"logs", containerName}, Logger: logger.Discard}); err == nil {
+			files["docker.log"] = dockerLogs
+		}
+
+		nodes = append(nodes, diagnosticsNode{
+			name: containerName,
+			run: func(command string) (string, error) {
+				return shell.RunCommandAndGetStdOutE(t, shell.Command{Command: "docker", Args: []string{"exec", containerName, "bash", "-c", command}, Logger: logger.Discard})
+			},
+			files: files,
+		})
+	}
+
+	collectDiagnostics(t, nodes, credentials)
+}
+
+// Collect diagnostics over SSH from all the EC2 Instances in the ASGs of the given clusters, if the test failed
+func collectDiagnosticsFromAsgsOnFailure(t *testing.T, terraformOptions *terraform.Options, awsRegion string, keyPair *aws.Ec2Keypair, osName string, clusterVarNames ...string) {
+	if !t.Failed() {
+		return
+	}
+
+	credentials := getCredentials(t, terraformOptions)
+
+	nodes := []diagnosticsNode{}
+	for _, clusterVarName := range clusterVarNames {
+		clusterName := getClusterName(t, clusterVarName, terraformOptions)
+
+		instanceIds, err := aws.GetInstanceIdsForAsgE(t, clusterName, awsRegion)
+		if err != nil {
+			logger.Logf(t, "Failed to look up the instances of ASG %s to collect diagnostics from: %v", clusterName, err)
+			continue
+		}
+
+		publicIps, err := aws.GetPublicIpsOfEc2InstancesE(t, instanceIds, awsRegion)
+		if err != nil {
+			logger.Logf(t, "Failed to look up the public IPs of the instances of ASG %s to collect diagnostics from: %v", clusterName, err)
+			continue
+		}
+
+		for _, instanceId := range instanceIds {
+			instanceId := instanceId
+			publicIp := publicIps[instanceId]
+
+			files := map[string]string{}
+			if syslog, err := aws.GetSyslogForInstanceE(t, instanceId, awsRegion); err == nil {
+				files["syslog.txt"] = syslog
+			}
+
+			nodes = append(nodes, diagnosticsNode{
+				name: fmt.Sprintf("%s-%s", clusterName, instanceId),
+				run: func(command string) (string, error) {
+					if keyPair == nil {
+						return "", fmt.Errorf("no EC2 Key Pair to SSH to instance %s with", instanceId)
+					}
+					host := ssh.Host{Hostname: publicIp, SshUserName: sshUserForOs(osName), SshKeyPair: keyPair.KeyPair}
+					return ssh.CheckSshCommandE(t, host, fmt.Sprintf("sudo bash -c %s", shellQuote(command)))
+				},
+				files: files,
+			})
+		}
+	}
+
+	collectDiagnostics(t, nodes, credentials)
+}
+
+// The user to SSH in as on the AMIs the couchbase-ami example builds for the given OS
+func sshUserForOs(osName string) string {
+	if osName == "amazon-linux" {
+		return "ec2-user"
+	}
+	return "ubuntu"
+}
+
+// Collect diagnostics from the given nodes in parallel into the bundle of this test, and log a summary of the most
+// likely causes of the failure. Failing to collect diagnostics does not fail the test, which already failed.
+func collectDiagnostics(t *testing.T, nodes []diagnosticsNode, credentials CouchbaseCredentials) {
+	bundle, err := diagnostics.NewBundle(diagnostics.DirForTest(*diagnosticsDirFlag, t.Name()))
+	if err != nil {
+		logger.Logf(t, "Failed to create the folder for the diagnostics: %v", err)
+		return
+	}
+
+	logger.Logf(t, "Collecting diagnostics from %d nodes into %s", len(nodes), bundle.Dir)
+
+	var waitForNodes sync.WaitGroup
+	for _, node := range nodes {
+		waitForNodes.Add(1)
+		go func(node diagnosticsNode) {
+			defer waitForNodes.Done()
+			collectDiagnosticsFromNode(t, bundle, node, credentials)
+		}(node)
+	}
+	waitForNodes.Wait()
+
+	files, err := bundle.Files()
+	if err != nil {
+		logger.Logf(t, "Failed to read back the diagnostics in %s: %v", bundle.Dir, err)
+		return
+	}
+
+	findings := diagnostics.Analyze(files)
+	if err := writeDiagnosticsSummary(bundle, findings); err != nil {
+		logger.Logf(t, "Failed to write the summary of the diagnostics: %v", err)
+	}
+
+	logger.Logf(t, "\n\n%s", diagnostics.FormatSummary(bundle.Dir, findings, maxDiagnosticsFindingsToLog))
+}
+
+func collectDiagnosticsFromNode(t *testing.T, bundle *diagnostics.Bundle, node diagnosticsNode, credentials CouchbaseCredentials) {
+	writeDiagnosticsFile := func(name string, contents []byte) {
+		if err := bundle.Write(node.name, name, contents); err != nil {
+			logger.Logf(t, "Failed to write diagnostics file %s of node %s: %v", name, node.name, err)
+		}
+	}
+
+	for name, contents := range node.files {
+		writeDiagnosticsFile(name, []byte(contents))
+	}
+
+	commands := append([]diagnosticsCommand{}, nodeDiagnosticsCommands...)
+
+	units, _ := node.run(fmt.Sprintf("for unit in %s %s; do systemctl is-enabled --quiet $unit && echo $unit; done; true", couchbaseServerUnit, syncGatewayUnit))
+	writeDiagnosticsFile("services.txt", []byte(units))
+
+	if strings.Contains(units, couchbaseServerUnit) {
+		commands = append(commands, couchbaseDiagnosticsCommands(credentials)...)
+	}
+	if strings.Contains(units, syncGatewayUnit) {
+		commands = append(commands, syncGatewayDiagnosticsCommands...)
+	}
+
+	for _, command := range commands {
+		out, err := node.run(command.command)
+
+		contents := []byte(out)
+		if err == nil && command.base64Encoded {
+			contents, err = base64.StdEncoding.DecodeString(strings.TrimSpace(out))
+		}
+
+		if err != nil {
+			if writeErr := bundle.WriteError(node.name, command.file, err, out); writeErr != nil {
+				logger.Logf(t, "Failed to write diagnostics file %s of node %s: %v", command.file, node.name, writeErr)
+			}
+			continue
+		}
+
+		writeDiagnosticsFile(command.file, contents)
+	}
+}
+
+func writeDiagnosticsSummary(bundle *diagnostics.Bundle, findings []diagnostics.Finding) error {
+	summary := diagnostics.FormatSummary(bundle.Dir, findings, len(findings))
+	return bundle.WriteSummary(summary)
+}
+
+// The names under which we save the EC2 Key Pairs the tests use to SSH to the nodes to collect diagnostics. Tests that
+// deploy to two regions need a Key Pair per region.
+const (
+	savedKeyPair        = "KeyPair"
+	savedKeyPairPrimary = "KeyPairPrimary"
+	savedKeyPairReplica = "KeyPairReplica"
+)
+
+// Create an EC2 Key Pair in the given region to SSH to the nodes with if the test fails, and save it under the given
+// name in the given test folder
+func createDiagnosticsKeyPair(t *testing.T, testFolder string, name string, awsRegion string, uniqueId string) *aws.Ec2Keypair {
+	keyPair := aws.CreateAndImportEC2KeyPair(t, awsRegion, fmt.Sprintf("couchbase-%s", uniqueId))
+	test_structure.SaveTestData(t, test_structure.FormatTestDataPath(testFolder, name+".json"), keyPair)
+	return keyPair
+}
+
+// Load the EC2 Key Pair saved under the given name in the given test folder, or return nil if there is none, e.g.
+// because the test failed before it deployed anything
+func loadDiagnosticsKeyPair(t *testing.T, testFolder string, name string) *aws.Ec2Keypair {
+	path := test_structure.FormatTestDataPath(testFolder, name+".json")
+	if !test_structure.IsTestDataPresent(t, path) {
+		return nil
+	}
+
+	var keyPair aws.Ec2Keypair
+	test_structure.LoadTestData(t, path, &keyPair)
+	return &keyPair
+}
+
+func deleteDiagnosticsKeyPair(t *testing.T, testFolder string, name string) {
+	if keyPair := loadDiagnosticsKeyPair(t, testFolder, name); keyPair != nil {
+		aws.DeleteEC2KeyPair(t, keyPair)
+	}
+}
@@ -0,0 +1,311 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The TLS ports Couchbase serves the Query service and the Data Service (KV) on
+const couchbaseSslQueryPort = 18093
+const couchbaseSslMemcachedPort = 11207
+
+// The non TLS ports clients use for the REST API / Web Console, Views and XDCR, the Query service, and the Data Service
+var couchbaseNonSslClientPorts = []int{8091, 8092, 8093, 11210}
+
+// The TLS ports of a single Couchbase node, as seen from the tests. In Docker, these are the ports on the host that
+// Docker Compose maps to the TLS ports of the container.
+type CouchbaseTlsPorts struct {
+	Rest      int
+	Query     int
+	Memcached int
+}
+
+// Return the env vars to pass to Docker Compose so it maps the given TLS ports, along with the given port for the TLS
+// port of Views and XDCR
+func (ports CouchbaseTlsPorts) envVars(capiTlsPort int) map[string]string {
+	return map[string]string{
+		"WEB_CONSOLE_TLS_PORT": strconv.Itoa(ports.Rest),
+		"CAPI_TLS_PORT":        strconv.Itoa(capiTlsPort),
+		"QUERY_TLS_PORT":       strconv.Itoa(ports.Query),
+		"MEMCACHED_TLS_PORT":   strconv.Itoa(ports.Memcached),
+	}
+}
+
+var defaultCouchbaseTlsPorts = CouchbaseTlsPorts{
+	Rest:      couchbaseSslRestPort,
+	Query:     couchbaseSslQueryPort,
+	Memcached: couchbaseSslMemcachedPort,
+}
+
+// The memcached binary protocol opcode for SASL_LIST_MECHS, which the Data Service answers before a client has
+// authenticated: https://github.com/couchbase/kv_engine/blob/master/docs/BinaryProtocol.md
+const memcachedMagicRequest = 0x80
+const memcachedMagicResponse = 0x81
+const memcachedOpcodeSaslListMechs = 0x20
+const memcachedHeaderLen = 24
+
+// Check that each of the given ports on the given host refuses connections or does not answer at all, as is the case
+// when a Security Group does not allow connections to that port
+func checkPortsRefuseConnections(t *testing.T, host string, ports []int) {
+	if err := checkPortsRefuseConnectionsE(testContext(t), host, ports); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkPortsRefuseConnectionsE(ctx context.Context, host string, ports []int) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	accepted := []string{}
+
+	for _, port := range ports {
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			conn.Close()
+			accepted = append(accepted, address)
+			continue
+		}
+
+		logf(ctx, "As expected, %s refused the connection: %v", address, err)
+	}
+
+	if len(accepted) > 0 {
+		return UnexpectedValueErr{Description: fmt.Sprintf("ports on %s that accept connections", host), Expected: "none", Actual: accepted}
+	}
+	return nil
+}
+
+// Check that the given host serves admin (REST API), query, and KV (Data Service) traffic over TLS on the given ports.
+// If caCertPem is not empty, also check that every port serves a certificate signed by that CA.
+func checkTlsPortsServeTraffic(t *testing.T, host string, ports CouchbaseTlsPorts, caCertPem []byte, credentials CouchbaseCredentials) {
+	if err := checkTlsPortsServeTrafficE(testContext(t), host, ports, caCertPem, credentials); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTlsPortsServeTrafficE(ctx context.Context, host string, ports CouchbaseTlsPorts, caCertPem []byte, credentials CouchbaseCredentials) error {
+	tlsConfig, err := newTlsConfigForCaE(caCertPem)
+	if err != nil {
+		return err
+	}
+
+	maxRetries := 30
+	sleepBetweenRetries := 5 * time.Second
+
+	restUrl := fmt.Sprintf("https://%s:%d/pools/default", host, ports.Rest)
+	_, err = pollE(ctx, fmt.Sprintf("Checking admin traffic over TLS to %s", restUrl), maxRetries, sleepBetweenRetries, func() (string, error) {
+		statusCode, body, err := httpDoE(ctx, "GET", restUrl, tlsConfig, &credentials, nil)
+		if err != nil {
+			return "", err
+		}
+		if statusCode != 200 {
+			return "", HttpStatusErr{Url: restUrl, Expected: 200, Actual: statusCode, Body: body}
+		}
+		return "", nil
+	})
+	if err != nil {
+		return err
+	}
+
+	queryUrl := fmt.Sprintf("https://%s:%d/query/service", host, ports.Query)
+	_, err = pollE(ctx, fmt.Sprintf("Checking query traffic over TLS to %s", queryUrl), maxRetries, sleepBetweenRetries, func() (string, error) {
+		statusCode, body, err := httpDoE(ctx, "POST", queryUrl, tlsConfig, &credentials, url.Values{"statement": []string{"SELECT 1"}})
+		if err != nil {
+			return "", err
+		}
+		if statusCode != 200 {
+			return "", HttpStatusErr{Url: queryUrl, Expected: 200, Actual: statusCode, Body: body}
+		}
+
+		var response struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal([]byte(body), &response); err != nil {
+			return "", ParseErr{Url: queryUrl, Body: body, Underlying: err}
+		}
+		if response.Status != "success" {
+			return "", UnexpectedResponseErr{Url: queryUrl, Reason: fmt.Sprintf("expected query status success but got %s", response.Status), Body: body}
+		}
+		return "", nil
+	})
+	if err != nil {
+		return err
+	}
+
+	memcachedAddress := net.JoinHostPort(host, strconv.Itoa(ports.Memcached))
+	_, err = pollE(ctx, fmt.Sprintf("Checking KV traffic over TLS to %s", memcachedAddress), maxRetries, sleepBetweenRetries, func() (string, error) {
+		mechanisms, err := listSaslMechanismsOverTls(tlsConfig, memcachedAddress)
+		if err != nil {
+			return "", err
+		}
+		logf(ctx, "Data Service at %s supports SASL mechanisms %s", memcachedAddress, mechanisms)
+		return "", nil
+	})
+	return err
+}
+
+// Check that every node in the cluster at the given URL has node-to-node encryption enabled and that the cluster uses
+// the given encryption level. If the URL is https, connect with the given TLS config.
+func checkNodeToNodeEncryption(t *testing.T, clusterUrl string, tlsConfig *tls.Config, credentials CouchbaseCredentials, expectedLevel string) {
+	if err := checkNodeToNodeEncryptionE(testContext(t), clusterUrl, tlsConfig, credentials, expectedLevel); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkNodeToNodeEncryptionE(ctx context.Context, clusterUrl string, tlsConfig *tls.Config, credentials CouchbaseCredentials, expectedLevel string) error {
+	securityUrl := fmt.Sprintf("%s/settings/security", clusterUrl)
+	statusCode, body, err := httpDoE(ctx, "GET", securityUrl, tlsConfig, &credentials, nil)
+	if err != nil {
+		return HttpRequestErr{Url: securityUrl, Underlying: err}
+	}
+	if statusCode != 200 {
+		return HttpStatusErr{Url: securityUrl, Expected: 200, Actual: statusCode, Body: body}
+	}
+
+	var securitySettings struct {
+		ClusterEncryptionLevel string `json:"clusterEncryptionLevel"`
+	}
+	if err := json.Unmarshal([]byte(body), &securitySettings); err != nil {
+		return ParseErr{Url: securityUrl, Body: body, Underlying: err}
+	}
+	if securitySettings.ClusterEncryptionLevel != expectedLevel {
+		return UnexpectedValueErr{Description: "cluster encryption level", Expected: expectedLevel, Actual: securitySettings.ClusterEncryptionLevel}
+	}
+
+	nodesUrl := fmt.Sprintf("%s/pools/nodes", clusterUrl)
+	statusCode, body, err = httpDoE(ctx, "GET", nodesUrl, tlsConfig, &credentials, nil)
+	if err != nil {
+		return HttpRequestErr{Url: nodesUrl, Underlying: err}
+	}
+	if statusCode != 200 {
+		return HttpStatusErr{Url: nodesUrl, Expected: 200, Actual: statusCode, Body: body}
+	}
+
+	var pool struct {
+		Nodes []struct {
+			Hostname       string `json:"hostname"`
+			NodeEncryption bool   `json:"nodeEncryption"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal([]byte(body), &pool); err != nil {
+		return ParseErr{Url: nodesUrl, Body: body, Underlying: err}
+	}
+
+	if len(pool.Nodes) == 0 {
+		return UnexpectedResponseErr{Url: nodesUrl, Reason: "expected to find nodes", Body: body}
+	}
+	for _, node := range pool.Nodes {
+		if !node.NodeEncryption {
+			return UnexpectedResponseErr{Url: nodesUrl, Reason: fmt.Sprintf("expected node-to-node encryption to be enabled on node %s", node.Hostname), Body: body}
+		}
+	}
+	return nil
+}
+
+// Create a TLS config as newTlsConfigForCaE does, failing the test if the CA certificate is invalid
+func newTlsConfigForCa(t *testing.T, caCertPem []byte) *tls.Config {
+	tlsConfig, err := newTlsConfigForCaE(caCertPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tlsConfig
+}
+
+// Create a TLS config that checks the server's certificate was signed by the given CA, but not which host it was issued
+// for, as the tests connect via localhost or public IPs, which are not in the node certificates. If caCertPem is empty,
+// the server's certificate is not checked at all.
+func newTlsConfigForCaE(caCertPem []byte) (*tls.Config, error) {
+	// We verify the chain ourselves in VerifyPeerCertificate, without checking the hostname
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+	if len(caCertPem) == 0 {
+		return tlsConfig, nil
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCertPem) {
+		return nil, CertificateErr{Source: "the CA certificate", Reason: "no PEM encoded certificate found"}
+	}
+
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return CertificateErr{Source: "the server", Reason: "it did not serve any certificates"}
+		}
+
+		chain := []*x509.Certificate{}
+		for _, rawCert := range rawCerts {
+			cert, err := x509.ParseCertificate(rawCert)
+			if err != nil {
+				return err
+			}
+			chain = append(chain, cert)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := chain[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+
+	return tlsConfig, nil
+}
+
+// Connect to the Data Service at the given address over TLS and send a SASL_LIST_MECHS request, which is the first thing
+// a Couchbase SDK does when it connects, and return the SASL mechanisms from the response
+func listSaslMechanismsOverTls(tlsConfig *tls.Config, address string) (string, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, memcachedHeaderLen)
+	request[0] = memcachedMagicRequest
+	request[1] = memcachedOpcodeSaslListMechs
+
+	if _, err := conn.Write(request); err != nil {
+		return "", err
+	}
+
+	header := make([]byte, memcachedHeaderLen)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+
+	if header[0] != memcachedMagicResponse || header[1] != memcachedOpcodeSaslListMechs {
+		return "", UnexpectedResponseErr{Url: address, Reason: "expected a SASL_LIST_MECHS response header", Body: fmt.Sprintf("%x", header)}
+	}
+
+	status := binary.BigEndian.Uint16(header[6:8])
+	bodyLen := binary.BigEndian.Uint32(header[8:12])
+
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return "", err
+	}
+
+	if status != 0 {
+		return "", UnexpectedResponseErr{Url: address, Reason: fmt.Sprintf("SASL_LIST_MECHS request failed with status %d", status), Body: string(body)}
+	}
+
+	return string(body), nil
+}
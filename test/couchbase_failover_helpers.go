@@ -0,0 +1,292 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The response from /settings/autoFailover:
+// https://docs.couchbase.com/server/current/rest-api/rest-cluster-autofailover-settings.html. MaxCount,
+// FailoverServerGroup, and FailoverOnDataDiskIssues are only returned by the Enterprise Edition.
+type AutoFailoverSettings struct {
+	Enabled                  bool                     `json:"enabled"`
+	Timeout                  int                      `json:"timeout"`
+	MaxCount                 int                      `json:"maxCount"`
+	FailoverServerGroup      bool                     `json:"failoverServerGroup"`
+	FailoverOnDataDiskIssues FailoverOnDataDiskIssues `json:"failoverOnDataDiskIssues"`
+}
+
+type FailoverOnDataDiskIssues struct {
+	Enabled    bool `json:"enabled"`
+	TimePeriod int  `json:"timePeriod"`
+}
+
+// The response from /settings/autoReprovision:
+// https://docs.couchbase.com/server/current/rest-api/rest-configure-autoreprovision.html
+type AutoReprovisionSettings struct {
+	Enabled  bool `json:"enabled"`
+	MaxNodes int  `json:"max_nodes"`
+}
+
+// A partial representation of the JSON structure returned by the logs API:
+// https://docs.couchbase.com/server/current/rest-api/logs-rest-api.html
+type ClusterLogsResponse struct {
+	List []ClusterLogEntry `json:"list"`
+}
+
+type ClusterLogEntry struct {
+	Node      string `json:"node"`
+	Module    string `json:"module"`
+	Timestamp int64  `json:"tstamp"`
+	Text      string `json:"text"`
+}
+
+// The failover settings a test expects run-couchbase-server to configure
+type FailoverSettings struct {
+	AutoFailover    AutoFailoverSettings
+	AutoReprovision AutoReprovisionSettings
+}
+
+// Read the auto-failover settings of the Couchbase cluster at the given URL
+func getAutoFailoverSettings(t *testing.T, clusterUrl string) AutoFailoverSettings {
+	settings, err := getAutoFailoverSettingsE(testContext(t), clusterUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return settings
+}
+
+func getAutoFailoverSettingsE(ctx context.Context, clusterUrl string) (AutoFailoverSettings, error) {
+	settingsUrl := fmt.Sprintf("%s/settings/autoFailover", clusterUrl)
+
+	var settings AutoFailoverSettings
+	err := readSettingsWithRetryE(ctx, settingsUrl, &settings)
+	return settings, err
+}
+
+// Read the auto-reprovision settings of the Couchbase cluster at the given URL
+func getAutoReprovisionSettings(t *testing.T, clusterUrl string) AutoReprovisionSettings {
+	settings, err := getAutoReprovisionSettingsE(testContext(t), clusterUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return settings
+}
+
+func getAutoReprovisionSettingsE(ctx context.Context, clusterUrl string) (AutoReprovisionSettings, error) {
+	settingsUrl := fmt.Sprintf("%s/settings/autoReprovision", clusterUrl)
+
+	var settings AutoReprovisionSettings
+	err := readSettingsWithRetryE(ctx, settingsUrl, &settings)
+	return settings, err
+}
+
+// Check that the Couchbase cluster at the given URL has the expected auto-failover settings, as configured by the
+// --auto-failover-xxx arguments of run-couchbase-server
+func checkAutoFailoverSettings(t *testing.T, clusterUrl string, expected AutoFailoverSettings) {
+	if err := checkAutoFailoverSettingsE(testContext(t), clusterUrl, expected); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkAutoFailoverSettingsE(ctx context.Context, clusterUrl string, expected AutoFailoverSettings) error {
+	actual, err := getAutoFailoverSettingsE(ctx, clusterUrl)
+	if err != nil {
+		return err
+	}
+
+	logf(ctx, "Auto-failover settings: %+v", actual)
+	if actual != expected {
+		return UnexpectedValueErr{Description: "auto-failover settings", Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// Check that the Couchbase cluster at the given URL has the expected auto-reprovision settings, as configured by the
+// --auto-reprovision-max-nodes and --disable-auto-reprovision arguments of run-couchbase-server
+func checkAutoReprovisionSettings(t *testing.T, clusterUrl string, expected AutoReprovisionSettings) {
+	if err := checkAutoReprovisionSettingsE(testContext(t), clusterUrl, expected); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkAutoReprovisionSettingsE(ctx context.Context, clusterUrl string, expected AutoReprovisionSettings) error {
+	actual, err := getAutoReprovisionSettingsE(ctx, clusterUrl)
+	if err != nil {
+		return err
+	}
+
+	logf(ctx, "Auto-reprovision settings: %+v", actual)
+	if actual != expected {
+		return UnexpectedValueErr{Description: "auto-reprovision settings", Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// Check that the Couchbase cluster at the given URL has the expected auto-failover and auto-reprovision settings
+func checkFailoverSettings(t *testing.T, clusterUrl string, expected FailoverSettings) {
+	if err := checkFailoverSettingsE(testContext(t), clusterUrl, expected); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFailoverSettingsE(ctx context.Context, clusterUrl string, expected FailoverSettings) error {
+	if err := checkAutoFailoverSettingsE(ctx, clusterUrl, expected.AutoFailover); err != nil {
+		return err
+	}
+	return checkAutoReprovisionSettingsE(ctx, clusterUrl, expected.AutoReprovision)
+}
+
+func readSettingsWithRetry(t *testing.T, settingsUrl string, out interface{}) {
+	if err := readSettingsWithRetryE(testContext(t), settingsUrl, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readSettingsWithRetryE(ctx context.Context, settingsUrl string, out interface{}) error {
+	maxRetries := 30
+	sleepBetweenRetries := 5 * time.Second
+
+	_, err := pollE(ctx, fmt.Sprintf("Reading settings from %s", settingsUrl), maxRetries, sleepBetweenRetries, func() (string, error) {
+		return "", httpGetJsonE(ctx, settingsUrl, out)
+	})
+	return err
+}
+
+// Return the OTP node name, such as ns_1@172.18.0.3, that Couchbase uses to identify the node with the given IP address
+func getOtpNodeForIp(t *testing.T, clusterUrl string, nodeIp string) string {
+	otpNode, err := getOtpNodeForIpE(testContext(t), clusterUrl, nodeIp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return otpNode
+}
+
+func getOtpNodeForIpE(ctx context.Context, clusterUrl string, nodeIp string) (string, error) {
+	serverNodeUrl := fmt.Sprintf("%s/pools/nodes", clusterUrl)
+
+	var serverNodesResponse ServerNodeResponse
+	if err := httpGetJsonE(ctx, serverNodeUrl, &serverNodesResponse); err != nil {
+		return "", err
+	}
+
+	for _, serverNode := range serverNodesResponse.Nodes {
+		if hostOf(serverNode.Hostname) == nodeIp {
+			return serverNode.OtpNode, nil
+		}
+	}
+
+	return "", UnexpectedResponseErr{
+		Url:    serverNodeUrl,
+		Reason: fmt.Sprintf("no node has IP %s", nodeIp),
+		Body:   fmt.Sprintf("%+v", serverNodesResponse.Nodes),
+	}
+}
+
+// Check whether a node with the given IP address is a member of the cluster at the given URL
+func checkNodeIsInCluster(t *testing.T, clusterUrl string, nodeIp string, expectInCluster bool) {
+	if err := checkNodeIsInClusterE(testContext(t), clusterUrl, nodeIp, expectInCluster); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkNodeIsInClusterE(ctx context.Context, clusterUrl string, nodeIp string, expectInCluster bool) error {
+	var serverNodesResponse ServerNodeResponse
+	if err := httpGetJsonE(ctx, fmt.Sprintf("%s/pools/nodes", clusterUrl), &serverNodesResponse); err != nil {
+		return err
+	}
+
+	actualInCluster := false
+	for _, serverNode := range serverNodesResponse.Nodes {
+		if hostOf(serverNode.Hostname) == nodeIp {
+			actualInCluster = true
+		}
+	}
+
+	logf(ctx, "Node %s in cluster: %t", nodeIp, actualInCluster)
+	if actualInCluster != expectInCluster {
+		return UnexpectedValueErr{Description: fmt.Sprintf("membership of node %s in the cluster", nodeIp), Expected: expectInCluster, Actual: actualInCluster}
+	}
+
+	return nil
+}
+
+// Hard fail over the node with the given OTP node name in the cluster at the given URL
+func failOverNode(t *testing.T, clusterUrl string, otpNode string) {
+	if err := failOverNodeE(testContext(t), clusterUrl, otpNode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Hard fail over the node with the given OTP node name in the cluster at the given URL. Couchbase only accepts one
+// topology change at a time, so retry while, e.g., a rebalance is still running.
+func failOverNodeE(ctx context.Context, clusterUrl string, otpNode string) error {
+	maxRetries := 30
+	sleepBetweenRetries := 5 * time.Second
+	failOverUrl := fmt.Sprintf("%s/controller/failOver", clusterUrl)
+
+	_, err := pollE(ctx, fmt.Sprintf("Failing over node %s", otpNode), maxRetries, sleepBetweenRetries, func() (string, error) {
+		statusCode, body, err := httpDoE(ctx, "POST", failOverUrl, nil, nil, url.Values{"otpNode": []string{otpNode}})
+		if err != nil {
+			return "", err
+		}
+
+		if statusCode != 200 {
+			return "", HttpStatusErr{Url: failOverUrl, Expected: 200, Actual: statusCode, Body: body}
+		}
+
+		return body, nil
+	})
+	return err
+}
+
+// Check that the last rebalance the cluster at the given URL started after the given timestamp (in milliseconds since
+// the epoch) added the node with the given OTP node name back with delta recovery. Couchbase logs the nodes it
+// recovers with delta recovery in the message for the start of each rebalance, e.g.:
+//
+// Starting rebalance, KeepNodes = ['ns_1@172.18.0.2','ns_1@172.18.0.3'], EjectNodes = [], Failed over and being ejected
+// nodes = []; Delta recovery nodes = ['ns_1@172.18.0.3'],  Delta recovery buckets = all
+func checkLastRebalanceUsedDeltaRecovery(t *testing.T, clusterUrl string, otpNode string, sinceTimestamp int64) {
+	if err := checkLastRebalanceUsedDeltaRecoveryE(testContext(t), clusterUrl, otpNode, sinceTimestamp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkLastRebalanceUsedDeltaRecoveryE(ctx context.Context, clusterUrl string, otpNode string, sinceTimestamp int64) error {
+	logsUrl := fmt.Sprintf("%s/logs", clusterUrl)
+
+	var logs ClusterLogsResponse
+	if err := httpGetJsonE(ctx, logsUrl, &logs); err != nil {
+		return err
+	}
+
+	var lastRebalance *ClusterLogEntry
+	for i, entry := range logs.List {
+		if entry.Timestamp < sinceTimestamp || !strings.HasPrefix(entry.Text, "Starting rebalance") {
+			continue
+		}
+		if lastRebalance == nil || entry.Timestamp > lastRebalance.Timestamp {
+			lastRebalance = &logs.List[i]
+		}
+	}
+
+	if lastRebalance == nil {
+		return UnexpectedResponseErr{Url: logsUrl, Reason: fmt.Sprintf("found no rebalance since timestamp %d", sinceTimestamp)}
+	}
+	logf(ctx, "Last rebalance: %s", lastRebalance.Text)
+
+	expectedDeltaRecoveryNodes := fmt.Sprintf("Delta recovery nodes = ['%s']", otpNode)
+	if !strings.Contains(lastRebalance.Text, expectedDeltaRecoveryNodes) {
+		return UnexpectedResponseErr{
+			Url:    logsUrl,
+			Reason: fmt.Sprintf("expected the last rebalance to add node %s back with delta recovery", otpNode),
+			Body:   lastRebalance.Text,
+		}
+	}
+
+	return nil
+}
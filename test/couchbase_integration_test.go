@@ -0,0 +1,39 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/matrix"
+)
+
+// The settings of the couchbase-cluster-simple-dns-tls cells of the test matrix
+type dnsTlsMatrixSettings struct {
+	EnableNodeCertificates bool   `json:"enable_node_certificates"`
+	ClusterEncryptionLevel string `json:"cluster_encryption_level"`
+	TlsOnly                bool   `json:"tls_only"`
+}
+
+// Deploy each example in the integration suite of test_matrix.json to AWS, once for each of its OSes, editions, and
+// versions
+func TestIntegrationCouchbase(t *testing.T) {
+	t.Parallel()
+
+	runTestMatrix(t, "integration", func(t *testing.T, cell matrix.Cell) {
+		versions := couchbaseVersionsForCell(cell)
+
+		switch cell.Example {
+		case "couchbase-cluster-simple":
+			testCouchbaseSingleCluster(t, cell.Os, cell.Edition, versions)
+		case "couchbase-cluster-mds":
+			testCouchbaseMultiCluster(t, cell.Os, cell.Edition, versions)
+		case "couchbase-cluster-simple-dns-tls":
+			settings := dnsTlsMatrixSettings{}
+			decodeMatrixSettings(t, cell, &settings)
+			testCouchbaseSingleClusterDnsTls(t, cell.Os, cell.Edition, versions, settings.EnableNodeCertificates, settings.ClusterEncryptionLevel, settings.TlsOnly)
+		case "couchbase-multi-datacenter-replication":
+			testCouchbaseMultiDataCenterReplication(t, cell.Os, cell.Edition, versions)
+		default:
+			t.Fatalf("The integration tests don't know how to test example %s", cell.Example)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/matrix"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/stretchr/testify/require"
+)
+
+const testMatrixPath = "test_matrix.json"
+
+// Filter the cells of the test matrix from the command line, e.g. go test -run TestIntegrationCouchbase -args
+// -matrix.os=ubuntu-18 -matrix.edition=enterprise. Each flag takes a comma separated list of values.
+var (
+	matrixExampleFlag            = flag.String("matrix.example", "", "Only run the test matrix cells of these examples, such as couchbase-cluster-simple or couchbase-cluster-simple-dns-tls-tls-only")
+	matrixOsFlag                 = flag.String("matrix.os", "", "Only run the test matrix cells of these OSes, such as ubuntu, ubuntu-18, or amazon-linux")
+	matrixEditionFlag            = flag.String("matrix.edition", "", "Only run the test matrix cells of these editions: community or enterprise")
+	matrixCouchbaseVersionFlag   = flag.String("matrix.couchbase-version", "", "Only run the test matrix cells of these Couchbase versions")
+	matrixSyncGatewayVersionFlag = flag.String("matrix.sync-gateway-version", "", "Only run the test matrix cells of these Sync Gateway versions")
+	matrixSummaryFlag            = flag.String("matrix.summary", "test-matrix-summary.json", "Write the outcome of each test matrix cell to this JSON file. Set to an empty string to disable.")
+)
+
+// All the top level tests record their cells in the same summary, so it covers every suite that ran
+var matrixRecorder = &matrix.Recorder{}
+
+func matrixFilterFromFlags() matrix.Filter {
+	return matrix.Filter{
+		Example:            matrix.SplitValues(*matrixExampleFlag),
+		Os:                 matrix.SplitValues(*matrixOsFlag),
+		Edition:            matrix.SplitValues(*matrixEditionFlag),
+		CouchbaseVersion:   matrix.SplitValues(*matrixCouchbaseVersionFlag),
+		SyncGatewayVersion: matrix.SplitValues(*matrixSyncGatewayVersionFlag),
+	}
+}
+
+// Run a parallel subtest for each cell of the given suite of the test matrix that matches the filters from the command
+// line, and once they are all done, write the summary of every cell recorded so far
+func runTestMatrix(t *testing.T, suite string, run func(t *testing.T, cell matrix.Cell)) {
+	testMatrix, err := matrix.Load(testMatrixPath)
+	require.NoError(t, err)
+
+	cells, err := testMatrix.Cells(suite)
+	require.NoError(t, err)
+
+	// Cleanup runs after all the parallel subtests are done
+	t.Cleanup(func() {
+		if *matrixSummaryFlag == "" {
+			return
+		}
+		if err := matrixRecorder.WriteSummary(*matrixSummaryFlag); err != nil {
+			t.Errorf("Failed to write the test matrix summary to %s: %v", *matrixSummaryFlag, err)
+			return
+		}
+		logger.Logf(t, "Wrote the test matrix summary to %s", *matrixSummaryFlag)
+	})
+
+	matrix.Run(t, cells, matrixFilterFromFlags(), matrixRecorder, true, run)
+}
+
+// Decode the settings of the given cell into the given struct, failing the test if they are invalid
+func decodeMatrixSettings(t *testing.T, cell matrix.Cell, settings interface{}) {
+	require.NoError(t, cell.DecodeSettings(settings))
+}
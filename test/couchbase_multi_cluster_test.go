@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/reaper"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
@@ -15,88 +16,87 @@ const dataNodeClusterVarName = "couchbase_data_node_cluster_name"
 const indexQuerySearchClusterVarName = "couchbase_index_query_search_node_cluster_name"
 const syncGatewayClusterVarName = "sync_gateway_cluster_name"
 
-func TestIntegrationCouchbaseEnterpriseMultiClusterUbuntu16(t *testing.T) {
-	t.Parallel()
-	testCouchbaseMultiCluster(t, "ubuntu", "enterprise")
-}
-
-func TestIntegrationCouchbaseEnterpriseMultiClusterUbuntu18(t *testing.T) {
-	t.Parallel()
-	testCouchbaseMultiCluster(t, "ubuntu-18", "enterprise")
-}
-
-func TestIntegrationCouchbaseEnterpriseMultiClusterAmazonLinux(t *testing.T) {
-	t.Parallel()
-	testCouchbaseMultiCluster(t, "amazon-linux", "enterprise")
-}
-
-func testCouchbaseMultiCluster(t *testing.T, osName string, edition string) {
+func testCouchbaseMultiCluster(t *testing.T, osName string, edition string, versions CouchbaseVersions) {
 	examplesFolder := test_structure.CopyTerraformFolderToTemp(t, "../", "examples")
 	couchbaseAmiDir := filepath.Join(examplesFolder, "couchbase-ami")
 	couchbaseMultiClusterDir := filepath.Join(examplesFolder, "couchbase-cluster-mds")
 
-	test_structure.RunTestStage(t, "setup_ami", func() {
+	runTestStage(t, "setup_ami", func() {
 		awsRegion := getRandomAwsRegion(t)
 		uniqueId := random.UniqueId()
 
-		amiId := buildCouchbaseAmi(t, osName, couchbaseAmiDir, edition, awsRegion, uniqueId)
+		amiId := buildCouchbaseAmi(t, osName, couchbaseAmiDir, edition, versions, awsRegion, uniqueId)
 
 		test_structure.SaveAmiId(t, couchbaseMultiClusterDir, amiId)
 		test_structure.SaveString(t, couchbaseMultiClusterDir, savedAwsRegion, awsRegion)
 		test_structure.SaveString(t, couchbaseMultiClusterDir, savedUniqueId, uniqueId)
 	})
 
-	defer test_structure.RunTestStage(t, "teardown", func() {
+	defer runTestStage(t, "teardown", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseMultiClusterDir)
 		terraform.Destroy(t, terraformOptions)
+		deleteDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPair)
 
 		amiId := test_structure.LoadAmiId(t, couchbaseMultiClusterDir)
 		awsRegion := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAwsRegion)
 		aws.DeleteAmi(t, awsRegion, amiId)
 	})
 
-	defer test_structure.RunTestStage(t, "logs", func() {
+	defer runTestStage(t, "logs", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseMultiClusterDir)
 		awsRegion := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAwsRegion)
 
 		testStageLogs(t, terraformOptions, dataNodeClusterVarName, awsRegion)
 		testStageLogs(t, terraformOptions, indexQuerySearchClusterVarName, awsRegion)
 		testStageLogs(t, terraformOptions, syncGatewayClusterVarName, awsRegion)
+
+		keyPair := loadDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPair)
+		collectDiagnosticsFromAsgsOnFailure(t, terraformOptions, awsRegion, keyPair, osName, dataNodeClusterVarName, indexQuerySearchClusterVarName, syncGatewayClusterVarName)
 	})
 
-	test_structure.RunTestStage(t, "setup_deploy", func() {
+	runTestStage(t, "setup_deploy", func() {
 		amiId := test_structure.LoadAmiId(t, couchbaseMultiClusterDir)
 		awsRegion := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAwsRegion)
 		uniqueId := test_structure.LoadString(t, couchbaseMultiClusterDir, savedUniqueId)
 
+		keyPair := createDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPair, awsRegion, uniqueId)
+
 		terraformOptions := &terraform.Options{
 			TerraformDir: couchbaseMultiClusterDir,
 			Vars: map[string]interface{}{
+				"ssh_key_name":                 keyPair.Name,
 				"ami_id":                       amiId,
 				dataNodeClusterVarName:         formatCouchbaseClusterName("data", uniqueId),
 				indexQuerySearchClusterVarName: formatCouchbaseClusterName("search", uniqueId),
 				syncGatewayClusterVarName:      formatCouchbaseClusterName("sync", uniqueId),
+				"custom_tags":                  reaper.TestRunTags(uniqueId),
 			},
 			EnvVars: map[string]string{
 				AWS_DEFAULT_REGION_ENV_VAR: awsRegion,
 			},
 		}
 
+		addCredentialsToTerraformVars(terraformOptions.Vars, newRandomCredentialsForTest())
+		// This example only creates test-user, and not the RBAC users
+		addTestUserPasswordsToTerraformVars(terraformOptions.Vars, TestUserPasswords{TestUser: newRandomPasswordForTest()})
+
 		terraform.InitAndApply(t, terraformOptions)
 
 		test_structure.SaveTerraformOptions(t, couchbaseMultiClusterDir, terraformOptions)
 	})
 
-	test_structure.RunTestStage(t, "validation", func() {
+	runTestStage(t, "validation", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseMultiClusterDir)
 		clusterName := getClusterName(t, dataNodeClusterVarName, terraformOptions)
+		credentials := getCredentials(t, terraformOptions)
 
-		couchbaseDataNodesUrl := fmt.Sprintf("http://%s:%s@%s", usernameForTest, passwordForTest, terraform.OutputRequired(t, terraformOptions, "couchbase_data_nodes_web_console_url"))
-		couchbaseIndexSearchQueryNodesUrl := fmt.Sprintf("http://%s:%s@%s", usernameForTest, passwordForTest, terraform.OutputRequired(t, terraformOptions, "couchbase_index_query_search_nodes_web_console_url"))
+		couchbaseDataNodesUrl := formatUrlWithCredentials("http", credentials, terraform.OutputRequired(t, terraformOptions, "couchbase_data_nodes_web_console_url"))
+		couchbaseIndexSearchQueryNodesUrl := formatUrlWithCredentials("http", credentials, terraform.OutputRequired(t, terraformOptions, "couchbase_index_query_search_nodes_web_console_url"))
 		syncGatewayUrl := fmt.Sprintf("http://%s/%s", terraform.OutputRequired(t, terraformOptions, "sync_gateway_url"), clusterName)
 
 		checkCouchbaseConsoleIsRunning(t, couchbaseDataNodesUrl)
 		checkCouchbaseClusterIsInitialized(t, couchbaseDataNodesUrl, 5)
+		checkCouchbaseVersion(t, couchbaseDataNodesUrl, edition, versions)
 		checkCouchbaseDataNodesWorking(t, couchbaseDataNodesUrl)
 		checkCouchbaseConsoleIsRunning(t, couchbaseIndexSearchQueryNodesUrl)
 		checkSyncGatewayWorking(t, syncGatewayUrl)
@@ -1,11 +1,12 @@
 package test
 
 import (
-	"fmt"
+	"context"
 	"path/filepath"
 	"sync"
 	"testing"
 
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/reaper"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
@@ -27,22 +28,7 @@ const savedUniqueIdReplica = "UniqueIdReplica"
 const providersFile = "providers.tf"
 const providersFileBackup = "providers.tf.bak"
 
-func TestIntegrationCouchbaseEnterpriseMultiDataCenterReplicationUbuntu16(t *testing.T) {
-	t.Parallel()
-	testCouchbaseMultiDataCenterReplication(t, "ubuntu", "enterprise")
-}
-
-func TestIntegrationCouchbaseEnterpriseMultiDataCenterReplicationUbuntu18(t *testing.T) {
-	t.Parallel()
-	testCouchbaseMultiDataCenterReplication(t, "ubuntu-18", "enterprise")
-}
-
-func TestIntegrationCouchbaseEnterpriseMultiDataCenterReplicationAmazonLinux(t *testing.T) {
-	t.Parallel()
-	testCouchbaseMultiDataCenterReplication(t, "amazon-linux", "enterprise")
-}
-
-func testCouchbaseMultiDataCenterReplication(t *testing.T, osName string, edition string) {
+func testCouchbaseMultiDataCenterReplication(t *testing.T, osName string, edition string, versions CouchbaseVersions) {
 	// For convenience - uncomment these as well as the "os" import
 	// when doing local testing if you need to skip any sections.
 	//os.Setenv("TERRATEST_REGION", "eu-west-1")
@@ -56,7 +42,7 @@ func testCouchbaseMultiDataCenterReplication(t *testing.T, osName string, editio
 	couchbaseAmiDir := filepath.Join(examplesFolder, "couchbase-ami")
 	couchbaseMultiClusterDir := filepath.Join(examplesFolder, "couchbase-multi-datacenter-replication")
 
-	test_structure.RunTestStage(t, "setup_ami", func() {
+	runTestStage(t, "setup_ami", func() {
 		awsRegionPrimary := getRandomAwsRegion(t)
 		uniqueIdPrimary := random.UniqueId()
 
@@ -75,12 +61,12 @@ func testCouchbaseMultiDataCenterReplication(t *testing.T, osName string, editio
 		go func() {
 			defer waitForPackerBuilds.Done()
 
-			amiIdPrimary, amiErrPrimary = buildCouchbaseAmiE(t, osName, couchbaseAmiDir, edition, awsRegionPrimary, uniqueIdPrimary)
+			amiIdPrimary, amiErrPrimary = buildCouchbaseAmiE(t, osName, couchbaseAmiDir, edition, versions, awsRegionPrimary, uniqueIdPrimary)
 		}()
 
 		go func() {
 			defer waitForPackerBuilds.Done()
-			amiIdReplica, amiErrReplica = buildCouchbaseAmiE(t, osName, couchbaseAmiDir, edition, awsRegionReplica, uniqueIdReplica)
+			amiIdReplica, amiErrReplica = buildCouchbaseAmiE(t, osName, couchbaseAmiDir, edition, versions, awsRegionReplica, uniqueIdReplica)
 		}()
 
 		waitForPackerBuilds.Wait()
@@ -104,9 +90,11 @@ func testCouchbaseMultiDataCenterReplication(t *testing.T, osName string, editio
 		test_structure.SaveString(t, couchbaseMultiClusterDir, savedUniqueIdReplica, uniqueIdReplica)
 	})
 
-	defer test_structure.RunTestStage(t, "teardown", func() {
+	defer runTestStage(t, "teardown", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseMultiClusterDir)
 		terraform.Destroy(t, terraformOptions)
+		deleteDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPairPrimary)
+		deleteDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPairReplica)
 
 		amiIdPrimary := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAmiIdPrimary)
 		amiIdReplica := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAmiIdReplica)
@@ -118,16 +106,22 @@ func testCouchbaseMultiDataCenterReplication(t *testing.T, osName string, editio
 		aws.DeleteAmi(t, awsRegionReplica, amiIdReplica)
 	})
 
-	defer test_structure.RunTestStage(t, "logs", func() {
+	defer runTestStage(t, "logs", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseMultiClusterDir)
 		awsRegionPrimary := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAwsRegionPrimary)
 		awsRegionReplica := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAwsRegionReplica)
 
 		testStageLogs(t, terraformOptions, clusterNamePrimaryVarName, awsRegionPrimary)
 		testStageLogs(t, terraformOptions, clusterNameReplicaVarName, awsRegionReplica)
+
+		// The clusters are in different regions, so each has its own Key Pair
+		keyPairPrimary := loadDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPairPrimary)
+		keyPairReplica := loadDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPairReplica)
+		collectDiagnosticsFromAsgsOnFailure(t, terraformOptions, awsRegionPrimary, keyPairPrimary, osName, clusterNamePrimaryVarName)
+		collectDiagnosticsFromAsgsOnFailure(t, terraformOptions, awsRegionReplica, keyPairReplica, osName, clusterNameReplicaVarName)
 	})
 
-	test_structure.RunTestStage(t, "setup_deploy", func() {
+	runTestStage(t, "setup_deploy", func() {
 		amiIdPrimary := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAmiIdPrimary)
 		amiIdReplica := test_structure.LoadString(t, couchbaseMultiClusterDir, savedAmiIdReplica)
 
@@ -137,6 +131,9 @@ func testCouchbaseMultiDataCenterReplication(t *testing.T, osName string, editio
 		uniqueIdPrimary := test_structure.LoadString(t, couchbaseMultiClusterDir, savedUniqueIdPrimary)
 		uniqueIdReplica := test_structure.LoadString(t, couchbaseMultiClusterDir, savedUniqueIdReplica)
 
+		keyPairPrimary := createDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPairPrimary, awsRegionPrimary, uniqueIdPrimary)
+		keyPairReplica := createDiagnosticsKeyPair(t, couchbaseMultiClusterDir, savedKeyPairReplica, awsRegionReplica, uniqueIdReplica)
+
 		terraformOptions := &terraform.Options{
 			TerraformDir: couchbaseMultiClusterDir,
 			Vars: map[string]interface{}{
@@ -144,27 +141,47 @@ func testCouchbaseMultiDataCenterReplication(t *testing.T, osName string, editio
 				"replica_region":          awsRegionReplica,
 				"ami_id_primary":          amiIdPrimary,
 				"ami_id_replica":          amiIdReplica,
+				"ssh_key_name_primary":    keyPairPrimary.Name,
+				"ssh_key_name_replica":    keyPairReplica.Name,
 				clusterNamePrimaryVarName: formatCouchbaseClusterName("primary", uniqueIdPrimary),
 				clusterNameReplicaVarName: formatCouchbaseClusterName("replica", uniqueIdReplica),
+				"custom_tags_primary":     reaper.TestRunTags(uniqueIdPrimary),
+				"custom_tags_replica":     reaper.TestRunTags(uniqueIdReplica),
 			},
 		}
 
+		addCredentialsToTerraformVars(terraformOptions.Vars, newRandomCredentialsForTest())
+		// This example only creates test-user, and not the RBAC users
+		addTestUserPasswordsToTerraformVars(terraformOptions.Vars, TestUserPasswords{TestUser: newRandomPasswordForTest()})
+
 		terraform.InitAndApply(t, terraformOptions)
 
 		test_structure.SaveTerraformOptions(t, couchbaseMultiClusterDir, terraformOptions)
 	})
 
-	test_structure.RunTestStage(t, "validation", func() {
+	runTestStage(t, "validation", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseMultiClusterDir)
+		credentials := getCredentials(t, terraformOptions)
+
+		consoleUrlPrimary := formatUrlWithCredentials("http", credentials, terraform.OutputRequired(t, terraformOptions, "couchbase_primary_web_console_url"))
+		consoleUrlReplica := formatUrlWithCredentials("http", credentials, terraform.OutputRequired(t, terraformOptions, "couchbase_replica_web_console_url"))
+
+		// The clusters boot independently, so wait for both of them at the same time
+		checkClusterIsUp := func(consoleUrl string) func(ctx context.Context) error {
+			return func(ctx context.Context) error {
+				if err := checkCouchbaseConsoleIsRunningE(ctx, consoleUrl); err != nil {
+					return err
+				}
+				if err := checkCouchbaseClusterIsInitializedE(ctx, consoleUrl, 3); err != nil {
+					return err
+				}
+				return checkCouchbaseVersionE(ctx, consoleUrl, edition, versions)
+			}
+		}
 
-		consoleUrlPrimary := fmt.Sprintf("http://%s:%s@%s", usernameForTest, passwordForTest, terraform.OutputRequired(t, terraformOptions, "couchbase_primary_web_console_url"))
-		consoleUrlReplica := fmt.Sprintf("http://%s:%s@%s", usernameForTest, passwordForTest, terraform.OutputRequired(t, terraformOptions, "couchbase_replica_web_console_url"))
-
-		checkCouchbaseConsoleIsRunning(t, consoleUrlPrimary)
-		checkCouchbaseConsoleIsRunning(t, consoleUrlReplica)
-
-		checkCouchbaseClusterIsInitialized(t, consoleUrlPrimary, 3)
-		checkCouchbaseClusterIsInitialized(t, consoleUrlReplica, 3)
+		if err := runInParallelE(testContext(t), checkClusterIsUp(consoleUrlPrimary), checkClusterIsUp(consoleUrlReplica)); err != nil {
+			t.Fatal(err)
+		}
 
 		checkReplicationIsWorking(t, consoleUrlPrimary, consoleUrlReplica, "test-bucket", "test-bucket-replica")
 	})
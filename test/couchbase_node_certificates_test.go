@@ -0,0 +1,77 @@
+package test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestUnitCouchbaseNodeCertificatesInDocker(t *testing.T) {
+	t.Parallel()
+
+	// Note that these ports are different from the ones in the other Docker tests, as all these tests run in parallel.
+	// Custom node certificates and XDCR encryption require the enterprise edition.
+	t.Run("TestUnitCouchbaseEnterpriseNodeCertificatesUbuntu16InDocker", func(t *testing.T) {
+		t.Parallel()
+		skipInCircleCi(t)
+		testNodeCertificatesInDocker(t, "ubuntu", "enterprise", 1091, 1984, CouchbaseTlsPorts{Rest: 1191, Query: 1193, Memcached: 1194}, 1192)
+	})
+
+	t.Run("TestUnitCouchbaseEnterpriseFullyEncryptedReplicationUbuntu16InDocker", func(t *testing.T) {
+		t.Parallel()
+		skipInCircleCi(t)
+		testFullyEncryptedReplicationInDocker(t, "ubuntu", "enterprise", 1291, 1391)
+	})
+}
+
+// Run the couchbase-cluster-simple-dns-tls example with a CA and check that the nodes serve certificates signed by that
+// CA on their TLS ports
+func testNodeCertificatesInDocker(t *testing.T, osName string, edition string, couchbaseWebConsolePort int, syncGatewayWebConsolePort int, couchbaseTlsPorts CouchbaseTlsPorts, couchbaseCapiTlsPort int) {
+	example := setupExampleInDocker(t, dockerExampleSettings{
+		Example:         "couchbase-cluster-simple-dns-tls",
+		Os:              osName,
+		Edition:         edition,
+		WebConsolePort:  couchbaseWebConsolePort,
+		SyncGatewayPort: syncGatewayWebConsolePort,
+		MockCa:          true,
+		EnvVars:         couchbaseTlsPorts.envVars(couchbaseCapiTlsPort),
+	})
+
+	runTestStage(t, "validation", func() {
+		checkCouchbaseClusterIsInitialized(t, example.DataNodesUrl, 2)
+
+		// We connect via localhost, which is not in the node certificate, so we only check who issued the certificate
+		addresses := []string{
+			fmt.Sprintf("localhost:%d", couchbaseTlsPorts.Rest),
+			fmt.Sprintf("localhost:%d", couchbaseCapiTlsPort),
+		}
+		checkNodeCertificates(t, addresses, example.CaCertPem, "")
+	})
+}
+
+// Run the couchbase-multi-datacenter-replication example with a CA shared by both clusters and XDCR with full
+// encryption, which only works if the primary cluster can verify the replica cluster's node certificates
+func testFullyEncryptedReplicationInDocker(t *testing.T, osName string, edition string, couchbaseWebConsolePortEast int, couchbaseWebConsolePortWest int) {
+	example := setupExampleInDocker(t, dockerExampleSettings{
+		Example: "couchbase-multi-datacenter-replication",
+		Os:      osName,
+		Edition: edition,
+		MockCa:  true,
+		EnvVars: map[string]string{
+			"WEB_CONSOLE_EAST_PORT": strconv.Itoa(couchbaseWebConsolePortEast),
+			"WEB_CONSOLE_WEST_PORT": strconv.Itoa(couchbaseWebConsolePortWest),
+			"XDCR_ENCRYPTION_TYPE":  "full",
+		},
+	})
+
+	runTestStage(t, "validation", func() {
+		consoleUrlEast := fmt.Sprintf("http://localhost:%d", couchbaseWebConsolePortEast)
+		dataNodesUrlEast := formatUrlWithCredentials("http", example.Credentials, fmt.Sprintf("localhost:%d", couchbaseWebConsolePortEast))
+		dataNodesUrlWest := formatUrlWithCredentials("http", example.Credentials, fmt.Sprintf("localhost:%d", couchbaseWebConsolePortWest))
+
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrlEast, 2)
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrlWest, 2)
+		checkReplicationIsWorking(t, dataNodesUrlEast, dataNodesUrlWest, "test-bucket", "test-bucket-replica")
+		checkXdcrEncryptionType(t, consoleUrlEast, example.Credentials, "full")
+	})
+}
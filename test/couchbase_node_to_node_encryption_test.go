@@ -0,0 +1,67 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUnitCouchbaseNodeToNodeEncryptionInDocker(t *testing.T) {
+	t.Parallel()
+
+	// Note that these ports are different from the ones in the other Docker tests, as all these tests run in parallel.
+	// Node-to-node encryption requires the enterprise edition.
+	t.Run("TestUnitCouchbaseEnterpriseNodeToNodeEncryptionUbuntu16InDocker", func(t *testing.T) {
+		t.Parallel()
+		skipInCircleCi(t)
+		testNodeToNodeEncryptionInDocker(t, "ubuntu", "enterprise", "all", false, 1491, 1484, CouchbaseTlsPorts{Rest: 1591, Query: 1593, Memcached: 1594}, 1592)
+	})
+
+	t.Run("TestUnitCouchbaseEnterpriseNodeToNodeEncryptionTlsOnlyUbuntu16InDocker", func(t *testing.T) {
+		t.Parallel()
+		skipInCircleCi(t)
+		testNodeToNodeEncryptionInDocker(t, "ubuntu", "enterprise", "all", true, 1791, 1784, CouchbaseTlsPorts{Rest: 1891, Query: 1893, Memcached: 1894}, 1892)
+	})
+}
+
+// Run the couchbase-cluster-simple-dns-tls example with node certificates and node-to-node encryption and check that
+// the nodes serve admin, query, and KV traffic over TLS. If tlsOnly is set, run it without docker-compose.override.yml,
+// so Docker Compose only maps the TLS ports of Couchbase to the host, as the Security Group rules do in tls_only mode
+// in AWS, and check that the Web Console port it would otherwise map refuses connections and that everything else
+// works over TLS.
+func testNodeToNodeEncryptionInDocker(t *testing.T, osName string, edition string, encryptionLevel string, tlsOnly bool, couchbaseWebConsolePort int, syncGatewayWebConsolePort int, couchbaseTlsPorts CouchbaseTlsPorts, couchbaseCapiTlsPort int) {
+	envVars := couchbaseTlsPorts.envVars(couchbaseCapiTlsPort)
+	envVars["CLUSTER_ENCRYPTION_LEVEL"] = encryptionLevel
+
+	composeFiles := []string{}
+	if tlsOnly {
+		composeFiles = []string{"-f", "docker-compose.yml"}
+	}
+
+	example := setupExampleInDocker(t, dockerExampleSettings{
+		Example:         "couchbase-cluster-simple-dns-tls",
+		Os:              osName,
+		Edition:         edition,
+		WebConsolePort:  couchbaseWebConsolePort,
+		SyncGatewayPort: syncGatewayWebConsolePort,
+		MockCa:          true,
+		ComposeFiles:    composeFiles,
+		EnvVars:         envVars,
+	})
+
+	runTestStage(t, "validation", func() {
+		if tlsOnly {
+			tlsConfig := newTlsConfigForCa(t, example.CaCertPem)
+			consoleUrl := fmt.Sprintf("https://localhost:%d", couchbaseTlsPorts.Rest)
+			dataNodesUrl := formatUrlWithCredentials("https", example.Credentials, fmt.Sprintf("localhost:%d", couchbaseTlsPorts.Rest))
+
+			checkCouchbaseClusterIsInitializedWithTls(t, dataNodesUrl, tlsConfig, 2)
+			checkNodeToNodeEncryption(t, consoleUrl, tlsConfig, example.Credentials, encryptionLevel)
+			checkPortsRefuseConnections(t, "localhost", []int{couchbaseWebConsolePort})
+		} else {
+			checkCouchbaseClusterIsInitialized(t, example.DataNodesUrl, 2)
+			checkNodeToNodeEncryption(t, example.ConsoleUrl, nil, example.Credentials, encryptionLevel)
+		}
+
+		checkTlsPortsServeTraffic(t, "localhost", couchbaseTlsPorts, example.CaCertPem, example.Credentials)
+	})
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/ports"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/stretchr/testify/require"
+)
+
+// Return the ports we expect each container in the Docker Compose setup of the given example to listen on, keyed by
+// container name. All the examples run Couchbase and Sync Gateway with their default ports.
+func expectedListeningPortsInDocker(t *testing.T, examplesFolderName string, containerBaseName string) map[string]ports.PortMatrix {
+	config, err := ports.ReadCouchbasePortConfig("../modules/run-couchbase-server/run-couchbase-server")
+	require.NoError(t, err)
+
+	couchbasePorts := ports.CouchbasePorts(config)
+	syncGatewayPorts := ports.SyncGatewayPorts(4984, 4985)
+
+	switch examplesFolderName {
+	case "couchbase-cluster-simple":
+		allPorts := append(couchbasePorts.ForServices(ports.ServiceCluster, ports.ServiceData, ports.ServiceIndex, ports.ServiceQuery, ports.ServiceFts), syncGatewayPorts...)
+		return map[string]ports.PortMatrix{
+			fmt.Sprintf("%s-0", containerBaseName): allPorts,
+		}
+	case "couchbase-cluster-mds":
+		return map[string]ports.PortMatrix{
+			fmt.Sprintf("%s-data-0", containerBaseName):               couchbasePorts.ForServices(ports.ServiceCluster, ports.ServiceData),
+			fmt.Sprintf("%s-index-query-search-0", containerBaseName): couchbasePorts.ForServices(ports.ServiceCluster, ports.ServiceIndex, ports.ServiceQuery, ports.ServiceFts),
+			fmt.Sprintf("%s-sync-gateway-0", containerBaseName):       syncGatewayPorts,
+		}
+	default:
+		t.Fatalf("Don't know which ports the containers of example %s listen on", examplesFolderName)
+		return nil
+	}
+}
+
+// Check that the given Docker container is listening on all the ports in the given port matrix. We read the listening
+// sockets from /proc/net, rather than trying to connect to each port, as some of the ports, such as the XDCR port, only
+// listen on localhost within the container.
+func checkPortsAreListeningInDocker(t *testing.T, containerName string, matrix ports.PortMatrix) {
+	maxRetries := 30
+	sleepBetweenRetries := 5 * time.Second
+
+	doWithRetry(t, fmt.Sprintf("Checking ports are listening in container %s", containerName), maxRetries, sleepBetweenRetries, func() (string, error) {
+		procNetTcp, err := shell.RunCommandAndGetStdOutE(t, shell.Command{
+			Command: "docker",
+			Args:    []string{"exec", containerName, "cat", "/proc/net/tcp", "/proc/net/tcp6"},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		listeningPorts, err := ports.ParseListeningPorts(procNetTcp)
+		if err != nil {
+			return "", err
+		}
+
+		notListening := ports.NotListening(matrix, listeningPorts)
+		if len(notListening) > 0 {
+			return "", UnexpectedValueErr{Description: fmt.Sprintf("listening ports in container %s", containerName), Expected: fmt.Sprintf("to include %v", notListening), Actual: listeningPorts}
+		}
+
+		logger.Logf(t, "Container %s is listening on all the expected ports", containerName)
+		return "", nil
+	})
+}
@@ -0,0 +1,252 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+// An RBAC user, as defined in the config file passed to run-couchbase-server via --rbac-users-config
+type RbacUser struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+// The RBAC users the couchbase-cluster-simple example creates via --rbac-users-config, with the given passwords
+func rbacUsersForTest(passwords TestUserPasswords) []RbacUser {
+	return []RbacUser{
+		{Username: "test-app", Password: passwords.AppUser, Roles: []string{"bucket_full_access[*]"}},
+		{Username: "test-monitor", Password: passwords.MonitorUser, Roles: []string{"ro_admin"}},
+	}
+}
+
+// A single request we make against the Couchbase REST API to check whether a user is allowed to perform some action.
+// GrantedBy is our own, hand-written list of the roles that allow the action, taken from the role reference at
+// https://docs.couchbase.com/server/current/learn/security/roles.html, so the test catches a user that was provisioned
+// with the wrong roles, or a role that grants more than we expect. For bucket-scoped actions, BucketName is the bucket
+// the request targets, and a role only grants access if it is scoped to that bucket or to all buckets (*).
+type RbacProbe struct {
+	Description string
+	Method      string
+	Path        string
+	Params      url.Values
+	BucketName  string
+	GrantedBy   []string
+}
+
+// A partial representation of the JSON structure returned by the users API:
+// https://docs.couchbase.com/server/current/rest-api/rbac.html
+type RbacUserResponse struct {
+	Id    string         `json:"id"`
+	Roles []RbacUserRole `json:"roles"`
+}
+
+type RbacUserRole struct {
+	Role       string `json:"role"`
+	BucketName string `json:"bucket_name"`
+}
+
+// Return the probes we use to check what a user can and cannot do. Note that the "create bucket" and "create a user"
+// probes intentionally send invalid requests, so users that are allowed to create buckets or users get back a 400 rather
+// than actually creating anything. Couchbase checks permissions before it validates the request, so users that are not
+// allowed get back a 403.
+func defaultRbacProbes(bucketName string, key string) []RbacProbe {
+	docPath := fmt.Sprintf("/pools/default/buckets/%s/docs/%s", bucketName, key)
+
+	return []RbacProbe{
+		{
+			Description: fmt.Sprintf("read document %s from bucket %s", key, bucketName),
+			Method:      "GET",
+			Path:        docPath,
+			BucketName:  bucketName,
+			GrantedBy:   []string{"admin", "bucket_full_access", "data_reader"},
+		},
+		{
+			// ro_admin can read the cluster settings, but no documents, and can't write anything
+			Description: fmt.Sprintf("write document %s to bucket %s", key, bucketName),
+			Method:      "POST",
+			Path:        docPath,
+			Params:      url.Values{"value": {`{"foo":"rbac-test"}`}},
+			BucketName:  bucketName,
+			GrantedBy:   []string{"admin", "bucket_full_access", "data_writer"},
+		},
+		{
+			// https://docs.couchbase.com/server/current/rest-api/rest-xdcr-get-ref.html
+			Description: "list XDCR remote cluster references",
+			Method:      "GET",
+			Path:        "/pools/default/remoteClusters",
+			GrantedBy:   []string{"admin", "cluster_admin", "ro_admin", "replication_admin"},
+		},
+		{
+			// https://docs.couchbase.com/server/current/rest-api/rest-bucket-create.html
+			Description: "create a bucket",
+			Method:      "POST",
+			Path:        "/pools/default/buckets",
+			Params:      url.Values{"name": {""}},
+			GrantedBy:   []string{"admin", "cluster_admin"},
+		},
+		{
+			// https://docs.couchbase.com/server/current/rest-api/rbac.html. None of the bucket roles, not even
+			// bucket_full_access[*], allow managing users.
+			Description: "create a user",
+			Method:      "PUT",
+			Path:        fmt.Sprintf("/settings/rbac/users/local/%s", key),
+			Params:      url.Values{"roles": {"no_such_role"}, "password": {random.UniqueId()}},
+			GrantedBy:   []string{"admin", "security_admin"},
+		},
+	}
+}
+
+// Returns true if any of the given roles grants the permission checked by the given probe
+func rbacRolesGrantProbe(roles []string, probe RbacProbe) bool {
+	for _, role := range roles {
+		roleName, roleBucket := parseRbacRole(role)
+
+		for _, grantedBy := range probe.GrantedBy {
+			if roleName != grantedBy {
+				continue
+			}
+
+			if probe.BucketName == "" || roleBucket == "" || roleBucket == "*" || roleBucket == probe.BucketName {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Parse a role of the format name[bucket] into its name and bucket. The bucket is empty for roles that are not scoped
+// to a bucket.
+func parseRbacRole(role string) (string, string) {
+	openBracket := strings.Index(role, "[")
+	if openBracket < 0 || !strings.HasSuffix(role, "]") {
+		return role, ""
+	}
+
+	return role[:openBracket], role[openBracket+1 : len(role)-1]
+}
+
+// Check that the given RBAC user exists in the cluster and has exactly the expected roles. The lookup is done using the
+// given admin credentials.
+func checkRbacUserExists(t *testing.T, clusterUrl string, adminCredentials CouchbaseCredentials, user RbacUser) {
+	if err := checkRbacUserExistsE(testContext(t), clusterUrl, adminCredentials, user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRbacUserExistsE(ctx context.Context, clusterUrl string, adminCredentials CouchbaseCredentials, user RbacUser) error {
+	description := fmt.Sprintf("Looking up RBAC user %s", user.Username)
+	maxRetries := 60
+	sleepBetweenRetries := 5 * time.Second
+
+	// https://docs.couchbase.com/server/current/rest-api/rbac.html
+	userUrl := fmt.Sprintf("%s/settings/rbac/users/local/%s", clusterUrl, user.Username)
+
+	body, err := pollE(ctx, description, maxRetries, sleepBetweenRetries, func() (string, error) {
+		statusCode, body, err := httpDoE(ctx, "GET", userUrl, nil, &adminCredentials, nil)
+		if err != nil {
+			return "", err
+		}
+
+		if statusCode != 200 {
+			return "", HttpStatusErr{Url: userUrl, Expected: 200, Actual: statusCode, Body: body}
+		}
+
+		return body, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var userResponse RbacUserResponse
+	if err := json.Unmarshal([]byte(body), &userResponse); err != nil {
+		return ParseErr{Url: userUrl, Body: body, Underlying: err}
+	}
+
+	actualRoles := []string{}
+	for _, role := range userResponse.Roles {
+		if role.BucketName == "" {
+			actualRoles = append(actualRoles, role.Role)
+		} else {
+			actualRoles = append(actualRoles, fmt.Sprintf("%s[%s]", role.Role, role.BucketName))
+		}
+	}
+
+	// The order of the roles doesn't matter
+	expectedRoles := append([]string{}, user.Roles...)
+	sort.Strings(expectedRoles)
+	sort.Strings(actualRoles)
+
+	if !reflect.DeepEqual(expectedRoles, actualRoles) {
+		return UnexpectedValueErr{Description: fmt.Sprintf("roles for RBAC user %s", user.Username), Expected: expectedRoles, Actual: actualRoles}
+	}
+	return nil
+}
+
+// Check that the given RBAC user can perform exactly the actions its roles allow and is denied every other action
+func checkRbacUserPermissions(t *testing.T, clusterUrl string, user RbacUser, probes []RbacProbe) {
+	if err := checkRbacUserPermissionsE(testContext(t), clusterUrl, user, probes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRbacUserPermissionsE(ctx context.Context, clusterUrl string, user RbacUser, probes []RbacProbe) error {
+	credentials := CouchbaseCredentials{Username: user.Username, Password: user.Password}
+
+	for _, probe := range probes {
+		expectAllowed := rbacRolesGrantProbe(user.Roles, probe)
+		probeUrl := fmt.Sprintf("%s%s", clusterUrl, probe.Path)
+
+		logf(ctx, "Checking that user %s with roles %v is allowed to %s: %t", user.Username, user.Roles, probe.Description, expectAllowed)
+
+		statusCode, body, err := httpDoE(ctx, probe.Method, probeUrl, nil, &credentials, probe.Params)
+		if err != nil {
+			return HttpRequestErr{Url: probeUrl, Underlying: err}
+		}
+
+		// Couchbase returns 401 if the credentials are wrong and 403 if the user lacks the required permissions
+		if statusCode == 401 {
+			return UnexpectedResponseErr{Url: probeUrl, Reason: fmt.Sprintf("got a 401 when trying to %s as user %s. Are the credentials correct?", probe.Description, user.Username), Body: body}
+		}
+
+		actualAllowed := statusCode != 403
+		if actualAllowed != expectAllowed {
+			return UnexpectedResponseErr{Url: probeUrl, Reason: fmt.Sprintf("expected user %s with roles %v to be allowed to %s: %t, but got status code %d", user.Username, user.Roles, probe.Description, expectAllowed, statusCode), Body: body}
+		}
+	}
+
+	return nil
+}
+
+// Check that each of the given RBAC users exists with the expected roles and has exactly the permissions those roles
+// allow on the given bucket
+func checkRbacUsersWorking(t *testing.T, clusterUrl string, adminCredentials CouchbaseCredentials, bucketName string, users []RbacUser) {
+	if err := checkRbacUsersWorkingE(testContext(t), clusterUrl, adminCredentials, bucketName, users); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRbacUsersWorkingE(ctx context.Context, clusterUrl string, adminCredentials CouchbaseCredentials, bucketName string, users []RbacUser) error {
+	probes := defaultRbacProbes(bucketName, fmt.Sprintf("rbac-test-key-%s", random.UniqueId()))
+
+	for _, user := range users {
+		if err := checkRbacUserExistsE(ctx, clusterUrl, adminCredentials, user); err != nil {
+			return err
+		}
+		if err := checkRbacUserPermissionsE(ctx, clusterUrl, user, probes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The Docker Compose files that run the couchbase-cluster-simple example with the data dir of couchbase-1 in a named
+// volume
+var reattachVolumeComposeFiles = []string{"-f", "docker-compose.yml", "-f", "docker-compose.reattach-volume.yml"}
+
+func TestUnitCouchbaseReattachedVolumeInDocker(t *testing.T) {
+	t.Parallel()
+
+	// Note that these ports are different from the ones in the other Docker tests, as all these tests run in parallel
+	t.Run("TestUnitCouchbaseReattachedVolumeUbuntu16InDocker", func(t *testing.T) {
+		t.Parallel()
+		skipInCircleCi(t)
+		testCouchbaseReattachedVolumeInDocker(t, "ubuntu", "community", 2091, 2184, CouchbaseAlternatePorts{Memcached: 2210, WebConsoleNode1: 2191, MemcachedNode1: 2310})
+	})
+}
+
+// Replace the couchbase-1 container of the couchbase-cluster-simple example with a new container that has a new IP, but
+// the same data dir, and check that run-couchbase-server notices the hostname change and replaces the old node in the
+// cluster with the new one
+func testCouchbaseReattachedVolumeInDocker(t *testing.T, osName string, edition string, couchbaseWebConsolePort int, syncGatewayWebConsolePort int, alternatePorts CouchbaseAlternatePorts) {
+	example := setupExampleInDocker(t, dockerExampleSettings{
+		Example:         "couchbase-cluster-simple",
+		Os:              osName,
+		Edition:         edition,
+		WebConsolePort:  couchbaseWebConsolePort,
+		SyncGatewayPort: syncGatewayWebConsolePort,
+		AlternatePorts:  &alternatePorts,
+		ComposeFiles:    reattachVolumeComposeFiles,
+	})
+
+	node1ContainerName := fmt.Sprintf("%s-1", example.ContainerBaseName)
+	placeholderContainerName := fmt.Sprintf("%s-placeholder", example.ContainerBaseName)
+
+	// Deferred calls run before the teardown of the fixture, which can only remove the network once the placeholder
+	// container is gone
+	defer shell.RunCommandE(t, shell.Command{Command: "docker", Args: []string{"rm", "--force", placeholderContainerName}})
+
+	dataNodesUrl := example.DataNodesUrl
+	testData := TestData{Foo: "reattached-volume", Bar: 42}
+
+	var oldNode1Ip string
+
+	runTestStage(t, "validate_cluster", func() {
+		checkCouchbaseConsoleIsRunning(t, example.ConsoleUrl)
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrl, 2)
+		writeToBucket(t, dataNodesUrl, "test-bucket", "reattached-volume", testData)
+
+		oldNode1Ip = getDockerContainerIp(t, node1ContainerName)
+		checkNodeIsInCluster(t, dataNodesUrl, oldNode1Ip, true)
+	})
+
+	runTestStage(t, "replace_container", func() {
+		example.compose(t, "rm", "--stop", "--force", "couchbase-1")
+
+		// Docker may hand the IP of the removed container to the next container it starts, so start a placeholder
+		// container to take that IP, and make sure the new couchbase-1 container gets a different one
+		network := getDockerContainerNetwork(t, example.ContainerBaseName+"-0")
+		shell.RunCommand(t, shell.Command{
+			Command: "docker",
+			Args:    []string{"run", "--detach", "--name", placeholderContainerName, "--network", network, "--entrypoint", "sleep", fmt.Sprintf("gruntwork/couchbase-%s-test", osName), "3600"},
+		})
+
+		example.compose(t, "up", "-d", "couchbase-1")
+	})
+
+	runTestStage(t, "validate_replacement", func() {
+		newNode1Ip := getDockerContainerIp(t, node1ContainerName)
+		require.NotEqual(t, oldNode1Ip, newNode1Ip, "Expected the new couchbase-1 container to get a new IP")
+
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrl, 2)
+		checkNodeIsInCluster(t, dataNodesUrl, newNode1Ip, true)
+		checkNodeIsInCluster(t, dataNodesUrl, oldNode1Ip, false)
+
+		actualData := readFromBucket(t, dataNodesUrl, "test-bucket", "reattached-volume")
+		assert.Equal(t, testData, actualData)
+	})
+}
+
+// Return the IP address of the given Docker container
+func getDockerContainerIp(t *testing.T, containerName string) string {
+	return inspectDockerContainer(t, containerName, "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}")
+}
+
+// Return the name of the Docker network the given Docker container is connected to
+func getDockerContainerNetwork(t *testing.T, containerName string) string {
+	return inspectDockerContainer(t, containerName, "{{range $name, $network := .NetworkSettings.Networks}}{{$name}}{{end}}")
+}
+
+func inspectDockerContainer(t *testing.T, containerName string, format string) string {
+	output := shell.RunCommandAndGetStdOut(t, shell.Command{
+		Command: "docker",
+		Args:    []string{"inspect", "--format", format, containerName},
+	})
+	return strings.TrimSpace(output)
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/shell"
+)
+
+// Rotate the password of the RBAC user Sync Gateway uses to connect to Couchbase in the couchbase-cluster-simple
+// example and check that Sync Gateway comes back up with the new password on every node
+func testRotateSyncGatewayUserPasswordInDocker(t *testing.T, osName string, edition string, couchbaseWebConsolePort int, syncGatewayWebConsolePort int, alternatePorts CouchbaseAlternatePorts) {
+	example := setupExampleInDocker(t, dockerExampleSettings{
+		Example:         "couchbase-cluster-simple",
+		Os:              osName,
+		Edition:         edition,
+		WebConsolePort:  couchbaseWebConsolePort,
+		SyncGatewayPort: syncGatewayWebConsolePort,
+		AlternatePorts:  &alternatePorts,
+	})
+
+	// These are the credentials the couchbase-cluster-simple example configures for Sync Gateway
+	syncGatewayUsername := "test-user"
+	oldSyncGatewayPassword := example.TestUserPasswords.TestUser
+	newSyncGatewayPassword := newRandomPasswordForTest()
+
+	// couchbase-rotate-password only passes a reference to the new password to docker exec, so write it to the mock
+	// secrets folder, which both the tool on this machine and the containers can read
+	mockSecretsDir := example.EnvVars["MOCK_SECRETS_DIR"]
+	newSyncGatewayPasswordReference := writeMockSecretForDocker(t, mockSecretsDir, "couchbase/sync-gateway-password-v2", newSyncGatewayPassword)
+
+	syncGatewayUrl := fmt.Sprintf("http://localhost:%d/mock-couchbase-asg", syncGatewayWebConsolePort)
+
+	runTestStage(t, "validation_before_rotation", func() {
+		checkCouchbaseClusterIsInitialized(t, example.DataNodesUrl, 2)
+		checkSyncGatewayWorking(t, syncGatewayUrl)
+	})
+
+	runTestStage(t, "rotate", func() {
+		runRotatePassword(t,
+			"--cluster-url", example.ConsoleUrl,
+			"--username", example.Credentials.Username,
+			"--password", example.Credentials.Password,
+			"--user", syncGatewayUsername,
+			"--new-password", newSyncGatewayPasswordReference,
+			"--local-dir", mockSecretsDir,
+			"--sync-gateway-executor", "docker",
+			"--sync-gateway-local-dir", "/opt/mock-secrets",
+			"--sync-gateway-node", fmt.Sprintf("%s-0", example.ContainerBaseName),
+			"--sync-gateway-node", fmt.Sprintf("%s-1", example.ContainerBaseName),
+		)
+	})
+
+	runTestStage(t, "validation_after_rotation", func() {
+		checkCredentialsAreValid(t, example.ConsoleUrl, CouchbaseCredentials{Username: syncGatewayUsername, Password: newSyncGatewayPassword}, true)
+		checkCredentialsAreValid(t, example.ConsoleUrl, CouchbaseCredentials{Username: syncGatewayUsername, Password: oldSyncGatewayPassword}, false)
+
+		// Sync Gateway only comes back online if it can connect to its bucket with the new password
+		checkSyncGatewayWorking(t, syncGatewayUrl)
+	})
+}
+
+// Rotate the admin password of the replica cluster in the couchbase-multi-datacenter-replication example and check
+// that the primary cluster's XDCR remote cluster reference picks up the new password, so replication keeps working
+func testRotateReplicationAdminPasswordInDocker(t *testing.T, osName string, edition string, couchbaseWebConsolePortEast int, couchbaseWebConsolePortWest int) {
+	example := setupExampleInDocker(t, dockerExampleSettings{
+		Example: "couchbase-multi-datacenter-replication",
+		Os:      osName,
+		Edition: edition,
+		EnvVars: map[string]string{
+			"WEB_CONSOLE_EAST_PORT": strconv.Itoa(couchbaseWebConsolePortEast),
+			"WEB_CONSOLE_WEST_PORT": strconv.Itoa(couchbaseWebConsolePortWest),
+		},
+	})
+
+	credentials := example.Credentials
+	newCredentialsWest := CouchbaseCredentials{
+		Username: credentials.Username,
+		Password: newRandomPasswordForTest(),
+	}
+
+	consoleUrlEast := fmt.Sprintf("http://localhost:%d", couchbaseWebConsolePortEast)
+	consoleUrlWest := fmt.Sprintf("http://localhost:%d", couchbaseWebConsolePortWest)
+
+	dataNodesUrlEast := formatUrlWithCredentials("http", credentials, fmt.Sprintf("localhost:%d", couchbaseWebConsolePortEast))
+
+	runTestStage(t, "validation_before_rotation", func() {
+		dataNodesUrlWest := formatUrlWithCredentials("http", credentials, fmt.Sprintf("localhost:%d", couchbaseWebConsolePortWest))
+
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrlEast, 2)
+		checkCouchbaseClusterIsInitialized(t, dataNodesUrlWest, 2)
+		checkReplicationIsWorking(t, dataNodesUrlEast, dataNodesUrlWest, "test-bucket", "test-bucket-replica")
+	})
+
+	runTestStage(t, "rotate", func() {
+		runRotatePassword(t,
+			"--cluster-url", consoleUrlWest,
+			"--username", credentials.Username,
+			"--password", credentials.Password,
+			"--new-password", newCredentialsWest.Password,
+			"--xdcr-source-cluster-url", consoleUrlEast,
+		)
+	})
+
+	runTestStage(t, "validation_after_rotation", func() {
+		checkCredentialsAreValid(t, consoleUrlWest, newCredentialsWest, true)
+		checkCredentialsAreValid(t, consoleUrlWest, credentials, false)
+
+		// The east cluster's admin password should not have changed
+		checkCredentialsAreValid(t, consoleUrlEast, credentials, true)
+
+		// New writes only make it to the west cluster if the XDCR remote cluster reference uses the new password
+		dataNodesUrlWest := formatUrlWithCredentials("http", newCredentialsWest, fmt.Sprintf("localhost:%d", couchbaseWebConsolePortWest))
+		checkReplicationIsWorking(t, dataNodesUrlEast, dataNodesUrlWest, "test-bucket", "test-bucket-replica")
+	})
+}
+
+// Build couchbase-rotate-password for the OS the tests are running on and run its rotate command with the given args,
+// failing the test if the rotation fails. Any args that hold passwords must be plaintext, or references to secrets the
+// machine running the tests can resolve.
+func runRotatePassword(t *testing.T, args ...string) {
+	tmpDir, err := ioutil.TempDir("", "couchbase-rotate-password")
+	if err != nil {
+		t.Fatalf("Failed to create temp folder: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	binaryPath := buildCouchbaseToolForHost(t, "couchbase-rotate-password", tmpDir)
+
+	shell.RunCommand(t, shell.Command{
+		Command: binaryPath,
+		Args:    append([]string{"rotate"}, args...),
+	})
+}
@@ -0,0 +1,264 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+	"github.com/gruntwork-io/terratest/modules/random"
+)
+
+// The ports on the host that Docker Compose maps to the Data Service (KV) of the first node in the
+// couchbase-cluster-simple example, and to the REST API and Data Service of the second node. The nodes advertise these
+// ports, along with the REST API port of the first node, as their alternate addresses, so the Couchbase SDKs can reach
+// every node from the host.
+type CouchbaseAlternatePorts struct {
+	Memcached       int
+	WebConsoleNode1 int
+	MemcachedNode1  int
+}
+
+// Return the env vars to pass to Docker Compose so it maps the given alternate ports
+func (ports CouchbaseAlternatePorts) envVars() map[string]string {
+	return map[string]string{
+		"MEMCACHED_PORT":          strconv.Itoa(ports.Memcached),
+		"WEB_CONSOLE_PORT_NODE_1": strconv.Itoa(ports.WebConsoleNode1),
+		"MEMCACHED_PORT_NODE_1":   strconv.Itoa(ports.MemcachedNode1),
+	}
+}
+
+// A test document we write to and read back from a bucket via the Couchbase SDK
+type sdkTestDocument struct {
+	Id      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// Check that we can write a document to the given bucket and read it back with the Couchbase Go SDK, connecting to the
+// Data Service at the given host and port and then talking to every node via its alternate address. Unlike the docs
+// REST API the other helpers use, this is the same client path our applications use.
+func checkKvWorkingViaSdk(t *testing.T, host string, memcachedPort int, bucketName string, credentials CouchbaseCredentials) {
+	if err := checkKvWorkingViaSdkE(testContext(t), host, memcachedPort, bucketName, credentials); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkKvWorkingViaSdkE(ctx context.Context, host string, memcachedPort int, bucketName string, credentials CouchbaseCredentials) error {
+	// network=external makes the SDK use the alternate addresses of the nodes from the cluster map, rather than their
+	// internal hostnames, which are not reachable from the host in Docker
+	connectionString := fmt.Sprintf("couchbase://%s:%d?network=external", host, memcachedPort)
+
+	maxRetries := 30
+	sleepBetweenRetries := 10 * time.Second
+
+	_, err := pollE(ctx, fmt.Sprintf("Writing and reading a document in bucket %s via the Couchbase SDK at %s", bucketName, connectionString), maxRetries, sleepBetweenRetries, func() (string, error) {
+		return "", writeAndReadDocumentViaSdk(ctx, connectionString, bucketName, credentials)
+	})
+	return err
+}
+
+func writeAndReadDocumentViaSdk(ctx context.Context, connectionString string, bucketName string, credentials CouchbaseCredentials) error {
+	cluster, err := gocb.Connect(connectionString, gocb.ClusterOptions{
+		Username: credentials.Username,
+		Password: credentials.Password,
+	})
+	if err != nil {
+		return err
+	}
+	defer cluster.Close(nil)
+
+	bucket := cluster.Bucket(bucketName)
+	if err := bucket.WaitUntilReady(30*time.Second, &gocb.WaitUntilReadyOptions{ServiceTypes: []gocb.ServiceType{gocb.ServiceTypeKeyValue}}); err != nil {
+		return err
+	}
+
+	collection := bucket.DefaultCollection()
+
+	key := fmt.Sprintf("sdk-test-%s", random.UniqueId())
+	expected := sdkTestDocument{Id: key, Message: "Written via the Couchbase SDK"}
+
+	logf(ctx, "Writing document %s to bucket %s via the Couchbase SDK", key, bucketName)
+	if _, err := collection.Upsert(key, expected, nil); err != nil {
+		return err
+	}
+
+	result, err := collection.Get(key, nil)
+	if err != nil {
+		return err
+	}
+
+	var actual sdkTestDocument
+	if err := result.Content(&actual); err != nil {
+		return err
+	}
+
+	if actual != expected {
+		return UnexpectedValueErr{Description: fmt.Sprintf("document %s in bucket %s", key, bucketName), Expected: expected, Actual: actual}
+	}
+
+	logf(ctx, "Read document %s back from bucket %s via the Couchbase SDK", key, bucketName)
+	return nil
+}
+
+// A partial representation of the JSON report couchbase-loadgen writes to stdout
+type LoadgenReport struct {
+	Total struct {
+		Count  int64 `json:"count"`
+		Errors int64 `json:"errors"`
+		Misses int64 `json:"misses"`
+	} `json:"total"`
+}
+
+// Run a short workload with couchbase-loadgen against the given bucket, connecting to the Data Service at the given host
+// and port via alternate addresses, and check that every operation succeeded
+func checkLoadGeneratorWorking(t *testing.T, host string, memcachedPort int, bucketName string, credentials CouchbaseCredentials) {
+	if err := checkLoadGeneratorWorkingE(testContext(t), host, memcachedPort, bucketName, credentials); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkLoadGeneratorWorkingE(ctx context.Context, host string, memcachedPort int, bucketName string, credentials CouchbaseCredentials) error {
+	tmpDir, err := ioutil.TempDir("", "couchbase-loadgen")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	binaryPath, err := buildCouchbaseToolForHostE(ctx, "couchbase-loadgen", tmpDir)
+	if err != nil {
+		return err
+	}
+
+	output, err := runCommandE(ctx, "", binaryPath,
+		"run",
+		"--connection-string", fmt.Sprintf("couchbase://%s:%d?network=external", host, memcachedPort),
+		"--username", credentials.Username,
+		"--password", credentials.Password,
+		"--bucket", bucketName,
+		"--workers", "4",
+		"--duration", "15s",
+		"--keys", "500",
+		"--mix", "get=70,upsert=30",
+		"--populate",
+		"--connect-timeout", "2m",
+	)
+	if err != nil {
+		return err
+	}
+
+	var report LoadgenReport
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return ParseErr{Url: binaryPath, Body: output, Underlying: err}
+	}
+
+	if report.Total.Count == 0 {
+		return UnexpectedValueErr{Description: "number of couchbase-loadgen operations", Expected: "more than 0", Actual: report.Total.Count}
+	}
+	if report.Total.Errors != 0 {
+		return UnexpectedValueErr{Description: "number of failed couchbase-loadgen operations", Expected: 0, Actual: report.Total.Errors}
+	}
+	// couchbase-loadgen populated every key and ran no deletes, so every get should find its key
+	if report.Total.Misses != 0 {
+		return UnexpectedValueErr{Description: "number of couchbase-loadgen gets of missing keys", Expected: 0, Actual: report.Total.Misses}
+	}
+
+	logf(ctx, "couchbase-loadgen ran %d operations without errors", report.Total.Count)
+	return nil
+}
+
+// The common flags the Couchbase SDKs use for JSON documents, with an extra bit set, so we can tell the flags we passed
+// to couchbase-dataset apart from the default ones
+const datasetTestFlags = 0x02000001
+
+// A document we seed into a bucket with couchbase-dataset
+type datasetTestDocument struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Seed a few documents from a JSON Lines file into the given bucket with couchbase-dataset, connecting to the Data
+// Service at the given host and port via alternate addresses, and check via the REST API at dataNodesUrl that each
+// document landed under the key from the key template, with the expiry and flags we asked for. We don't check the
+// export command here, as it needs the Query service, which the Docker cluster does not expose on the host.
+func checkDatasetSeedingWorking(t *testing.T, dataNodesUrl string, host string, memcachedPort int, bucketName string, credentials CouchbaseCredentials) {
+	if err := checkDatasetSeedingWorkingE(testContext(t), dataNodesUrl, host, memcachedPort, bucketName, credentials); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkDatasetSeedingWorkingE(ctx context.Context, dataNodesUrl string, host string, memcachedPort int, bucketName string, credentials CouchbaseCredentials) error {
+	tmpDir, err := ioutil.TempDir("", "couchbase-dataset")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	binaryPath, err := buildCouchbaseToolForHostE(ctx, "couchbase-dataset", tmpDir)
+	if err != nil {
+		return err
+	}
+
+	uniqueId := random.UniqueId()
+	documents := []datasetTestDocument{}
+	lines := ""
+	for i := 1; i <= 3; i++ {
+		document := datasetTestDocument{Id: i, Name: fmt.Sprintf("dataset-%s-%d", uniqueId, i)}
+		line, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+
+		documents = append(documents, document)
+		lines += string(line) + "\n"
+	}
+
+	inputPath := filepath.Join(tmpDir, "documents.jsonl")
+	if err := ioutil.WriteFile(inputPath, []byte(lines), 0644); err != nil {
+		return err
+	}
+
+	_, err = runCommandE(ctx, "", binaryPath,
+		"seed",
+		"--connection-string", fmt.Sprintf("couchbase://%s:%d?network=external", host, memcachedPort),
+		"--username", credentials.Username,
+		"--password", credentials.Password,
+		"--bucket", bucketName,
+		"--file", inputPath,
+		"--key-template", fmt.Sprintf("dataset-%s::{id}", uniqueId),
+		"--expiry", "1h",
+		"--flags", strconv.Itoa(datasetTestFlags),
+		"--checkpoint-file", filepath.Join(tmpDir, "checkpoint.json"),
+		"--connect-timeout", "2m",
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, expected := range documents {
+		key := fmt.Sprintf("dataset-%s::%d", uniqueId, expected.Id)
+
+		var actual datasetTestDocument
+		meta, err := readDocumentFromBucketE(ctx, dataNodesUrl, bucketName, key, &actual)
+		if err != nil {
+			return err
+		}
+
+		if actual != expected {
+			return UnexpectedValueErr{Description: fmt.Sprintf("document %s in bucket %s", key, bucketName), Expected: expected, Actual: actual}
+		}
+		if meta.Flags != datasetTestFlags {
+			return UnexpectedValueErr{Description: fmt.Sprintf("flags of document %s in bucket %s", key, bucketName), Expected: datasetTestFlags, Actual: meta.Flags}
+		}
+		if meta.Expiration == 0 {
+			return UnexpectedValueErr{Description: fmt.Sprintf("expiration of document %s in bucket %s", key, bucketName), Expected: "an expiration", Actual: meta.Expiration}
+		}
+	}
+
+	return nil
+}
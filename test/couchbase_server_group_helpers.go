@@ -0,0 +1,148 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// The response from /pools/default/serverGroups:
+// https://docs.couchbase.com/server/current/rest-api/rest-servergroup-get.html
+type ServerGroupsResponse struct {
+	Groups []ServerGroup `json:"groups"`
+}
+
+type ServerGroup struct {
+	Name  string       `json:"name"`
+	Nodes []ServerNode `json:"nodes"`
+}
+
+// The parts of the response from /pools/default/buckets/<BUCKET> that describe where each vBucket lives. Each entry in
+// VBucketMap lists the indices in ServerList of the active copy of a vBucket, followed by its replicas, with -1 for a
+// replica that doesn't exist (yet).
+type BucketVBucketsResponse struct {
+	VBucketServerMap struct {
+		ServerList []string `json:"serverList"`
+		VBucketMap [][]int  `json:"vBucketMap"`
+	} `json:"vBucketServerMap"`
+}
+
+// Check that the given Couchbase cluster places every copy of every vBucket in the given bucket in a different server
+// group, which is the case once run-couchbase-server has put each node in the server group of its availability zone and
+// the cluster has rebalanced
+func checkReplicasInDifferentServerGroups(t *testing.T, clusterUrl string, bucketName string) {
+	if err := checkReplicasInDifferentServerGroupsE(testContext(t), clusterUrl, bucketName); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkReplicasInDifferentServerGroupsE(ctx context.Context, clusterUrl string, bucketName string) error {
+	maxRetries := 60
+	sleepBetweenRetries := 5 * time.Second
+
+	serverGroupsUrl := fmt.Sprintf("%s/pools/default/serverGroups", clusterUrl)
+	bucketUrl := fmt.Sprintf("%s/pools/default/buckets/%s", clusterUrl, bucketName)
+	description := fmt.Sprintf("Checking that the replicas of bucket %s are in different server groups", bucketName)
+
+	out, err := pollE(ctx, description, maxRetries, sleepBetweenRetries, func() (string, error) {
+		var serverGroups ServerGroupsResponse
+		if err := httpGetJsonE(ctx, serverGroupsUrl, &serverGroups); err != nil {
+			return "", err
+		}
+
+		var bucket BucketVBucketsResponse
+		if err := httpGetJsonE(ctx, bucketUrl, &bucket); err != nil {
+			return "", err
+		}
+
+		return checkVBucketServerGroups(bucketName, serverGroups, bucket)
+	})
+	if err != nil {
+		return err
+	}
+
+	logf(ctx, out)
+	return nil
+}
+
+// Check that no two copies of a vBucket in the given vBucket map are in the same server group, and that there is at
+// least one replica, as otherwise the check would pass trivially
+func checkVBucketServerGroups(bucketName string, serverGroups ServerGroupsResponse, bucket BucketVBucketsResponse) (string, error) {
+	// The server groups list nodes by their REST API address, while the vBucket map lists them by their Data Service
+	// address, so we match them by host
+	serverGroupForHost := map[string]string{}
+	for _, group := range serverGroups.Groups {
+		for _, node := range group.Nodes {
+			serverGroupForHost[hostOf(node.Hostname)] = group.Name
+		}
+	}
+
+	serverList := bucket.VBucketServerMap.ServerList
+	numReplicas := 0
+
+	for vBucket, serverIndices := range bucket.VBucketServerMap.VBucketMap {
+		serverGroupsOfVBucket := map[string]string{}
+
+		for _, serverIndex := range serverIndices {
+			if serverIndex < 0 {
+				continue
+			}
+			if serverIndex >= len(serverList) {
+				return "", VBucketMapErr{Bucket: bucketName, Reason: fmt.Sprintf("vBucket %d refers to server %d, but there are only %d servers", vBucket, serverIndex, len(serverList))}
+			}
+
+			server := serverList[serverIndex]
+			serverGroup, ok := serverGroupForHost[hostOf(server)]
+			if !ok {
+				return "", VBucketMapErr{Bucket: bucketName, Reason: fmt.Sprintf("server %s of vBucket %d is not in any server group", server, vBucket)}
+			}
+
+			if otherServer, inUse := serverGroupsOfVBucket[serverGroup]; inUse {
+				return "", VBucketMapErr{Bucket: bucketName, Reason: fmt.Sprintf("vBucket %d has copies on servers %s and %s, which are both in server group %s", vBucket, otherServer, server, serverGroup)}
+			}
+			serverGroupsOfVBucket[serverGroup] = server
+		}
+
+		numReplicas += len(serverGroupsOfVBucket) - 1
+	}
+
+	if numReplicas <= 0 {
+		return "", VBucketMapErr{Bucket: bucketName, Reason: "it does not have any replicas yet"}
+	}
+
+	return fmt.Sprintf("All %d replicas of the %d vBuckets in bucket %s are in a different server group than the other copies of their vBucket", numReplicas, len(bucket.VBucketServerMap.VBucketMap), bucketName), nil
+}
+
+// Make a GET request to the given URL and decode the JSON response into out
+func httpGetJson(t *testing.T, url string, out interface{}) error {
+	return httpGetJsonE(testContext(t), url, out)
+}
+
+func httpGetJsonE(ctx context.Context, url string, out interface{}) error {
+	statusCode, body, err := httpGetE(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != 200 {
+		return HttpStatusErr{Url: url, Expected: 200, Actual: statusCode, Body: body}
+	}
+
+	if err := json.Unmarshal([]byte(body), out); err != nil {
+		return ParseErr{Url: url, Body: body, Underlying: err}
+	}
+
+	return nil
+}
+
+// Return the host part of the given host:port address, or the address itself if it has no port
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
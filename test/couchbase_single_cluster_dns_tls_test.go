@@ -1,9 +1,11 @@
 package test
 
 import (
+	"fmt"
 	"path/filepath"
 	"testing"
 
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/reaper"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
@@ -17,17 +19,7 @@ const domainNameForTest = "gruntwork.in"
 // filter them down to the real public hosted zone for domainNameForTest.
 var domainNameTags = map[string]string{"original": "true"}
 
-func TestIntegrationCouchbaseCommunitySingleClusterDnsTlsUbuntu16(t *testing.T) {
-	t.Parallel()
-	testCouchbaseSingleClusterDnsTls(t, "ubuntu", "community")
-}
-
-func TestIntegrationCouchbaseCommunitySingleClusterDnsTlsUbuntu18(t *testing.T) {
-	t.Parallel()
-	testCouchbaseSingleClusterDnsTls(t, "ubuntu-18", "community")
-}
-
-func testCouchbaseSingleClusterDnsTls(t *testing.T, osName string, edition string) {
+func testCouchbaseSingleClusterDnsTls(t *testing.T, osName string, edition string, versions CouchbaseVersions, enableNodeCertificates bool, clusterEncryptionLevel string, tlsOnly bool) {
 	// For convenience - uncomment these as well as the "os" import
 	// when doing local testing if you need to skip any sections.
 	//os.Setenv("TERRATEST_REGION", "eu-west-1")
@@ -41,57 +33,91 @@ func testCouchbaseSingleClusterDnsTls(t *testing.T, osName string, edition strin
 	couchbaseAmiDir := filepath.Join(examplesFolder, "couchbase-ami")
 	couchbaseSingleClusterDnsTlsDir := filepath.Join(examplesFolder, "couchbase-cluster-simple-dns-tls")
 
-	test_structure.RunTestStage(t, "setup_ami", func() {
+	runTestStage(t, "setup_ami", func() {
 		awsRegion := getRandomAwsRegion(t)
 		uniqueId := random.UniqueId()
 
-		amiId := buildCouchbaseAmi(t, osName, couchbaseAmiDir, edition, awsRegion, uniqueId)
+		amiId := buildCouchbaseAmi(t, osName, couchbaseAmiDir, edition, versions, awsRegion, uniqueId)
 
 		test_structure.SaveAmiId(t, couchbaseSingleClusterDnsTlsDir, amiId)
 		test_structure.SaveString(t, couchbaseSingleClusterDnsTlsDir, savedAwsRegion, awsRegion)
 		test_structure.SaveString(t, couchbaseSingleClusterDnsTlsDir, savedUniqueId, uniqueId)
 	})
 
-	defer test_structure.RunTestStage(t, "teardown", func() {
+	defer runTestStage(t, "teardown", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseSingleClusterDnsTlsDir)
 		terraform.Destroy(t, terraformOptions)
+		deleteDiagnosticsKeyPair(t, couchbaseSingleClusterDnsTlsDir, savedKeyPair)
 
 		amiId := test_structure.LoadAmiId(t, couchbaseSingleClusterDnsTlsDir)
 		awsRegion := test_structure.LoadString(t, couchbaseSingleClusterDnsTlsDir, savedAwsRegion)
 		aws.DeleteAmi(t, awsRegion, amiId)
 	})
 
-	defer test_structure.RunTestStage(t, "logs", func() {
+	defer runTestStage(t, "logs", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseSingleClusterDnsTlsDir)
 		awsRegion := test_structure.LoadString(t, couchbaseSingleClusterDnsTlsDir, savedAwsRegion)
 		testStageLogs(t, terraformOptions, couchbaseClusterVarName, awsRegion)
+
+		keyPair := loadDiagnosticsKeyPair(t, couchbaseSingleClusterDnsTlsDir, savedKeyPair)
+		collectDiagnosticsFromAsgsOnFailure(t, terraformOptions, awsRegion, keyPair, osName, couchbaseClusterVarName)
 	})
 
-	test_structure.RunTestStage(t, "setup_deploy", func() {
+	runTestStage(t, "setup_deploy", func() {
 		amiId := test_structure.LoadAmiId(t, couchbaseSingleClusterDnsTlsDir)
 		awsRegion := test_structure.LoadString(t, couchbaseSingleClusterDnsTlsDir, savedAwsRegion)
 		uniqueId := test_structure.LoadString(t, couchbaseSingleClusterDnsTlsDir, savedUniqueId)
 
+		keyPair := createDiagnosticsKeyPair(t, couchbaseSingleClusterDnsTlsDir, savedKeyPair, awsRegion, uniqueId)
+
 		terraformOptions := &terraform.Options{
 			TerraformDir: couchbaseSingleClusterDnsTlsDir,
 			Vars: map[string]interface{}{
-				"ami_id":                amiId,
-				"domain_name":           domainNameForTest,
-				"domain_name_tags":      domainNameTags,
-				couchbaseClusterVarName: formatCouchbaseClusterName("single-cluster", uniqueId),
+				"ssh_key_name":             keyPair.Name,
+				"ami_id":                   amiId,
+				"domain_name":              domainNameForTest,
+				"domain_name_tags":         domainNameTags,
+				"enable_node_certificates": enableNodeCertificates,
+				"cluster_encryption_level": clusterEncryptionLevel,
+				"tls_only":                 tlsOnly,
+				couchbaseClusterVarName:    formatCouchbaseClusterName("single-cluster", uniqueId),
+				"custom_tags":              reaper.TestRunTags(uniqueId),
 			},
 			EnvVars: map[string]string{
 				AWS_DEFAULT_REGION_ENV_VAR: awsRegion,
 			},
 		}
 
+		addCredentialsToTerraformVars(terraformOptions.Vars, newRandomCredentialsForTest())
+		addTestUserPasswordsToTerraformVars(terraformOptions.Vars, newRandomTestUserPasswordsForTest())
+
 		test_structure.SaveTerraformOptions(t, couchbaseSingleClusterDnsTlsDir, terraformOptions)
 
 		terraform.InitAndApply(t, terraformOptions)
 	})
 
-	test_structure.RunTestStage(t, "validation", func() {
+	runTestStage(t, "validation", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, couchbaseSingleClusterDnsTlsDir)
-		validateSingleClusterWorks(t, terraformOptions, couchbaseClusterVarName, "https")
+		validateSingleClusterWorks(t, terraformOptions, couchbaseClusterVarName, "https", edition, versions)
+
+		awsRegion := test_structure.LoadString(t, couchbaseSingleClusterDnsTlsDir, savedAwsRegion)
+		credentials := getCredentials(t, terraformOptions)
+
+		if enableNodeCertificates {
+			checkNodeCertificatesInAsg(t, terraformOptions, couchbaseClusterVarName, awsRegion)
+		}
+
+		if clusterEncryptionLevel != "" {
+			consoleUrl := fmt.Sprintf("https://%s", terraform.OutputRequired(t, terraformOptions, "couchbase_web_console_url"))
+			checkNodeToNodeEncryption(t, consoleUrl, nil, credentials, clusterEncryptionLevel)
+		}
+
+		if tlsOnly {
+			caCertPem := []byte(terraform.Output(t, terraformOptions, "couchbase_ca_cert_pem"))
+			for _, publicIp := range getPublicIpsOfCouchbaseNodes(t, terraformOptions, couchbaseClusterVarName, awsRegion) {
+				checkPortsRefuseConnections(t, publicIp, couchbaseNonSslClientPorts)
+				checkTlsPortsServeTraffic(t, publicIp, defaultCouchbaseTlsPorts, caCertPem, credentials)
+			}
+		}
 	})
 }
@@ -4,6 +4,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/reaper"
 	"github.com/gruntwork-io/terratest/modules/aws"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
@@ -12,37 +13,7 @@ import (
 
 const couchbaseClusterVarName = "cluster_name"
 
-func TestIntegrationCouchbaseCommunitySingleClusterUbuntu16(t *testing.T) {
-	t.Parallel()
-	testCouchbaseSingleCluster(t, "ubuntu", "community")
-}
-
-func TestIntegrationCouchbaseCommunitySingleClusterUbuntu18(t *testing.T) {
-	t.Parallel()
-	testCouchbaseSingleCluster(t, "ubuntu-18", "community")
-}
-
-func TestIntegrationCouchbaseCommunitySingleClusterAmazonLinux(t *testing.T) {
-	t.Parallel()
-	testCouchbaseSingleCluster(t, "amazon-linux", "community")
-}
-
-func TestIntegrationCouchbaseEnterpriseSingleClusterUbuntu16(t *testing.T) {
-	t.Parallel()
-	testCouchbaseSingleCluster(t, "ubuntu", "enterprise")
-}
-
-func TestIntegrationCouchbaseEnterpriseSingleClusterUbuntu18(t *testing.T) {
-	t.Parallel()
-	testCouchbaseSingleCluster(t, "ubuntu-18", "enterprise")
-}
-
-func TestIntegrationCouchbaseEnterpriseSingleClusterAmazonLinux(t *testing.T) {
-	t.Parallel()
-	testCouchbaseSingleCluster(t, "amazon-linux", "enterprise")
-}
-
-func testCouchbaseSingleCluster(t *testing.T, osName string, edition string) {
+func testCouchbaseSingleCluster(t *testing.T, osName string, edition string, versions CouchbaseVersions) {
 	// For convenience - uncomment these as well as the "os" import
 	// when doing local testing if you need to skip any sections.
 	//os.Setenv("TERRATEST_REGION", "eu-west-1")
@@ -55,55 +26,66 @@ func testCouchbaseSingleCluster(t *testing.T, osName string, edition string) {
 	rootFolder := test_structure.CopyTerraformFolderToTemp(t, "../", ".")
 	couchbaseAmiDir := filepath.Join(rootFolder, "examples", "couchbase-ami")
 
-	test_structure.RunTestStage(t, "setup_ami", func() {
+	runTestStage(t, "setup_ami", func() {
 		awsRegion := getRandomAwsRegion(t)
 		uniqueId := random.UniqueId()
 
-		amiId := buildCouchbaseAmi(t, osName, couchbaseAmiDir, edition, awsRegion, uniqueId)
+		amiId := buildCouchbaseAmi(t, osName, couchbaseAmiDir, edition, versions, awsRegion, uniqueId)
 
 		test_structure.SaveAmiId(t, rootFolder, amiId)
 		test_structure.SaveString(t, rootFolder, savedAwsRegion, awsRegion)
 		test_structure.SaveString(t, rootFolder, savedUniqueId, uniqueId)
 	})
 
-	defer test_structure.RunTestStage(t, "teardown", func() {
+	defer runTestStage(t, "teardown", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, rootFolder)
 		terraform.Destroy(t, terraformOptions)
+		deleteDiagnosticsKeyPair(t, rootFolder, savedKeyPair)
 
 		amiId := test_structure.LoadAmiId(t, rootFolder)
 		awsRegion := test_structure.LoadString(t, rootFolder, savedAwsRegion)
 		aws.DeleteAmi(t, awsRegion, amiId)
 	})
 
-	defer test_structure.RunTestStage(t, "logs", func() {
+	defer runTestStage(t, "logs", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, rootFolder)
 		awsRegion := test_structure.LoadString(t, rootFolder, savedAwsRegion)
 		testStageLogs(t, terraformOptions, couchbaseClusterVarName, awsRegion)
+
+		keyPair := loadDiagnosticsKeyPair(t, rootFolder, savedKeyPair)
+		collectDiagnosticsFromAsgsOnFailure(t, terraformOptions, awsRegion, keyPair, osName, couchbaseClusterVarName)
 	})
 
-	test_structure.RunTestStage(t, "setup_deploy", func() {
+	runTestStage(t, "setup_deploy", func() {
 		amiId := test_structure.LoadAmiId(t, rootFolder)
 		awsRegion := test_structure.LoadString(t, rootFolder, savedAwsRegion)
 		uniqueId := test_structure.LoadString(t, rootFolder, savedUniqueId)
 
+		keyPair := createDiagnosticsKeyPair(t, rootFolder, savedKeyPair, awsRegion, uniqueId)
+
 		terraformOptions := &terraform.Options{
 			TerraformDir: rootFolder,
 			Vars: map[string]interface{}{
+				"ssh_key_name":          keyPair.Name,
 				"ami_id":                amiId,
 				couchbaseClusterVarName: formatCouchbaseClusterName("single-cluster", uniqueId),
+				"custom_tags":           reaper.TestRunTags(uniqueId),
 			},
 			EnvVars: map[string]string{
 				AWS_DEFAULT_REGION_ENV_VAR: awsRegion,
 			},
 		}
 
+		addCredentialsToTerraformVars(terraformOptions.Vars, newRandomCredentialsForTest())
+		addTestUserPasswordsToTerraformVars(terraformOptions.Vars, newRandomTestUserPasswordsForTest())
+
 		terraform.InitAndApply(t, terraformOptions)
 
 		test_structure.SaveTerraformOptions(t, rootFolder, terraformOptions)
 	})
 
-	test_structure.RunTestStage(t, "validation", func() {
+	runTestStage(t, "validation", func() {
 		terraformOptions := test_structure.LoadTerraformOptions(t, rootFolder)
-		validateSingleClusterWorks(t, terraformOptions, couchbaseClusterVarName, "http")
+		validateSingleClusterWorks(t, terraformOptions, couchbaseClusterVarName, "http", edition, versions)
 	})
 }
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/timeline"
+	http_helper "github.com/gruntwork-io/terratest/modules/http-helper"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+)
+
+var (
+	timelineJsonFlag  = flag.String("timeline.json", "test-timeline.json", "Write how long each test stage and each poll took to this JSON file. Set to an empty string to disable.")
+	timelineJunitFlag = flag.String("timeline.junit", "test-timeline.xml", "Write how long each test stage and each poll took to this JUnit XML file. Set to an empty string to disable.")
+)
+
+// All the tests record their stages and polls in the same timeline, which TestMain writes out once they're all done
+var testTimeline = &timeline.Timeline{}
+
+// Run the given stage with test_structure.RunTestStage, and record how long it took and whether it failed or was
+// skipped in the timeline. Use it in place of test_structure.RunTestStage.
+func runTestStage(t *testing.T, stageName string, stage func()) {
+	start := time.Now()
+	failedBefore := t.Failed()
+	ran := false
+
+	// Deferred, so that we also record stages that fail via t.Fatal, which exits the goroutine, or panic
+	defer func() {
+		recovered := recover()
+
+		testTimeline.Record(timeline.Event{
+			Test:            t.Name(),
+			Kind:            timeline.KindStage,
+			Name:            stageName,
+			Start:           start,
+			DurationSeconds: time.Since(start).Seconds(),
+			Skipped:         !ran,
+			Failed:          recovered != nil || (!failedBefore && t.Failed()),
+			Error:           formatPanic(recovered),
+		})
+
+		if recovered != nil {
+			panic(recovered)
+		}
+	}()
+
+	test_structure.RunTestStage(t, stageName, func() {
+		ran = true
+		stage()
+	})
+}
+
+func formatPanic(recovered interface{}) string {
+	if recovered == nil {
+		return ""
+	}
+	return fmt.Sprintf("panic: %v", recovered)
+}
+
+// Like retry.DoWithRetry, but also records how long the poll took, how many times it retried, and its final error in
+// the timeline
+func doWithRetry(t *testing.T, description string, maxRetries int, sleepBetweenRetries time.Duration, action func() (string, error)) string {
+	out, err := doWithRetryE(t, description, maxRetries, sleepBetweenRetries, action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// Like retry.DoWithRetryE, but also records how long the poll took, how many times it retried, and its final error in
+// the timeline. See pollE.
+func doWithRetryE(t *testing.T, description string, maxRetries int, sleepBetweenRetries time.Duration, action func() (string, error)) (string, error) {
+	return pollE(testContext(t), description, maxRetries, sleepBetweenRetries, action)
+}
+
+// Like http_helper.HttpGetWithRetryWithCustomValidation, but polls via pollE, so the poll is in the timeline
+func httpGetWithRetryWithCustomValidation(t *testing.T, url string, tlsConfig *tls.Config, maxRetries int, sleepBetweenRetries time.Duration, validateResponse func(int, string) bool) {
+	_, err := httpGetWithRetryE(testContext(t), url, tlsConfig, maxRetries, sleepBetweenRetries, func(statusCode int, body string) error {
+		if !validateResponse(statusCode, body) {
+			return http_helper.ValidationFunctionFailed{Url: url, Status: statusCode, Body: body}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Write the timeline of all the tests that ran to the files the flags point to
+func writeTestTimeline() error {
+	if *timelineJsonFlag != "" {
+		if err := testTimeline.WriteJSON(*timelineJsonFlag); err != nil {
+			return fmt.Errorf("failed to write the test timeline to %s: %v", *timelineJsonFlag, err)
+		}
+	}
+	if *timelineJunitFlag != "" {
+		if err := testTimeline.WriteJUnit(*timelineJunitFlag); err != nil {
+			return fmt.Errorf("failed to write the test timeline to %s: %v", *timelineJunitFlag, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+)
+
+// The TLS ports Couchbase serves the node certificate on: the REST API / Web Console and Views / XDCR
+const couchbaseSslRestPort = 18091
+const couchbaseSslCapiPort = 18092
+
+// In the Docker tests, we pass the CA to the User Data scripts as these references to secrets, which the mocks resolve
+// from the files at these paths in the mock secrets folder, rather than from AWS
+const mockCaCertReference = "ssm:///couchbase/ca-cert"
+const mockCaCertPath = "couchbase/ca-cert"
+const mockCaKeyReference = "ssm:///couchbase/ca-key"
+const mockCaKeyPath = "couchbase/ca-key"
+
+// Generate a new CA with couchbase-certs in the given mock secrets folder and return the CA certificate in PEM format,
+// along with the env vars to pass to Docker Compose so the Couchbase nodes sign their node certificates with it
+func writeMockCaForDocker(t *testing.T, mockSecretsDir string) ([]byte, map[string]string) {
+	tmpDir, err := ioutil.TempDir("", "couchbase-certs")
+	if err != nil {
+		t.Fatalf("Failed to create temp folder: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	binaryPath := buildCouchbaseToolForHost(t, "couchbase-certs", tmpDir)
+
+	caCertPath := filepath.Join(mockSecretsDir, filepath.FromSlash(mockCaCertPath))
+	caKeyPath := filepath.Join(mockSecretsDir, filepath.FromSlash(mockCaKeyPath))
+
+	if err := os.MkdirAll(filepath.Dir(caCertPath), 0755); err != nil {
+		t.Fatalf("Failed to create folder for mock secret %s: %v", caCertPath, err)
+	}
+
+	shell.RunCommand(t, shell.Command{
+		Command: binaryPath,
+		Args:    []string{"generate-ca", "--common-name", "Couchbase Test CA", "--cert-out", caCertPath, "--key-out", caKeyPath},
+	})
+
+	caCertPem, err := ioutil.ReadFile(caCertPath)
+	if err != nil {
+		t.Fatalf("Failed to read CA certificate %s: %v", caCertPath, err)
+	}
+
+	envVars := map[string]string{
+		"CA_CERT": mockCaCertReference,
+		"CA_KEY":  mockCaKeyReference,
+	}
+
+	return caCertPem, envVars
+}
+
+// Check that each of the given addresses, of the format <HOST>:<PORT>, serves a certificate chain signed by the given
+// CA. If serverName is not empty, also check that the certificate is valid for that hostname or IP address.
+func checkNodeCertificates(t *testing.T, addresses []string, caCertPem []byte, serverName string) {
+	if err := checkNodeCertificatesE(testContext(t), addresses, caCertPem, serverName); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkNodeCertificatesE(ctx context.Context, addresses []string, caCertPem []byte, serverName string) error {
+	for _, address := range addresses {
+		if err := checkNodeCertificateE(ctx, address, caCertPem, serverName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkNodeCertificate(t *testing.T, address string, caCertPem []byte, serverName string) {
+	if err := checkNodeCertificateE(testContext(t), address, caCertPem, serverName); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkNodeCertificateE(ctx context.Context, address string, caCertPem []byte, serverName string) error {
+	maxRetries := 60
+	sleepBetweenRetries := 5 * time.Second
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caCertPem) {
+		return CertificateErr{Source: "the CA certificate", Reason: "no PEM encoded certificate found"}
+	}
+
+	description := fmt.Sprintf("Checking %s serves a certificate signed by the expected CA", address)
+
+	_, err := pollE(ctx, description, maxRetries, sleepBetweenRetries, func() (string, error) {
+		chain, err := fetchCertificateChain(address)
+		if err != nil {
+			return "", err
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		// Until run-couchbase-server sets the node certificate, Couchbase serves a self-signed certificate, which fails
+		// here, so we retry
+		_, err = chain[0].Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			return "", CertificateErr{Source: address, Reason: fmt.Sprintf("certificate %s was not issued by the expected CA", chain[0].Subject), Underlying: err}
+		}
+
+		logf(ctx, "%s serves certificate %s, issued by %s", address, chain[0].Subject, chain[0].Issuer)
+		return "", nil
+	})
+	return err
+}
+
+// Check that every node in the Couchbase cluster deployed by the given TerraformOptions serves a certificate signed by
+// the CA in the couchbase_ca_cert_pem output on its public IP
+func checkNodeCertificatesInAsg(t *testing.T, terraformOptions *terraform.Options, clusterVarName string, awsRegion string) {
+	caCertPem := terraform.OutputRequired(t, terraformOptions, "couchbase_ca_cert_pem")
+
+	addresses := []string{}
+	for _, publicIp := range getPublicIpsOfCouchbaseNodes(t, terraformOptions, clusterVarName, awsRegion) {
+		addresses = append(addresses, fmt.Sprintf("%s:%d", publicIp, couchbaseSslRestPort))
+		addresses = append(addresses, fmt.Sprintf("%s:%d", publicIp, couchbaseSslCapiPort))
+	}
+
+	// The node certificates are for the private hostnames of the nodes, so we only check who issued the certificates
+	checkNodeCertificates(t, addresses, []byte(caCertPem), "")
+}
+
+// Return the public IPs of the Couchbase nodes in the cluster deployed by the given TerraformOptions
+func getPublicIpsOfCouchbaseNodes(t *testing.T, terraformOptions *terraform.Options, clusterVarName string, awsRegion string) []string {
+	clusterName := getClusterName(t, clusterVarName, terraformOptions)
+	instanceIds := aws.GetInstanceIdsForAsg(t, clusterName, awsRegion)
+
+	publicIps := []string{}
+	for _, publicIp := range aws.GetPublicIpsOfEc2Instances(t, instanceIds, awsRegion) {
+		publicIps = append(publicIps, publicIp)
+	}
+	return publicIps
+}
+
+// Connect to the given address over TLS and return the certificate chain it serves, without verifying it
+func fetchCertificateChain(address string) ([]*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	// We verify the chain ourselves, against the expected CA rather than the system roots
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	chain := conn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, CertificateErr{Source: address, Reason: "it did not serve any certificates"}
+	}
+
+	return chain, nil
+}
+
+// A partial representation of the JSON structure returned by the remote cluster references API:
+// https://docs.couchbase.com/server/current/rest-api/rest-xdcr-get-ref.html
+type RemoteClusterReference struct {
+	Name           string `json:"name"`
+	Deleted        bool   `json:"deleted"`
+	EncryptionType string `json:"encryptionType"`
+}
+
+// Check that every XDCR remote cluster reference in the given cluster uses the given encryption type
+func checkXdcrEncryptionType(t *testing.T, clusterUrl string, credentials CouchbaseCredentials, expectedEncryptionType string) {
+	if err := checkXdcrEncryptionTypeE(testContext(t), clusterUrl, credentials, expectedEncryptionType); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkXdcrEncryptionTypeE(ctx context.Context, clusterUrl string, credentials CouchbaseCredentials, expectedEncryptionType string) error {
+	referencesUrl := fmt.Sprintf("%s/pools/default/remoteClusters", clusterUrl)
+
+	statusCode, body, err := httpDoE(ctx, "GET", referencesUrl, nil, &credentials, nil)
+	if err != nil {
+		return HttpRequestErr{Url: referencesUrl, Underlying: err}
+	}
+	if statusCode != 200 {
+		return HttpStatusErr{Url: referencesUrl, Expected: 200, Actual: statusCode, Body: body}
+	}
+
+	var references []RemoteClusterReference
+	if err := json.Unmarshal([]byte(body), &references); err != nil {
+		return ParseErr{Url: referencesUrl, Body: body, Underlying: err}
+	}
+
+	checked := 0
+	for _, reference := range references {
+		if reference.Deleted {
+			continue
+		}
+
+		if reference.EncryptionType != expectedEncryptionType {
+			return UnexpectedValueErr{Description: fmt.Sprintf("encryption type for XDCR remote cluster reference %s", reference.Name), Expected: expectedEncryptionType, Actual: reference.EncryptionType}
+		}
+		checked++
+	}
+
+	if checked == 0 {
+		return UnexpectedResponseErr{Url: referencesUrl, Reason: "expected to find at least one XDCR remote cluster reference", Body: body}
+	}
+	return nil
+}
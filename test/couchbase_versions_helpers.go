@@ -0,0 +1,158 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/matrix"
+)
+
+const installCouchbaseServerPath = "../modules/install-couchbase-server/install-couchbase-server"
+
+// The versions of Couchbase and Sync Gateway to install in an image, and the checksums of their packages. The zero
+// value of each installs the default version of install-couchbase-server or install-sync-gateway.
+type CouchbaseVersions struct {
+	Couchbase   matrix.Package
+	SyncGateway matrix.Package
+}
+
+func couchbaseVersionsForCell(cell matrix.Cell) CouchbaseVersions {
+	return CouchbaseVersions{Couchbase: cell.CouchbasePackage, SyncGateway: cell.SyncGatewayPackage}
+}
+
+// Return the vars to pass to the couchbase.json Packer template to install these versions
+func (versions CouchbaseVersions) packerVars() map[string]string {
+	vars := map[string]string{}
+
+	if versions.Couchbase.Version != "" {
+		vars["couchbase_version"] = versions.Couchbase.Version
+		vars["couchbase_checksum"] = versions.Couchbase.Checksum
+		vars["couchbase_checksum_type"] = versions.Couchbase.ChecksumType
+	}
+
+	if versions.SyncGateway.Version != "" {
+		vars["sync_gateway_version"] = versions.SyncGateway.Version
+		vars["sync_gateway_checksum"] = versions.SyncGateway.Checksum
+		vars["sync_gateway_checksum_type"] = versions.SyncGateway.ChecksumType
+	}
+
+	return vars
+}
+
+// Return the version of Couchbase these versions install for the given edition, reading the default version from
+// install-couchbase-server if they don't pin one
+func (versions CouchbaseVersions) expectedCouchbaseVersion(t *testing.T, edition string) string {
+	version, err := versions.expectedCouchbaseVersionE(edition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func (versions CouchbaseVersions) expectedCouchbaseVersionE(edition string) (string, error) {
+	if versions.Couchbase.Version != "" {
+		return versions.Couchbase.Version, nil
+	}
+
+	contents, err := ioutil.ReadFile(installCouchbaseServerPath)
+	if err != nil {
+		return "", err
+	}
+
+	versionRegex := regexp.MustCompile(fmt.Sprintf(`(?m)^readonly DEFAULT_COUCHBASE_%s_VERSION="([^"]+)"$`, strings.ToUpper(edition)))
+	match := versionRegex.FindStringSubmatch(string(contents))
+	if match == nil {
+		return "", UnexpectedValueErr{Description: fmt.Sprintf("contents of %s", installCouchbaseServerPath), Expected: fmt.Sprintf("a line matching %s", versionRegex), Actual: "no such line"}
+	}
+
+	return match[1], nil
+}
+
+// The parts of the response of /pools we check
+type PoolsResponse struct {
+	ImplementationVersion string `json:"implementationVersion"`
+}
+
+// Couchbase reports its version in the format <VERSION>-<BUILD>-<EDITION>, e.g. 6.6.0-7909-enterprise
+func isCouchbaseVersion(reportedVersion string, version string, edition string) bool {
+	return strings.HasPrefix(reportedVersion, version+"-") && strings.HasSuffix(reportedVersion, "-"+edition)
+}
+
+// Check that the node that serves the given cluster URL, and every node in its cluster, report they run the version
+// of the given edition of Couchbase we asked the image to install
+func checkCouchbaseVersion(t *testing.T, clusterUrl string, edition string, versions CouchbaseVersions) {
+	if err := checkCouchbaseVersionE(testContext(t), clusterUrl, edition, versions); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkCouchbaseVersionE(ctx context.Context, clusterUrl string, edition string, versions CouchbaseVersions) error {
+	expectedVersion, err := versions.expectedCouchbaseVersionE(edition)
+	if err != nil {
+		return err
+	}
+	expected := fmt.Sprintf("%s (%s edition)", expectedVersion, edition)
+
+	var pools PoolsResponse
+	if err := getCouchbaseJsonE(ctx, fmt.Sprintf("%s/pools", clusterUrl), &pools); err != nil {
+		return err
+	}
+
+	if !isCouchbaseVersion(pools.ImplementationVersion, expectedVersion, edition) {
+		return UnexpectedValueErr{Description: "Couchbase version in /pools", Expected: expected, Actual: pools.ImplementationVersion}
+	}
+
+	var serverNodes ServerNodeResponse
+	if err := getCouchbaseJsonE(ctx, fmt.Sprintf("%s/pools/nodes", clusterUrl), &serverNodes); err != nil {
+		return err
+	}
+
+	for _, serverNode := range serverNodes.Nodes {
+		if !isCouchbaseVersion(serverNode.Version, expectedVersion, edition) {
+			return UnexpectedValueErr{Description: fmt.Sprintf("Couchbase version of node %s", serverNode.Hostname), Expected: expected, Actual: serverNode.Version}
+		}
+	}
+
+	logf(ctx, "All nodes of the cluster at %s run Couchbase %s", clusterUrl, expected)
+	return nil
+}
+
+// Make a GET request to the given Couchbase REST API URL and parse the response into out
+func getCouchbaseJson(t *testing.T, url string, out interface{}) {
+	if err := getCouchbaseJsonE(testContext(t), url, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getCouchbaseJsonE(ctx context.Context, url string, out interface{}) error {
+	description := fmt.Sprintf("Making a GET request to %s", url)
+	maxRetries := 10
+	sleepBetweenRetries := 5 * time.Second
+
+	body, err := pollE(ctx, description, maxRetries, sleepBetweenRetries, func() (string, error) {
+		statusCode, body, err := httpGetE(ctx, url, nil)
+		if err != nil {
+			return "", err
+		}
+
+		if statusCode != 200 {
+			return "", HttpStatusErr{Url: url, Expected: 200, Actual: statusCode, Body: body}
+		}
+
+		return body, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(body), out); err != nil {
+		return ParseErr{Url: url, Body: body, Underlying: err}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+// Package diagnostics stores the diagnostics the tests collect from each Couchbase and Sync Gateway node when a test
+// fails, such as logs and the output of the Couchbase REST API, in a bundle on disk, and looks through them for the most
+// likely cause of the failure.
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The names of the files in the bundle the analysis knows how to read
+const (
+	PoolsNodesFile    = "pools-nodes.json"
+	TasksFile         = "tasks.json"
+	RemoteClusterFile = "remote-clusters.json"
+	SummaryFile       = "summary.txt"
+)
+
+// The suffix of the file we write in place of a file we failed to collect, with the error in it
+const ErrorFileSuffix = ".error"
+
+// A folder with a subfolder per node, each of which has the files collected from that node
+type Bundle struct {
+	Dir string
+}
+
+// A file collected from a node
+type File struct {
+	Node     string
+	Name     string
+	Contents []byte
+}
+
+// Create the folder for a bundle
+func NewBundle(dir string) (*Bundle, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Bundle{Dir: dir}, nil
+}
+
+// Write a file collected from the given node into the bundle
+func (bundle *Bundle) Write(node string, name string, contents []byte) error {
+	nodeDir := filepath.Join(bundle.Dir, sanitize(node))
+	if err := os.MkdirAll(nodeDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(nodeDir, name), contents, 0644)
+}
+
+// Record that we failed to collect the given file from the given node
+func (bundle *Bundle) WriteError(node string, name string, err error, output string) error {
+	return bundle.Write(node, name+ErrorFileSuffix, []byte(fmt.Sprintf("%v\n\n%s", err, output)))
+}
+
+// Write the summary of the findings to the top of the bundle, next to the node folders
+func (bundle *Bundle) WriteSummary(summary string) error {
+	return ioutil.WriteFile(filepath.Join(bundle.Dir, SummaryFile), []byte(summary), 0644)
+}
+
+// Read all the files collected from all the nodes in the bundle, sorted by node and name
+func (bundle *Bundle) Files() ([]File, error) {
+	nodeDirs, err := ioutil.ReadDir(bundle.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []File{}
+	for _, nodeDir := range nodeDirs {
+		if !nodeDir.IsDir() {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(filepath.Join(bundle.Dir, nodeDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			contents, err := ioutil.ReadFile(filepath.Join(bundle.Dir, nodeDir.Name(), entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, File{Node: nodeDir.Name(), Name: entry.Name(), Contents: contents})
+		}
+	}
+
+	return files, nil
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Make the given string safe to use as a file or folder name
+func sanitize(name string) string {
+	return unsafeChars.ReplaceAllString(name, "_")
+}
+
+// The path in the bundle folder for the test with the given name, e.g. TestFoo/bar becomes TestFoo_bar
+func DirForTest(rootDir string, testName string) string {
+	return filepath.Join(rootDir, sanitize(testName))
+}
+
+// How likely a finding is to be the cause of the failure. Lower is more likely.
+type Severity int
+
+const (
+	// The node itself is in trouble, e.g. out of memory or disk space
+	SeverityNode Severity = iota
+	// Couchbase or one of our scripts failed
+	SeverityCluster
+	// A feature on top of a working cluster failed, e.g. a rebalance or XDCR
+	SeverityFeature
+	// Something that may or may not be related
+	SeverityHint
+)
+
+// Something in the diagnostics that may explain the failure
+type Finding struct {
+	Severity Severity
+	Node     string
+	Source   string
+	Message  string
+}
+
+func (finding Finding) String() string {
+	return fmt.Sprintf("[%s] %s (from %s)", finding.Node, finding.Message, finding.Source)
+}
+
+// A pattern in a log file that points to a likely cause of failure
+type logPattern struct {
+	regex    *regexp.Regexp
+	severity Severity
+	// The message for the finding. If it has a %s, it's replaced with the first submatch of the regex.
+	message string
+}
+
+var logPatterns = []logPattern{
+	{regexp.MustCompile(`(?i)out of memory|oom-killer|Cannot allocate memory`), SeverityNode, "The node ran out of memory"},
+	{regexp.MustCompile(`(?i)No space left on device`), SeverityNode, "The node ran out of disk space"},
+	{regexp.MustCompile(`(?i)Too many open files`), SeverityNode, "The node ran out of file descriptors"},
+	{regexp.MustCompile(`(?i)address already in use|eaddrinuse`), SeverityCluster, "A port Couchbase or Sync Gateway needs was already in use"},
+	{regexp.MustCompile(`\[ERROR\] \[(run-couchbase-server|run-sync-gateway|run-replication|couchbase-rally-point|install-couchbase-server|install-sync-gateway)\] (.*)`), SeverityCluster, "%s failed: %s"},
+	{regexp.MustCompile(`(?i)checksum (validation )?(failed|mismatch)`), SeverityCluster, "The checksum of a downloaded package didn't match"},
+	{regexp.MustCompile(`(?i)Unable to connect to (the )?Couchbase Server|Error connecting to bucket|Unable to connect to server`), SeverityFeature, "Sync Gateway could not connect to Couchbase"},
+	{regexp.MustCompile(`\[FATAL\]|FATAL: (.*)`), SeverityFeature, "Sync Gateway hit a fatal error"},
+}
+
+// The most findings of the same message we report per node, so a log full of the same error doesn't drown the rest
+const maxFindingsPerMessage = 1
+
+// Look through the given files for the likely causes of a failure, sorted from most to least likely
+func Analyze(files []File) []Finding {
+	findings := []Finding{}
+	unreachable := map[string]int{}
+	restFiles := map[string]int{}
+
+	for _, file := range files {
+		switch {
+		case strings.HasSuffix(file.Name, ErrorFileSuffix):
+			name := strings.TrimSuffix(file.Name, ErrorFileSuffix)
+			if isRestFile(name) {
+				unreachable[file.Node]++
+			}
+		case file.Name == PoolsNodesFile:
+			findings = append(findings, analyzePoolsNodes(file)...)
+		case file.Name == TasksFile:
+			findings = append(findings, analyzeTasks(file)...)
+		case strings.HasSuffix(file.Name, ".log") || strings.HasSuffix(file.Name, ".txt"):
+			findings = append(findings, analyzeLog(file)...)
+		}
+
+		if isRestFile(strings.TrimSuffix(file.Name, ErrorFileSuffix)) {
+			restFiles[file.Node]++
+		}
+	}
+
+	for node, count := range unreachable {
+		if count == restFiles[node] {
+			findings = append(findings, Finding{Severity: SeverityCluster, Node: node, Source: "Couchbase REST API", Message: "Couchbase Server did not respond to any REST API call, so it may not be running"})
+		}
+	}
+
+	findings = dedupe(findings)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return findings[i].Severity < findings[j].Severity
+		}
+		return findings[i].Node < findings[j].Node
+	})
+
+	return findings
+}
+
+func isRestFile(name string) bool {
+	return name == PoolsNodesFile || name == TasksFile || name == RemoteClusterFile
+}
+
+func dedupe(findings []Finding) []Finding {
+	counts := map[string]int{}
+	out := []Finding{}
+	for _, finding := range findings {
+		key := finding.Node + "\x00" + finding.Message
+		counts[key]++
+		if counts[key] <= maxFindingsPerMessage {
+			out = append(out, finding)
+		}
+	}
+	return out
+}
+
+func analyzeLog(file File) []Finding {
+	findings := []Finding{}
+	for _, line := range strings.Split(string(file.Contents), "\n") {
+		for _, pattern := range logPatterns {
+			match := pattern.regex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			message := pattern.message
+			if strings.Contains(message, "%s") {
+				args := []interface{}{}
+				for _, submatch := range match[1:] {
+					args = append(args, strings.TrimSpace(submatch))
+				}
+				message = fmt.Sprintf(message, args[:strings.Count(message, "%s")]...)
+			}
+
+			findings = append(findings, Finding{Severity: pattern.severity, Node: file.Node, Source: file.Name, Message: message})
+		}
+	}
+	return findings
+}
+
+// The parts of the response of /pools/nodes we look at
+type poolsNodes struct {
+	Nodes []struct {
+		Hostname          string `json:"hostname"`
+		Status            string `json:"status"`
+		ClusterMembership string `json:"clusterMembership"`
+	} `json:"nodes"`
+}
+
+func analyzePoolsNodes(file File) []Finding {
+	var response poolsNodes
+	if err := json.Unmarshal(file.Contents, &response); err != nil {
+		return []Finding{{Severity: SeverityHint, Node: file.Node, Source: file.Name, Message: fmt.Sprintf("Could not parse the response of /pools/nodes: %v", err)}}
+	}
+
+	findings := []Finding{}
+	for _, node := range response.Nodes {
+		if node.Status != "" && node.Status != "healthy" {
+			findings = append(findings, Finding{Severity: SeverityCluster, Node: file.Node, Source: file.Name, Message: fmt.Sprintf("Cluster node %s is %s", node.Hostname, node.Status)})
+		}
+
+		switch node.ClusterMembership {
+		case "inactiveFailed":
+			findings = append(findings, Finding{Severity: SeverityCluster, Node: file.Node, Source: file.Name, Message: fmt.Sprintf("Cluster node %s was failed over", node.Hostname)})
+		case "inactiveAdded":
+			findings = append(findings, Finding{Severity: SeverityFeature, Node: file.Node, Source: file.Name, Message: fmt.Sprintf("Cluster node %s was added, but never rebalanced into the cluster", node.Hostname)})
+		}
+	}
+	return findings
+}
+
+// The parts of each task in the response of /pools/default/tasks we look at
+type task struct {
+	Type         string   `json:"type"`
+	Status       string   `json:"status"`
+	ErrorMessage string   `json:"errorMessage"`
+	Source       string   `json:"source"`
+	Target       string   `json:"target"`
+	Errors       []string `json:"errors"`
+}
+
+// XDCR reports errors as either strings or objects, depending on the version of Couchbase
+func (t *task) UnmarshalJSON(data []byte) error {
+	type plainTask task
+	var raw struct {
+		plainTask
+		Errors []json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*t = task(raw.plainTask)
+	t.Errors = nil
+	for _, rawError := range raw.Errors {
+		var message string
+		if err := json.Unmarshal(rawError, &message); err == nil {
+			t.Errors = append(t.Errors, message)
+			continue
+		}
+
+		var object struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(rawError, &object); err == nil && object.Error != "" {
+			t.Errors = append(t.Errors, object.Error)
+			continue
+		}
+
+		t.Errors = append(t.Errors, string(rawError))
+	}
+	return nil
+}
+
+func analyzeTasks(file File) []Finding {
+	var tasks []task
+	if err := json.Unmarshal(file.Contents, &tasks); err != nil {
+		return []Finding{{Severity: SeverityHint, Node: file.Node, Source: file.Name, Message: fmt.Sprintf("Could not parse the response of /pools/default/tasks: %v", err)}}
+	}
+
+	findings := []Finding{}
+	for _, task := range tasks {
+		switch task.Type {
+		case "rebalance":
+			if task.ErrorMessage != "" {
+				findings = append(findings, Finding{Severity: SeverityFeature, Node: file.Node, Source: file.Name, Message: fmt.Sprintf("Rebalance failed: %s", task.ErrorMessage)})
+			}
+		case "xdcr":
+			for _, message := range task.Errors {
+				findings = append(findings, Finding{Severity: SeverityFeature, Node: file.Node, Source: file.Name, Message: fmt.Sprintf("XDCR replication from %s to %s reported an error: %s", task.Source, task.Target, message)})
+			}
+			if task.Status == "notRunning" {
+				findings = append(findings, Finding{Severity: SeverityFeature, Node: file.Node, Source: file.Name, Message: fmt.Sprintf("XDCR replication from %s to %s is not running", task.Source, task.Target)})
+			}
+		}
+	}
+	return findings
+}
+
+// Format a short summary of the given findings, listing at most maxFindings of them
+func FormatSummary(dir string, findings []Finding, maxFindings int) string {
+	var summary strings.Builder
+
+	if len(findings) == 0 {
+		fmt.Fprintf(&summary, "Found no known causes of failure in the diagnostics. See the full diagnostics in %s.\n", dir)
+		return summary.String()
+	}
+
+	fmt.Fprintf(&summary, "Most likely causes of the failure, from the diagnostics in %s:\n", dir)
+	for i, finding := range findings {
+		if i == maxFindings {
+			fmt.Fprintf(&summary, "... and %d more. See %s.\n", len(findings)-maxFindings, filepath.Join(dir, SummaryFile))
+			break
+		}
+		fmt.Fprintf(&summary, "%d. %s\n", i+1, finding)
+	}
+	return summary.String()
+}
@@ -0,0 +1,123 @@
+package diagnostics
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleWritesAndReadsFilesPerNode(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", "diagnostics-bundle")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	bundle, err := NewBundle(DirForTest(tmpDir, "TestUnitCouchbaseInDocker/couchbase-cluster-simple_ubuntu"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tmpDir, "TestUnitCouchbaseInDocker_couchbase-cluster-simple_ubuntu"), bundle.Dir)
+
+	require.NoError(t, bundle.Write("couchbase-abc-0", PoolsNodesFile, []byte(`{"nodes": []}`)))
+	require.NoError(t, bundle.WriteError("10.0.0.1:22", TasksFile, errors.New("connection refused"), "curl: (7) Failed to connect"))
+
+	files, err := bundle.Files()
+	require.NoError(t, err)
+	assert.Equal(t, []File{
+		{Node: "10.0.0.1_22", Name: TasksFile + ErrorFileSuffix, Contents: []byte("connection refused\n\ncurl: (7) Failed to connect")},
+		{Node: "couchbase-abc-0", Name: PoolsNodesFile, Contents: []byte(`{"nodes": []}`)},
+	}, files)
+}
+
+func TestAnalyzeFindsUnhealthyAndFailedOverNodes(t *testing.T) {
+	t.Parallel()
+
+	findings := Analyze([]File{{Node: "node-0", Name: PoolsNodesFile, Contents: []byte(`{"nodes": [
+		{"hostname": "node-0:8091", "status": "healthy", "clusterMembership": "active"},
+		{"hostname": "node-1:8091", "status": "unhealthy", "clusterMembership": "inactiveFailed"},
+		{"hostname": "node-2:8091", "status": "healthy", "clusterMembership": "inactiveAdded"}
+	]}`)}})
+
+	assert.Equal(t, []Finding{
+		{Severity: SeverityCluster, Node: "node-0", Source: PoolsNodesFile, Message: "Cluster node node-1:8091 is unhealthy"},
+		{Severity: SeverityCluster, Node: "node-0", Source: PoolsNodesFile, Message: "Cluster node node-1:8091 was failed over"},
+		{Severity: SeverityFeature, Node: "node-0", Source: PoolsNodesFile, Message: "Cluster node node-2:8091 was added, but never rebalanced into the cluster"},
+	}, findings)
+}
+
+func TestAnalyzeFindsFailedRebalanceAndXdcrErrors(t *testing.T) {
+	t.Parallel()
+
+	findings := Analyze([]File{{Node: "node-0", Name: TasksFile, Contents: []byte(`[
+		{"type": "rebalance", "status": "notRunning", "errorMessage": "Rebalance failed. See logs for detailed reason."},
+		{"type": "xdcr", "status": "running", "source": "test-bucket", "target": "/remoteClusters/abc/buckets/test-bucket-replica", "errors": ["Failed to connect to target"]},
+		{"type": "xdcr", "status": "notRunning", "source": "other-bucket", "target": "/remoteClusters/abc/buckets/other", "errors": [{"time": "2020-01-01", "error": "Target bucket missing"}]}
+	]`)}})
+
+	assert.Equal(t, []string{
+		"Rebalance failed: Rebalance failed. See logs for detailed reason.",
+		"XDCR replication from test-bucket to /remoteClusters/abc/buckets/test-bucket-replica reported an error: Failed to connect to target",
+		"XDCR replication from other-bucket to /remoteClusters/abc/buckets/other reported an error: Target bucket missing",
+		"XDCR replication from other-bucket to /remoteClusters/abc/buckets/other is not running",
+	}, messages(findings))
+}
+
+func TestAnalyzeFindsKnownLogPatternsMostLikelyFirst(t *testing.T) {
+	t.Parallel()
+
+	findings := Analyze([]File{
+		{Node: "node-0", Name: "sync-gateway-error.log", Contents: []byte("2020-01-01 Unable to connect to Couchbase Server\n")},
+		{Node: "node-0", Name: "syslog.txt", Contents: []byte(
+			"2020-01-01 12:00:00 [ERROR] [run-couchbase-server] Timed out waiting for the cluster\n" +
+				"2020-01-01 12:00:01 [ERROR] [run-couchbase-server] Timed out waiting for the cluster\n")},
+		{Node: "node-1", Name: "dmesg.txt", Contents: []byte("[123.456] Out of memory: Killed process 1234 (memcached)\n")},
+	})
+
+	assert.Equal(t, []Finding{
+		{Severity: SeverityNode, Node: "node-1", Source: "dmesg.txt", Message: "The node ran out of memory"},
+		{Severity: SeverityCluster, Node: "node-0", Source: "syslog.txt", Message: "run-couchbase-server failed: Timed out waiting for the cluster"},
+		{Severity: SeverityFeature, Node: "node-0", Source: "sync-gateway-error.log", Message: "Sync Gateway could not connect to Couchbase"},
+	}, findings)
+}
+
+func TestAnalyzeFindsNodesWhereTheRestApiIsDown(t *testing.T) {
+	t.Parallel()
+
+	findings := Analyze([]File{
+		{Node: "node-0", Name: PoolsNodesFile + ErrorFileSuffix},
+		{Node: "node-0", Name: TasksFile + ErrorFileSuffix},
+		{Node: "node-1", Name: PoolsNodesFile, Contents: []byte(`{"nodes": []}`)},
+		{Node: "node-1", Name: TasksFile + ErrorFileSuffix},
+	})
+
+	assert.Equal(t, []Finding{
+		{Severity: SeverityCluster, Node: "node-0", Source: "Couchbase REST API", Message: "Couchbase Server did not respond to any REST API call, so it may not be running"},
+	}, findings)
+}
+
+func TestFormatSummary(t *testing.T) {
+	t.Parallel()
+
+	findings := []Finding{
+		{Severity: SeverityNode, Node: "node-1", Source: "dmesg.txt", Message: "The node ran out of memory"},
+		{Severity: SeverityCluster, Node: "node-0", Source: PoolsNodesFile, Message: "Cluster node node-1:8091 is unhealthy"},
+	}
+
+	assert.Equal(t, "Most likely causes of the failure, from the diagnostics in diag:\n"+
+		"1. [node-1] The node ran out of memory (from dmesg.txt)\n"+
+		"... and 1 more. See diag/summary.txt.\n", FormatSummary("diag", findings, 1))
+
+	assert.Equal(t, "Found no known causes of failure in the diagnostics. See the full diagnostics in diag.\n", FormatSummary("diag", nil, 5))
+}
+
+func messages(findings []Finding) []string {
+	out := []string{}
+	for _, finding := range findings {
+		out = append(out, finding.Message)
+	}
+	return out
+}
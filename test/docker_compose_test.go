@@ -7,61 +7,79 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/matrix"
 	"github.com/gruntwork-io/terratest/modules/docker"
 	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/gruntwork-io/terratest/modules/random"
 	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
 )
 
+// The settings of the cells of the docker suite of the test matrix. Each cell maps its containers to its own ports on
+// the host, as all the cells run in parallel.
+type dockerMatrixSettings struct {
+	ClusterSize         int `json:"cluster_size"`
+	WebConsolePort      int `json:"web_console_port"`
+	SyncGatewayPort     int `json:"sync_gateway_port"`
+	MemcachedPort       int `json:"memcached_port"`
+	WebConsolePortNode1 int `json:"web_console_port_node_1"`
+	MemcachedPortNode1  int `json:"memcached_port_node_1"`
+	WebConsolePortEast  int `json:"web_console_port_east"`
+	WebConsolePortWest  int `json:"web_console_port_west"`
+}
+
+// Run each example in the docker suite of test_matrix.json in Docker, once for each of its OSes, editions, and versions
 func TestUnitCouchbaseInDocker(t *testing.T) {
 	t.Parallel()
 
-	basicTestCases := []struct {
-		testName                  string
-		examplesFolderName        string
-		osName                    string
-		edition                   string
-		clusterSize               int
-		couchbaseWebConsolePort   int
-		syncGatewayWebConsolePort int
-	}{
-		{"TestUnitCouchbaseCommunitySingleClusterUbuntu16InDocker", "couchbase-cluster-simple", "ubuntu", "community", 2, 8091, 4984},
-		{"TestUnitCouchbaseCommunitySingleClusterUbuntu18InDocker", "couchbase-cluster-simple", "ubuntu-18", "community", 2, 8091, 4984},
-		{"TestUnitCouchbaseEnterpriseMultiClusterAmazonLinuxInDocker", "couchbase-cluster-mds", "amazon-linux", "enterprise", 3, 7091, 3984},
-	}
-
-	for _, testCase := range basicTestCases {
-		testCase := testCase // capture range variable; otherwise, only the very last test case will run!
-
-		t.Run(testCase.testName, func(t *testing.T) {
-			t.Parallel()
-			skipInCircleCi(t)
-			testCouchbaseInDockerBasic(t, testCase.examplesFolderName, testCase.osName, testCase.edition, testCase.clusterSize, testCase.couchbaseWebConsolePort, testCase.syncGatewayWebConsolePort)
-		})
-	}
+	runTestMatrix(t, "docker", func(t *testing.T, cell matrix.Cell) {
+		skipInCircleCi(t)
 
-	replicationTestCases := []struct {
-		testName                    string
-		examplesFolderName          string
-		osName                      string
-		edition                     string
-		clusterSize                 int
-		couchbaseWebConsolePortEast int
-		couchbaseWebConsolePortWest int
-	}{
-		{"TestUnitCouchbaseEnterpriseMultiDataCenterUbuntu16InDocker", "couchbase-multi-datacenter-replication", "ubuntu", "enterprise", 2, 6091, 5091},
-		{"TestUnitCouchbaseEnterpriseMultiDataCenterUbuntu18InDocker", "couchbase-multi-datacenter-replication", "ubuntu-18", "enterprise", 2, 6091, 5091},
-	}
+		settings := dockerMatrixSettings{}
+		decodeMatrixSettings(t, cell, &settings)
 
-	for _, testCase := range replicationTestCases {
-		testCase := testCase // capture range variable; otherwise, only the very last test case will run!
+		if cell.Variant == "rotate-password" {
+			switch cell.Example {
+			case "couchbase-cluster-simple":
+				alternatePorts := CouchbaseAlternatePorts{Memcached: settings.MemcachedPort, WebConsoleNode1: settings.WebConsolePortNode1, MemcachedNode1: settings.MemcachedPortNode1}
+				testRotateSyncGatewayUserPasswordInDocker(t, cell.Os, cell.Edition, settings.WebConsolePort, settings.SyncGatewayPort, alternatePorts)
+			case "couchbase-multi-datacenter-replication":
+				testRotateReplicationAdminPasswordInDocker(t, cell.Os, cell.Edition, settings.WebConsolePortEast, settings.WebConsolePortWest)
+			default:
+				t.Fatalf("The Docker tests don't know how to rotate passwords in example %s", cell.Example)
+			}
+			return
+		}
 
-		t.Run(testCase.testName, func(t *testing.T) {
-			t.Parallel()
-			skipInCircleCi(t)
-			testCouchbaseInDockerReplication(t, testCase.examplesFolderName, testCase.osName, testCase.edition, testCase.clusterSize, testCase.couchbaseWebConsolePortEast, testCase.couchbaseWebConsolePortWest)
-		})
-	}
+		switch cell.Example {
+		case "couchbase-cluster-simple":
+			alternatePorts := &CouchbaseAlternatePorts{Memcached: settings.MemcachedPort, WebConsoleNode1: settings.WebConsolePortNode1, MemcachedNode1: settings.MemcachedPortNode1}
+			testCouchbaseInDockerBasic(t, cell.Example, cell.Os, cell.Edition, couchbaseVersionsForCell(cell), settings.ClusterSize, settings.WebConsolePort, settings.SyncGatewayPort, true, alternatePorts, nil)
+		case "couchbase-cluster-mds":
+			testCouchbaseInDockerBasic(t, cell.Example, cell.Os, cell.Edition, couchbaseVersionsForCell(cell), settings.ClusterSize, settings.WebConsolePort, settings.SyncGatewayPort, false, nil, &mdsFailoverSettingsForTest)
+		case "couchbase-multi-datacenter-replication":
+			testCouchbaseInDockerReplication(t, cell.Example, cell.Os, cell.Edition, couchbaseVersionsForCell(cell), settings.ClusterSize, settings.WebConsolePortEast, settings.WebConsolePortWest)
+		default:
+			t.Fatalf("The Docker tests don't know how to test example %s", cell.Example)
+		}
+	})
+}
+
+// The failover settings the data nodes in the couchbase-cluster-mds example pass to run-couchbase-server
+var mdsFailoverSettingsForTest = FailoverSettings{
+	AutoFailover: AutoFailoverSettings{
+		Enabled:             true,
+		Timeout:             30,
+		MaxCount:            2,
+		FailoverServerGroup: true,
+		FailoverOnDataDiskIssues: FailoverOnDataDiskIssues{
+			Enabled:    true,
+			TimePeriod: 60,
+		},
+	},
+	AutoReprovision: AutoReprovisionSettings{
+		Enabled:  true,
+		MaxNodes: 2,
+	},
 }
 
 func skipInCircleCi(t *testing.T) {
@@ -70,7 +88,11 @@ func skipInCircleCi(t *testing.T) {
 	}
 }
 
-func testCouchbaseInDockerBasic(t *testing.T, examplesFolderName string, osName string, edition string, clusterSize int, couchbaseWebConsolePort int, syncGatewayWebConsolePort int) {
+// If alternatePorts is not nil, the example must advertise those ports as the alternate addresses of its nodes, and
+// we also check that the Couchbase SDK can read and write data via those alternate addresses. If failoverSettings is
+// not nil, we check that the example configured the cluster with those failover settings. If checkRbacUsers is set,
+// we check that the example created the RBAC users in rbacUsersForTest.
+func testCouchbaseInDockerBasic(t *testing.T, examplesFolderName string, osName string, edition string, versions CouchbaseVersions, clusterSize int, couchbaseWebConsolePort int, syncGatewayWebConsolePort int, checkRbacUsers bool, alternatePorts *CouchbaseAlternatePorts, failoverSettings *FailoverSettings) {
 	uniqueId := random.UniqueId()
 	envVars := map[string]string{
 		"OS_NAME":             osName,
@@ -79,37 +101,74 @@ func testCouchbaseInDockerBasic(t *testing.T, examplesFolderName string, osName
 		"SYNC_GATEWAY_PORT":   strconv.Itoa(syncGatewayWebConsolePort),
 	}
 
+	if alternatePorts != nil {
+		for key, value := range alternatePorts.envVars() {
+			envVars[key] = value
+		}
+	}
+
+	credentials := newRandomCredentialsForTest()
+	mockSecretsDir, mockSecretsEnvVars := writeMockSecretsForDocker(t, credentials)
+	defer os.RemoveAll(mockSecretsDir)
+	for key, value := range mockSecretsEnvVars {
+		envVars[key] = value
+	}
+
+	testUserPasswords := newRandomTestUserPasswordsForTest()
+	for key, value := range testUserPasswords.envVars() {
+		envVars[key] = value
+	}
+
 	tmpExamplesDir := test_structure.CopyTerraformFolderToTemp(t, "../", "examples")
 	couchbaseAmiDir := filepath.Join(tmpExamplesDir, "couchbase-ami")
 	couchbaseSingleClusterDockerDir := filepath.Join(tmpExamplesDir, examplesFolderName, "local-test")
 
-	test_structure.RunTestStage(t, "setup_image", func() {
-		buildCouchbaseWithPacker(t, fmt.Sprintf("%s-docker", osName), "couchbase", "us-east-1", couchbaseAmiDir, edition)
+	runTestStage(t, "setup_image", func() {
+		buildCouchbaseWithPacker(t, fmt.Sprintf("%s-docker", osName), "couchbase", "us-east-1", couchbaseAmiDir, edition, versions)
 	})
 
-	defer test_structure.RunTestStage(t, "teardown", func() {
-		getDockerComposeLogs(t, couchbaseSingleClusterDockerDir, envVars)
+	defer runTestStage(t, "teardown", func() {
+		getDockerComposeLogs(t, couchbaseSingleClusterDockerDir, envVars, credentials)
 		stopCouchbaseWithDockerCompose(t, couchbaseSingleClusterDockerDir, envVars)
 	})
 
-	test_structure.RunTestStage(t, "setup_docker", func() {
+	runTestStage(t, "setup_docker", func() {
 		startCouchbaseWithDockerCompose(t, couchbaseSingleClusterDockerDir, envVars)
 	})
 
-	test_structure.RunTestStage(t, "validation", func() {
+	runTestStage(t, "validation", func() {
 		consoleUrl := fmt.Sprintf("http://localhost:%d", couchbaseWebConsolePort)
 		checkCouchbaseConsoleIsRunning(t, consoleUrl)
 
-		dataNodesUrl := fmt.Sprintf("http://%s:%s@localhost:%d", usernameForTest, passwordForTest, couchbaseWebConsolePort)
+		dataNodesUrl := formatUrlWithCredentials("http", credentials, fmt.Sprintf("localhost:%d", couchbaseWebConsolePort))
 		checkCouchbaseClusterIsInitialized(t, dataNodesUrl, clusterSize)
+		checkCouchbaseVersion(t, dataNodesUrl, edition, versions)
 		checkCouchbaseDataNodesWorking(t, dataNodesUrl)
 
 		syncGatewayUrl := fmt.Sprintf("http://localhost:%d/mock-couchbase-asg", syncGatewayWebConsolePort)
 		checkSyncGatewayWorking(t, syncGatewayUrl)
+
+		for containerName, matrix := range expectedListeningPortsInDocker(t, examplesFolderName, envVars["CONTAINER_BASE_NAME"]) {
+			checkPortsAreListeningInDocker(t, containerName, matrix)
+		}
+
+		if checkRbacUsers {
+			checkRbacUsersWorking(t, consoleUrl, credentials, "test-bucket", rbacUsersForTest(testUserPasswords))
+		}
+
+		if alternatePorts != nil {
+			checkKvWorkingViaSdk(t, "localhost", alternatePorts.Memcached, "test-bucket", credentials)
+			checkLoadGeneratorWorking(t, "localhost", alternatePorts.Memcached, "test-bucket", credentials)
+			checkDatasetSeedingWorking(t, dataNodesUrl, "localhost", alternatePorts.Memcached, "test-bucket", credentials)
+		}
+
+		if failoverSettings != nil {
+			checkFailoverSettings(t, dataNodesUrl, *failoverSettings)
+		}
 	})
 }
 
-func testCouchbaseInDockerReplication(t *testing.T, examplesFolderName string, osName string, edition string, clusterSize int, couchbaseWebConsolePortEast int, couchbaseWebConsolePortWest int) {
+func testCouchbaseInDockerReplication(t *testing.T, examplesFolderName string, osName string, edition string, versions CouchbaseVersions, clusterSize int, couchbaseWebConsolePortEast int, couchbaseWebConsolePortWest int) {
 	uniqueId := random.UniqueId()
 	envVars := map[string]string{
 		"OS_NAME":               osName,
@@ -118,37 +177,59 @@ func testCouchbaseInDockerReplication(t *testing.T, examplesFolderName string, o
 		"WEB_CONSOLE_WEST_PORT": strconv.Itoa(couchbaseWebConsolePortWest),
 	}
 
+	credentials := newRandomCredentialsForTest()
+	mockSecretsDir, mockSecretsEnvVars := writeMockSecretsForDocker(t, credentials)
+	defer os.RemoveAll(mockSecretsDir)
+	for key, value := range mockSecretsEnvVars {
+		envVars[key] = value
+	}
+
+	testUserPasswords := newRandomTestUserPasswordsForTest()
+	for key, value := range testUserPasswords.envVars() {
+		envVars[key] = value
+	}
+
 	tmpExamplesDir := test_structure.CopyTerraformFolderToTemp(t, "../", "examples")
 	couchbaseAmiDir := filepath.Join(tmpExamplesDir, "couchbase-ami")
 	couchbaseSingleClusterDockerDir := filepath.Join(tmpExamplesDir, examplesFolderName, "local-test")
 
-	test_structure.RunTestStage(t, "setup_image", func() {
-		buildCouchbaseWithPacker(t, fmt.Sprintf("%s-docker", osName), "couchbase", "us-east-1", couchbaseAmiDir, edition)
+	runTestStage(t, "setup_image", func() {
+		buildCouchbaseWithPacker(t, fmt.Sprintf("%s-docker", osName), "couchbase", "us-east-1", couchbaseAmiDir, edition, versions)
 	})
 
-	test_structure.RunTestStage(t, "setup_docker", func() {
+	runTestStage(t, "setup_docker", func() {
 		startCouchbaseWithDockerCompose(t, couchbaseSingleClusterDockerDir, envVars)
 	})
 
-	defer test_structure.RunTestStage(t, "teardown", func() {
-		getDockerComposeLogs(t, couchbaseSingleClusterDockerDir, envVars)
+	defer runTestStage(t, "teardown", func() {
+		getDockerComposeLogs(t, couchbaseSingleClusterDockerDir, envVars, credentials)
 		stopCouchbaseWithDockerCompose(t, couchbaseSingleClusterDockerDir, envVars)
 	})
 
-	test_structure.RunTestStage(t, "validation", func() {
+	runTestStage(t, "validation", func() {
 		consoleUrlEast := fmt.Sprintf("http://localhost:%d", couchbaseWebConsolePortEast)
 		checkCouchbaseConsoleIsRunning(t, consoleUrlEast)
 
 		consoleUrlWest := fmt.Sprintf("http://localhost:%d", couchbaseWebConsolePortWest)
 		checkCouchbaseConsoleIsRunning(t, consoleUrlWest)
 
-		dataNodesUrlEast := fmt.Sprintf("http://%s:%s@localhost:%d", usernameForTest, passwordForTest, couchbaseWebConsolePortEast)
+		dataNodesUrlEast := formatUrlWithCredentials("http", credentials, fmt.Sprintf("localhost:%d", couchbaseWebConsolePortEast))
 		checkCouchbaseClusterIsInitialized(t, dataNodesUrlEast, clusterSize)
 
-		dataNodesUrlWest := fmt.Sprintf("http://%s:%s@localhost:%d", usernameForTest, passwordForTest, couchbaseWebConsolePortWest)
+		dataNodesUrlWest := formatUrlWithCredentials("http", credentials, fmt.Sprintf("localhost:%d", couchbaseWebConsolePortWest))
 		checkCouchbaseClusterIsInitialized(t, dataNodesUrlWest, clusterSize)
 
+		checkCouchbaseVersion(t, dataNodesUrlEast, edition, versions)
+		checkCouchbaseVersion(t, dataNodesUrlWest, edition, versions)
+
 		checkReplicationIsWorking(t, dataNodesUrlEast, dataNodesUrlWest, "test-bucket", "test-bucket-replica")
+
+		// The docker-compose file puts the nodes of each cluster in different (mock) availability zones, and server
+		// groups are only supported by the Enterprise Edition
+		if edition == "enterprise" {
+			checkReplicasInDifferentServerGroups(t, dataNodesUrlEast, "test-bucket")
+			checkReplicasInDifferentServerGroups(t, dataNodesUrlWest, "test-bucket-replica")
+		}
 	})
 }
 
@@ -156,7 +237,14 @@ func startCouchbaseWithDockerCompose(t *testing.T, exampleDir string, envVars ma
 	docker.RunDockerCompose(t, &docker.Options{WorkingDir: exampleDir, EnvVars: envVars}, "up", "-d")
 }
 
-func getDockerComposeLogs(t *testing.T, exampleDir string, envVars map[string]string) {
+// If the test failed, collect a diagnostics bundle from every container instead of printing the logs, as the bundle
+// has the logs of each container in it, and printing them all makes the cause of the failure hard to find
+func getDockerComposeLogs(t *testing.T, exampleDir string, envVars map[string]string, credentials CouchbaseCredentials) {
+	if t.Failed() {
+		collectDiagnosticsInDockerOnFailure(t, envVars["CONTAINER_BASE_NAME"], credentials)
+		return
+	}
+
 	logger.Logf(t, "Fetching docker-compose logs:")
 	docker.RunDockerCompose(t, &docker.Options{WorkingDir: exampleDir, EnvVars: envVars}, "logs")
 }
@@ -165,3 +253,146 @@ func stopCouchbaseWithDockerCompose(t *testing.T, exampleDir string, envVars map
 	docker.RunDockerCompose(t, &docker.Options{WorkingDir: exampleDir, EnvVars: envVars}, "down")
 	docker.RunDockerCompose(t, &docker.Options{WorkingDir: exampleDir, EnvVars: envVars}, "rm", "-f")
 }
+
+// The settings for setupExampleInDocker
+type dockerExampleSettings struct {
+	// The folder of the example under examples, e.g., couchbase-cluster-simple
+	Example string
+	Os      string
+	Edition string
+
+	// The ports on the host to map the Web Console and Sync Gateway of the example to. Leave them at 0 for examples
+	// with more than one cluster and pass their ports via EnvVars instead.
+	WebConsolePort  int
+	SyncGatewayPort int
+
+	// If not nil, Docker Compose also maps these ports, which the nodes advertise as their alternate addresses
+	AlternatePorts *CouchbaseAlternatePorts
+
+	// If set, generate a mock CA that the nodes sign their node certificates with
+	MockCa bool
+
+	// The args that select the Docker Compose files, e.g., -f docker-compose.yml. If empty, use the default files.
+	ComposeFiles []string
+
+	// Any other env vars to pass to Docker Compose
+	EnvVars map[string]string
+}
+
+// An example running in Docker Compose, as started by setupExampleInDocker
+type dockerExample struct {
+	UniqueId          string
+	ContainerBaseName string
+	EnvVars           map[string]string
+	Credentials       CouchbaseCredentials
+	TestUserPasswords TestUserPasswords
+	DockerOptions     *docker.Options
+	ComposeFiles      []string
+
+	// The CA certificate in PEM format, if the settings asked for a mock CA
+	CaCertPem []byte
+
+	// The URLs of the Web Console, without and with the credentials, if the settings had a WebConsolePort
+	ConsoleUrl   string
+	DataNodesUrl string
+}
+
+// Build the Docker image for the OS and edition in the given settings and start the example in Docker Compose with
+// random credentials in a mock secrets folder and random passwords for the test users. When the test is done, collect
+// the logs, or a diagnostics bundle if the test failed, and stop the example.
+func setupExampleInDocker(t *testing.T, settings dockerExampleSettings) *dockerExample {
+	uniqueId := random.UniqueId()
+	containerBaseName := fmt.Sprintf("couchbase-%s", uniqueId)
+
+	envVars := map[string]string{
+		"OS_NAME":             settings.Os,
+		"CONTAINER_BASE_NAME": containerBaseName,
+
+		// Give each test its own Docker Compose project, so tests running in parallel don't share networks or volumes
+		"COMPOSE_PROJECT_NAME": containerBaseName,
+	}
+
+	if settings.WebConsolePort != 0 {
+		envVars["WEB_CONSOLE_PORT"] = strconv.Itoa(settings.WebConsolePort)
+		envVars["SYNC_GATEWAY_PORT"] = strconv.Itoa(settings.SyncGatewayPort)
+	}
+
+	if settings.AlternatePorts != nil {
+		for key, value := range settings.AlternatePorts.envVars() {
+			envVars[key] = value
+		}
+	}
+
+	for key, value := range settings.EnvVars {
+		envVars[key] = value
+	}
+
+	credentials := newRandomCredentialsForTest()
+	mockSecretsDir, mockSecretsEnvVars := writeMockSecretsForDocker(t, credentials)
+	t.Cleanup(func() { os.RemoveAll(mockSecretsDir) })
+	for key, value := range mockSecretsEnvVars {
+		envVars[key] = value
+	}
+
+	testUserPasswords := newRandomTestUserPasswordsForTest()
+	for key, value := range testUserPasswords.envVars() {
+		envVars[key] = value
+	}
+
+	var caCertPem []byte
+	if settings.MockCa {
+		var mockCaEnvVars map[string]string
+		caCertPem, mockCaEnvVars = writeMockCaForDocker(t, mockSecretsDir)
+		for key, value := range mockCaEnvVars {
+			envVars[key] = value
+		}
+	}
+
+	tmpExamplesDir := test_structure.CopyTerraformFolderToTemp(t, "../", "examples")
+	couchbaseAmiDir := filepath.Join(tmpExamplesDir, "couchbase-ami")
+	exampleDockerDir := filepath.Join(tmpExamplesDir, settings.Example, "local-test")
+
+	example := &dockerExample{
+		UniqueId:          uniqueId,
+		ContainerBaseName: containerBaseName,
+		EnvVars:           envVars,
+		Credentials:       credentials,
+		TestUserPasswords: testUserPasswords,
+		DockerOptions:     &docker.Options{WorkingDir: exampleDockerDir, EnvVars: envVars},
+		ComposeFiles:      settings.ComposeFiles,
+		CaCertPem:         caCertPem,
+	}
+
+	if settings.WebConsolePort != 0 {
+		example.ConsoleUrl = fmt.Sprintf("http://localhost:%d", settings.WebConsolePort)
+		example.DataNodesUrl = formatUrlWithCredentials("http", credentials, fmt.Sprintf("localhost:%d", settings.WebConsolePort))
+	}
+
+	runTestStage(t, "setup_image", func() {
+		buildCouchbaseWithPacker(t, fmt.Sprintf("%s-docker", settings.Os), "couchbase", "us-east-1", couchbaseAmiDir, settings.Edition, CouchbaseVersions{})
+	})
+
+	// Cleanup runs once the test and its deferred calls are done
+	t.Cleanup(func() {
+		runTestStage(t, "teardown", func() {
+			if t.Failed() {
+				collectDiagnosticsInDockerOnFailure(t, containerBaseName, credentials)
+			} else {
+				logger.Logf(t, "Fetching docker-compose logs:")
+				example.compose(t, "logs")
+			}
+			example.compose(t, "down", "--volumes")
+		})
+	})
+
+	runTestStage(t, "setup_docker", func() {
+		example.compose(t, "up", "-d")
+	})
+
+	return example
+}
+
+// Run docker-compose with the given args, and the Compose files and env vars of the example, in its folder
+func (example *dockerExample) compose(t *testing.T, args ...string) {
+	docker.RunDockerCompose(t, example.DockerOptions, append(append([]string{}, example.ComposeFiles...), args...)...)
+}
@@ -0,0 +1,159 @@
+package fakecli
+
+// Minimal versions of the bash-commons (https://github.com/gruntwork-io/bash-commons) files the scripts source, with
+// just the functions they use. The functions in aws-wrapper.sh read from env vars rather than EC2 Instance Metadata and
+// the AWS APIs, and the tests pass --cluster-name, --hostname, and --rally-point-hostname, so couchbase-rally-point
+// never needs to look up the ASG. None of these files may declare readonly variables, as the scripts source some of
+// them more than once.
+var fakeBashCommons = map[string]string{
+	"log.sh": `#!/bin/bash
+
+function log {
+  local -r level="$1"
+  local -r message="$2"
+  local -r timestamp=$(date +"%Y-%m-%d %H:%M:%S")
+  local -r script_name="$(basename "$0")"
+  >&2 echo -e "${timestamp} [${level}] [$script_name] ${message}"
+}
+
+function log_info {
+  log "INFO" "$1"
+}
+
+function log_warn {
+  log "WARN" "$1"
+}
+
+function log_error {
+  log "ERROR" "$1"
+}
+`,
+
+	"assert.sh": `#!/bin/bash
+
+function assert_not_empty {
+  local -r arg_name="$1"
+  local -r arg_value="$2"
+  local -r reason="$3"
+
+  if [[ -z "$arg_value" ]]; then
+    log_error "The value for '$arg_name' cannot be empty. $reason"
+    exit 1
+  fi
+}
+
+function assert_empty {
+  local -r arg_name="$1"
+  local -r arg_value="$2"
+  local -r reason="$3"
+
+  if [[ ! -z "$arg_value" ]]; then
+    log_error "The value for '$arg_name' must be empty. $reason"
+    exit 1
+  fi
+}
+
+function assert_not_empty_or_null {
+  local -r response="$1"
+  local -r description="$2"
+
+  if [[ -z "$response" || "$response" == "null" ]]; then
+    log_error "Got empty or null response for $description."
+    exit 1
+  fi
+}
+
+function assert_value_in_list {
+  local -r arg_name="$1"
+  local -r arg_value="$2"
+  shift 2
+  local -ar list=("$@")
+
+  local value
+  for value in "${list[@]}"; do
+    if [[ "$value" == "$arg_value" ]]; then
+      return
+    fi
+  done
+
+  log_error "'$arg_value' is not a valid value for $arg_name. Must be one of: [${list[*]}]."
+  exit 1
+}
+
+function assert_is_installed {
+  local -r name="$1"
+
+  if [[ ! $(command -v "$name") ]]; then
+    log_error "The binary '$name' is required by this script but is not installed or in the system's PATH."
+    exit 1
+  fi
+}
+`,
+
+	"string.sh": `#!/bin/bash
+
+function string_contains {
+  local -r haystack="$1"
+  local -r needle="$2"
+
+  [[ "$haystack" == *"$needle"* ]]
+}
+
+function string_multiline_contains {
+  local -r haystack="$1"
+  local -r needle="$2"
+
+  echo "$haystack" | grep -q "$needle"
+}
+`,
+
+	"file.sh": `#!/bin/bash
+
+function file_replace_or_append_text {
+  local -r original_text_regex="$1"
+  local -r replacement_text="$2"
+  local -r file="$3"
+
+  if grep -q "$original_text_regex" "$file"; then
+    sed -i "s|$original_text_regex|$replacement_text|" "$file"
+  else
+    echo "$replacement_text" >> "$file"
+  fi
+}
+`,
+
+	"os.sh": `#!/bin/bash
+
+function os_get_available_memory_mb {
+  echo "${FAKE_AVAILABLE_MEMORY_MB:-4096}"
+}
+`,
+
+	"aws-wrapper.sh": `#!/bin/bash
+
+function aws_get_instance_region {
+  echo "${FAKE_AWS_REGION:-us-east-1}"
+}
+
+function aws_get_ec2_instance_availability_zone {
+  echo "${FAKE_AVAILABILITY_ZONE:-us-east-1a}"
+}
+
+function aws_wrapper_get_asg_name {
+  echo "$FAKE_ASG_NAME"
+}
+
+function aws_wrapper_get_hostname {
+  echo "$FAKE_HOSTNAME"
+}
+
+function aws_wrapper_get_hostnames_in_asg {
+  echo "$FAKE_ASG_HOSTNAMES"
+}
+
+function aws_wrapper_wait_for_instances_in_asg {
+  log_error "The fake bash-commons can't look up the Instances in an ASG. Pass --rally-point-hostname."
+  exit 1
+}
+`,
+}
@@ -0,0 +1,48 @@
+package fakecli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSecretReference(t *testing.T) {
+	t.Parallel()
+
+	sandbox := NewSandbox(t, testRepoRoot, nil)
+	defer sandbox.Close()
+
+	testCases := []struct {
+		value    string
+		expected bool
+	}{
+		{"env://COUCHBASE_PASSWORD", true},
+		{"file:///etc/couchbase/password", true},
+		{"ssm:///couchbase/admin-password", true},
+		{"secretsmanager://couchbase-admin-password", true},
+		{"password", false},
+		{"http://sync-gateway.example.com:4984", false},
+		{"couchbase://10.0.0.1", false},
+		{"SSM:///couchbase/admin-password", false},
+	}
+
+	for _, testCase := range testCases {
+		_, err := sandbox.RunCommonFunctionE("is_secret_reference", testCase.value)
+		assert.Equal(t, testCase.expected, err == nil, testCase.value)
+	}
+}
+
+func TestResolveSecretPassesThroughPlainValues(t *testing.T) {
+	t.Parallel()
+
+	sandbox := NewSandbox(t, testRepoRoot, nil)
+	defer sandbox.Close()
+
+	// couchbase-secrets is not installed in the sandbox, so resolve_secret would fail if it tried to resolve these
+	for _, value := range []string{"password", "http://sync-gateway.example.com:4984/db", "couchbase://10.0.0.1"} {
+		out, err := sandbox.RunCommonFunctionE("resolve_secret", value)
+		require.NoError(t, err, out)
+		assert.Equal(t, value, out)
+	}
+}
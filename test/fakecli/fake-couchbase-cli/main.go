@@ -0,0 +1,30 @@
+// A fake of couchbase-cli that records every invocation and returns the outputs scripted in the folder in the
+// FAKE_COUCHBASE_CLI_STATE_DIR env var. It acts as whatever command it's invoked as, so the test harness also links it
+// in as sudo, sleep, and the other commands the scripts call. See the fakecli package.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/fakecli"
+)
+
+func main() {
+	stateDir := os.Getenv(fakecli.StateDirEnvVar)
+	if stateDir == "" {
+		fmt.Fprintf(os.Stderr, "The %s env var must be set\n", fakecli.StateDirEnvVar)
+		os.Exit(1)
+	}
+
+	invocation := fakecli.Invocation{Command: filepath.Base(os.Args[0]), Args: os.Args[1:]}
+
+	exitCode, err := fakecli.Run(stateDir, invocation, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fake %s failed: %v\n", invocation.Command, err)
+		os.Exit(1)
+	}
+
+	os.Exit(exitCode)
+}
@@ -0,0 +1,204 @@
+// Package fakecli is a fake of couchbase-cli, and of the other commands run-couchbase-server calls, such as sudo and
+// sleep, that records every invocation and returns scripted outputs, plus a harness that runs the real
+// run-couchbase-server in a temp sandbox against those fakes. This lets us test the branching logic of the scripts
+// (e.g., init vs join, manual vs automatic memory settings, custom ports) without a live Couchbase server.
+package fakecli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The env var that tells the fake commands which folder to read their responses from and record their invocations in
+const StateDirEnvVar = "FAKE_COUCHBASE_CLI_STATE_DIR"
+
+// The name of the fake couchbase-cli command. The fake binary acts as whatever command it's invoked as.
+const CouchbaseCli = "couchbase-cli"
+
+const (
+	responsesFileName   = "responses.json"
+	invocationsFileName = "invocations.json"
+)
+
+// A scripted output of a fake command. A response applies to an invocation if the command and subcommand (the first
+// arg) match, the invocation has all the args in ArgsContain, and if After is set, couchbase-cli has already been
+// invoked with that subcommand. The first response that applies wins, so list responses with After before the ones
+// without.
+type Response struct {
+	// The command, e.g., couchbase-cli or curl. Defaults to couchbase-cli.
+	Command string
+
+	// The first arg, e.g., server-list. If empty, the response applies to every subcommand.
+	Subcommand string
+
+	ArgsContain []string
+	After       string
+
+	Output   string
+	ExitCode int
+}
+
+// A single run of a fake command
+type Invocation struct {
+	Command string
+	Args    []string
+}
+
+func (invocation Invocation) String() string {
+	return strings.Join(append([]string{invocation.Command}, invocation.Args...), " ")
+}
+
+// Write the responses the fake commands should return to the given state dir
+func WriteResponses(stateDir string, responses []Response) error {
+	bytes, err := json.MarshalIndent(responses, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(stateDir, responsesFileName), bytes, 0644)
+}
+
+func readResponses(stateDir string) ([]Response, error) {
+	bytes, err := ioutil.ReadFile(filepath.Join(stateDir, responsesFileName))
+	if os.IsNotExist(err) {
+		return []Response{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	responses := []Response{}
+	if err := json.Unmarshal(bytes, &responses); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", responsesFileName, err)
+	}
+	return responses, nil
+}
+
+// Return all the invocations of the fake commands recorded in the given state dir, in order
+func ReadInvocations(stateDir string) ([]Invocation, error) {
+	file, err := os.Open(filepath.Join(stateDir, invocationsFileName))
+	if os.IsNotExist(err) {
+		return []Invocation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	invocations := []Invocation{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var invocation Invocation
+		if err := json.Unmarshal(scanner.Bytes(), &invocation); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %v", invocationsFileName, err)
+		}
+		invocations = append(invocations, invocation)
+	}
+
+	return invocations, scanner.Err()
+}
+
+func recordInvocation(stateDir string, invocation Invocation) error {
+	bytes, err := json.Marshal(invocation)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(stateDir, invocationsFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(bytes, '\n'))
+	return err
+}
+
+// Return the first of the given responses that applies to the given invocation, given the invocations that came before
+// it, and whether any response applies
+func FindResponse(responses []Response, previous []Invocation, invocation Invocation) (Response, bool) {
+	for _, response := range responses {
+		if responseApplies(response, previous, invocation) {
+			return response, true
+		}
+	}
+	return Response{}, false
+}
+
+func responseApplies(response Response, previous []Invocation, invocation Invocation) bool {
+	command := response.Command
+	if command == "" {
+		command = CouchbaseCli
+	}
+	if command != invocation.Command {
+		return false
+	}
+
+	if response.Subcommand != "" && (len(invocation.Args) == 0 || invocation.Args[0] != response.Subcommand) {
+		return false
+	}
+
+	for _, expectedArg := range response.ArgsContain {
+		if !containsString(invocation.Args, expectedArg) {
+			return false
+		}
+	}
+
+	if response.After == "" {
+		return true
+	}
+
+	for _, previousInvocation := range previous {
+		if previousInvocation.Command == CouchbaseCli && len(previousInvocation.Args) > 0 && previousInvocation.Args[0] == response.After {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Record the given invocation in the given state dir, write the output of the response that applies to it to out, and
+// return its exit code. If no response applies, couchbase-cli fails with an error, as the real couchbase-cli does for
+// most unexpected calls, while all other commands, such as sudo and sleep, succeed without any output.
+func Run(stateDir string, invocation Invocation, out io.Writer) (int, error) {
+	responses, err := readResponses(stateDir)
+	if err != nil {
+		return 1, err
+	}
+
+	previous, err := ReadInvocations(stateDir)
+	if err != nil {
+		return 1, err
+	}
+
+	if err := recordInvocation(stateDir, invocation); err != nil {
+		return 1, err
+	}
+
+	response, hasResponse := FindResponse(responses, previous, invocation)
+	if !hasResponse {
+		if invocation.Command == CouchbaseCli {
+			fmt.Fprintf(out, "ERROR: The fake couchbase-cli has no scripted output for: %s\n", invocation)
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	fmt.Fprint(out, response.Output)
+	return response.ExitCode, nil
+}
@@ -0,0 +1,205 @@
+package fakecli
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRepoRoot       = "../.."
+	testClusterName    = "couchbase-test"
+	testRallyPoint     = "10.0.0.1"
+	testNode           = "10.0.0.2"
+	testClusterUser    = "admin"
+	testClusterPass    = "password"
+	testMemoryMb       = "4096"
+	testRestPort       = 8091
+	testCustomRestPort = 9091
+	defaultDataDir     = "couchbase/var/lib/couchbase/data"
+)
+
+// The line server-list prints for a node
+func serverListLine(hostname string, port int, status string) string {
+	return fmt.Sprintf("ns_1@%s %s:%d healthy %s\n", hostname, hostname, port, status)
+}
+
+func runCouchbaseServerArgs(nodeHostname string, extraArgs ...string) []string {
+	args := []string{
+		"--cluster-name", testClusterName,
+		"--hostname", nodeHostname,
+		"--rally-point-hostname", testRallyPoint,
+		"--cluster-username", testClusterUser,
+		"--cluster-password", testClusterPass,
+	}
+	return append(args, extraArgs...)
+}
+
+func serverList(hostname string, port int) string {
+	return fmt.Sprintf("couchbase-cli server-list --cluster=%s:%d --username=%s --password=%s", hostname, port, testClusterUser, testClusterPass)
+}
+
+// The node-init call with the default data dir of run-couchbase-server, which is in the sandbox
+func nodeInit(sandbox *Sandbox, hostname string, port int) string {
+	dataDir := sandbox.Path(defaultDataDir)
+	return fmt.Sprintf("couchbase-cli node-init --cluster=%s:%d --username=%s --password=%s --node-init-data-path=%s --node-init-index-path=%s --node-init-hostname=%s", hostname, port, testClusterUser, testClusterPass, dataDir, dataDir, hostname)
+}
+
+func TestRallyPointInitializesNewCluster(t *testing.T) {
+	t.Parallel()
+
+	sandbox := NewSandbox(t, testRepoRoot, []Response{
+		{Subcommand: "server-list", Output: "ERROR: unknown pool\n"},
+		{Subcommand: "node-init", Output: "SUCCESS: Node initialized\n"},
+		{Subcommand: "cluster-init", Output: "SUCCESS: Cluster initialized\n"},
+	})
+	defer sandbox.Close()
+
+	sandbox.RunCouchbaseServer(map[string]string{"FAKE_AVAILABLE_MEMORY_MB": testMemoryMb}, runCouchbaseServerArgs(testRallyPoint)...)
+
+	// 65% of 4096 MB is 2662 MB, which is split 50/25/25 across the data, index, and fts services
+	assert.Equal(t, []string{
+		serverList(testRallyPoint, testRestPort),
+		nodeInit(sandbox, testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		fmt.Sprintf("couchbase-cli cluster-init --cluster=%s --cluster-name=%s --cluster-port=%d --cluster-username=%s --cluster-password=%s --index-storage-setting=default --services=data,index,query,fts --cluster-ramsize=1331 --cluster-index-ramsize=665 --cluster-fts-ramsize=665", testRallyPoint, testClusterName, testRestPort, testClusterUser, testClusterPass),
+	}, sandbox.Invocations(CouchbaseCli))
+
+	assert.Equal(t, []string{
+		"sudo systemctl enable couchbase-server",
+		"sudo systemctl start couchbase-server",
+	}, sandbox.Invocations("sudo"))
+
+	assert.Contains(t, sandbox.ReadFile(defaultDataDir+"/.couchbase-node.json"), testRallyPoint)
+}
+
+func TestRallyPointRejoinsInitializedCluster(t *testing.T) {
+	t.Parallel()
+
+	sandbox := NewSandbox(t, testRepoRoot, []Response{
+		{Subcommand: "server-list", Output: serverListLine(testRallyPoint, testRestPort, "active") + serverListLine(testNode, testRestPort, "active")},
+		{Subcommand: "node-init", Output: "SUCCESS: Node initialized\n"},
+	})
+	defer sandbox.Close()
+
+	sandbox.RunCouchbaseServer(map[string]string{"FAKE_AVAILABLE_MEMORY_MB": testMemoryMb}, runCouchbaseServerArgs(testRallyPoint)...)
+
+	// The cluster is already initialized and the rally point is already active in it, so there's nothing to do but
+	// check: wait for the cluster, check for failover, check if added, wait for the cluster, check if active
+	assert.Equal(t, []string{
+		serverList(testRallyPoint, testRestPort),
+		nodeInit(sandbox, testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+	}, sandbox.Invocations(CouchbaseCli))
+}
+
+func TestNodeJoinsCluster(t *testing.T) {
+	t.Parallel()
+
+	sandbox := NewSandbox(t, testRepoRoot, []Response{
+		{Subcommand: "server-list", ArgsContain: []string{"--cluster=" + testNode + ":8091"}, Output: "ERROR: unknown pool\n"},
+		{Subcommand: "server-list", After: "rebalance", Output: serverListLine(testRallyPoint, testRestPort, "active") + serverListLine(testNode, testRestPort, "active")},
+		{Subcommand: "server-list", After: "server-add", Output: serverListLine(testRallyPoint, testRestPort, "active") + serverListLine(testNode, testRestPort, "inactiveAdded")},
+		{Subcommand: "server-list", Output: serverListLine(testRallyPoint, testRestPort, "active")},
+		{Subcommand: "node-init", Output: "SUCCESS: Node initialized\n"},
+		{Subcommand: "server-add", Output: "SUCCESS: Server added\n"},
+		{Subcommand: "rebalance", Output: "SUCCESS: Rebalance complete\n"},
+	})
+	defer sandbox.Close()
+
+	sandbox.RunCouchbaseServer(map[string]string{"FAKE_AVAILABLE_MEMORY_MB": testMemoryMb}, runCouchbaseServerArgs(testNode, "--node-services", "index,query")...)
+
+	assert.Equal(t, []string{
+		serverList(testNode, testRestPort),
+		nodeInit(sandbox, testNode, testRestPort),
+		// Wait for the cluster, check for failover, check if added
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		fmt.Sprintf("couchbase-cli server-add --cluster=%s:%d --username=%s --password=%s --server-add=http://%s:%d --server-add-username=%s --server-add-password=%s --services=index,query", testRallyPoint, testRestPort, testClusterUser, testClusterPass, testNode, testRestPort, testClusterUser, testClusterPass),
+		// Wait for the cluster, check if active
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		fmt.Sprintf("couchbase-cli rebalance --cluster=%s:%d --username=%s --password=%s --no-progress-bar", testRallyPoint, testRestPort, testClusterUser, testClusterPass),
+		serverList(testRallyPoint, testRestPort),
+	}, sandbox.Invocations(CouchbaseCli))
+}
+
+func TestNodeAlreadyAddedOnlyRebalances(t *testing.T) {
+	t.Parallel()
+
+	sandbox := NewSandbox(t, testRepoRoot, []Response{
+		{Subcommand: "server-list", ArgsContain: []string{"--cluster=" + testNode + ":8091"}, Output: "ERROR: unknown pool\n"},
+		{Subcommand: "server-list", After: "rebalance", Output: serverListLine(testRallyPoint, testRestPort, "active") + serverListLine(testNode, testRestPort, "active")},
+		{Subcommand: "server-list", Output: serverListLine(testRallyPoint, testRestPort, "active") + serverListLine(testNode, testRestPort, "inactiveAdded")},
+		{Subcommand: "node-init", Output: "SUCCESS: Node initialized\n"},
+		{Subcommand: "rebalance", Output: "SUCCESS: Rebalance complete\n"},
+	})
+	defer sandbox.Close()
+
+	sandbox.RunCouchbaseServer(map[string]string{"FAKE_AVAILABLE_MEMORY_MB": testMemoryMb}, runCouchbaseServerArgs(testNode)...)
+
+	assert.Equal(t, []string{
+		serverList(testNode, testRestPort),
+		nodeInit(sandbox, testNode, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		serverList(testRallyPoint, testRestPort),
+		fmt.Sprintf("couchbase-cli rebalance --cluster=%s:%d --username=%s --password=%s --no-progress-bar", testRallyPoint, testRestPort, testClusterUser, testClusterPass),
+		serverList(testRallyPoint, testRestPort),
+	}, sandbox.Invocations(CouchbaseCli))
+}
+
+func TestRallyPointWithCustomPortsAndManualMemory(t *testing.T) {
+	t.Parallel()
+
+	sandbox := NewSandbox(t, testRepoRoot, []Response{
+		{Subcommand: "server-list", Output: "ERROR: unknown pool\n"},
+		{Subcommand: "node-init", Output: "SUCCESS: Node initialized\n"},
+		{Subcommand: "cluster-init", Output: "SUCCESS: Cluster initialized\n"},
+	})
+	defer sandbox.Close()
+
+	sandbox.RunCouchbaseServer(nil, runCouchbaseServerArgs(
+		testRallyPoint,
+		"--cluster-services", "data,query",
+		"--node-services", "data,query",
+		"--manage-memory-manually",
+		"--data-ramsize", "512",
+		"--rest-port", fmt.Sprintf("%d", testCustomRestPort),
+		"--capi-port", "9092",
+		"--memcached-port", "12210",
+	)...)
+
+	assert.Equal(t, []string{
+		serverList(testRallyPoint, testCustomRestPort),
+		nodeInit(sandbox, testRallyPoint, testCustomRestPort),
+		serverList(testRallyPoint, testCustomRestPort),
+		fmt.Sprintf("couchbase-cli cluster-init --cluster=%s --cluster-name=%s --cluster-port=%d --cluster-username=%s --cluster-password=%s --index-storage-setting=default --services=data,query --cluster-ramsize=512", testRallyPoint, testClusterName, testCustomRestPort, testClusterUser, testClusterPass),
+	}, sandbox.Invocations(CouchbaseCli))
+
+	staticConfig := sandbox.ReadFile(StaticConfigPath)
+	assert.Contains(t, staticConfig, fmt.Sprintf("{rest_port, %d}.", testCustomRestPort))
+	assert.Contains(t, staticConfig, "{memcached_port, 12210}.")
+	assert.Contains(t, sandbox.ReadFile(CapiConfigPath), "port = 9092")
+}
+
+func TestManualMemoryRequiresAllSettings(t *testing.T) {
+	t.Parallel()
+
+	sandbox := NewSandbox(t, testRepoRoot, []Response{})
+	defer sandbox.Close()
+
+	out, err := sandbox.RunCouchbaseServerE(nil, runCouchbaseServerArgs(testRallyPoint, "--manage-memory-manually", "--data-ramsize", "512")...)
+	assert.Error(t, err)
+	assert.Contains(t, out, "--index-ramsize")
+	assert.Empty(t, sandbox.Invocations(CouchbaseCli))
+}
@@ -0,0 +1,196 @@
+package fakecli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/stretchr/testify/require"
+)
+
+// The scripts hard code paths under /opt, so we copy them into the sandbox and point those paths at the sandbox instead
+const optDir = "/opt/"
+
+// The scripts from the modules we copy into the sandbox, relative to the root of this repo, and where they go in /opt
+var sandboxScripts = map[string]string{
+	"modules/run-couchbase-server/run-couchbase-server": "couchbase/bin/run-couchbase-server",
+	"modules/couchbase-commons/couchbase-common.sh":     "couchbase-commons/couchbase-common.sh",
+	"modules/couchbase-commons/couchbase-rally-point":   "couchbase-commons/couchbase-rally-point",
+}
+
+// The commands, other than couchbase-cli, that we replace with the fake, so the scripts don't start services, sleep
+// between retries, or talk to AWS. The fakes succeed without output unless a Response says otherwise.
+var fakeCommands = []string{"sudo", "sleep", "aws", "curl"}
+
+// The folders run-couchbase-server expects to exist, relative to /opt
+var sandboxDirs = []string{
+	"couchbase/etc/couchbase",
+	"couchbase/etc/couchdb/default.d",
+	"couchbase/var/lib/couchbase/data",
+}
+
+// The config files run-couchbase-server writes the ports to, relative to /opt
+const (
+	StaticConfigPath = "couchbase/etc/couchbase/static_config"
+	CapiConfigPath   = "couchbase/etc/couchdb/default.d/capi.ini"
+)
+
+var buildFakeOnce sync.Once
+var fakeBinaryPath string
+var buildFakeErr error
+
+// Build the fake couchbase-cli binary once for all the tests in this process
+func buildFake(t *testing.T) string {
+	buildFakeOnce.Do(func() {
+		var tmpDir string
+		tmpDir, buildFakeErr = ioutil.TempDir("", "fake-couchbase-cli")
+		if buildFakeErr != nil {
+			return
+		}
+
+		fakeBinaryPath = filepath.Join(tmpDir, CouchbaseCli)
+		buildFakeErr = shell.RunCommandE(t, shell.Command{
+			Command: "go",
+			Args:    []string{"build", "-o", fakeBinaryPath, "github.com/gruntwork-io/terraform-aws-couchbase/test/fakecli/fake-couchbase-cli"},
+		})
+	})
+
+	require.NoError(t, buildFakeErr, "Failed to build the fake couchbase-cli")
+	return fakeBinaryPath
+}
+
+// A temp folder with copies of the real scripts, which use the fake couchbase-cli, the fake bash-commons, and the fake
+// commands instead of the real ones
+type Sandbox struct {
+	Dir string
+	t   *testing.T
+}
+
+// Create a sandbox in which the fake commands return the given responses. The repoRoot is the path to the root of
+// this repo, from which we copy the scripts. Call Close when you're done with it.
+func NewSandbox(t *testing.T, repoRoot string, responses []Response) *Sandbox {
+	for _, command := range []string{"bash", "jq", "go"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("The script tests require %s, which is not installed", command)
+		}
+	}
+
+	fake := buildFake(t)
+
+	dir, err := ioutil.TempDir("", "couchbase-script-sandbox")
+	require.NoError(t, err)
+	sandbox := &Sandbox{Dir: dir, t: t}
+
+	for _, sandboxDir := range append(sandboxDirs, "gruntwork/bash-commons", "couchbase-commons", "couchbase/bin") {
+		require.NoError(t, os.MkdirAll(sandbox.Path(sandboxDir), 0755))
+	}
+	require.NoError(t, os.MkdirAll(sandbox.binDir(), 0755))
+	require.NoError(t, os.MkdirAll(sandbox.stateDir(), 0755))
+
+	for _, configPath := range []string{StaticConfigPath, CapiConfigPath} {
+		require.NoError(t, ioutil.WriteFile(sandbox.Path(configPath), []byte{}, 0644))
+	}
+
+	for name, contents := range fakeBashCommons {
+		require.NoError(t, ioutil.WriteFile(sandbox.Path("gruntwork/bash-commons/"+name), []byte(contents), 0644))
+	}
+
+	for repoPath, sandboxPath := range sandboxScripts {
+		contents, err := ioutil.ReadFile(filepath.Join(repoRoot, repoPath))
+		require.NoError(t, err)
+
+		rewritten := strings.ReplaceAll(string(contents), optDir, filepath.Join(dir, "opt")+"/")
+		require.NoError(t, ioutil.WriteFile(sandbox.Path(sandboxPath), []byte(rewritten), 0755))
+	}
+
+	require.NoError(t, os.Symlink(fake, sandbox.Path("couchbase/bin/"+CouchbaseCli)))
+	for _, command := range fakeCommands {
+		require.NoError(t, os.Symlink(fake, filepath.Join(sandbox.binDir(), command)))
+	}
+
+	require.NoError(t, WriteResponses(sandbox.stateDir(), responses))
+
+	return sandbox
+}
+
+// Return the path in the sandbox of the given path, relative to /opt
+func (sandbox *Sandbox) Path(optPath string) string {
+	return filepath.Join(sandbox.Dir, "opt", optPath)
+}
+
+func (sandbox *Sandbox) binDir() string {
+	return filepath.Join(sandbox.Dir, "bin")
+}
+
+func (sandbox *Sandbox) stateDir() string {
+	return filepath.Join(sandbox.Dir, "state")
+}
+
+// Delete the sandbox
+func (sandbox *Sandbox) Close() {
+	os.RemoveAll(sandbox.Dir)
+}
+
+// Run run-couchbase-server in the sandbox with the given args and env vars, and return its stdout and stderr
+func (sandbox *Sandbox) RunCouchbaseServerE(env map[string]string, args ...string) (string, error) {
+	fullEnv := map[string]string{
+		StateDirEnvVar: sandbox.stateDir(),
+		"PATH":         fmt.Sprintf("%s:%s", sandbox.binDir(), os.Getenv("PATH")),
+	}
+	for key, value := range env {
+		fullEnv[key] = value
+	}
+
+	return shell.RunCommandAndGetOutputE(sandbox.t, shell.Command{
+		Command: "bash",
+		Args:    append([]string{sandbox.Path("couchbase/bin/run-couchbase-server")}, args...),
+		Env:     fullEnv,
+	})
+}
+
+// Source couchbase-common.sh in the sandbox, run the given function in it with the given args, and return its stdout
+// and stderr
+func (sandbox *Sandbox) RunCommonFunctionE(function string, args ...string) (string, error) {
+	return shell.RunCommandAndGetOutputE(sandbox.t, shell.Command{
+		Command: "bash",
+		Args:    append([]string{"-c", `source "$0" && "$@"`, sandbox.Path("couchbase-commons/couchbase-common.sh"), function}, args...),
+		Env: map[string]string{
+			StateDirEnvVar: sandbox.stateDir(),
+			"PATH":         fmt.Sprintf("%s:%s", sandbox.binDir(), os.Getenv("PATH")),
+		},
+	})
+}
+
+// Run run-couchbase-server in the sandbox with the given args and env vars, failing the test if it fails
+func (sandbox *Sandbox) RunCouchbaseServer(env map[string]string, args ...string) string {
+	out, err := sandbox.RunCouchbaseServerE(env, args...)
+	require.NoError(sandbox.t, err, "run-couchbase-server failed:\n%s", out)
+	return out
+}
+
+// Return each invocation of the given fake command so far, in order, in the format: command arg1 arg2 ...
+func (sandbox *Sandbox) Invocations(command string) []string {
+	invocations, err := ReadInvocations(sandbox.stateDir())
+	require.NoError(sandbox.t, err)
+
+	out := []string{}
+	for _, invocation := range invocations {
+		if invocation.Command == command {
+			out = append(out, invocation.String())
+		}
+	}
+	return out
+}
+
+// Return the contents of the given file in the sandbox, relative to /opt
+func (sandbox *Sandbox) ReadFile(optPath string) string {
+	contents, err := ioutil.ReadFile(sandbox.Path(optPath))
+	require.NoError(sandbox.t, err)
+	return string(contents)
+}
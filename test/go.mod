@@ -3,6 +3,10 @@ module github.com/gruntwork-io/terraform-aws-couchbase/test
 go 1.14
 
 require (
+	github.com/aws/aws-sdk-go v1.38.28
+	github.com/couchbase/gocb/v2 v2.1.6
 	github.com/gruntwork-io/terratest v0.36.0
+	github.com/hashicorp/terraform-json v0.9.0
 	github.com/stretchr/testify v1.6.1
+	github.com/urfave/cli v1.22.2
 )
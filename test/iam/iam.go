@@ -0,0 +1,350 @@
+// Package iam works out which AWS API actions the scripts in the modules call, and checks them against the actions
+// the IAM policies of the couchbase-iam-policies module grant, so the policies stay least privilege as the scripts
+// change.
+package iam
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// The AWS API actions each bash-commons function the scripts use calls. Most of the scripts don't call the AWS CLI
+// directly, but call these functions from aws.sh and aws-wrapper.sh in bash-commons
+// (https://github.com/gruntwork-io/bash-commons). The functions that only read EC2 Instance Metadata don't call any
+// AWS APIs, so they don't need any IAM permissions. If you use a new bash-commons function in a script, add it here.
+var BashCommonsActions = map[string][]string{
+	"aws_get_instance_private_ip":            {},
+	"aws_get_instance_public_ip":             {},
+	"aws_get_instance_private_hostname":      {},
+	"aws_get_instance_public_hostname":       {},
+	"aws_get_instance_region":                {},
+	"aws_get_ec2_instance_availability_zone": {},
+	"aws_get_instance_id":                    {},
+	"aws_get_instance_tags":                  {"ec2:DescribeTags"},
+	"aws_describe_asg":                       {"autoscaling:DescribeAutoScalingGroups"},
+	"aws_describe_instances_in_asg":          {"ec2:DescribeInstances"},
+	"aws_wrapper_get_hostname":               {},
+	"aws_wrapper_get_instance_tag":           {"ec2:DescribeTags"},
+	"aws_wrapper_get_asg_name":               {"ec2:DescribeTags"},
+	"aws_wrapper_get_asg_size":               {"autoscaling:DescribeAutoScalingGroups"},
+	"aws_wrapper_wait_for_instances_in_asg":  {"autoscaling:DescribeAutoScalingGroups", "ec2:DescribeInstances"},
+	"aws_wrapper_get_ips_in_asg":             {"autoscaling:DescribeAutoScalingGroups", "ec2:DescribeInstances"},
+	"aws_wrapper_get_hostnames_in_asg":       {"autoscaling:DescribeAutoScalingGroups", "ec2:DescribeInstances"},
+}
+
+// The prefixes of the names of the bash-commons functions that talk to AWS. We fail on any function with one of these
+// prefixes that isn't in BashCommonsActions, rather than silently assuming it doesn't need any permissions.
+var bashCommonsFunctionRegex = regexp.MustCompile(`(^|[^$\w{])(aws_(?:wrapper_|get_|describe_)\w+)`)
+
+// A call to the AWS CLI, e.g.: aws ec2 describe-instances --region "$aws_region"
+var awsCliRegex = regexp.MustCompile(`(^|[\s;&|(])aws\s+(.+)$`)
+
+// The AWS CLI service names whose IAM prefix is different
+var awsCliServicePrefixes = map[string]string{
+	"s3api": "s3",
+}
+
+// An AWS API action a script calls, and where it calls it
+type ActionUse struct {
+	Action string
+	Script string
+	Line   int
+}
+
+func (use ActionUse) String() string {
+	return fmt.Sprintf("%s (%s:%d)", use.Action, use.Script, use.Line)
+}
+
+// Return the AWS API actions the given bash script calls, either via the AWS CLI, or via the bash-commons functions
+// in BashCommonsActions. Returns an error if the script calls an AWS function of bash-commons we don't know.
+func ScriptActions(scriptPath string) ([]ActionUse, error) {
+	contents, err := ioutil.ReadFile(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScriptActions(scriptPath, string(contents))
+}
+
+// Return the AWS API actions the given contents of a bash script call. See ScriptActions.
+func ParseScriptActions(scriptPath string, contents string) ([]ActionUse, error) {
+	uses := []ActionUse{}
+
+	lineNumber := 0
+	startLineNumber := 0
+	command := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+
+		if command == "" {
+			startLineNumber = lineNumber
+		}
+
+		// Join commands that are split over several lines with a trailing backslash, so we see all the arguments of
+		// an AWS CLI call together
+		if strings.HasSuffix(line, "\\") {
+			command += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		command += line
+
+		commandUses, err := parseCommandActions(scriptPath, startLineNumber, command)
+		if err != nil {
+			return nil, err
+		}
+		uses = append(uses, commandUses...)
+		command = ""
+	}
+
+	return uses, scanner.Err()
+}
+
+func parseCommandActions(scriptPath string, lineNumber int, command string) ([]ActionUse, error) {
+	command = strings.TrimSpace(command)
+	if strings.HasPrefix(command, "#") {
+		return nil, nil
+	}
+
+	uses := []ActionUse{}
+
+	for _, match := range bashCommonsFunctionRegex.FindAllStringSubmatch(command, -1) {
+		function := match[2]
+		actions, isKnown := BashCommonsActions[function]
+		if !isKnown {
+			return nil, fmt.Errorf("%s:%d calls bash-commons function %s, but we don't know which AWS API actions it calls. Add it to BashCommonsActions.", scriptPath, lineNumber, function)
+		}
+		for _, action := range actions {
+			uses = append(uses, ActionUse{Action: action, Script: scriptPath, Line: lineNumber})
+		}
+	}
+
+	if match := awsCliRegex.FindStringSubmatch(command); match != nil {
+		if action, isCall := awsCliAction(strings.Fields(match[2])); isCall {
+			uses = append(uses, ActionUse{Action: action, Script: scriptPath, Line: lineNumber})
+		}
+	}
+
+	return uses, nil
+}
+
+// Return the IAM action for the given arguments of the AWS CLI, e.g., ec2:DescribeInstances for ec2
+// describe-instances, and whether the arguments are an API call at all
+func awsCliAction(args []string) (string, bool) {
+	positional := []string{}
+	for i := 0; i < len(args) && len(positional) < 2; i++ {
+		arg := strings.Trim(args[i], `"'`)
+		if strings.HasPrefix(arg, "-") {
+			// Skip the value of global options, such as --region "$aws_region", too
+			if !strings.Contains(arg, "=") {
+				i++
+			}
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	if len(positional) < 2 || !isCliName(positional[0]) || !isCliName(positional[1]) {
+		return "", false
+	}
+
+	service := positional[0]
+	if prefix, hasPrefix := awsCliServicePrefixes[service]; hasPrefix {
+		service = prefix
+	}
+
+	operation := ""
+	for _, word := range strings.Split(positional[1], "-") {
+		operation += strings.ToUpper(word[:1]) + word[1:]
+	}
+
+	return fmt.Sprintf("%s:%s", service, operation), true
+}
+
+var cliNameRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func isCliName(arg string) bool {
+	return cliNameRegex.MatchString(arg)
+}
+
+// Return the AWS API actions the given scripts call
+func ScriptsActions(scriptPaths []string) ([]ActionUse, error) {
+	uses := []ActionUse{}
+	for _, scriptPath := range scriptPaths {
+		scriptUses, err := ScriptActions(scriptPath)
+		if err != nil {
+			return nil, err
+		}
+		uses = append(uses, scriptUses...)
+	}
+	return uses, nil
+}
+
+// The parts of an IAM policy document we care about
+type policyDocument struct {
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Effect string      `json:"Effect"`
+	Action interface{} `json:"Action"`
+}
+
+// Return the actions the Allow statements of the given IAM policy document grant. The actions may contain wildcards,
+// such as ec2:Describe*.
+func ParsePolicyActions(policy string) ([]string, error) {
+	var document policyDocument
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return nil, fmt.Errorf("Failed to parse IAM policy document: %v", err)
+	}
+
+	actions := []string{}
+	for _, statement := range document.Statement {
+		if statement.Effect != "Allow" {
+			continue
+		}
+
+		switch action := statement.Action.(type) {
+		case string:
+			actions = append(actions, action)
+		case []interface{}:
+			for _, value := range action {
+				if actionString, isString := value.(string); isString {
+					actions = append(actions, actionString)
+				}
+			}
+		}
+	}
+
+	sort.Strings(actions)
+	return actions, nil
+}
+
+// Return the actions the IAM role policy with the given address grants, reading the policy document from the planned
+// values of a plan (e.g., terraform.PlanStruct.ResourcePlannedValuesMap). Returns an error if the plan doesn't create
+// the policy, or if Terraform can't work out the policy document until apply.
+func PlannedPolicyActions(plannedValues map[string]*tfjson.StateResource, address string) ([]string, error) {
+	resource, hasResource := plannedValues[address]
+	if !hasResource {
+		return nil, fmt.Errorf("The plan doesn't create IAM role policy %s", address)
+	}
+
+	policy, hasPolicy := resource.AttributeValues["policy"].(string)
+	if !hasPolicy {
+		return nil, fmt.Errorf("The policy document of %s isn't known until apply", address)
+	}
+
+	return ParsePolicyActions(policy)
+}
+
+// The differences between the actions scripts call and the actions a policy grants
+type Report struct {
+	// Actions the scripts call that the policy doesn't grant, with where the scripts call them
+	Missing map[string][]ActionUse
+
+	// Actions the policy grants that none of the scripts call
+	Unused []string
+}
+
+func (report Report) IsEmpty() bool {
+	return len(report.Missing) == 0 && len(report.Unused) == 0
+}
+
+func (report Report) String() string {
+	if report.IsEmpty() {
+		return "The IAM policy grants exactly the actions the scripts call"
+	}
+
+	var out strings.Builder
+
+	actions := []string{}
+	for action := range report.Missing {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	for _, action := range actions {
+		calls := []string{}
+		for _, use := range report.Missing[action] {
+			calls = append(calls, fmt.Sprintf("%s:%d", use.Script, use.Line))
+		}
+		fmt.Fprintf(&out, "Action %s is not granted, but is called by %s\n", action, strings.Join(calls, ", "))
+	}
+
+	for _, action := range report.Unused {
+		fmt.Fprintf(&out, "Action %s is granted, but never called\n", action)
+	}
+
+	return strings.TrimSpace(out.String())
+}
+
+// Compare the actions the scripts call against the actions a policy grants. Every action a script calls must be
+// granted by at least one of the granted actions, which may contain wildcards, and every granted action must match at
+// least one action a script calls.
+func Check(uses []ActionUse, granted []string) Report {
+	report := Report{Missing: map[string][]ActionUse{}, Unused: []string{}}
+
+	for _, use := range uses {
+		isGranted := false
+		for _, grantedAction := range granted {
+			if actionMatches(grantedAction, use.Action) {
+				isGranted = true
+				break
+			}
+		}
+
+		if !isGranted {
+			report.Missing[use.Action] = append(report.Missing[use.Action], use)
+		}
+	}
+
+	for _, grantedAction := range granted {
+		isUsed := false
+		for _, use := range uses {
+			if actionMatches(grantedAction, use.Action) {
+				isUsed = true
+				break
+			}
+		}
+
+		if !isUsed {
+			report.Unused = append(report.Unused, grantedAction)
+		}
+	}
+
+	return report
+}
+
+// IAM action names are case insensitive, and a granted action may contain * and ? wildcards
+func actionMatches(grantedAction string, action string) bool {
+	matches, err := path.Match(strings.ToLower(grantedAction), strings.ToLower(action))
+	return err == nil && matches
+}
+
+// Return the paths of the scripts in the modules that run on the Couchbase and Sync Gateway nodes with the permissions
+// of the couchbase-iam-policies module, given the root of this repo
+func NodeScripts(repoRoot string) []string {
+	scripts := []string{
+		"modules/couchbase-commons/couchbase-common.sh",
+		"modules/couchbase-commons/couchbase-rally-point",
+		"modules/couchbase-commons/mount-volume.sh",
+		"modules/run-couchbase-server/run-couchbase-server",
+		"modules/run-replication/run-replication",
+		"modules/run-sync-gateway/run-sync-gateway",
+	}
+
+	paths := []string{}
+	for _, script := range scripts {
+		paths = append(paths, path.Join(repoRoot, script))
+	}
+	return paths
+}
@@ -0,0 +1,120 @@
+package iam
+
+import (
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScriptActions(t *testing.T) {
+	t.Parallel()
+
+	script := `#!/bin/bash
+source "/opt/gruntwork/bash-commons/aws-wrapper.sh"
+
+function get_hostnames {
+  local readonly aws_region="$1"
+  # aws_wrapper_get_hostnames_in_asg would also work here
+  instances=$(aws_wrapper_wait_for_instances_in_asg "$asg_name" "${aws_region}")
+  zone=$(aws_get_ec2_instance_availability_zone)
+  aws --region "$aws_region" ec2 \
+    describe-volumes --volume-ids "$volume_id"
+  aws s3api get-object --bucket "$bucket" --key "$key" out
+  assert_is_installed "aws"
+}
+`
+
+	uses, err := ParseScriptActions("script", script)
+	require.NoError(t, err)
+	assert.Equal(t, []ActionUse{
+		{Action: "autoscaling:DescribeAutoScalingGroups", Script: "script", Line: 7},
+		{Action: "ec2:DescribeInstances", Script: "script", Line: 7},
+		{Action: "ec2:DescribeVolumes", Script: "script", Line: 9},
+		{Action: "s3:GetObject", Script: "script", Line: 11},
+	}, uses)
+}
+
+func TestParseScriptActionsUnknownBashCommonsFunction(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseScriptActions("script", `tags=$(aws_wrapper_get_all_tags "$instance_id")`)
+	assert.Error(t, err)
+}
+
+func TestParsePolicyActions(t *testing.T) {
+	t.Parallel()
+
+	policy := `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {"Effect": "Allow", "Action": ["ec2:DescribeTags", "ec2:DescribeInstances"], "Resource": "*"},
+    {"Effect": "Allow", "Action": "autoscaling:DescribeAutoScalingGroups", "Resource": "*"},
+    {"Effect": "Deny", "Action": "ec2:TerminateInstances", "Resource": "*"}
+  ]
+}`
+
+	actions, err := ParsePolicyActions(policy)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"autoscaling:DescribeAutoScalingGroups", "ec2:DescribeInstances", "ec2:DescribeTags"}, actions)
+}
+
+func TestPlannedPolicyActions(t *testing.T) {
+	t.Parallel()
+
+	plannedValues := map[string]*tfjson.StateResource{
+		"module.iam_policies.aws_iam_role_policy.auto_discover_cluster": {
+			AttributeValues: map[string]interface{}{"policy": `{"Statement": [{"Effect": "Allow", "Action": "ec2:DescribeTags"}]}`},
+		},
+		"module.iam_policies.aws_iam_role_policy.backup_to_s3[0]": {
+			AttributeValues: map[string]interface{}{"name": "backup-to-s3"},
+		},
+	}
+
+	actions, err := PlannedPolicyActions(plannedValues, "module.iam_policies.aws_iam_role_policy.auto_discover_cluster")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ec2:DescribeTags"}, actions)
+
+	_, err = PlannedPolicyActions(plannedValues, "module.iam_policies.aws_iam_role_policy.backup_to_s3[0]")
+	assert.Error(t, err)
+
+	_, err = PlannedPolicyActions(plannedValues, "module.other.aws_iam_role_policy.auto_discover_cluster")
+	assert.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	uses := []ActionUse{
+		{Action: "ec2:DescribeTags", Script: "a", Line: 1},
+		{Action: "ec2:DescribeInstances", Script: "a", Line: 2},
+		{Action: "autoscaling:DescribeAutoScalingGroups", Script: "b", Line: 3},
+		{Action: "autoscaling:DescribeAutoScalingGroups", Script: "c", Line: 4},
+	}
+
+	report := Check(uses, []string{"ec2:describe*", "s3:GetObject"})
+	assert.False(t, report.IsEmpty())
+	assert.Equal(t, map[string][]ActionUse{"autoscaling:DescribeAutoScalingGroups": {uses[2], uses[3]}}, report.Missing)
+	assert.Equal(t, []string{"s3:GetObject"}, report.Unused)
+
+	report = Check(uses, []string{"ec2:DescribeTags", "ec2:DescribeInstances", "autoscaling:DescribeAutoScalingGroups"})
+	assert.True(t, report.IsEmpty(), report.String())
+}
+
+func TestModuleScriptsOnlyCallKnownActions(t *testing.T) {
+	t.Parallel()
+
+	uses, err := ScriptsActions(NodeScripts("../.."))
+	require.NoError(t, err)
+
+	actions := map[string]bool{}
+	for _, use := range uses {
+		actions[use.Action] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"autoscaling:DescribeAutoScalingGroups": true,
+		"ec2:DescribeInstances":                 true,
+		"ec2:DescribeTags":                      true,
+	}, actions)
+}
@@ -0,0 +1,21 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// Write the timeline of the test run once all the tests, including the parallel ones, are done
+func TestMain(m *testing.M) {
+	exitCode := m.Run()
+
+	if err := writeTestTimeline(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		if exitCode == 0 {
+			exitCode = 1
+		}
+	}
+
+	os.Exit(exitCode)
+}
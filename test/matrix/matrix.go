@@ -0,0 +1,386 @@
+// Package matrix loads the combinations of example, OS, edition, Couchbase version, and Sync Gateway version the tests
+// run from a data file, expands them into cells, filters the cells, and records the outcome of each cell in a JSON
+// summary. See test_matrix.json in the test folder.
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The version to use when the matrix doesn't pin one: whatever the install scripts install by default
+const DefaultVersion = "default"
+
+// The outcomes of a cell
+const (
+	OutcomePassed   = "passed"
+	OutcomeFailed   = "failed"
+	OutcomeSkipped  = "skipped"
+	OutcomeFiltered = "filtered"
+)
+
+// The suites in the data file. Each suite is a list of entries, each of which expands into one cell per combination of
+// its dimensions. Every version of Couchbase and Sync Gateway the entries pin, rather than using DefaultVersion, must
+// have its checksums in CouchbaseVersions or SyncGatewayVersions.
+type Matrix struct {
+	Integration         []Entry                     `json:"integration"`
+	Docker              []Entry                     `json:"docker"`
+	CouchbaseVersions   map[string]PackageChecksums `json:"couchbase_versions"`
+	SyncGatewayVersions map[string]PackageChecksums `json:"sync_gateway_versions"`
+}
+
+// The checksums of the packages of a version of Couchbase or Sync Gateway. The packages differ per edition and per OS,
+// so Checksums maps each edition to a map of each OS to the checksum of its package.
+type PackageChecksums struct {
+	ChecksumType string                       `json:"checksum_type"`
+	Checksums    map[string]map[string]string `json:"checksums"`
+}
+
+// The version of Couchbase or Sync Gateway to install, and the checksum of its package. The zero value means the
+// default version of the install script.
+type Package struct {
+	Version      string
+	Checksum     string
+	ChecksumType string
+}
+
+// An entry of a suite. The Variant tells apart entries of the same example that run with different settings. The
+// Settings are passed as is to every cell of the entry, unless SettingsByOs has settings for the OS of the cell.
+type Entry struct {
+	Example            string                     `json:"example"`
+	Variant            string                     `json:"variant"`
+	Os                 []string                   `json:"os"`
+	Edition            []string                   `json:"edition"`
+	CouchbaseVersion   []string                   `json:"couchbase_version"`
+	SyncGatewayVersion []string                   `json:"sync_gateway_version"`
+	Settings           json.RawMessage            `json:"settings"`
+	SettingsByOs       map[string]json.RawMessage `json:"settings_by_os"`
+}
+
+// A single combination of example, OS, edition, and versions to test
+type Cell struct {
+	Suite              string          `json:"suite"`
+	Example            string          `json:"example"`
+	Variant            string          `json:"variant,omitempty"`
+	Os                 string          `json:"os"`
+	Edition            string          `json:"edition"`
+	CouchbaseVersion   string          `json:"couchbase_version"`
+	SyncGatewayVersion string          `json:"sync_gateway_version"`
+	Settings           json.RawMessage `json:"-"`
+	// The packages to install for CouchbaseVersion and SyncGatewayVersion
+	CouchbasePackage   Package `json:"-"`
+	SyncGatewayPackage Package `json:"-"`
+}
+
+// Load the matrix from the given JSON file
+func Load(path string) (*Matrix, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var matrix Matrix
+	if err := json.Unmarshal(contents, &matrix); err != nil {
+		return nil, fmt.Errorf("Failed to parse test matrix %s: %v", path, err)
+	}
+
+	return &matrix, nil
+}
+
+// Return the cells of the given suite, in the order of the entries, and within each entry, in the order of the values
+// of each dimension
+func (matrix *Matrix) Cells(suite string) ([]Cell, error) {
+	var entries []Entry
+	switch suite {
+	case "integration":
+		entries = matrix.Integration
+	case "docker":
+		entries = matrix.Docker
+	default:
+		return nil, fmt.Errorf("Unknown test matrix suite '%s'", suite)
+	}
+
+	cells := []Cell{}
+	names := map[string]bool{}
+
+	for _, entry := range entries {
+		entryCells, err := entry.cells(suite)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cell := range entryCells {
+			cell.CouchbasePackage, err = lookUpPackage("Couchbase", matrix.CouchbaseVersions, cell.CouchbaseVersion, cell)
+			if err != nil {
+				return nil, err
+			}
+
+			cell.SyncGatewayPackage, err = lookUpPackage("Sync Gateway", matrix.SyncGatewayVersions, cell.SyncGatewayVersion, cell)
+			if err != nil {
+				return nil, err
+			}
+
+			if names[cell.Name()] {
+				return nil, fmt.Errorf("Test matrix suite '%s' has more than one cell named %s", suite, cell.Name())
+			}
+			names[cell.Name()] = true
+			cells = append(cells, cell)
+		}
+	}
+
+	return cells, nil
+}
+
+// Return the package of the given version for the edition and OS of the given cell
+func lookUpPackage(product string, versions map[string]PackageChecksums, version string, cell Cell) (Package, error) {
+	if version == DefaultVersion {
+		return Package{}, nil
+	}
+
+	checksums, ok := versions[version]
+	if !ok {
+		return Package{}, fmt.Errorf("Test matrix cell %s uses %s version %s, but the test matrix has no checksums for that version", cell.Name(), product, version)
+	}
+
+	checksum := checksums.Checksums[cell.Edition][cell.Os]
+	if checksum == "" {
+		return Package{}, fmt.Errorf("Test matrix cell %s uses %s version %s, but the test matrix has no checksum for the package of that version for the %s edition on %s", cell.Name(), product, version, cell.Edition, cell.Os)
+	}
+
+	if checksums.ChecksumType == "" {
+		return Package{}, fmt.Errorf("The checksums of %s version %s in the test matrix have no checksum_type", product, version)
+	}
+
+	return Package{Version: version, Checksum: checksum, ChecksumType: checksums.ChecksumType}, nil
+}
+
+func (entry Entry) cells(suite string) ([]Cell, error) {
+	if entry.Example == "" {
+		return nil, fmt.Errorf("An entry of test matrix suite '%s' has no example", suite)
+	}
+
+	dimensions := map[string][]string{
+		"os":                   entry.Os,
+		"edition":              entry.Edition,
+		"couchbase_version":    entry.CouchbaseVersion,
+		"sync_gateway_version": entry.SyncGatewayVersion,
+	}
+	for name, values := range dimensions {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("The entry for example %s in test matrix suite '%s' must have at least one value for %s", entry.Example, suite, name)
+		}
+	}
+
+	for osName := range entry.SettingsByOs {
+		if !contains(entry.Os, osName) {
+			return nil, fmt.Errorf("The entry for example %s in test matrix suite '%s' has settings for OS %s, which it doesn't test", entry.Example, suite, osName)
+		}
+	}
+
+	cells := []Cell{}
+	for _, osName := range entry.Os {
+		settings := entry.Settings
+		if osSettings, ok := entry.SettingsByOs[osName]; ok {
+			settings = osSettings
+		}
+
+		for _, edition := range entry.Edition {
+			for _, couchbaseVersion := range entry.CouchbaseVersion {
+				for _, syncGatewayVersion := range entry.SyncGatewayVersion {
+					cells = append(cells, Cell{
+						Suite:              suite,
+						Example:            entry.Example,
+						Variant:            entry.Variant,
+						Os:                 osName,
+						Edition:            edition,
+						CouchbaseVersion:   couchbaseVersion,
+						SyncGatewayVersion: syncGatewayVersion,
+						Settings:           settings,
+					})
+				}
+			}
+		}
+	}
+
+	return cells, nil
+}
+
+// The name of the subtest for this cell, such as couchbase-cluster-simple_ubuntu-18_community_cb-default_sg-default
+func (cell Cell) Name() string {
+	example := cell.Example
+	if cell.Variant != "" {
+		example = fmt.Sprintf("%s-%s", cell.Example, cell.Variant)
+	}
+	return fmt.Sprintf("%s_%s_%s_cb-%s_sg-%s", example, cell.Os, cell.Edition, cell.CouchbaseVersion, cell.SyncGatewayVersion)
+}
+
+// Decode the settings of this cell into the given struct. Leaves the struct as is if the cell has no settings.
+func (cell Cell) DecodeSettings(settings interface{}) error {
+	if len(cell.Settings) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(cell.Settings))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(settings); err != nil {
+		return fmt.Errorf("Failed to parse the settings of test matrix cell %s: %v", cell.Name(), err)
+	}
+	return nil
+}
+
+// The values of each dimension to run. An empty list matches every value of its dimension. An example value matches
+// both the example and, for cells with a variant, the example and the variant joined with a dash, such as
+// couchbase-cluster-simple-dns-tls-tls-only.
+type Filter struct {
+	Example            []string
+	Os                 []string
+	Edition            []string
+	CouchbaseVersion   []string
+	SyncGatewayVersion []string
+}
+
+// Split a comma separated list of values, as passed on the command line, ignoring empty values
+func SplitValues(values string) []string {
+	out := []string{}
+	for _, value := range strings.Split(values, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			out = append(out, value)
+		}
+	}
+	return out
+}
+
+// Return true if the given cell matches every dimension of this filter
+func (filter Filter) Matches(cell Cell) bool {
+	examples := []string{cell.Example}
+	if cell.Variant != "" {
+		examples = append(examples, fmt.Sprintf("%s-%s", cell.Example, cell.Variant))
+	}
+
+	return matchesAny(filter.Example, examples...) &&
+		matchesAny(filter.Os, cell.Os) &&
+		matchesAny(filter.Edition, cell.Edition) &&
+		matchesAny(filter.CouchbaseVersion, cell.CouchbaseVersion) &&
+		matchesAny(filter.SyncGatewayVersion, cell.SyncGatewayVersion)
+}
+
+func matchesAny(allowed []string, values ...string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if contains(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// The outcome of a cell in the JSON summary
+type Result struct {
+	Cell
+	Name            string  `json:"name"`
+	Outcome         string  `json:"outcome"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Collects the outcome of each cell. Safe to use from parallel tests.
+type Recorder struct {
+	mutex   sync.Mutex
+	results []Result
+}
+
+// Record the outcome of the given cell
+func (recorder *Recorder) Record(cell Cell, outcome string, duration time.Duration) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.results = append(recorder.results, Result{
+		Cell:            cell,
+		Name:            cell.Name(),
+		Outcome:         outcome,
+		DurationSeconds: duration.Seconds(),
+	})
+}
+
+// Return the results recorded so far, sorted by suite and name
+func (recorder *Recorder) Results() []Result {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	results := append([]Result{}, recorder.results...)
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Suite != results[j].Suite {
+			return results[i].Suite < results[j].Suite
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// Write the results recorded so far to the given path as JSON
+func (recorder *Recorder) WriteSummary(path string) error {
+	contents, err := json.MarshalIndent(map[string][]Result{"results": recorder.Results()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, contents, 0644)
+}
+
+// Run a subtest for each of the given cells that matches the filter, calling run with the cell, and record the outcome
+// of each cell, including the ones the filter skips. If parallel is true, the subtests run in parallel.
+func Run(t *testing.T, cells []Cell, filter Filter, recorder *Recorder, parallel bool, run func(t *testing.T, cell Cell)) {
+	for _, cell := range cells {
+		cell := cell // capture range variable; otherwise, only the very last test case will run!
+
+		if !filter.Matches(cell) {
+			recorder.Record(cell, OutcomeFiltered, 0)
+			continue
+		}
+
+		t.Run(cell.Name(), func(t *testing.T) {
+			if parallel {
+				t.Parallel()
+			}
+
+			start := time.Now()
+			// Deferred, so we also record the cells that fail or skip via t.FailNow or t.SkipNow, or that panic
+			defer func() {
+				if r := recover(); r != nil {
+					recorder.Record(cell, OutcomeFailed, time.Since(start))
+					panic(r)
+				}
+				recorder.Record(cell, outcome(t), time.Since(start))
+			}()
+
+			run(t, cell)
+		})
+	}
+}
+
+func outcome(t *testing.T) string {
+	if t.Failed() {
+		return OutcomeFailed
+	}
+	if t.Skipped() {
+		return OutcomeSkipped
+	}
+	return OutcomePassed
+}
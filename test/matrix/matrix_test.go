@@ -0,0 +1,329 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRealMatrix(t *testing.T) {
+	t.Parallel()
+
+	matrix, err := Load("../test_matrix.json")
+	require.NoError(t, err)
+
+	for _, suite := range []string{"integration", "docker"} {
+		cells, err := matrix.Cells(suite)
+		require.NoError(t, err)
+		assert.NotEmpty(t, cells, suite)
+	}
+}
+
+// At least one cell must pin its versions, so the tests cover passing a version and checksum to the install scripts
+func TestRealMatrixPinsVersionsOfSomeCells(t *testing.T) {
+	t.Parallel()
+
+	matrix, err := Load("../test_matrix.json")
+	require.NoError(t, err)
+
+	pinnedCells := []string{}
+	for _, suite := range []string{"integration", "docker"} {
+		cells, err := matrix.Cells(suite)
+		require.NoError(t, err)
+
+		for _, cell := range cells {
+			if cell.CouchbasePackage.Checksum != "" && cell.SyncGatewayPackage.Checksum != "" {
+				pinnedCells = append(pinnedCells, cell.Name())
+			}
+		}
+	}
+
+	assert.NotEmpty(t, pinnedCells, "Expected at least one cell of the test matrix to pin the versions of Couchbase and Sync Gateway")
+}
+
+// The cells of the docker suite run in parallel on the same host, so no two of them may map their containers to the same
+// port on the host
+func TestRealMatrixDockerCellsUseUniqueHostPorts(t *testing.T) {
+	t.Parallel()
+
+	matrix, err := Load("../test_matrix.json")
+	require.NoError(t, err)
+
+	cells, err := matrix.Cells("docker")
+	require.NoError(t, err)
+
+	cellsByPort := map[int]string{}
+	for _, cell := range cells {
+		settings := map[string]interface{}{}
+		require.NoError(t, cell.DecodeSettings(&settings), cell.Name())
+
+		for key, value := range settings {
+			if !strings.Contains(key, "_port") {
+				continue
+			}
+
+			port, isNumber := value.(float64)
+			require.True(t, isNumber, "Setting %s of cell %s is not a number: %v", key, cell.Name(), value)
+
+			if otherCell, inUse := cellsByPort[int(port)]; inUse {
+				assert.Fail(t, "Host port used by more than one cell", "Setting %s of cell %s uses host port %d, which cell %s also uses", key, cell.Name(), int(port), otherCell)
+				continue
+			}
+			cellsByPort[int(port)] = cell.Name()
+		}
+	}
+}
+
+func TestCellsExpandsEveryCombination(t *testing.T) {
+	t.Parallel()
+
+	matrix := Matrix{Integration: []Entry{
+		{
+			Example:            "couchbase-cluster-simple",
+			Os:                 []string{"ubuntu", "amazon-linux"},
+			Edition:            []string{"community", "enterprise"},
+			CouchbaseVersion:   []string{"6.5.1", "6.6.0"},
+			SyncGatewayVersion: []string{DefaultVersion},
+		},
+	}}
+	matrix.CouchbaseVersions = map[string]PackageChecksums{
+		"6.5.1": checksumsForTest("6.5.1"),
+		"6.6.0": checksumsForTest("6.6.0"),
+	}
+
+	cells, err := matrix.Cells("integration")
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, cell := range cells {
+		names = append(names, cell.Name())
+	}
+
+	assert.Equal(t, []string{
+		"couchbase-cluster-simple_ubuntu_community_cb-6.5.1_sg-default",
+		"couchbase-cluster-simple_ubuntu_community_cb-6.6.0_sg-default",
+		"couchbase-cluster-simple_ubuntu_enterprise_cb-6.5.1_sg-default",
+		"couchbase-cluster-simple_ubuntu_enterprise_cb-6.6.0_sg-default",
+		"couchbase-cluster-simple_amazon-linux_community_cb-6.5.1_sg-default",
+		"couchbase-cluster-simple_amazon-linux_community_cb-6.6.0_sg-default",
+		"couchbase-cluster-simple_amazon-linux_enterprise_cb-6.5.1_sg-default",
+		"couchbase-cluster-simple_amazon-linux_enterprise_cb-6.6.0_sg-default",
+	}, names)
+}
+
+// Checksums for every edition and OS in the tests, which are made up from the version
+func checksumsForTest(version string) PackageChecksums {
+	checksums := map[string]map[string]string{}
+	for _, edition := range []string{"community", "enterprise"} {
+		checksums[edition] = map[string]string{}
+		for _, osName := range []string{"ubuntu", "ubuntu-18", "amazon-linux"} {
+			checksums[edition][osName] = fmt.Sprintf("%s-%s-%s", version, edition, osName)
+		}
+	}
+	return PackageChecksums{ChecksumType: "sha256", Checksums: checksums}
+}
+
+func TestCellsLookUpPackagesOfPinnedVersions(t *testing.T) {
+	t.Parallel()
+
+	matrix := Matrix{
+		Integration: []Entry{
+			{
+				Example:            "couchbase-cluster-mds",
+				Os:                 []string{"amazon-linux"},
+				Edition:            []string{"enterprise"},
+				CouchbaseVersion:   []string{"6.6.0", DefaultVersion},
+				SyncGatewayVersion: []string{"2.7.3"},
+			},
+		},
+		CouchbaseVersions:   map[string]PackageChecksums{"6.6.0": checksumsForTest("6.6.0")},
+		SyncGatewayVersions: map[string]PackageChecksums{"2.7.3": checksumsForTest("2.7.3")},
+	}
+
+	cells, err := matrix.Cells("integration")
+	require.NoError(t, err)
+	require.Len(t, cells, 2)
+
+	assert.Equal(t, Package{Version: "6.6.0", Checksum: "6.6.0-enterprise-amazon-linux", ChecksumType: "sha256"}, cells[0].CouchbasePackage)
+	assert.Equal(t, Package{Version: "2.7.3", Checksum: "2.7.3-enterprise-amazon-linux", ChecksumType: "sha256"}, cells[0].SyncGatewayPackage)
+	assert.Equal(t, Package{}, cells[1].CouchbasePackage)
+}
+
+func TestCellsRejectsVersionsWithoutChecksums(t *testing.T) {
+	t.Parallel()
+
+	entry := Entry{Example: "couchbase-cluster-simple", Os: []string{"ubuntu"}, Edition: []string{"community"}, CouchbaseVersion: []string{"6.6.0"}, SyncGatewayVersion: []string{DefaultVersion}}
+
+	noVersion := Matrix{Integration: []Entry{entry}}
+	_, err := noVersion.Cells("integration")
+	assert.Error(t, err)
+
+	noEdition := Matrix{Integration: []Entry{entry}, CouchbaseVersions: map[string]PackageChecksums{
+		"6.6.0": {ChecksumType: "sha256", Checksums: map[string]map[string]string{"enterprise": {"ubuntu": "abc"}}},
+	}}
+	_, err = noEdition.Cells("integration")
+	assert.Error(t, err)
+
+	noChecksumType := Matrix{Integration: []Entry{entry}, CouchbaseVersions: map[string]PackageChecksums{
+		"6.6.0": {Checksums: map[string]map[string]string{"community": {"ubuntu": "abc"}}},
+	}}
+	_, err = noChecksumType.Cells("integration")
+	assert.Error(t, err)
+}
+
+func TestCellsUseSettingsOfTheirOs(t *testing.T) {
+	t.Parallel()
+
+	matrix := Matrix{Docker: []Entry{
+		{
+			Example:            "couchbase-cluster-simple",
+			Os:                 []string{"ubuntu", "ubuntu-18"},
+			Edition:            []string{"community"},
+			CouchbaseVersion:   []string{DefaultVersion},
+			SyncGatewayVersion: []string{DefaultVersion},
+			Settings:           json.RawMessage(`{"port": 1}`),
+			SettingsByOs:       map[string]json.RawMessage{"ubuntu-18": json.RawMessage(`{"port": 2}`)},
+		},
+	}}
+
+	cells, err := matrix.Cells("docker")
+	require.NoError(t, err)
+	require.Len(t, cells, 2)
+
+	type settings struct {
+		Port int `json:"port"`
+	}
+
+	var ubuntu, ubuntu18 settings
+	require.NoError(t, cells[0].DecodeSettings(&ubuntu))
+	require.NoError(t, cells[1].DecodeSettings(&ubuntu18))
+	assert.Equal(t, 1, ubuntu.Port)
+	assert.Equal(t, 2, ubuntu18.Port)
+}
+
+func TestDecodeSettingsRejectsUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	cell := Cell{Example: "couchbase-cluster-simple", Settings: json.RawMessage(`{"prot": 1}`)}
+
+	var settings struct {
+		Port int `json:"port"`
+	}
+	assert.Error(t, cell.DecodeSettings(&settings))
+}
+
+func TestCellsRejectsInvalidEntries(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		entry Entry
+	}{
+		{"no example", Entry{Os: []string{"ubuntu"}, Edition: []string{"community"}, CouchbaseVersion: []string{DefaultVersion}, SyncGatewayVersion: []string{DefaultVersion}}},
+		{"no edition", Entry{Example: "couchbase-cluster-simple", Os: []string{"ubuntu"}, CouchbaseVersion: []string{DefaultVersion}, SyncGatewayVersion: []string{DefaultVersion}}},
+		{"settings for an OS it doesn't test", Entry{Example: "couchbase-cluster-simple", Os: []string{"ubuntu"}, Edition: []string{"community"}, CouchbaseVersion: []string{DefaultVersion}, SyncGatewayVersion: []string{DefaultVersion}, SettingsByOs: map[string]json.RawMessage{"amazon-linux": nil}}},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			matrix := Matrix{Integration: []Entry{testCase.entry}}
+			_, err := matrix.Cells("integration")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCellsRejectsDuplicateCells(t *testing.T) {
+	t.Parallel()
+
+	entry := Entry{Example: "couchbase-cluster-simple", Os: []string{"ubuntu"}, Edition: []string{"community"}, CouchbaseVersion: []string{DefaultVersion}, SyncGatewayVersion: []string{DefaultVersion}}
+	matrix := Matrix{Integration: []Entry{entry, entry}}
+
+	_, err := matrix.Cells("integration")
+	assert.Error(t, err)
+}
+
+func TestFilterMatchesEveryDimension(t *testing.T) {
+	t.Parallel()
+
+	cell := Cell{Example: "couchbase-cluster-simple-dns-tls", Variant: "tls-only", Os: "ubuntu-18", Edition: "enterprise", CouchbaseVersion: "6.6.0", SyncGatewayVersion: DefaultVersion}
+
+	assert.True(t, Filter{}.Matches(cell))
+	assert.True(t, Filter{Example: []string{"couchbase-cluster-simple-dns-tls"}}.Matches(cell))
+	assert.True(t, Filter{Example: []string{"couchbase-cluster-simple-dns-tls-tls-only"}}.Matches(cell))
+	assert.True(t, Filter{Os: []string{"ubuntu", "ubuntu-18"}, Edition: []string{"enterprise"}}.Matches(cell))
+	assert.True(t, Filter{CouchbaseVersion: []string{"6.6.0"}, SyncGatewayVersion: []string{DefaultVersion}}.Matches(cell))
+
+	assert.False(t, Filter{Example: []string{"couchbase-cluster-simple"}}.Matches(cell))
+	assert.False(t, Filter{Os: []string{"amazon-linux"}}.Matches(cell))
+	assert.False(t, Filter{Edition: []string{"community"}}.Matches(cell))
+	assert.False(t, Filter{CouchbaseVersion: []string{"6.5.1"}}.Matches(cell))
+	assert.False(t, Filter{SyncGatewayVersion: []string{"2.7.3"}}.Matches(cell))
+}
+
+func TestSplitValues(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{}, SplitValues(""))
+	assert.Equal(t, []string{"ubuntu", "ubuntu-18"}, SplitValues("ubuntu, ubuntu-18,"))
+}
+
+func TestRunRecordsOutcomeOfEveryCell(t *testing.T) {
+	t.Parallel()
+
+	cells := []Cell{
+		{Suite: "docker", Example: "couchbase-cluster-simple", Os: "ubuntu", Edition: "community", CouchbaseVersion: DefaultVersion, SyncGatewayVersion: DefaultVersion},
+		{Suite: "docker", Example: "couchbase-cluster-simple", Os: "ubuntu-18", Edition: "community", CouchbaseVersion: DefaultVersion, SyncGatewayVersion: DefaultVersion},
+		{Suite: "docker", Example: "couchbase-cluster-mds", Os: "amazon-linux", Edition: "enterprise", CouchbaseVersion: DefaultVersion, SyncGatewayVersion: DefaultVersion},
+	}
+
+	recorder := &Recorder{}
+	t.Run("cells", func(t *testing.T) {
+		Run(t, cells, Filter{Example: []string{"couchbase-cluster-simple"}}, recorder, true, func(t *testing.T, cell Cell) {
+			if cell.Os == "ubuntu-18" {
+				t.Skip("Skipping to check the recorder records skipped cells")
+			}
+		})
+	})
+
+	outcomes := map[string]string{}
+	for _, result := range recorder.Results() {
+		outcomes[result.Name] = result.Outcome
+	}
+
+	assert.Equal(t, map[string]string{
+		"couchbase-cluster-simple_ubuntu_community_cb-default_sg-default":     OutcomePassed,
+		"couchbase-cluster-simple_ubuntu-18_community_cb-default_sg-default":  OutcomeSkipped,
+		"couchbase-cluster-mds_amazon-linux_enterprise_cb-default_sg-default": OutcomeFiltered,
+	}, outcomes)
+
+	tmpDir, err := ioutil.TempDir("", "test-matrix-summary")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	summaryPath := filepath.Join(tmpDir, "summary.json")
+	require.NoError(t, recorder.WriteSummary(summaryPath))
+
+	contents, err := ioutil.ReadFile(summaryPath)
+	require.NoError(t, err)
+
+	var summary struct {
+		Results []map[string]interface{} `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(contents, &summary))
+	require.Len(t, summary.Results, 3)
+	assert.Equal(t, "couchbase-cluster-mds", summary.Results[0]["example"])
+	assert.Equal(t, "amazon-linux", summary.Results[0]["os"])
+	assert.Equal(t, OutcomeFiltered, summary.Results[0]["outcome"])
+}
@@ -0,0 +1,237 @@
+package testplan
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/ports"
+	"github.com/stretchr/testify/assert"
+)
+
+// The plan tests run terraform plan on each example against a fake of the AWS APIs (see FakeAws) and check the
+// resources it would create, so they catch most regressions in the modules without AWS credentials or deploying
+// anything. They still need Terraform, and network access to download the providers.
+
+const (
+	planTestAmiId             = "ami-0fedcba9876543210"
+	planTestDomainName        = "plan-test.example.com"
+	planTestDataVolumeDevice  = "/dev/xvdh"
+	planTestIndexVolumeDevice = "/dev/xvdi"
+)
+
+func TestUnitPlanCouchbaseClusterSimple(t *testing.T) {
+	t.Parallel()
+
+	fake := startFakeAws(t, planTestDomainName)
+	defer fake.Close()
+
+	clusterName := "couchbase-plan-simple"
+
+	// Don't set ami_id, so the example looks up the public example AMI
+	plan := planExample(t, fake, ".", nil, map[string]interface{}{
+		"cluster_name":             clusterName,
+		"data_volume_device_name":  planTestDataVolumeDevice,
+		"index_volume_device_name": planTestIndexVolumeDevice,
+	})
+
+	checkClusterPlan(t, plan, expectedCluster{
+		ModuleAddress: "module.couchbase",
+		ClusterName:   clusterName,
+		Size:          3,
+		InstanceType:  "t2.medium",
+		AmiId:         fakeAmiId,
+		EbsVolumes: []expectedEbsVolume{
+			{DeviceName: planTestDataVolumeDevice, VolumeType: "gp2", VolumeSize: 50, Encrypted: true},
+			{DeviceName: planTestIndexVolumeDevice, VolumeType: "gp2", VolumeSize: 50, Encrypted: true},
+		},
+		Tags:              map[string]string{"Environment": "development"},
+		UserDataTemplate:  "data.template_file.user_data_server",
+		UserDataContains:  []string{clusterName, "/opt/couchbase/bin/run-couchbase-server", "run-sync-gateway"},
+		IamPoliciesModule: "iam_policies",
+	})
+
+	checkSecurityGroupRulesPlan(t, plan, "module.couchbase_security_group_rules", couchbasePortMatrix(t, false, false))
+	checkSecurityGroupRulesPlan(t, plan, "module.sync_gateway_security_group_rules", ports.SyncGatewayPorts(4984, 4985))
+}
+
+func TestUnitPlanCouchbaseClusterMds(t *testing.T) {
+	t.Parallel()
+
+	fake := startFakeAws(t, planTestDomainName)
+	defer fake.Close()
+
+	dataNodesClusterName := "couchbase-plan-mds-data"
+	indexQuerySearchNodesClusterName := "couchbase-plan-mds-search"
+	syncGatewayClusterName := "couchbase-plan-mds-sync-gateway"
+
+	plan := planExample(t, fake, "examples/couchbase-cluster-mds", nil, map[string]interface{}{
+		"ami_id":                           planTestAmiId,
+		"couchbase_data_node_cluster_name": dataNodesClusterName,
+		"couchbase_index_query_search_node_cluster_name": indexQuerySearchNodesClusterName,
+		"sync_gateway_cluster_name":                      syncGatewayClusterName,
+		"data_volume_device_name":                        planTestDataVolumeDevice,
+		"index_volume_device_name":                       planTestIndexVolumeDevice,
+	})
+
+	checkClusterPlan(t, plan, expectedCluster{
+		ModuleAddress: "module.couchbase_data_nodes",
+		ClusterName:   dataNodesClusterName,
+		Size:          3,
+		InstanceType:  "t2.micro",
+		AmiId:         planTestAmiId,
+		EbsVolumes: []expectedEbsVolume{
+			{DeviceName: planTestDataVolumeDevice, VolumeType: "gp2", VolumeSize: 50},
+		},
+		Tags:              map[string]string{"Environment": "development"},
+		UserDataTemplate:  "data.template_file.user_data_couchbase_data_nodes",
+		UserDataContains:  []string{dataNodesClusterName, "/opt/couchbase/bin/run-couchbase-server"},
+		IamPoliciesModule: "iam_policies_couchbase_data_nodes",
+	})
+
+	checkClusterPlan(t, plan, expectedCluster{
+		ModuleAddress: "module.couchbase_index_query_search_nodes",
+		ClusterName:   indexQuerySearchNodesClusterName,
+		Size:          2,
+		InstanceType:  "t2.micro",
+		AmiId:         planTestAmiId,
+		EbsVolumes: []expectedEbsVolume{
+			{DeviceName: planTestIndexVolumeDevice, VolumeType: "gp2", VolumeSize: 50},
+		},
+		Tags:             map[string]string{"Environment": "development"},
+		UserDataTemplate: "data.template_file.user_data_couchbase_index_query_search_nodes",
+		// The index, query, and search nodes join the cluster of the data nodes
+		UserDataContains:  []string{dataNodesClusterName, "/opt/couchbase/bin/run-couchbase-server"},
+		IamPoliciesModule: "iam_policies_couchbase_index_query_search_nodes",
+	})
+
+	checkClusterPlan(t, plan, expectedCluster{
+		ModuleAddress:     "module.sync_gateway",
+		ClusterName:       syncGatewayClusterName,
+		Size:              2,
+		InstanceType:      "t2.micro",
+		AmiId:             planTestAmiId,
+		EbsVolumes:        []expectedEbsVolume{},
+		Tags:              map[string]string{"Environment": "development"},
+		UserDataTemplate:  "data.template_file.user_data_sync_gateway",
+		UserDataContains:  []string{dataNodesClusterName, "run-sync-gateway"},
+		IamPoliciesModule: "iam_policies_sync_gateway",
+	})
+
+	// The security group rules modules don't know which services run on which nodes, so both groups of Couchbase
+	// nodes open the ports of all the services
+	couchbasePorts := couchbasePortMatrix(t, false, false)
+	checkSecurityGroupRulesPlan(t, plan, "module.couchbase_data_nodes_security_group_rules", couchbasePorts)
+	checkSecurityGroupRulesPlan(t, plan, "module.couchbase_index_query_search_nodes_security_group_rules", couchbasePorts)
+	checkSecurityGroupRulesPlan(t, plan, "module.sync_gateway_security_group_rules", ports.SyncGatewayPorts(4984, 4985))
+}
+
+func TestUnitPlanCouchbaseClusterSimpleDnsTls(t *testing.T) {
+	t.Parallel()
+
+	fake := startFakeAws(t, planTestDomainName)
+	defer fake.Close()
+
+	clusterName := "couchbase-plan-dns-tls"
+
+	plan := planExample(t, fake, "examples/couchbase-cluster-simple-dns-tls", nil, map[string]interface{}{
+		"ami_id":                   planTestAmiId,
+		"cluster_name":             clusterName,
+		"domain_name":              planTestDomainName,
+		"data_volume_device_name":  planTestDataVolumeDevice,
+		"index_volume_device_name": planTestIndexVolumeDevice,
+	})
+
+	checkClusterPlan(t, plan, expectedCluster{
+		ModuleAddress: "module.couchbase",
+		ClusterName:   clusterName,
+		Size:          3,
+		InstanceType:  "t2.medium",
+		AmiId:         planTestAmiId,
+		EbsVolumes: []expectedEbsVolume{
+			{DeviceName: planTestDataVolumeDevice, VolumeType: "gp2", VolumeSize: 50, Encrypted: true},
+			{DeviceName: planTestIndexVolumeDevice, VolumeType: "gp2", VolumeSize: 50, Encrypted: true},
+		},
+		Tags:              map[string]string{"Environment": "development"},
+		UserDataTemplate:  "data.template_file.user_data_server",
+		UserDataContains:  []string{clusterName, "/opt/couchbase/bin/run-couchbase-server"},
+		IamPoliciesModule: "iam_policies",
+	})
+
+	// The DNS record of the load balancer goes in the hosted zone the example looks up for the domain name
+	record := requirePlannedResource(t, plan, "module.load_balancer.aws_route53_record.load_balancer[0]")
+	assert.Equal(t, fmt.Sprintf("%s.%s", clusterName, planTestDomainName), record.AttributeValues["name"])
+	assert.Equal(t, fakeHostedZoneId, record.AttributeValues["zone_id"])
+
+	checkSecurityGroupRulesPlan(t, plan, "module.couchbase_security_group_rules", couchbasePortMatrix(t, false, false))
+	checkSecurityGroupRulesPlan(t, plan, "module.sync_gateway_security_group_rules", ports.SyncGatewayPorts(4984, 4985))
+}
+
+func TestUnitPlanCouchbaseClusterSimpleDnsTlsOnly(t *testing.T) {
+	t.Parallel()
+
+	fake := startFakeAws(t, planTestDomainName)
+	defer fake.Close()
+
+	plan := planExample(t, fake, "examples/couchbase-cluster-simple-dns-tls", nil, map[string]interface{}{
+		"ami_id":                   planTestAmiId,
+		"cluster_name":             "couchbase-plan-tls-only",
+		"domain_name":              planTestDomainName,
+		"data_volume_device_name":  planTestDataVolumeDevice,
+		"index_volume_device_name": planTestIndexVolumeDevice,
+		"enable_node_certificates": true,
+		"tls_only":                 true,
+	})
+
+	checkSecurityGroupRulesPlan(t, plan, "module.couchbase_security_group_rules", couchbasePortMatrix(t, true, true))
+}
+
+func TestUnitPlanCouchbaseMultiDatacenterReplication(t *testing.T) {
+	t.Parallel()
+
+	fake := startFakeAws(t, planTestDomainName)
+	defer fake.Close()
+
+	primaryClusterName := "couchbase-plan-primary"
+	replicaClusterName := "couchbase-plan-replica"
+	replicaRegion := "us-west-2"
+
+	// Only set the AMI of the primary cluster, so the replica cluster looks up the public example AMI
+	providers := []fakeAwsProvider{{Alias: "primary"}, {Alias: "replica"}}
+	plan := planExample(t, fake, "examples/couchbase-multi-datacenter-replication", providers, map[string]interface{}{
+		"primary_region":       "us-east-1",
+		"replica_region":       replicaRegion,
+		"ami_id_primary":       planTestAmiId,
+		"cluster_name_primary": primaryClusterName,
+		"cluster_name_replica": replicaClusterName,
+	})
+
+	checkClusterPlan(t, plan, expectedCluster{
+		ModuleAddress:     "module.couchbase_primary",
+		ClusterName:       primaryClusterName,
+		Size:              3,
+		InstanceType:      "t2.micro",
+		AmiId:             planTestAmiId,
+		EbsVolumes:        []expectedEbsVolume{},
+		Tags:              map[string]string{},
+		UserDataTemplate:  "data.template_file.user_data_primary",
+		UserDataContains:  []string{primaryClusterName, replicaClusterName, replicaRegion, "/opt/couchbase/bin/run-couchbase-server"},
+		IamPoliciesModule: "iam_policies_primary",
+	})
+
+	checkClusterPlan(t, plan, expectedCluster{
+		ModuleAddress:     "module.couchbase_replica",
+		ClusterName:       replicaClusterName,
+		Size:              3,
+		InstanceType:      "t2.micro",
+		AmiId:             fakeAmiId,
+		EbsVolumes:        []expectedEbsVolume{},
+		Tags:              map[string]string{},
+		UserDataTemplate:  "data.template_file.user_data_replica",
+		UserDataContains:  []string{replicaClusterName, "/opt/couchbase/bin/run-couchbase-server"},
+		IamPoliciesModule: "iam_policies_replica",
+	})
+
+	couchbasePorts := couchbasePortMatrix(t, false, false)
+	checkSecurityGroupRulesPlan(t, plan, "module.couchbase_security_group_rules_primary", couchbasePorts)
+	checkSecurityGroupRulesPlan(t, plan, "module.couchbase_security_group_rules_replica", couchbasePorts)
+}
@@ -0,0 +1,244 @@
+package testplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const (
+	fakeAccountId     = "123456789012"
+	fakeAmiId         = "ami-0123456789abcdef0"
+	fakeVpcId         = "vpc-0123456789abcdef0"
+	fakeRouteTableId  = "rtb-0123456789abcdef0"
+	fakeHostedZoneId  = "Z0123456789ABCDEFGHIJ"
+	fakeCertificateId = "01234567-89ab-cdef-0123-456789abcdef"
+
+	ec2Namespace     = "http://ec2.amazonaws.com/doc/2016-11-15/"
+	route53Namespace = "https://route53.amazonaws.com/doc/2013-04-01/"
+	stsNamespace     = "https://sts.amazonaws.com/doc/2011-06-15/"
+)
+
+// The availability zones the fake AWS APIs report for every region. The fake default VPC has one subnet in each.
+var fakeAvailabilityZoneSuffixes = []string{"a", "b", "c"}
+
+// The region is the third part of the credential scope in the Authorization header of a request signed with AWS
+// Signature Version 4, e.g.: Credential=fake-access-key/20210101/us-east-1/ec2/aws4_request
+var credentialScopeRegionRegex = regexp.MustCompile(`Credential=[^/]+/[^/]+/([^/]+)/`)
+
+// FakeAws is a local stand-in for the handful of AWS APIs that the data sources in the examples call when we run
+// terraform plan: EC2, to look up the AMI and the default VPC and subnets, and Route 53 and ACM, to look up the hosted
+// zone and certificate for a domain name. Everything else in a plan (e.g., ASGs, launch configurations, IAM roles) is
+// computed by Terraform itself, so this is all we need to plan the examples without AWS credentials.
+type FakeAws struct {
+	server *httptest.Server
+	t      *testing.T
+
+	// The domain name the fake Route 53 hosted zone and the fake wildcard ACM certificate are for
+	DomainName string
+}
+
+// Start the fake AWS APIs on a random local port. Call Close when you're done.
+func startFakeAws(t *testing.T, domainName string) *FakeAws {
+	fake := &FakeAws{t: t, DomainName: domainName}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	return fake
+}
+
+// The URL to configure as the endpoint of every AWS service in the AWS provider
+func (fake *FakeAws) URL() string {
+	return fake.server.URL
+}
+
+func (fake *FakeAws) Close() {
+	fake.server.Close()
+}
+
+func (fake *FakeAws) handle(w http.ResponseWriter, r *http.Request) {
+	region := regionOfRequest(r)
+
+	// Route 53 is a REST API, ACM is a JSON API that names the operation in a header, and EC2 and STS are query APIs
+	// that name the operation in the Action form param
+	if strings.HasPrefix(r.URL.Path, "/2013-04-01/") {
+		fake.handleRoute53(w, r)
+		return
+	}
+
+	if target := r.Header.Get("X-Amz-Target"); strings.HasPrefix(target, "CertificateManager.") {
+		fake.handleAcm(w, strings.TrimPrefix(target, "CertificateManager."), region)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fake.writeError(w, "InvalidRequest", fmt.Sprintf("Failed to parse request: %v", err))
+		return
+	}
+
+	action := r.Form.Get("Action")
+	fake.t.Logf("Fake AWS: %s in %s", action, region)
+
+	switch action {
+	case "DescribeImages":
+		writeXml(w, action, ec2Namespace, fakeImagesXml())
+	case "DescribeVpcs":
+		writeXml(w, action, ec2Namespace, fakeVpcsXml())
+	case "DescribeVpcAttribute":
+		writeXml(w, action, ec2Namespace, fakeVpcAttributeXml())
+	case "DescribeRouteTables":
+		writeXml(w, action, ec2Namespace, fakeRouteTablesXml())
+	case "DescribeSubnets":
+		writeXml(w, action, ec2Namespace, fakeSubnetsXml(region))
+	case "DescribeAvailabilityZones":
+		writeXml(w, action, ec2Namespace, fakeAvailabilityZonesXml(region))
+	case "DescribeAccountAttributes":
+		writeXml(w, action, ec2Namespace, fakeAccountAttributesXml())
+	case "GetCallerIdentity":
+		writeXml(w, action, stsNamespace, fakeCallerIdentityXml())
+	default:
+		fake.writeError(w, "UnsupportedOperation", fmt.Sprintf("The fake AWS APIs do not support %s. Add it to FakeAws if an example needs it.", action))
+	}
+}
+
+func (fake *FakeAws) handleRoute53(w http.ResponseWriter, r *http.Request) {
+	fake.t.Logf("Fake AWS: Route 53 %s %s", r.Method, r.URL.Path)
+
+	hostedZoneXml := fmt.Sprintf(`<HostedZone><Id>/hostedzone/%s</Id><Name>%s.</Name><CallerReference>fake</CallerReference><Config><PrivateZone>false</PrivateZone></Config><ResourceRecordSetCount>2</ResourceRecordSetCount></HostedZone>`, fakeHostedZoneId, fake.DomainName)
+
+	switch {
+	case r.URL.Path == "/2013-04-01/hostedzone":
+		writeRoute53Xml(w, "ListHostedZonesResponse", fmt.Sprintf(`<HostedZones>%s</HostedZones><IsTruncated>false</IsTruncated><MaxItems>100</MaxItems>`, hostedZoneXml))
+	case r.URL.Path == "/2013-04-01/hostedzone/"+fakeHostedZoneId:
+		writeRoute53Xml(w, "GetHostedZoneResponse", fmt.Sprintf(`%s<DelegationSet><NameServers><NameServer>ns-1.%s</NameServer><NameServer>ns-2.%s</NameServer></NameServers></DelegationSet>`, hostedZoneXml, fake.DomainName, fake.DomainName))
+	case r.URL.Path == "/2013-04-01/tags/hostedzone/"+fakeHostedZoneId:
+		writeRoute53Xml(w, "ListTagsForResourceResponse", fmt.Sprintf(`<ResourceTagSet><ResourceType>hostedzone</ResourceType><ResourceId>%s</ResourceId><Tags></Tags></ResourceTagSet>`, fakeHostedZoneId))
+	default:
+		fake.writeError(w, "UnsupportedOperation", fmt.Sprintf("The fake AWS APIs do not support Route 53 %s %s. Add it to FakeAws if an example needs it.", r.Method, r.URL.Path))
+	}
+}
+
+func (fake *FakeAws) handleAcm(w http.ResponseWriter, operation string, region string) {
+	fake.t.Logf("Fake AWS: ACM %s in %s", operation, region)
+
+	certificateArn := fmt.Sprintf("arn:aws:acm:%s:%s:certificate/%s", region, fakeAccountId, fakeCertificateId)
+	domainName := "*." + fake.DomainName
+
+	var response interface{}
+
+	switch operation {
+	case "ListCertificates":
+		response = map[string]interface{}{
+			"CertificateSummaryList": []map[string]interface{}{
+				{"CertificateArn": certificateArn, "DomainName": domainName},
+			},
+		}
+	case "DescribeCertificate":
+		response = map[string]interface{}{
+			"Certificate": map[string]interface{}{
+				"CertificateArn": certificateArn,
+				"DomainName":     domainName,
+				"Status":         "ISSUED",
+				"Type":           "AMAZON_ISSUED",
+				"KeyAlgorithm":   "RSA_2048",
+				"IssuedAt":       1609459200,
+			},
+		}
+	case "ListTagsForCertificate":
+		response = map[string]interface{}{"Tags": []interface{}{}}
+	default:
+		fake.writeError(w, "UnsupportedOperation", fmt.Sprintf("The fake AWS APIs do not support ACM %s. Add it to FakeAws if an example needs it.", operation))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fake.t.Logf("Fake AWS: failed to write ACM %s response: %v", operation, err)
+	}
+}
+
+// Write an error in the format of the EC2 query API. The AWS SDK surfaces the code and message, so an unsupported
+// call fails the plan with a message that says exactly what's missing.
+func (fake *FakeAws) writeError(w http.ResponseWriter, code string, message string) {
+	fake.t.Logf("Fake AWS: %s: %s", code, message)
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors><RequestID>fake-request-id</RequestID></Response>`, code, message)
+}
+
+// Return the region a request is for, based on its signature, or us-east-1 if the request isn't signed
+func regionOfRequest(r *http.Request) string {
+	matches := credentialScopeRegionRegex.FindStringSubmatch(r.Header.Get("Authorization"))
+	if len(matches) < 2 {
+		return "us-east-1"
+	}
+	return matches[1]
+}
+
+func writeXml(w http.ResponseWriter, action string, namespace string, body string) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><%sResponse xmlns="%s"><requestId>fake-request-id</requestId>%s</%sResponse>`, action, namespace, body, action)
+}
+
+func writeRoute53Xml(w http.ResponseWriter, element string, body string) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><%s xmlns="%s">%s</%s>`, element, route53Namespace, body, element)
+}
+
+func fakeImagesXml() string {
+	return fmt.Sprintf(`<imagesSet><item><imageId>%s</imageId><imageLocation>%s/couchbase-ubuntu-example</imageLocation><imageState>available</imageState><imageOwnerId>%s</imageOwnerId><creationDate>2021-01-01T00:00:00.000Z</creationDate><isPublic>true</isPublic><architecture>x86_64</architecture><imageType>machine</imageType><name>couchbase-ubuntu-example</name><rootDeviceType>ebs</rootDeviceType><rootDeviceName>/dev/sda1</rootDeviceName><blockDeviceMapping></blockDeviceMapping><virtualizationType>hvm</virtualizationType><hypervisor>xen</hypervisor></item></imagesSet>`, fakeAmiId, fakeAccountId, fakeAccountId)
+}
+
+func fakeVpcsXml() string {
+	return fmt.Sprintf(`<vpcSet><item><vpcId>%s</vpcId><ownerId>%s</ownerId><state>available</state><cidrBlock>172.31.0.0/16</cidrBlock><cidrBlockAssociationSet><item><cidrBlock>172.31.0.0/16</cidrBlock><associationId>vpc-cidr-assoc-0123456789abcdef0</associationId><cidrBlockState><state>associated</state></cidrBlockState></item></cidrBlockAssociationSet><dhcpOptionsId>dopt-0123456789abcdef0</dhcpOptionsId><instanceTenancy>default</instanceTenancy><isDefault>true</isDefault></item></vpcSet>`, fakeVpcId, fakeAccountId)
+}
+
+// The AWS provider reads both DNS attributes of a VPC with separate calls, so we return both for either call
+func fakeVpcAttributeXml() string {
+	return fmt.Sprintf(`<vpcId>%s</vpcId><enableDnsSupport><value>true</value></enableDnsSupport><enableDnsHostnames><value>true</value></enableDnsHostnames>`, fakeVpcId)
+}
+
+func fakeRouteTablesXml() string {
+	return fmt.Sprintf(`<routeTableSet><item><routeTableId>%s</routeTableId><vpcId>%s</vpcId><ownerId>%s</ownerId><routeSet></routeSet><associationSet><item><routeTableAssociationId>rtbassoc-0123456789abcdef0</routeTableAssociationId><routeTableId>%s</routeTableId><main>true</main></item></associationSet></item></routeTableSet>`, fakeRouteTableId, fakeVpcId, fakeAccountId, fakeRouteTableId)
+}
+
+func fakeSubnetsXml(region string) string {
+	var items strings.Builder
+	for i, suffix := range fakeAvailabilityZoneSuffixes {
+		fmt.Fprintf(&items, `<item><subnetId>%s</subnetId><state>available</state><vpcId>%s</vpcId><ownerId>%s</ownerId><cidrBlock>172.31.%d.0/20</cidrBlock><availableIpAddressCount>4091</availableIpAddressCount><availabilityZone>%s%s</availabilityZone><defaultForAz>true</defaultForAz><mapPublicIpOnLaunch>true</mapPublicIpOnLaunch></item>`, fakeSubnetId(i), fakeVpcId, fakeAccountId, i*16, region, suffix)
+	}
+	return fmt.Sprintf(`<subnetSet>%s</subnetSet>`, items.String())
+}
+
+func fakeAvailabilityZonesXml(region string) string {
+	var items strings.Builder
+	for _, suffix := range fakeAvailabilityZoneSuffixes {
+		fmt.Fprintf(&items, `<item><zoneName>%s%s</zoneName><zoneState>available</zoneState><regionName>%s</regionName><zoneType>availability-zone</zoneType></item>`, region, suffix, region)
+	}
+	return fmt.Sprintf(`<availabilityZoneInfo>%s</availabilityZoneInfo>`, items.String())
+}
+
+func fakeAccountAttributesXml() string {
+	return `<accountAttributeSet><item><attributeName>supported-platforms</attributeName><attributeValueSet><item><attributeValue>VPC</attributeValue></item></attributeValueSet></item></accountAttributeSet>`
+}
+
+func fakeCallerIdentityXml() string {
+	return fmt.Sprintf(`<GetCallerIdentityResult><Arn>arn:aws:iam::%s:user/fake</Arn><UserId>AIDAFAKEUSERID</UserId><Account>%s</Account></GetCallerIdentityResult>`, fakeAccountId, fakeAccountId)
+}
+
+// Return the ID of the fake default subnet in the availability zone with the given index
+func fakeSubnetId(index int) string {
+	return fmt.Sprintf("subnet-0123456789abcdef%d", index)
+}
+
+// Return the IDs of the subnets of the fake default VPC
+func fakeSubnetIds() []string {
+	ids := []string{}
+	for i := range fakeAvailabilityZoneSuffixes {
+		ids = append(ids, fakeSubnetId(i))
+	}
+	return ids
+}
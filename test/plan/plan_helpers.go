@@ -0,0 +1,321 @@
+package testplan
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/iam"
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/ports"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The AWS services whose endpoints we point at the fake AWS APIs. Only a few of them get any calls during a plan (see
+// FakeAws), but we point all the services the examples use at the fake, so a plan can never reach the real AWS.
+var fakeAwsServices = []string{"acm", "autoscaling", "ec2", "elb", "elbv2", "iam", "route53", "ssm", "sts"}
+
+// The examples don't pin the AWS provider, but they use data sources, such as aws_subnet_ids, that version 5 of the AWS
+// provider removed, and the provider settings in fakeAwsProviderTemplate only exist before version 5
+const fakeAwsVersionsFile = `# Generated by the plan tests in test/plan
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "< 5.0.0"
+    }
+  }
+}
+`
+
+const fakeAwsProviderTemplate = `# Generated by the plan tests in test/plan: point the AWS provider at the fake AWS APIs, so we can run terraform plan
+# without AWS credentials
+{{- range .Providers }}
+
+provider "aws" {
+{{- if .Alias }}
+  alias  = "{{ .Alias }}"
+{{- end }}
+{{- if .Region }}
+  region = "{{ .Region }}"
+{{- end }}
+
+  access_key                  = "fake-access-key"
+  secret_key                  = "fake-secret-key"
+  skip_credentials_validation = true
+  skip_requesting_account_id  = true
+  skip_metadata_api_check     = true
+  skip_region_validation      = true
+  skip_get_ec2_platforms      = true
+
+  endpoints {
+{{- range $.Services }}
+    {{ . }} = "{{ $.Endpoint }}"
+{{- end }}
+  }
+}
+{{- end }}
+`
+
+type fakeAwsProvider struct {
+	Alias  string
+	Region string
+}
+
+// The ASG and launch configuration we expect a couchbase-cluster module in an example to plan
+type expectedCluster struct {
+	// The address of the couchbase-cluster module, e.g., module.couchbase
+	ModuleAddress string
+	ClusterName   string
+	Size          int
+	InstanceType  string
+	AmiId         string
+	EbsVolumes    []expectedEbsVolume
+
+	// The custom tags of the ASG, in addition to the Name tag, which is always set to the cluster name
+	Tags map[string]string
+
+	// The address of the template_file data source that renders the User Data, and strings the rendered User Data must
+	// contain
+	UserDataTemplate string
+	UserDataContains []string
+
+	// The name of the couchbase-iam-policies module call that attaches policies to the IAM role of this cluster
+	IamPoliciesModule string
+}
+
+type expectedEbsVolume struct {
+	DeviceName string
+	VolumeType string
+	VolumeSize int
+	Encrypted  bool
+}
+
+// Copy the example at the given path (relative to the root of the repo) to a temp folder, point its AWS providers at
+// the fake AWS APIs, and run terraform plan on it with the given variables. If the example configures its own AWS
+// providers, pass them in providers, so we can override their settings; otherwise, pass nil, and the example gets one
+// AWS provider in us-east-1. Skips the test if Terraform is not installed.
+func planExample(t *testing.T, fake *FakeAws, examplePath string, providers []fakeAwsProvider, vars map[string]interface{}) *terraform.PlanStruct {
+	if _, err := exec.LookPath("terraform"); err != nil {
+		t.Skip("The plan tests require terraform, which is not installed")
+	}
+
+	exampleDir := test_structure.CopyTerraformFolderToTemp(t, "../..", examplePath)
+	writeFakeAwsProviderConfig(t, exampleDir, fake.URL(), providers)
+
+	terraformOptions := &terraform.Options{
+		TerraformDir: exampleDir,
+		Vars:         vars,
+		PlanFilePath: filepath.Join(exampleDir, "plan.out"),
+		EnvVars: map[string]string{
+			"AWS_EC2_METADATA_DISABLED": "true",
+		},
+	}
+
+	return terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+}
+
+// Write the Terraform files that point the AWS providers of the example in the given folder at the fake AWS APIs. If
+// the example configures its own providers, we write an override file, which Terraform merges into the existing
+// provider blocks; otherwise, we add a provider block, as an override file can't add one.
+func writeFakeAwsProviderConfig(t *testing.T, exampleDir string, endpoint string, providers []fakeAwsProvider) {
+	providerFileName := "fake_aws_provider_override.tf"
+	if len(providers) == 0 {
+		providerFileName = "fake_aws_provider.tf"
+		providers = []fakeAwsProvider{{Region: "us-east-1"}}
+	}
+
+	var providerConfig strings.Builder
+	tmpl := template.Must(template.New("provider").Parse(fakeAwsProviderTemplate))
+	require.NoError(t, tmpl.Execute(&providerConfig, map[string]interface{}{
+		"Providers": providers,
+		"Services":  fakeAwsServices,
+		"Endpoint":  endpoint,
+	}))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(exampleDir, providerFileName), []byte(providerConfig.String()), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(exampleDir, "fake_aws_versions.tf"), []byte(fakeAwsVersionsFile), 0644))
+}
+
+// Check that the plan creates the ASG, launch configuration, and IAM policies we expect for a couchbase-cluster module
+func checkClusterPlan(t *testing.T, plan *terraform.PlanStruct, expected expectedCluster) {
+	checkAutoScalingGroupPlan(t, plan, expected)
+	checkLaunchConfigurationPlan(t, plan, expected)
+	checkUserDataPlan(t, plan, expected)
+	checkIamPoliciesPlan(t, plan, expected)
+}
+
+func checkAutoScalingGroupPlan(t *testing.T, plan *terraform.PlanStruct, expected expectedCluster) {
+	asg := requirePlannedResource(t, plan, expected.ModuleAddress+".aws_autoscaling_group.autoscaling_group")
+
+	assert.Equal(t, expected.ClusterName, asg.AttributeValues["name"])
+	assert.Equal(t, float64(expected.Size), asg.AttributeValues["min_size"])
+	assert.Equal(t, float64(expected.Size), asg.AttributeValues["max_size"])
+	assert.ElementsMatch(t, fakeSubnetIds(), asg.AttributeValues["vpc_zone_identifier"])
+
+	expectedTags := map[string]string{"Name": expected.ClusterName}
+	for key, value := range expected.Tags {
+		expectedTags[key] = value
+	}
+
+	actualTags := map[string]string{}
+	for _, tag := range listOfMaps(asg.AttributeValues["tag"]) {
+		key, _ := tag["key"].(string)
+		value, _ := tag["value"].(string)
+		actualTags[key] = value
+		assert.Equal(t, true, tag["propagate_at_launch"], "Expected tag %s of ASG %s to propagate at launch", key, expected.ClusterName)
+	}
+
+	assert.Equal(t, expectedTags, actualTags, "Tags of ASG %s", expected.ClusterName)
+}
+
+func checkLaunchConfigurationPlan(t *testing.T, plan *terraform.PlanStruct, expected expectedCluster) {
+	launchConfiguration := requirePlannedResource(t, plan, expected.ModuleAddress+".aws_launch_configuration.launch_configuration")
+
+	assert.Equal(t, expected.AmiId, launchConfiguration.AttributeValues["image_id"])
+	assert.Equal(t, expected.InstanceType, launchConfiguration.AttributeValues["instance_type"])
+	assert.Equal(t, expected.ClusterName+"-", launchConfiguration.AttributeValues["name_prefix"])
+
+	actualVolumes := map[string]expectedEbsVolume{}
+	for _, volume := range listOfMaps(launchConfiguration.AttributeValues["ebs_block_device"]) {
+		deviceName, _ := volume["device_name"].(string)
+		volumeType, _ := volume["volume_type"].(string)
+		volumeSize, _ := volume["volume_size"].(float64)
+		encrypted, _ := volume["encrypted"].(bool)
+		actualVolumes[deviceName] = expectedEbsVolume{DeviceName: deviceName, VolumeType: volumeType, VolumeSize: int(volumeSize), Encrypted: encrypted}
+	}
+
+	expectedVolumes := map[string]expectedEbsVolume{}
+	for _, volume := range expected.EbsVolumes {
+		expectedVolumes[volume.DeviceName] = volume
+	}
+
+	assert.Equal(t, expectedVolumes, actualVolumes, "EBS volumes of launch configuration for %s", expected.ClusterName)
+}
+
+// Check the User Data of the launch configuration. The AWS provider only stores a hash of the User Data, so we check the
+// hash against the rendered template. If the template depends on values that are only known after apply (e.g., the DNS
+// name of a load balancer), Terraform only renders it during apply, and all we can check is that it's set.
+func checkUserDataPlan(t *testing.T, plan *terraform.PlanStruct, expected expectedCluster) {
+	launchConfigurationAddress := expected.ModuleAddress + ".aws_launch_configuration.launch_configuration"
+
+	rendered, isRendered := getRenderedTemplate(plan, expected.UserDataTemplate)
+	if !isRendered {
+		logger.Logf(t, "%s is only rendered during apply, so we can't check the User Data of %s", expected.UserDataTemplate, launchConfigurationAddress)
+
+		change, hasChange := plan.ResourceChangesMap[launchConfigurationAddress]
+		require.True(t, hasChange, "Expected the plan to create %s", launchConfigurationAddress)
+		afterUnknown, _ := change.Change.AfterUnknown.(map[string]interface{})
+		assert.Equal(t, true, afterUnknown["user_data"], "Expected the User Data of %s to be computed during apply", launchConfigurationAddress)
+		return
+	}
+
+	for _, expectedContent := range expected.UserDataContains {
+		assert.Contains(t, rendered, expectedContent, "Expected the User Data rendered by %s to contain %s", expected.UserDataTemplate, expectedContent)
+	}
+
+	launchConfiguration := requirePlannedResource(t, plan, launchConfigurationAddress)
+	assert.Equal(t, userDataHash(rendered), launchConfiguration.AttributeValues["user_data"], "Expected %s to use the User Data rendered by %s", launchConfigurationAddress, expected.UserDataTemplate)
+}
+
+// Check that the auto discovery IAM policy is attached to the IAM role of the cluster. The ID of the role is only known
+// after apply, so we check the reference in the configuration instead.
+func checkIamPoliciesPlan(t *testing.T, plan *terraform.PlanStruct, expected expectedCluster) {
+	requirePlannedResource(t, plan, expected.ModuleAddress+".aws_iam_role.instance_role")
+	requirePlannedResource(t, plan, expected.ModuleAddress+".aws_iam_instance_profile.instance_profile")
+
+	// The auto discovery policy must grant exactly the AWS API actions the scripts that run on the nodes call
+	policyAddress := "module." + expected.IamPoliciesModule + ".aws_iam_role_policy.auto_discover_cluster"
+	grantedActions, err := iam.PlannedPolicyActions(plan.ResourcePlannedValuesMap, policyAddress)
+	require.NoError(t, err)
+	scriptActions, err := iam.ScriptsActions(iam.NodeScripts("../.."))
+	require.NoError(t, err)
+	report := iam.Check(scriptActions, grantedActions)
+	assert.True(t, report.IsEmpty(), "The auto discovery policy of %s isn't least privilege:\n%s", expected.ClusterName, report.String())
+
+	require.NotNil(t, plan.RawPlan.Config)
+	moduleCall, hasModuleCall := plan.RawPlan.Config.RootModule.ModuleCalls[expected.IamPoliciesModule]
+	require.True(t, hasModuleCall, "Expected the example to call module %s", expected.IamPoliciesModule)
+
+	iamRoleId, hasIamRoleId := moduleCall.Expressions["iam_role_id"]
+	require.True(t, hasIamRoleId, "Expected module %s to set iam_role_id", expected.IamPoliciesModule)
+	assert.Contains(t, iamRoleId.References, expected.ModuleAddress+".iam_role_id", "Expected module %s to attach policies to the IAM role of %s", expected.IamPoliciesModule, expected.ModuleAddress)
+}
+
+// Return the planned values of the resource with the given address, failing the test if the plan doesn't create it
+func requirePlannedResource(t *testing.T, plan *terraform.PlanStruct, address string) *tfjson.StateResource {
+	terraform.RequirePlannedValuesMapKeyExists(t, plan, address)
+	return plan.ResourcePlannedValuesMap[address]
+}
+
+// Return the rendered value of the template_file data source with the given address in the root module, and whether
+// Terraform rendered it during the plan
+func getRenderedTemplate(plan *terraform.PlanStruct, address string) (string, bool) {
+	priorState := plan.RawPlan.PriorState
+	if priorState == nil || priorState.Values == nil || priorState.Values.RootModule == nil {
+		return "", false
+	}
+
+	for _, resource := range priorState.Values.RootModule.Resources {
+		if resource.Address == address {
+			rendered, isString := resource.AttributeValues["rendered"].(string)
+			return rendered, isString
+		}
+	}
+
+	return "", false
+}
+
+// The AWS provider stores the SHA1 of the User Data of a launch configuration, rather than the User Data itself, and it
+// hashes the decoded User Data if it's base64 encoded
+func userDataHash(userData string) string {
+	decoded, err := base64.StdEncoding.DecodeString(userData)
+	if err != nil {
+		decoded = []byte(userData)
+	}
+	hash := sha1.Sum(decoded)
+	return hex.EncodeToString(hash[:])
+}
+
+// Terraform represents nested blocks, such as the tags of an ASG, as lists of maps in the planned values
+func listOfMaps(value interface{}) []map[string]interface{} {
+	list, _ := value.([]interface{})
+
+	out := []map[string]interface{}{}
+	for _, item := range list {
+		if itemMap, isMap := item.(map[string]interface{}); isMap {
+			out = append(out, itemMap)
+		}
+	}
+	return out
+}
+
+// Return the ports of all the Couchbase services with the default ports of run-couchbase-server, which is what all the
+// examples use, and the given settings of the couchbase-server-security-group-rules module
+func couchbasePortMatrix(t *testing.T, enableSslPorts bool, tlsOnly bool) ports.PortMatrix {
+	config, err := ports.ReadCouchbasePortConfig("../../modules/run-couchbase-server/run-couchbase-server")
+	require.NoError(t, err)
+
+	config.EnableSslPorts = enableSslPorts
+	config.TlsOnly = tlsOnly
+
+	return ports.CouchbasePorts(config)
+}
+
+// Check the security group rules module with the given address plans exactly the ingress rules the services in the
+// given port matrix need
+func checkSecurityGroupRulesPlan(t *testing.T, plan *terraform.PlanStruct, moduleAddress string, matrix ports.PortMatrix) {
+	rules := ports.PlannedIngressRules(plan.ResourcePlannedValuesMap, moduleAddress)
+	report := ports.Check(matrix, rules)
+	assert.True(t, report.IsEmpty(), "Security group rules of %s don't match the ports of the services:\n%s", moduleAddress, report.String())
+}
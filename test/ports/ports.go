@@ -0,0 +1,388 @@
+// Package ports describes which ports the Couchbase and Sync Gateway services listen on, and checks that description
+// against the ingress rules the security group rules modules plan and against the ports a node actually listens on.
+package ports
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// Who needs to reach a port
+type Exposure string
+
+const (
+	// Clients outside the cluster (e.g., apps and load balancers) and the other nodes
+	ExposureClients Exposure = "clients"
+
+	// Only the other nodes in the cluster
+	ExposureCluster Exposure = "cluster"
+
+	// Only processes on the node itself, so the port must NOT be opened in the security group
+	ExposureLocal Exposure = "local"
+)
+
+// The services a port can belong to. Data, index, query, and fts are the Couchbase services you pass to the
+// --node-services argument of run-couchbase-server; the ports of ServiceCluster are used by every Couchbase node.
+const (
+	ServiceCluster     = "cluster"
+	ServiceData        = "data"
+	ServiceIndex       = "index"
+	ServiceQuery       = "query"
+	ServiceFts         = "fts"
+	ServiceSyncGateway = "sync-gateway"
+)
+
+// A port, or range of ports, that a service listens on
+type ServicePort struct {
+	Service  string
+	Name     string
+	FromPort int
+	ToPort   int
+	Exposure Exposure
+
+	// Current versions of Couchbase no longer listen on some of the ports the security group rules modules open, such
+	// as the moxi port, which Couchbase removed in 5.0. We still expect a rule for them, but don't probe them.
+	Legacy bool
+}
+
+func (port ServicePort) String() string {
+	if port.FromPort == port.ToPort {
+		return fmt.Sprintf("%s %s port %d", port.Service, port.Name, port.FromPort)
+	}
+	return fmt.Sprintf("%s %s ports %d-%d", port.Service, port.Name, port.FromPort, port.ToPort)
+}
+
+// The ports of all the services that run in a cluster
+type PortMatrix []ServicePort
+
+// Return only the ports of the given services
+func (matrix PortMatrix) ForServices(services ...string) PortMatrix {
+	out := PortMatrix{}
+	for _, port := range matrix {
+		for _, service := range services {
+			if port.Service == service {
+				out = append(out, port)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// The ports run-couchbase-server configures via its --xxx-port arguments (see configure_couchbase_ports), and which
+// ports the couchbase-server-security-group-rules module opens
+type CouchbasePortConfig struct {
+	RestPort      int
+	CapiPort      int
+	QueryPort     int
+	FtsPort       int
+	MemcachedPort int
+	XdcrPort      int
+
+	// The enable_non_ssl_ports, enable_ssl_ports, and tls_only variables of couchbase-server-security-group-rules
+	EnableNonSslPorts bool
+	EnableSslPorts    bool
+	TlsOnly           bool
+}
+
+// The default ports of run-couchbase-server and the defaults of the couchbase-server-security-group-rules module
+func DefaultCouchbasePortConfig() CouchbasePortConfig {
+	return CouchbasePortConfig{
+		RestPort:          8091,
+		CapiPort:          8092,
+		QueryPort:         8093,
+		FtsPort:           8094,
+		MemcachedPort:     11210,
+		XdcrPort:          9998,
+		EnableNonSslPorts: true,
+	}
+}
+
+// The constants that hold the default ports in run-couchbase-server, e.g.: readonly DEFAULT_REST_PORT=8091
+var runCouchbaseServerDefaultPortRegex = regexp.MustCompile(`^readonly DEFAULT_([A-Z]+)_PORT=([0-9]+)$`)
+
+// Read the default ports from the run-couchbase-server script at the given path, so the port matrix can't drift from
+// the ports the script actually configures
+func ReadCouchbasePortConfig(runCouchbaseServerPath string) (CouchbasePortConfig, error) {
+	config := DefaultCouchbasePortConfig()
+
+	contents, err := ioutil.ReadFile(runCouchbaseServerPath)
+	if err != nil {
+		return config, err
+	}
+
+	portsByName := map[string]*int{
+		"REST":      &config.RestPort,
+		"CAPI":      &config.CapiPort,
+		"QUERY":     &config.QueryPort,
+		"SEARCH":    &config.FtsPort,
+		"MEMCACHED": &config.MemcachedPort,
+		"XDCR":      &config.XdcrPort,
+	}
+	found := map[string]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(contents)))
+	for scanner.Scan() {
+		matches := runCouchbaseServerDefaultPortRegex.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if len(matches) != 3 {
+			continue
+		}
+
+		port, isKnown := portsByName[matches[1]]
+		if !isKnown {
+			continue
+		}
+
+		value, err := strconv.Atoi(matches[2])
+		if err != nil {
+			return config, err
+		}
+
+		*port = value
+		found[matches[1]] = true
+	}
+
+	for name := range portsByName {
+		if !found[name] {
+			return config, fmt.Errorf("Did not find DEFAULT_%s_PORT in %s", name, runCouchbaseServerPath)
+		}
+	}
+
+	return config, nil
+}
+
+// Return the ports of all the Couchbase services for the given config. See
+// https://docs.couchbase.com/server/current/install/install-ports.html
+func CouchbasePorts(config CouchbasePortConfig) PortMatrix {
+	nonSsl := config.EnableNonSslPorts || config.TlsOnly
+	ssl := config.EnableSslPorts || config.TlsOnly
+
+	// In TLS-only mode, only the nodes themselves use the non SSL ports
+	nonSslExposure := ExposureClients
+	if config.TlsOnly {
+		nonSslExposure = ExposureCluster
+	}
+
+	matrix := PortMatrix{}
+
+	if nonSsl {
+		matrix = append(matrix,
+			ServicePort{Service: ServiceCluster, Name: "rest", FromPort: config.RestPort, ToPort: config.RestPort, Exposure: nonSslExposure},
+			ServicePort{Service: ServiceData, Name: "capi", FromPort: config.CapiPort, ToPort: config.CapiPort, Exposure: nonSslExposure},
+			ServicePort{Service: ServiceQuery, Name: "query", FromPort: config.QueryPort, ToPort: config.QueryPort, Exposure: nonSslExposure},
+			ServicePort{Service: ServiceFts, Name: "fts", FromPort: config.FtsPort, ToPort: config.FtsPort, Exposure: nonSslExposure},
+			ServicePort{Service: ServiceData, Name: "memcached", FromPort: config.MemcachedPort, ToPort: config.MemcachedPort, Exposure: nonSslExposure},
+		)
+	}
+
+	if ssl {
+		matrix = append(matrix,
+			ServicePort{Service: ServiceCluster, Name: "ssl_rest", FromPort: 18091, ToPort: 18091, Exposure: ExposureClients},
+			ServicePort{Service: ServiceData, Name: "ssl_capi", FromPort: 18092, ToPort: 18092, Exposure: ExposureClients},
+			ServicePort{Service: ServiceQuery, Name: "ssl_query", FromPort: 18093, ToPort: 18093, Exposure: ExposureClients},
+			ServicePort{Service: ServiceFts, Name: "ssl_fts", FromPort: 18094, ToPort: 18094, Exposure: ExposureClients},
+			ServicePort{Service: ServiceData, Name: "ssl_memcached", FromPort: 11207, ToPort: 11207, Exposure: ExposureClients},
+		)
+	}
+
+	return append(matrix,
+		ServicePort{Service: ServiceData, Name: "memcached_dedicated", FromPort: 11209, ToPort: 11209, Exposure: ExposureCluster},
+		ServicePort{Service: ServiceData, Name: "moxi", FromPort: 11211, ToPort: 11211, Exposure: ExposureClients, Legacy: true},
+		ServicePort{Service: ServiceData, Name: "projector", FromPort: 9999, ToPort: 9999, Exposure: ExposureCluster},
+		ServicePort{Service: ServiceIndex, Name: "indexer", FromPort: 9100, ToPort: 9105, Exposure: ExposureCluster},
+		ServicePort{Service: ServiceCluster, Name: "epmd", FromPort: 4369, ToPort: 4369, Exposure: ExposureCluster},
+		ServicePort{Service: ServiceCluster, Name: "internal_data", FromPort: 21100, ToPort: 21299, Exposure: ExposureCluster},
+		ServicePort{Service: ServiceCluster, Name: "xdcr", FromPort: config.XdcrPort, ToPort: config.XdcrPort, Exposure: ExposureLocal},
+	)
+}
+
+// Return the ports of Sync Gateway for the given interface and admin interface ports. The admin interface gives full
+// access to all the data in Sync Gateway, so we only expose it on localhost.
+func SyncGatewayPorts(interfacePort int, adminInterfacePort int) PortMatrix {
+	return PortMatrix{
+		{Service: ServiceSyncGateway, Name: "interface", FromPort: interfacePort, ToPort: interfacePort, Exposure: ExposureClients},
+		{Service: ServiceSyncGateway, Name: "admin_interface", FromPort: adminInterfacePort, ToPort: adminInterfacePort, Exposure: ExposureLocal},
+	}
+}
+
+// An ingress rule a security group rules module plans to create
+type IngressRule struct {
+	Address  string
+	FromPort int
+	ToPort   int
+}
+
+func (rule IngressRule) String() string {
+	return fmt.Sprintf("%s (ports %d-%d)", rule.Address, rule.FromPort, rule.ToPort)
+}
+
+// Return the ingress rules in the planned values of a plan (e.g., terraform.PlanStruct.ResourcePlannedValuesMap) that
+// are created by the module with the given address, e.g., module.couchbase_security_group_rules
+func PlannedIngressRules(plannedValues map[string]*tfjson.StateResource, moduleAddress string) []IngressRule {
+	rules := []IngressRule{}
+
+	for address, resource := range plannedValues {
+		if resource.Type != "aws_security_group_rule" || !strings.HasPrefix(address, moduleAddress+".") {
+			continue
+		}
+		if ruleType, _ := resource.AttributeValues["type"].(string); ruleType != "ingress" {
+			continue
+		}
+
+		fromPort, _ := resource.AttributeValues["from_port"].(float64)
+		toPort, _ := resource.AttributeValues["to_port"].(float64)
+		rules = append(rules, IngressRule{Address: address, FromPort: int(fromPort), ToPort: int(toPort)})
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Address < rules[j].Address })
+	return rules
+}
+
+// The differences between a port matrix and the ingress rules of a security group
+type Report struct {
+	// Ports in the matrix that no ingress rule opens, by service
+	Missing map[string][]ServicePort
+
+	// Ingress rules that open a port that isn't in the matrix, or that the matrix says must only be used locally
+	Superfluous []IngressRule
+}
+
+func (report Report) IsEmpty() bool {
+	return len(report.Missing) == 0 && len(report.Superfluous) == 0
+}
+
+func (report Report) String() string {
+	if report.IsEmpty() {
+		return "The ingress rules match the port matrix"
+	}
+
+	var out strings.Builder
+
+	services := []string{}
+	for service := range report.Missing {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		for _, port := range report.Missing[service] {
+			fmt.Fprintf(&out, "Missing ingress rule for %s\n", port)
+		}
+	}
+
+	for _, rule := range report.Superfluous {
+		fmt.Fprintf(&out, "Superfluous ingress rule %s\n", rule)
+	}
+
+	return strings.TrimSpace(out.String())
+}
+
+// Compare the port matrix against the given ingress rules. Every port the matrix exposes to clients or the cluster
+// must be opened by at least one rule with exactly its port range, and every rule must open a port in the matrix that
+// is not local only.
+func Check(matrix PortMatrix, rules []IngressRule) Report {
+	report := Report{Missing: map[string][]ServicePort{}, Superfluous: []IngressRule{}}
+
+	for _, port := range matrix {
+		if port.Exposure == ExposureLocal {
+			continue
+		}
+
+		opened := false
+		for _, rule := range rules {
+			if rule.FromPort == port.FromPort && rule.ToPort == port.ToPort {
+				opened = true
+				break
+			}
+		}
+
+		if !opened {
+			report.Missing[port.Service] = append(report.Missing[port.Service], port)
+		}
+	}
+
+	for _, rule := range rules {
+		expected := false
+		for _, port := range matrix {
+			if port.Exposure != ExposureLocal && rule.FromPort == port.FromPort && rule.ToPort == port.ToPort {
+				expected = true
+				break
+			}
+		}
+
+		if !expected {
+			report.Superfluous = append(report.Superfluous, rule)
+		}
+	}
+
+	return report
+}
+
+// Return the ports in the matrix that none of the given listening ports fall into. A range counts as listening if any
+// port in it is listening, as, e.g., Couchbase only uses one port of the internal data range. Legacy ports are skipped.
+func NotListening(matrix PortMatrix, listeningPorts []int) PortMatrix {
+	out := PortMatrix{}
+
+	for _, port := range matrix {
+		if port.Legacy {
+			continue
+		}
+
+		listening := false
+		for _, listeningPort := range listeningPorts {
+			if listeningPort >= port.FromPort && listeningPort <= port.ToPort {
+				listening = true
+				break
+			}
+		}
+
+		if !listening {
+			out = append(out, port)
+		}
+	}
+
+	return out
+}
+
+// Parse the contents of /proc/net/tcp or /proc/net/tcp6 and return the ports in the LISTEN state. Each line after the
+// header looks like this, where the local address is in hex and state 0A is LISTEN:
+//
+//	0: 00000000:1F9B 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 12345 1 ...
+func ParseListeningPorts(procNetTcp string) ([]int, error) {
+	ports := []int{}
+	seen := map[int]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(procNetTcp))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] == "sl" || fields[3] != "0A" {
+			continue
+		}
+
+		localAddress := fields[1]
+		separator := strings.LastIndex(localAddress, ":")
+		if separator < 0 {
+			return nil, fmt.Errorf("Unexpected local address %s in /proc/net/tcp", localAddress)
+		}
+
+		port, err := strconv.ParseInt(localAddress[separator+1:], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[int(port)] {
+			seen[int(port)] = true
+			ports = append(ports, int(port))
+		}
+	}
+
+	sort.Ints(ports)
+	return ports, nil
+}
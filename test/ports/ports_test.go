@@ -0,0 +1,138 @@
+package ports
+
+import (
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCouchbasePortConfigMatchesDefaults(t *testing.T) {
+	t.Parallel()
+
+	config, err := ReadCouchbasePortConfig("../../modules/run-couchbase-server/run-couchbase-server")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCouchbasePortConfig(), config)
+}
+
+func TestCheckReportsMissingAndSuperfluousRules(t *testing.T) {
+	t.Parallel()
+
+	matrix := PortMatrix{
+		{Service: ServiceCluster, Name: "rest", FromPort: 8091, ToPort: 8091, Exposure: ExposureClients},
+		{Service: ServiceQuery, Name: "query", FromPort: 8093, ToPort: 8093, Exposure: ExposureClients},
+		{Service: ServiceIndex, Name: "indexer", FromPort: 9100, ToPort: 9105, Exposure: ExposureCluster},
+		{Service: ServiceCluster, Name: "xdcr", FromPort: 9998, ToPort: 9998, Exposure: ExposureLocal},
+	}
+
+	rules := []IngressRule{
+		{Address: "module.sg.aws_security_group_rule.rest_port_self[0]", FromPort: 8091, ToPort: 8091},
+		{Address: "module.sg.aws_security_group_rule.indexer_port_self", FromPort: 9100, ToPort: 9104},
+		{Address: "module.sg.aws_security_group_rule.xdcr_port_self", FromPort: 9998, ToPort: 9998},
+	}
+
+	report := Check(matrix, rules)
+
+	assert.False(t, report.IsEmpty())
+	assert.Equal(t, map[string][]ServicePort{
+		ServiceQuery: {matrix[1]},
+		ServiceIndex: {matrix[2]},
+	}, report.Missing)
+	assert.Equal(t, []IngressRule{rules[1], rules[2]}, report.Superfluous)
+}
+
+func TestCheckDefaultMatrixAgainstMatchingRules(t *testing.T) {
+	t.Parallel()
+
+	matrix := CouchbasePorts(DefaultCouchbasePortConfig())
+
+	rules := []IngressRule{}
+	for _, port := range matrix {
+		if port.Exposure != ExposureLocal {
+			rules = append(rules, IngressRule{Address: port.Name, FromPort: port.FromPort, ToPort: port.ToPort})
+		}
+	}
+
+	report := Check(matrix, rules)
+	assert.True(t, report.IsEmpty(), report.String())
+}
+
+func TestCouchbasePortsTlsOnly(t *testing.T) {
+	t.Parallel()
+
+	config := DefaultCouchbasePortConfig()
+	config.TlsOnly = true
+
+	matrix := CouchbasePorts(config)
+
+	rest := findPort(t, matrix, "rest")
+	assert.Equal(t, ExposureCluster, rest.Exposure)
+
+	sslRest := findPort(t, matrix, "ssl_rest")
+	assert.Equal(t, ExposureClients, sslRest.Exposure)
+}
+
+func TestPlannedIngressRules(t *testing.T) {
+	t.Parallel()
+
+	plannedValues := map[string]*tfjson.StateResource{
+		"module.sg.aws_security_group_rule.rest_port_self[0]": {
+			Type:            "aws_security_group_rule",
+			AttributeValues: map[string]interface{}{"type": "ingress", "from_port": float64(8091), "to_port": float64(8091)},
+		},
+		"module.sg.aws_security_group_rule.allow_all_outbound": {
+			Type:            "aws_security_group_rule",
+			AttributeValues: map[string]interface{}{"type": "egress", "from_port": float64(0), "to_port": float64(0)},
+		},
+		"module.other_sg.aws_security_group_rule.interface_port_self": {
+			Type:            "aws_security_group_rule",
+			AttributeValues: map[string]interface{}{"type": "ingress", "from_port": float64(4984), "to_port": float64(4984)},
+		},
+		"module.sg.aws_security_group.sg": {
+			Type: "aws_security_group",
+		},
+	}
+
+	rules := PlannedIngressRules(plannedValues, "module.sg")
+	assert.Equal(t, []IngressRule{{Address: "module.sg.aws_security_group_rule.rest_port_self[0]", FromPort: 8091, ToPort: 8091}}, rules)
+}
+
+func TestParseListeningPorts(t *testing.T) {
+	t.Parallel()
+
+	procNetTcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F9B 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 12345 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:270E 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 12346 1 0000000000000000 100 0 0 10 0
+   2: 0200A8C0:1F9B 0300A8C0:D431 01 00000000:00000000 00:00000000 00000000   999        0 12347 1 0000000000000000 20 4 30 10 -1
+   3: 00000000:1F9B 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 12348 1 0000000000000000 100 0 0 10 0
+`
+
+	ports, err := ParseListeningPorts(procNetTcp)
+	require.NoError(t, err)
+	assert.Equal(t, []int{8091, 9998}, ports)
+}
+
+func TestNotListening(t *testing.T) {
+	t.Parallel()
+
+	matrix := PortMatrix{
+		{Service: ServiceCluster, Name: "rest", FromPort: 8091, ToPort: 8091},
+		{Service: ServiceCluster, Name: "internal_data", FromPort: 21100, ToPort: 21299},
+		{Service: ServiceIndex, Name: "indexer", FromPort: 9100, ToPort: 9105},
+		{Service: ServiceData, Name: "moxi", FromPort: 11211, ToPort: 11211, Legacy: true},
+	}
+
+	assert.Equal(t, PortMatrix{matrix[2]}, NotListening(matrix, []int{8091, 21100}))
+}
+
+func findPort(t *testing.T, matrix PortMatrix, name string) ServicePort {
+	for _, port := range matrix {
+		if port.Name == name {
+			return port
+		}
+	}
+
+	t.Fatalf("Did not find port %s in matrix %v", name, matrix)
+	return ServicePort{}
+}
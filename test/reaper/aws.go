@@ -0,0 +1,281 @@
+package reaper
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// The format of the creation date of AMIs
+const imageCreationDateFormat = "2006-01-02T15:04:05.000Z"
+
+// AwsCloud is a Cloud backed by the AWS APIs
+type AwsCloud struct {
+	sessions map[string]*session.Session
+}
+
+func NewAwsCloud() *AwsCloud {
+	return &AwsCloud{sessions: map[string]*session.Session{}}
+}
+
+func (cloud *AwsCloud) session(region string) (*session.Session, error) {
+	if sess, ok := cloud.sessions[region]; ok {
+		return sess, nil
+	}
+
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	cloud.sessions[region] = sess
+	return sess, nil
+}
+
+func (cloud *AwsCloud) List(region string) ([]Resource, error) {
+	sess, err := cloud.session(region)
+	if err != nil {
+		return nil, err
+	}
+
+	listers := []func(*session.Session, string) ([]Resource, error){
+		listAutoScalingGroups,
+		listLaunchConfigurations,
+		listLoadBalancers,
+		listTargetGroups,
+		listSecurityGroups,
+		listKeyPairs,
+		listImages,
+	}
+
+	resources := []Resource{}
+	for _, list := range listers {
+		listed, err := list(sess, region)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, listed...)
+	}
+	return resources, nil
+}
+
+func listAutoScalingGroups(sess *session.Session, region string) ([]Resource, error) {
+	resources := []Resource{}
+	err := autoscaling.New(sess).DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{}, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		for _, group := range page.AutoScalingGroups {
+			name := aws.StringValue(group.AutoScalingGroupName)
+			tags := map[string]string{}
+			for _, tag := range group.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			resources = append(resources, Resource{Type: AutoScalingGroup, Region: region, Id: name, Name: name, Created: group.CreatedTime, Tags: tags})
+		}
+		return true
+	})
+	return resources, err
+}
+
+func listLaunchConfigurations(sess *session.Session, region string) ([]Resource, error) {
+	resources := []Resource{}
+	err := autoscaling.New(sess).DescribeLaunchConfigurationsPages(&autoscaling.DescribeLaunchConfigurationsInput{}, func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+		for _, config := range page.LaunchConfigurations {
+			name := aws.StringValue(config.LaunchConfigurationName)
+			resources = append(resources, Resource{Type: LaunchConfiguration, Region: region, Id: name, Name: name, Created: config.CreatedTime})
+		}
+		return true
+	})
+	return resources, err
+}
+
+func listLoadBalancers(sess *session.Session, region string) ([]Resource, error) {
+	resources := []Resource{}
+	err := elbv2.New(sess).DescribeLoadBalancersPages(&elbv2.DescribeLoadBalancersInput{}, func(page *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, lb := range page.LoadBalancers {
+			resources = append(resources, Resource{Type: LoadBalancer, Region: region, Id: aws.StringValue(lb.LoadBalancerArn), Name: aws.StringValue(lb.LoadBalancerName), Created: lb.CreatedTime})
+		}
+		return true
+	})
+	return resources, err
+}
+
+func listTargetGroups(sess *session.Session, region string) ([]Resource, error) {
+	resources := []Resource{}
+	err := elbv2.New(sess).DescribeTargetGroupsPages(&elbv2.DescribeTargetGroupsInput{}, func(page *elbv2.DescribeTargetGroupsOutput, lastPage bool) bool {
+		for _, group := range page.TargetGroups {
+			resources = append(resources, Resource{Type: TargetGroup, Region: region, Id: aws.StringValue(group.TargetGroupArn), Name: aws.StringValue(group.TargetGroupName)})
+		}
+		return true
+	})
+	return resources, err
+}
+
+func listSecurityGroups(sess *session.Session, region string) ([]Resource, error) {
+	resources := []Resource{}
+	err := ec2.New(sess).DescribeSecurityGroupsPages(&ec2.DescribeSecurityGroupsInput{}, func(page *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+		for _, group := range page.SecurityGroups {
+			resources = append(resources, Resource{Type: SecurityGroup, Region: region, Id: aws.StringValue(group.GroupId), Name: aws.StringValue(group.GroupName), Tags: ec2Tags(group.Tags)})
+		}
+		return true
+	})
+	return resources, err
+}
+
+func listKeyPairs(sess *session.Session, region string) ([]Resource, error) {
+	out, err := ec2.New(sess).DescribeKeyPairs(&ec2.DescribeKeyPairsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	resources := []Resource{}
+	for _, keyPair := range out.KeyPairs {
+		name := aws.StringValue(keyPair.KeyName)
+		resources = append(resources, Resource{Type: KeyPair, Region: region, Id: name, Name: name})
+	}
+	return resources, nil
+}
+
+func listImages(sess *session.Session, region string) ([]Resource, error) {
+	out, err := ec2.New(sess).DescribeImages(&ec2.DescribeImagesInput{Owners: aws.StringSlice([]string{"self"})})
+	if err != nil {
+		return nil, err
+	}
+
+	resources := []Resource{}
+	for _, image := range out.Images {
+		resource := Resource{Type: Image, Region: region, Id: aws.StringValue(image.ImageId), Name: aws.StringValue(image.Name), Tags: ec2Tags(image.Tags)}
+		if created, err := time.Parse(imageCreationDateFormat, aws.StringValue(image.CreationDate)); err == nil {
+			resource.Created = &created
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+func ec2Tags(tags []*ec2.Tag) map[string]string {
+	out := map[string]string{}
+	for _, tag := range tags {
+		out[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return out
+}
+
+func (cloud *AwsCloud) Delete(resource Resource) error {
+	sess, err := cloud.session(resource.Region)
+	if err != nil {
+		return err
+	}
+
+	switch resource.Type {
+	case AutoScalingGroup:
+		return deleteAutoScalingGroup(sess, resource.Id)
+	case LoadBalancer:
+		return deleteLoadBalancer(sess, resource.Id)
+	case TargetGroup:
+		_, err := elbv2.New(sess).DeleteTargetGroup(&elbv2.DeleteTargetGroupInput{TargetGroupArn: aws.String(resource.Id)})
+		return err
+	case LaunchConfiguration:
+		_, err := autoscaling.New(sess).DeleteLaunchConfiguration(&autoscaling.DeleteLaunchConfigurationInput{LaunchConfigurationName: aws.String(resource.Id)})
+		return err
+	case SecurityGroup:
+		return deleteSecurityGroup(sess, resource.Id)
+	case KeyPair:
+		_, err := ec2.New(sess).DeleteKeyPair(&ec2.DeleteKeyPairInput{KeyName: aws.String(resource.Id)})
+		return err
+	case Image:
+		return deleteImage(sess, resource.Id)
+	default:
+		return UnsupportedResourceType{resource.Type}
+	}
+}
+
+// Delete the Auto Scaling Group along with its instances, and wait until they're gone, as they use the launch
+// configuration, security groups, Key Pair, and AMI
+func deleteAutoScalingGroup(sess *session.Session, name string) error {
+	client := autoscaling.New(sess)
+
+	if _, err := client.DeleteAutoScalingGroup(&autoscaling.DeleteAutoScalingGroupInput{AutoScalingGroupName: aws.String(name), ForceDelete: aws.Bool(true)}); err != nil {
+		return err
+	}
+
+	return client.WaitUntilGroupNotExists(&autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: aws.StringSlice([]string{name})})
+}
+
+// Delete the load balancer and wait until it's gone, as its listeners use the target groups and it uses a security
+// group
+func deleteLoadBalancer(sess *session.Session, arn string) error {
+	client := elbv2.New(sess)
+
+	if _, err := client.DeleteLoadBalancer(&elbv2.DeleteLoadBalancerInput{LoadBalancerArn: aws.String(arn)}); err != nil {
+		return err
+	}
+
+	return client.WaitUntilLoadBalancersDeleted(&elbv2.DescribeLoadBalancersInput{LoadBalancerArns: aws.StringSlice([]string{arn})})
+}
+
+// The security groups of the clusters and load balancers refer to each other in their rules, and AWS won't delete a
+// security group that another group's rules refer to, so we remove the rules of each group before deleting it. Once
+// all the groups that refer to a group are gone, deleting it succeeds, if need be on the retry.
+func deleteSecurityGroup(sess *session.Session, id string) error {
+	client := ec2.New(sess)
+
+	out, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: aws.StringSlice([]string{id})})
+	if err != nil {
+		return err
+	}
+
+	for _, group := range out.SecurityGroups {
+		if len(group.IpPermissions) > 0 {
+			if _, err := client.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{GroupId: group.GroupId, IpPermissions: group.IpPermissions}); err != nil {
+				return err
+			}
+		}
+		if len(group.IpPermissionsEgress) > 0 {
+			if _, err := client.RevokeSecurityGroupEgress(&ec2.RevokeSecurityGroupEgressInput{GroupId: group.GroupId, IpPermissions: group.IpPermissionsEgress}); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = client.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(id)})
+	return err
+}
+
+// Deregister the AMI and delete the EBS snapshots it was made from, which would otherwise leak
+func deleteImage(sess *session.Session, id string) error {
+	client := ec2.New(sess)
+
+	out, err := client.DescribeImages(&ec2.DescribeImagesInput{ImageIds: aws.StringSlice([]string{id})})
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.DeregisterImage(&ec2.DeregisterImageInput{ImageId: aws.String(id)}); err != nil {
+		return err
+	}
+
+	for _, image := range out.Images {
+		for _, mapping := range image.BlockDeviceMappings {
+			if mapping.Ebs == nil || mapping.Ebs.SnapshotId == nil {
+				continue
+			}
+			if _, err := client.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: mapping.Ebs.SnapshotId}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// UnsupportedResourceType is returned when asked to delete a type of resource the reaper doesn't know about
+type UnsupportedResourceType struct {
+	Type ResourceType
+}
+
+func (err UnsupportedResourceType) Error() string {
+	return "unsupported resource type: " + string(err.Type)
+}
@@ -0,0 +1,303 @@
+// Package reaper finds the AWS resources the tests in this folder created, such as AMIs, Auto Scaling Groups, and load
+// balancers, that outlived the test that created them, e.g. because the test was aborted before its teardown stage ran,
+// and deletes them in an order that respects the dependencies between them.
+package reaper
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The regions the tests deploy to. They include the regions where Gruntwork's accounts have ACM certs for testing.
+var TestRegions = []string{
+	"eu-west-1",
+	"eu-central-1",
+	"us-east-1",
+	"us-east-2",
+	"us-west-1",
+	"us-west-2",
+	"ap-northeast-1",
+	"ca-central-1",
+}
+
+// The types of resources the reaper knows how to find and delete
+type ResourceType string
+
+const (
+	AutoScalingGroup    ResourceType = "autoscaling-group"
+	LoadBalancer        ResourceType = "load-balancer"
+	TargetGroup         ResourceType = "target-group"
+	LaunchConfiguration ResourceType = "launch-configuration"
+	SecurityGroup       ResourceType = "security-group"
+	KeyPair             ResourceType = "key-pair"
+	Image               ResourceType = "image"
+)
+
+// The order to delete resources in. Resources of each type may be in use by resources of the types before it, e.g. an
+// Auto Scaling Group uses a launch configuration, security groups, a Key Pair, and an AMI, so it goes first.
+var deletionOrder = []ResourceType{
+	AutoScalingGroup,
+	LoadBalancer,
+	TargetGroup,
+	LaunchConfiguration,
+	SecurityGroup,
+	KeyPair,
+	Image,
+}
+
+// A resource in AWS
+type Resource struct {
+	Type   ResourceType
+	Region string
+	// The ID to delete the resource by, such as the ARN of a load balancer or the ID of an AMI
+	Id   string
+	Name string
+	// When the resource was created, or nil if AWS doesn't tell us, e.g. for security groups
+	Created *time.Time
+	// The tags of the resource, or nil for the types whose tags we don't list, such as launch configurations, which
+	// can't have tags
+	Tags map[string]string
+}
+
+func (resource Resource) String() string {
+	if resource.Id == resource.Name {
+		return fmt.Sprintf("%s %s in %s", resource.Type, resource.Name, resource.Region)
+	}
+	return fmt.Sprintf("%s %s (%s) in %s", resource.Type, resource.Name, resource.Id, resource.Region)
+}
+
+// Cloud lists and deletes resources, so we can test the reaper without AWS
+type Cloud interface {
+	// Return all the resources of the types in deletionOrder in the given region, whether the tests created them or not
+	List(region string) ([]Resource, error)
+
+	// Delete the given resource. If resources of later types in deletionOrder may depend on it, only return once it's
+	// gone, so that we can delete them next.
+	Delete(resource Resource) error
+}
+
+// The tests name everything after a unique ID from random.UniqueId. AMIs and Key Pairs are named couchbase-<ID>, and
+// clusters are named <BASE NAME>-<ID>, in lower case, where the base name is the role of the cluster in the example.
+// The Terraform modules then name the resources of each cluster after the cluster name, some with a suffix.
+const clusterNamePattern = `(?:single-cluster|data|search|sync|primary|replica)-([a-z0-9]{6})`
+
+var namePatterns = map[ResourceType]*regexp.Regexp{
+	AutoScalingGroup:    regexp.MustCompile(`^` + clusterNamePattern + `$`),
+	LoadBalancer:        regexp.MustCompile(`^` + clusterNamePattern + `$`),
+	TargetGroup:         regexp.MustCompile(`^` + clusterNamePattern + `(?:-cb|-sg|-hole)?$`),
+	LaunchConfiguration: regexp.MustCompile(`^` + clusterNamePattern + `-[0-9]+$`),
+	SecurityGroup:       regexp.MustCompile(`^` + clusterNamePattern + `(?:-lb|[0-9]+)$`),
+	KeyPair:             regexp.MustCompile(`^couchbase-([A-Za-z0-9]{6})$`),
+	Image:               regexp.MustCompile(`^couchbase-([A-Za-z0-9]{6})-(?:ubuntu|ubuntu-18|amazon-linux)-example-`),
+}
+
+// The tests put this tag, with their unique ID as its value, on the AMIs they build and on the Auto Scaling Groups and
+// security groups of the clusters they deploy, via the custom_tags vars of the examples. A name that matches
+// namePatterns is not enough to delete a resource, as anyone could name a resource like that: we only delete the
+// resources of a test if at least one of them has this tag with the ID of the test.
+const TestRunTag = "couchbase-test-run"
+
+// Return the tags the test with the given unique ID puts on its resources
+func TestRunTags(uniqueId string) map[string]string {
+	return map[string]string{TestRunTag: strings.ToLower(uniqueId)}
+}
+
+// Return the unique ID of the test that created the given resource, or false if no test created it. Cluster names are
+// in lower case, so we lower case all the IDs, to match the resources of a cluster to the AMI and Key Pair it uses.
+func TestId(resource Resource) (string, bool) {
+	pattern, ok := namePatterns[resource.Type]
+	if !ok {
+		return "", false
+	}
+
+	matches := pattern.FindStringSubmatch(resource.Name)
+	if matches == nil {
+		return "", false
+	}
+	return strings.ToLower(matches[1]), true
+}
+
+// List the resources in all the given regions
+func Find(cloud Cloud, regions []string) ([]Resource, error) {
+	resources := []Resource{}
+	for _, region := range regions {
+		regionResources, err := cloud.List(region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the resources in %s: %v", region, err)
+		}
+		resources = append(resources, regionResources...)
+	}
+	return resources, nil
+}
+
+// What the reaper will do with the resources the tests created
+type Plan struct {
+	// The resources to delete, in the order to delete them in
+	Delete []Resource
+	// The resources to leave alone, as their test may still be running
+	Keep []KeptResource
+}
+
+// A resource the tests created that the reaper leaves alone, and why
+type KeptResource struct {
+	Resource Resource
+	Reason   string
+}
+
+// Work out which of the given resources to delete: those the tests created, whose test started at least minAge before
+// now. As AWS doesn't tell us when some resources were created, a test started when the oldest resource with its ID
+// was created, in any region. We keep the resources of tests whose start we can't tell at all, and of tests none of
+// whose resources has the TestRunTag.
+func MakePlan(resources []Resource, now time.Time, minAge time.Duration) Plan {
+	resourcesByTest := map[string][]Resource{}
+	testStarts := map[string]time.Time{}
+	taggedTests := map[string]bool{}
+
+	for _, resource := range resources {
+		testId, ok := TestId(resource)
+		if !ok {
+			continue
+		}
+
+		resourcesByTest[testId] = append(resourcesByTest[testId], resource)
+
+		if strings.ToLower(resource.Tags[TestRunTag]) == testId {
+			taggedTests[testId] = true
+		}
+
+		if resource.Created == nil {
+			continue
+		}
+		if start, ok := testStarts[testId]; !ok || resource.Created.Before(start) {
+			testStarts[testId] = *resource.Created
+		}
+	}
+
+	plan := Plan{Delete: []Resource{}, Keep: []KeptResource{}}
+
+	for testId, testResources := range resourcesByTest {
+		start, ok := testStarts[testId]
+		for _, resource := range testResources {
+			switch {
+			case !ok:
+				plan.Keep = append(plan.Keep, KeptResource{resource, fmt.Sprintf("can't tell when test %s started, as none of its resources has a creation time", testId)})
+			case now.Sub(start) < minAge:
+				plan.Keep = append(plan.Keep, KeptResource{resource, fmt.Sprintf("test %s started %s ago, less than %s ago", testId, formatAge(now.Sub(start)), minAge)})
+			case !taggedTests[testId]:
+				plan.Keep = append(plan.Keep, KeptResource{resource, fmt.Sprintf("none of the resources of test %s has the tag %s=%s", testId, TestRunTag, testId)})
+			default:
+				plan.Delete = append(plan.Delete, resource)
+			}
+		}
+	}
+
+	sortResources(plan.Delete)
+	sort.SliceStable(plan.Keep, func(i, j int) bool {
+		return lessResource(plan.Keep[i].Resource, plan.Keep[j].Resource)
+	})
+
+	return plan
+}
+
+func sortResources(resources []Resource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		return lessResource(resources[i], resources[j])
+	})
+}
+
+// Order resources by deletionOrder, then by region and name, so the plan is easy to read
+func lessResource(a Resource, b Resource) bool {
+	if typeIndex(a.Type) != typeIndex(b.Type) {
+		return typeIndex(a.Type) < typeIndex(b.Type)
+	}
+	if a.Region != b.Region {
+		return a.Region < b.Region
+	}
+	return a.Name < b.Name
+}
+
+func typeIndex(resourceType ResourceType) int {
+	for index, orderedType := range deletionOrder {
+		if orderedType == resourceType {
+			return index
+		}
+	}
+	return len(deletionOrder)
+}
+
+func formatAge(age time.Duration) string {
+	return age.Truncate(time.Minute).String()
+}
+
+// Write the plan in a human readable form to the given writer
+func (plan Plan) Format(writer io.Writer) {
+	fmt.Fprintf(writer, "Resources to delete: %d\n", len(plan.Delete))
+	for _, resource := range plan.Delete {
+		fmt.Fprintf(writer, "  - %s\n", resource)
+	}
+
+	fmt.Fprintf(writer, "Resources to keep: %d\n", len(plan.Keep))
+	for _, kept := range plan.Keep {
+		fmt.Fprintf(writer, "  - %s: %s\n", kept.Resource, kept.Reason)
+	}
+}
+
+// A resource we failed to delete
+type DeleteError struct {
+	Resource Resource
+	Err      error
+}
+
+func (err DeleteError) Error() string {
+	return fmt.Sprintf("failed to delete %s: %v", err.Resource, err.Err)
+}
+
+// All the resources we failed to delete
+type DeleteErrors []DeleteError
+
+func (errs DeleteErrors) Error() string {
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("failed to delete %d resources:\n%s", len(errs), strings.Join(messages, "\n"))
+}
+
+// Delete the resources in the plan, in order. AWS is eventually consistent, so it may refuse to delete a resource
+// because it still thinks a resource we just deleted depends on it. Therefore, if a resource fails to delete, we try it
+// once more after all the others. Return a DeleteErrors with the resources that failed both times.
+func Execute(cloud Cloud, plan Plan, logf func(format string, args ...interface{})) error {
+	failed := deleteAll(cloud, plan.Delete, logf)
+	if len(failed) == 0 {
+		return nil
+	}
+
+	logf("Retrying the %d resources that failed to delete", len(failed))
+
+	retry := []Resource{}
+	for _, err := range failed {
+		retry = append(retry, err.Resource)
+	}
+
+	failed = deleteAll(cloud, retry, logf)
+	if len(failed) == 0 {
+		return nil
+	}
+	return failed
+}
+
+func deleteAll(cloud Cloud, resources []Resource, logf func(format string, args ...interface{})) DeleteErrors {
+	failed := DeleteErrors{}
+	for _, resource := range resources {
+		logf("Deleting %s", resource)
+		if err := cloud.Delete(resource); err != nil {
+			logf("Failed to delete %s: %v", resource, err)
+			failed = append(failed, DeleteError{resource, err})
+		}
+	}
+	return failed
+}
@@ -0,0 +1,265 @@
+package reaper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// Which types of resources use each type of resource, e.g. a launch configuration uses security groups, so AWS won't
+// delete a security group while a launch configuration that uses it exists
+var usedBy = map[ResourceType][]ResourceType{
+	TargetGroup:         {AutoScalingGroup, LoadBalancer},
+	LaunchConfiguration: {AutoScalingGroup},
+	SecurityGroup:       {LoadBalancer, LaunchConfiguration},
+	KeyPair:             {LaunchConfiguration},
+	Image:               {LaunchConfiguration},
+}
+
+// An in-memory Cloud, which refuses to delete a resource while a resource of the same test that uses it still exists,
+// as AWS would
+type fakeCloud struct {
+	resources map[string][]Resource
+	deleted   []string
+	// How many more times to fail deleting the resource with the given ID
+	failures map[string]int
+}
+
+func newFakeCloud(resources ...Resource) *fakeCloud {
+	cloud := &fakeCloud{resources: map[string][]Resource{}, failures: map[string]int{}}
+	for _, resource := range resources {
+		cloud.resources[resource.Region] = append(cloud.resources[resource.Region], resource)
+	}
+	return cloud
+}
+
+func (cloud *fakeCloud) List(region string) ([]Resource, error) {
+	if region == "broken-region" {
+		return nil, errors.New("not authorized")
+	}
+	return append([]Resource{}, cloud.resources[region]...), nil
+}
+
+func (cloud *fakeCloud) Delete(resource Resource) error {
+	if cloud.failures[resource.Id] > 0 {
+		cloud.failures[resource.Id]--
+		return errors.New("DependencyViolation")
+	}
+
+	testId, _ := TestId(resource)
+	remaining := []Resource{}
+	for _, other := range cloud.resources[resource.Region] {
+		otherTestId, _ := TestId(other)
+		if otherTestId == testId && usesType(other.Type, resource.Type) {
+			return fmt.Errorf("%s is still in use by %s", resource, other)
+		}
+		if other.Id != resource.Id {
+			remaining = append(remaining, other)
+		}
+	}
+
+	cloud.resources[resource.Region] = remaining
+	cloud.deleted = append(cloud.deleted, resource.Id)
+	return nil
+}
+
+func usesType(user ResourceType, used ResourceType) bool {
+	for _, userType := range usedBy[used] {
+		if userType == user {
+			return true
+		}
+	}
+	return false
+}
+
+func created(age time.Duration) *time.Time {
+	createdAt := now.Add(-age)
+	return &createdAt
+}
+
+// The resources the couchbase-cluster-simple test creates, with the tags it puts on them
+func singleClusterResources(region string, uniqueId string, age time.Duration) []Resource {
+	return singleClusterResourcesWithTags(region, uniqueId, age, TestRunTags(uniqueId))
+}
+
+func singleClusterResourcesWithTags(region string, uniqueId string, age time.Duration, tags map[string]string) []Resource {
+	cluster := "single-cluster-" + uniqueId
+	return []Resource{
+		{Type: Image, Region: region, Id: "ami-" + uniqueId, Name: fmt.Sprintf("couchbase-%s-ubuntu-18-example-2020-06-01T10-00-00Z", uniqueId), Created: created(age), Tags: tags},
+		{Type: KeyPair, Region: region, Id: "couchbase-" + uniqueId, Name: "couchbase-" + uniqueId},
+		{Type: SecurityGroup, Region: region, Id: "sg-lb-" + uniqueId, Name: cluster + "-lb"},
+		{Type: SecurityGroup, Region: region, Id: "sg-" + uniqueId, Name: cluster + "20200601100000000000000001", Tags: tags},
+		{Type: LaunchConfiguration, Region: region, Id: cluster + "-20200601100000000000000002", Name: cluster + "-20200601100000000000000002", Created: created(age - time.Minute)},
+		{Type: TargetGroup, Region: region, Id: "arn:tg-cb-" + uniqueId, Name: cluster + "-cb"},
+		{Type: LoadBalancer, Region: region, Id: "arn:lb-" + uniqueId, Name: cluster, Created: created(age - time.Minute)},
+		{Type: AutoScalingGroup, Region: region, Id: cluster, Name: cluster, Created: created(age - 2*time.Minute), Tags: tags},
+	}
+}
+
+func ids(resources []Resource) []string {
+	out := []string{}
+	for _, resource := range resources {
+		out = append(out, resource.Id)
+	}
+	return out
+}
+
+func TestTestIdMatchesOnlyResourcesTheTestsCreate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		resource Resource
+		testId   string
+	}{
+		{Resource{Type: Image, Name: "couchbase-AbC123-amazon-linux-example-2020-06-01T10-00-00Z"}, "abc123"},
+		{Resource{Type: KeyPair, Name: "couchbase-AbC123"}, "abc123"},
+		{Resource{Type: AutoScalingGroup, Name: "data-abc123"}, "abc123"},
+		{Resource{Type: TargetGroup, Name: "primary-abc123-sg"}, "abc123"},
+		{Resource{Type: SecurityGroup, Name: "replica-abc123-lb"}, "abc123"},
+		{Resource{Type: Image, Name: "couchbase-ubuntu-example-2020-06-01T10-00-00Z"}, ""},
+		{Resource{Type: AutoScalingGroup, Name: "production-abc123"}, ""},
+		{Resource{Type: KeyPair, Name: "data-abc123"}, ""},
+		{Resource{Type: LaunchConfiguration, Name: "data-abc123"}, ""},
+	}
+
+	for _, testCase := range testCases {
+		testId, ok := TestId(testCase.resource)
+		assert.Equal(t, testCase.testId != "", ok, "%s", testCase.resource)
+		assert.Equal(t, testCase.testId, testId, "%s", testCase.resource)
+	}
+}
+
+func TestMakePlanDeletesOnlyOldTestResourcesInDependencyOrder(t *testing.T) {
+	t.Parallel()
+
+	resources := singleClusterResources("us-east-1", "old111", 10*time.Hour)
+	resources = append(resources, singleClusterResources("eu-west-1", "new222", time.Hour)...)
+	resources = append(resources,
+		Resource{Type: AutoScalingGroup, Region: "us-east-1", Id: "production", Name: "production", Created: created(100 * time.Hour)},
+		Resource{Type: KeyPair, Region: "us-east-1", Id: "couchbase-nodate", Name: "couchbase-nodate"},
+	)
+
+	plan := MakePlan(resources, now, 6*time.Hour)
+
+	assert.Equal(t, []string{
+		"single-cluster-old111",
+		"arn:lb-old111",
+		"arn:tg-cb-old111",
+		"single-cluster-old111-20200601100000000000000002",
+		"sg-lb-old111",
+		"sg-old111",
+		"couchbase-old111",
+		"ami-old111",
+	}, ids(plan.Delete))
+
+	require.Len(t, plan.Keep, 9)
+	assert.Equal(t, "single-cluster-new222", plan.Keep[0].Resource.Id)
+	assert.Equal(t, "test new222 started 1h0m0s ago, less than 6h0m0s ago", plan.Keep[0].Reason)
+	assert.Equal(t, "couchbase-nodate", plan.Keep[7].Resource.Id)
+	assert.Equal(t, "can't tell when test nodate started, as none of its resources has a creation time", plan.Keep[7].Reason)
+}
+
+func TestMakePlanKeepsResourcesWithoutTestRunTag(t *testing.T) {
+	t.Parallel()
+
+	resources := singleClusterResourcesWithTags("us-east-1", "untagd", 10*time.Hour, map[string]string{})
+	// The tag must have the ID of the test, not just be there
+	resources = append(resources, singleClusterResourcesWithTags("us-east-1", "othrid", 10*time.Hour, TestRunTags("abc123"))...)
+	// Tests lower case the IDs in the names of the clusters, but not in the names of AMIs
+	resources = append(resources, singleClusterResourcesWithTags("us-east-1", "mixed1", 10*time.Hour, TestRunTags("MiXeD1"))...)
+
+	plan := MakePlan(resources, now, 6*time.Hour)
+
+	assert.Len(t, plan.Delete, 8)
+	for _, resource := range plan.Delete {
+		testId, _ := TestId(resource)
+		assert.Equal(t, "mixed1", testId, "%s", resource)
+	}
+
+	require.Len(t, plan.Keep, 16)
+	for _, kept := range plan.Keep {
+		testId, _ := TestId(kept.Resource)
+		assert.Equal(t, fmt.Sprintf("none of the resources of test %s has the tag couchbase-test-run=%s", testId, testId), kept.Reason)
+	}
+}
+
+func TestFindListsAllRegions(t *testing.T) {
+	t.Parallel()
+
+	cloud := newFakeCloud(append(singleClusterResources("us-east-1", "abc111", time.Hour), singleClusterResources("eu-west-1", "abc222", time.Hour)...)...)
+
+	resources, err := Find(cloud, []string{"us-east-1", "eu-west-1"})
+	require.NoError(t, err)
+	assert.Len(t, resources, 16)
+
+	_, err = Find(cloud, []string{"us-east-1", "broken-region"})
+	assert.EqualError(t, err, "failed to list the resources in broken-region: not authorized")
+}
+
+func TestExecuteDeletesInOrderAndRetriesFailures(t *testing.T) {
+	t.Parallel()
+
+	cloud := newFakeCloud(singleClusterResources("us-east-1", "abc111", 10*time.Hour)...)
+	cloud.failures["sg-lb-abc111"] = 1
+
+	resources, err := Find(cloud, []string{"us-east-1"})
+	require.NoError(t, err)
+	plan := MakePlan(resources, now, 6*time.Hour)
+
+	require.NoError(t, Execute(cloud, plan, t.Logf))
+	assert.Empty(t, cloud.resources["us-east-1"])
+	assert.Equal(t, []string{
+		"single-cluster-abc111",
+		"arn:lb-abc111",
+		"arn:tg-cb-abc111",
+		"single-cluster-abc111-20200601100000000000000002",
+		"sg-abc111",
+		"couchbase-abc111",
+		"ami-abc111",
+		"sg-lb-abc111",
+	}, cloud.deleted)
+}
+
+func TestExecuteReportsResourcesThatFailTwice(t *testing.T) {
+	t.Parallel()
+
+	cloud := newFakeCloud(singleClusterResources("us-east-1", "abc111", 10*time.Hour)...)
+	cloud.failures["arn:tg-cb-abc111"] = 2
+
+	resources, err := Find(cloud, []string{"us-east-1"})
+	require.NoError(t, err)
+	plan := MakePlan(resources, now, 6*time.Hour)
+
+	err = Execute(cloud, plan, t.Logf)
+	require.Error(t, err)
+
+	deleteErrors, ok := err.(DeleteErrors)
+	require.True(t, ok)
+	require.Len(t, deleteErrors, 1)
+	assert.Equal(t, "arn:tg-cb-abc111", deleteErrors[0].Resource.Id)
+	assert.Len(t, cloud.resources["us-east-1"], 1)
+}
+
+func TestPlanFormat(t *testing.T) {
+	t.Parallel()
+
+	plan := Plan{
+		Delete: []Resource{{Type: LoadBalancer, Region: "us-east-1", Id: "arn:lb", Name: "data-abc123"}},
+		Keep:   []KeptResource{{Resource{Type: KeyPair, Region: "us-east-1", Id: "couchbase-abc456", Name: "couchbase-abc456"}, "too young"}},
+	}
+
+	var out bytes.Buffer
+	plan.Format(&out)
+
+	assert.Equal(t, "Resources to delete: 1\n"+
+		"  - load-balancer data-abc123 (arn:lb) in us-east-1\n"+
+		"Resources to keep: 1\n"+
+		"  - key-pair couchbase-abc456 in us-east-1: too young\n", out.String())
+}
@@ -1,85 +1,78 @@
 package test
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
-	"strings"
+	"path/filepath"
 	"testing"
-	"time"
 
+	"github.com/gruntwork-io/terraform-aws-couchbase/test/reaper"
 	"github.com/gruntwork-io/terratest/modules/aws"
-	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/gruntwork-io/terratest/modules/packer"
 )
 
-// The username and password we use in all the examples, mocks, and tests
-const usernameForTest = "admin"
-const passwordForTest = "password"
-
 const savedAwsRegion = "AwsRegion"
 const savedUniqueId = "UniqueId"
 
 func getRandomAwsRegion(t *testing.T) string {
-	// Include regions where Gruntwork's accounts have ACM certs for testing
-	includedRegions := []string{
-		"eu-west-1",
-		"eu-central-1",
-		"us-east-1",
-		"us-east-2",
-		"us-west-1",
-		"us-west-2",
-		"ap-northeast-1",
-		"ca-central-1",
-	}
-
-	return aws.GetRandomRegion(t, includedRegions, nil)
+	// The reaper looks for the resources tests leave behind in the same regions
+	return aws.GetRandomRegion(t, reaper.TestRegions, nil)
 }
 
-func buildCouchbaseWithPacker(t *testing.T, builderName string, baseAmiName string, awsRegion string, folderPath string, edition string) string {
-	amiId, err := buildCouchbaseWithPackerE(t, builderName, baseAmiName, awsRegion, folderPath, edition)
+func buildCouchbaseWithPacker(t *testing.T, builderName string, baseAmiName string, awsRegion string, folderPath string, edition string, versions CouchbaseVersions) string {
+	amiId, err := buildCouchbaseWithPackerE(t, builderName, baseAmiName, awsRegion, folderPath, edition, versions)
 	if err != nil {
 		t.Fatal(err)
 	}
 	return amiId
 }
 
-func buildCouchbaseWithPackerE(t *testing.T, builderName string, baseAmiName string, awsRegion string, folderPath string, edition string) (string, error) {
+func buildCouchbaseWithPackerE(t *testing.T, builderName string, baseAmiName string, awsRegion string, folderPath string, edition string, versions CouchbaseVersions) (string, error) {
+	// The Packer template builds the Go tools, such as couchbase-secrets, into the modules folder and copies it into the
+	// image
 	templatePath := fmt.Sprintf("%s/couchbase.json", folderPath)
 
+	vars := map[string]string{
+		"aws_region":    awsRegion,
+		"base_ami_name": baseAmiName,
+		"edition":       edition,
+	}
+	for key, value := range versions.packerVars() {
+		vars[key] = value
+	}
+
 	options := &packer.Options{
 		Template: templatePath,
 		Only:     builderName,
-		Vars: map[string]string{
-			"aws_region":    awsRegion,
-			"base_ami_name": baseAmiName,
-			"edition":       edition,
-		},
+		Vars:     vars,
 	}
 
 	return packer.BuildAmiE(t, options)
 }
 
-func HttpPostForm(t *testing.T, postUrl string, postParams url.Values) (int, string, error) {
-	logger.Logf(t, "Making an HTTP POST call to URL %s with body %v", postUrl, postParams)
-
-	client := http.Client{
-		// By default, Go does not impose a timeout, so an HTTP connection attempt can hang for a LONG time.
-		Timeout: 10 * time.Second,
-	}
-
-	resp, err := client.PostForm(postUrl, postParams)
+// Build the Go tool in the given folder under cmd, such as couchbase-rotate-password, for the OS the tests are running
+// on, so the tests can run it directly, and return the path to the binary
+func buildCouchbaseToolForHost(t *testing.T, toolName string, outputDir string) string {
+	binaryPath, err := buildCouchbaseToolForHostE(testContext(t), toolName, outputDir)
 	if err != nil {
-		return -1, "", err
+		t.Fatal(err)
 	}
+	return binaryPath
+}
 
-	defer resp.Body.Close()
-	respBody, err := ioutil.ReadAll(resp.Body)
+func buildCouchbaseToolForHostE(ctx context.Context, toolName string, outputDir string) (string, error) {
+	binaryPath := filepath.Join(outputDir, toolName)
+	_, err := runCommandE(ctx, "../", "go", "build", "-o", binaryPath, fmt.Sprintf("./cmd/%s", toolName))
+	return binaryPath, err
+}
 
-	if err != nil {
-		return -1, "", err
-	}
+func HttpPostForm(t *testing.T, postUrl string, postParams url.Values) (int, string, error) {
+	return httpDoE(testContext(t), "POST", postUrl, nil, nil, postParams)
+}
 
-	return resp.StatusCode, strings.TrimSpace(string(respBody)), nil
+// Make an HTTP request with the given method to the given URL, authenticating with the given username and password via
+// HTTP basic auth. If params is not nil, it is sent as a URL-encoded form body.
+func HttpDoWithBasicAuth(t *testing.T, method string, reqUrl string, username string, password string, params url.Values) (int, string, error) {
+	return httpDoE(testContext(t), method, reqUrl, nil, &CouchbaseCredentials{Username: username, Password: password}, params)
 }
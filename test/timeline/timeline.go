@@ -0,0 +1,164 @@
+// Package timeline records how long each stage of each test, and each poll of the helpers within a stage, took, how
+// many times the polls retried, and how they ended, and writes them out as a JSON timeline and as a JUnit XML report, so
+// CI can show where the time of a test run went.
+package timeline
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The kinds of events in a timeline
+type Kind string
+
+const (
+	// A test_structure stage, such as setup_ami or validation
+	KindStage Kind = "stage"
+	// A helper that polls until a condition holds, such as waiting for a cluster to rebalance
+	KindPoll Kind = "poll"
+)
+
+// Something a test did, and how long it took
+type Event struct {
+	Test            string    `json:"test"`
+	Kind            Kind      `json:"kind"`
+	Name            string    `json:"name"`
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// How many times a poll retried, not counting the first attempt
+	Retries int  `json:"retries"`
+	Skipped bool `json:"skipped,omitempty"`
+	Failed  bool `json:"failed,omitempty"`
+	// The final error, if the event failed and we know why
+	Error string `json:"error,omitempty"`
+}
+
+// Collects the events of all the tests in a test run. Safe to use from parallel tests.
+type Timeline struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+// Record the given event
+func (timeline *Timeline) Record(event Event) {
+	timeline.mutex.Lock()
+	defer timeline.mutex.Unlock()
+
+	timeline.events = append(timeline.events, event)
+}
+
+// Return the events recorded so far, sorted by test, then by start time
+func (timeline *Timeline) Events() []Event {
+	timeline.mutex.Lock()
+	defer timeline.mutex.Unlock()
+
+	events := append([]Event{}, timeline.events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Test != events[j].Test {
+			return events[i].Test < events[j].Test
+		}
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events
+}
+
+// Write the events recorded so far to the given path as JSON
+func (timeline *Timeline) WriteJSON(path string) error {
+	contents, err := json.MarshalIndent(map[string][]Event{"events": timeline.Events()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, contents, 0644)
+}
+
+// The subset of the JUnit XML format that CI servers such as CircleCI and Jenkins read
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// Write the events recorded so far to the given path as JUnit XML, with a test suite per test, and a test case per
+// stage and poll in it, such as "stage: validation" or "poll: Waiting for rebalance"
+func (timeline *Timeline) WriteJUnit(path string) error {
+	report := junitTestSuites{Suites: []junitTestSuite{}}
+	// Polls run within stages, so only the stages add up to the time of a test
+	stageSeconds := []float64{}
+
+	for _, event := range timeline.Events() {
+		if len(report.Suites) == 0 || report.Suites[len(report.Suites)-1].Name != event.Test {
+			report.Suites = append(report.Suites, junitTestSuite{Name: event.Test})
+			stageSeconds = append(stageSeconds, 0)
+		}
+		suite := &report.Suites[len(report.Suites)-1]
+
+		if event.Kind == KindStage {
+			stageSeconds[len(stageSeconds)-1] += event.DurationSeconds
+		}
+
+		testCase := junitTestCase{
+			ClassName: event.Test,
+			Name:      fmt.Sprintf("%s: %s", event.Kind, event.Name),
+			Time:      formatSeconds(event.DurationSeconds),
+		}
+		if event.Retries > 0 {
+			testCase.SystemOut = fmt.Sprintf("Retried %d times", event.Retries)
+		}
+
+		switch {
+		case event.Failed:
+			message := event.Error
+			if message == "" {
+				message = "failed"
+			}
+			testCase.Failure = &junitFailure{Message: message}
+			suite.Failures++
+		case event.Skipped:
+			testCase.Skipped = &struct{}{}
+			suite.Skipped++
+		}
+
+		suite.Tests++
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	for index := range report.Suites {
+		report.Suites[index].Time = formatSeconds(stageSeconds[index])
+	}
+
+	contents, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), contents...), 0644)
+}
+
+func formatSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
@@ -0,0 +1,93 @@
+package timeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func testTimeline() *Timeline {
+	timeline := &Timeline{}
+	timeline.Record(Event{Test: "TestB", Kind: KindStage, Name: "setup_ami", Start: start, DurationSeconds: 600})
+	timeline.Record(Event{Test: "TestA", Kind: KindStage, Name: "validation", Start: start.Add(time.Minute), DurationSeconds: 30, Failed: true})
+	timeline.Record(Event{Test: "TestA", Kind: KindPoll, Name: "Waiting for rebalance", Start: start.Add(90 * time.Second), DurationSeconds: 20.5, Retries: 4, Failed: true, Error: "timed out"})
+	timeline.Record(Event{Test: "TestA", Kind: KindStage, Name: "setup_ami", Start: start, Skipped: true})
+	return timeline
+}
+
+func TestEventsAreSortedByTestThenStart(t *testing.T) {
+	t.Parallel()
+
+	events := testTimeline().Events()
+
+	names := []string{}
+	for _, event := range events {
+		names = append(names, event.Test+"/"+event.Name)
+	}
+	assert.Equal(t, []string{"TestA/setup_ami", "TestA/validation", "TestA/Waiting for rebalance", "TestB/setup_ami"}, names)
+}
+
+func TestWriteJSON(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", "timeline")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "timeline.json")
+	timeline := &Timeline{}
+	timeline.Record(Event{Test: "TestA", Kind: KindPoll, Name: "Waiting for rebalance", Start: start, DurationSeconds: 20.5, Retries: 4, Failed: true, Error: "timed out"})
+	require.NoError(t, timeline.WriteJSON(path))
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"events": [{
+		"test": "TestA",
+		"kind": "poll",
+		"name": "Waiting for rebalance",
+		"start": "2020-06-01T12:00:00Z",
+		"duration_seconds": 20.5,
+		"retries": 4,
+		"failed": true,
+		"error": "timed out"
+	}]}`, string(contents))
+}
+
+func TestWriteJUnit(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", "timeline")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "timeline.xml")
+	require.NoError(t, testTimeline().WriteJUnit(path))
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="TestA" tests="3" failures="2" skipped="1" time="30.000">
+    <testcase classname="TestA" name="stage: setup_ami" time="0.000">
+      <skipped></skipped>
+    </testcase>
+    <testcase classname="TestA" name="stage: validation" time="30.000">
+      <failure message="failed"></failure>
+    </testcase>
+    <testcase classname="TestA" name="poll: Waiting for rebalance" time="20.500">
+      <failure message="timed out"></failure>
+      <system-out>Retried 4 times</system-out>
+    </testcase>
+  </testsuite>
+  <testsuite name="TestB" tests="1" failures="0" skipped="0" time="600.000">
+    <testcase classname="TestB" name="stage: setup_ami" time="600.000"></testcase>
+  </testsuite>
+</testsuites>`, string(contents))
+}
@@ -77,3 +77,38 @@ variable "sync_gateway_load_balancer_port" {
   default     = 4984
 }
 
+variable "cluster_username" {
+  description = "The username for the Couchbase cluster's admin account."
+  type        = string
+  default     = "admin"
+}
+
+variable "cluster_password" {
+  description = "The password for the Couchbase cluster's admin account. This may be a reference to a secret, such as ssm:///couchbase/cluster-password or secretsmanager://couchbase-credentials#password, which is resolved at runtime on each EC2 Instance, so the plaintext password never ends up in User Data. The default plaintext password is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "test_user_password" {
+  description = "The password of test-user, which the User Data script creates for testing, along with test-bucket, and which Sync Gateway uses to connect to Couchbase. It's passed to the EC2 Instances in plaintext, so the default is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "test_app_user_password" {
+  description = "The password of the RBAC user test-app, which the User Data script creates for testing. It's passed to the EC2 Instances in plaintext, so the default is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "test_monitor_user_password" {
+  description = "The password of the RBAC user test-monitor, which the User Data script creates for testing. It's passed to the EC2 Instances in plaintext, so the default is only meant for testing!"
+  type        = string
+  default     = "password"
+}
+
+variable "custom_tags" {
+  description = "Custom tags to add to the Auto Scaling Groups and security groups of the Couchbase clusters, and to the EC2 Instances they launch. The automated tests use these to mark the resources they create."
+  type        = map(string)
+  default     = {}
+}